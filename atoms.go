@@ -0,0 +1,170 @@
+package jsonfmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Handling of unknown atoms. See `Conf.Atoms`.
+type Strictness string
+
+const (
+	// Any atom is accepted and copied as-is.
+	StrictnessPermissive Strictness = ``
+
+	// Unknown atoms are reported by `Warnings`, and formatted as usual.
+	StrictnessWarn Strictness = `warn`
+
+	// Unknown atoms fail formatting with `*AtomError`.
+	StrictnessStrict Strictness = `strict`
+)
+
+/*
+Returned or panicked when `Conf.Atoms` is `StrictnessStrict` and the source
+contains an unknown atom. The position refers to the formatted source, which
+differs from the original only with document-level transformations such as
+`Conf.SortKeys`, and counts bytes. Lines and columns start at 1.
+*/
+type AtomError struct {
+	Offset int
+	Line   int
+	Column int
+	Text   string
+}
+
+func (self *AtomError) Error() string {
+	return fmt.Sprintf(`[jsonfmt] %v at line %v, column %v`, unknownAtomMsg(self.Text), self.Line, self.Column)
+}
+
+// Position of the atom.
+func (self *AtomError) Pos() Pos { return Pos{self.Offset, self.Line, self.Column} }
+
+/*
+Atoms are any content other than strings, dicts, lists, comments and
+punctuation. Only numbers and literals are valid JSON. Words, such as "NaN",
+"undefined", or a typo like "ture", are identifier-like, and anything else is
+garbage, such as "0x10" or "+1".
+*/
+type atomClass byte
+
+const (
+	atomGarbage atomClass = iota
+	atomNumber
+	atomLiteral
+	atomWord
+)
+
+func classifyAtom(val string) atomClass {
+	switch {
+	case val == `true` || val == `false` || val == `null`:
+		return atomLiteral
+	case val != `` && (val[0] == '-' || isDigit(val[0])) && json.Valid([]byte(val)):
+		return atomNumber
+	case isWord(val):
+		return atomWord
+	default:
+		return atomGarbage
+	}
+}
+
+func isDigit(char byte) bool { return char >= '0' && char <= '9' }
+
+func isWord(val string) bool {
+	for ind, char := range val {
+		if !(unicode.IsLetter(char) || char == '_' || char == '$' || ind > 0 && unicode.IsDigit(char)) {
+			return false
+		}
+	}
+	return val != ``
+}
+
+/*
+True if the atom is valid JSON, contains placeholders, or is a dict key, which
+is followed by a colon. Unquoted keys are common in JSON5 and JavaScript, and
+don't hide typos of literals.
+*/
+func (self *fmter) isKnownAtom(start int) bool {
+	val := self.source[start:self.cursor]
+	switch classifyAtom(val) {
+	case atomNumber, atomLiteral:
+		return true
+	}
+
+	for _, delim := range self.conf.Placeholders {
+		if strings.Contains(val, delim.Start) {
+			return true
+		}
+	}
+
+	ind := self.cursor
+	for ind < len(self.source) && byteClasses[self.source[ind]]&classSpace != 0 {
+		ind++
+	}
+	return ind < len(self.source) && self.source[ind] == ':'
+}
+
+// Called after scanning an atom. See `Conf.Atoms`.
+func (self *fmter) checkAtom(start int) {
+	if self.conf.Atoms != StrictnessStrict || self.cursor == start || self.isKnownAtom(start) {
+		return
+	}
+	line, col := lineCol(self.source, start)
+	panic(fmterErr{&AtomError{Offset: start, Line: line, Column: col, Text: strings.Clone(self.source[start:self.cursor])}})
+}
+
+func unknownAtomMsg(val string) string {
+	switch classifyAtom(val) {
+	case atomWord:
+		if hint := literalHint(val); hint != `` {
+			return fmt.Sprintf(`unknown word %q, did you mean %q?`, val, hint)
+		}
+		return fmt.Sprintf(`unknown word %q`, val)
+	default:
+		return fmt.Sprintf(`invalid atom %q`, val)
+	}
+}
+
+// Literal which the word likely misspells, if any.
+func literalHint(val string) string {
+	for _, lit := range [...]string{`true`, `false`, `null`} {
+		if isTypo(strings.ToLower(val), lit) {
+			return lit
+		}
+	}
+	return ``
+}
+
+/*
+True if the strings differ by case, or by one of: a substituted, inserted or
+deleted character, or two swapped adjacent characters.
+*/
+func isTypo(one, two string) bool {
+	if one == two {
+		return true
+	}
+	if utf8.RuneCountInString(one) > utf8.RuneCountInString(two) {
+		one, two = two, one
+	}
+
+	short, long := []rune(one), []rune(two)
+	ind := 0
+	for ind < len(short) && short[ind] == long[ind] {
+		ind++
+	}
+
+	switch len(long) - len(short) {
+	case 0:
+		if string(short[ind+1:]) == string(long[ind+1:]) {
+			return true
+		}
+		return ind+1 < len(short) && short[ind] == long[ind+1] && short[ind+1] == long[ind] &&
+			string(short[ind+2:]) == string(long[ind+2:])
+	case 1:
+		return string(short[ind:]) == string(long[ind+1:])
+	default:
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package jsonfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+True if the string at the cursor may be replaced with a placeholder, see
+`Conf.ElideBlobs`. Scans and offset tracking for `Unmarshal` need the actual
+strings.
+*/
+func (self *fmter) elidesStrings() bool {
+	return self.conf.ElideBlobs > 0 && !self.discard && !self.track
+}
+
+/*
+Replaces the string at the cursor with a placeholder when it's a base64 or hex
+blob at least as long as `Conf.ElideBlobs`, returning true. The prefix of a
+data URL, such as "data:image/png;base64,", is kept.
+*/
+func (self *fmter) elidedString() bool {
+	end := stringEnd(self.source, self.cursor)
+	if end < 0 || uint64(end-self.cursor-2) < self.conf.ElideBlobs {
+		return false
+	}
+
+	body := self.source[self.cursor+1 : end-1]
+	prefix, kind := dataPrefix(body)
+	body = body[len(prefix):]
+	if prefix == `` {
+		kind = blobKind(body)
+	} else if blobKind(body) != `base64` {
+		kind = ``
+	}
+	if kind == `` || uint64(len(body)) < self.conf.ElideBlobs {
+		return false
+	}
+
+	self.cursor = end
+	self.writeByte('"')
+	self.writeString(prefix)
+	self.writeString(`<`)
+	if prefix == `` {
+		self.writeString(kind)
+		self.writeString(`: `)
+	}
+	self.writeString(strconv.Itoa(len(body)))
+	self.writeString(` chars>"`)
+	return true
+}
+
+/*
+Returns the prefix of a base64 data URL, such as "data:image/png;base64,", and
+"base64", or empty strings for other strings.
+*/
+func dataPrefix(src string) (string, string) {
+	if !strings.HasPrefix(src, `data:`) {
+		return ``, ``
+	}
+	ind := strings.IndexByte(src, ',')
+	if ind < 0 || !strings.HasSuffix(src[:ind], `;base64`) {
+		return ``, ``
+	}
+	return src[:ind+1], `base64`
+}
+
+/*
+Returns "hex" or "base64" when the content of a string consists only of
+characters of that encoding, or an empty string otherwise. Escaped slashes,
+which some encoders produce, are allowed in base64. Even-length strings of hex
+digits are considered hex, although they're valid base64 too.
+*/
+func blobKind(src string) string {
+	if src == `` {
+		return ``
+	}
+
+	hex := len(src)%2 == 0
+	padding := false
+
+	for ind := 0; ind < len(src); ind++ {
+		char := src[ind]
+
+		if char == '=' {
+			padding = true
+			hex = false
+			continue
+		}
+		if padding {
+			return ``
+		}
+
+		switch {
+		case isHexDigit(char):
+		case isLetter(char), char == '+', char == '/', char == '-', char == '_':
+			hex = false
+		case char == '\\' && ind+1 < len(src) && src[ind+1] == '/':
+			hex = false
+			ind++
+		default:
+			return ``
+		}
+	}
+
+	if hex {
+		return `hex`
+	}
+	return `base64`
+}
+
+func isHexDigit(char byte) bool {
+	return isDigit(char) || (char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F')
+}
+
+func isLetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
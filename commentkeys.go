@@ -0,0 +1,240 @@
+package jsonfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+/*
+Moves the comments of each dict into one string property with the given key,
+since strict consumers keep only one of duplicate keys. The comments of entries
+are joined with newlines in order, including comments which follow an entry on
+the same line, see `Node.Inline`, or belong to it, see `Node.After`, and
+comments at the end of the dict. The property is inserted before the first
+commented entry, or last when only the end of the dict has comments. Comments
+preceding a top-level dict are moved into it. An existing string property with
+the key is merged into the new one at its position; when the key has a value of
+another type, the comments of the dict are left unchanged. Comments elsewhere,
+such as in lists, are left unchanged. See `Conf.CommentsToKey`.
+*/
+func (self *Node) commentsToKey(conf Conf, key string) {
+	if self.Kind == KindTop {
+		for _, val := range self.Items {
+			if val.Kind == KindDict {
+				val.hoistComments(conf)
+			}
+		}
+	}
+
+	for _, val := range self.Items {
+		val.commentsToKey(conf, key)
+	}
+	for _, val := range self.Entries {
+		if val.Val != nil {
+			val.Val.commentsToKey(conf, key)
+		}
+	}
+
+	if self.Kind == KindDict && self.hasCommentKey(key) {
+		self.dictCommentsToKey(conf, key)
+	}
+}
+
+/*
+True unless the dict has a property with the given key whose value is not a
+string, which can't hold comments.
+*/
+func (self *Node) hasCommentKey(key string) bool {
+	for _, val := range self.Entries {
+		if val.Key.keyText() == key && (val.Val == nil || val.Val.Kind != KindString) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *Node) dictCommentsToKey(conf Conf, key string) {
+	var out []Entry
+	var texts, pending []string
+	at := -1
+
+	for _, val := range self.Entries {
+		comments := append(pending, val.Key.Comments...)
+		pending = nil
+		if len(val.Key.After) > 0 {
+			comments = append(comments[:len(comments):len(comments)], val.Key.After...)
+			val.Key.After = nil
+		}
+		if val.Val != nil {
+			comments = append(comments[:len(comments):len(comments)], val.Val.Comments...)
+			val.Val.Comments = nil
+		}
+		if last := val.last(); len(last.Inline) > 0 || len(last.After) > 0 {
+			comments = append(comments[:len(comments):len(comments)], last.Inline...)
+			comments = append(comments, last.After...)
+			last.Inline, last.After = nil, nil
+		}
+
+		text, rest := commentTexts(conf, comments)
+		if len(text) > 0 && at < 0 {
+			at = len(out)
+		}
+		texts = append(texts, text...)
+
+		if val.Key.keyText() == key {
+			if at < 0 {
+				at = len(out)
+			}
+			texts = append(texts, val.Val.keyText())
+			pending = rest
+			continue
+		}
+
+		val.Key.Comments = rest
+		out = append(out, val)
+	}
+
+	text, rest := commentTexts(conf, self.Trailing)
+	texts = append(texts, text...)
+	self.Trailing = append(pending, rest...)
+
+	if len(texts) > 0 {
+		if at < 0 {
+			at = len(out)
+		}
+		out = append(out[:at], append([]Entry{{
+			Key: &Node{Kind: KindString, Text: jsonString(key)},
+			Val: &Node{Kind: KindString, Text: jsonString(strings.Join(texts, "\n"))},
+		}}, out[at:]...)...)
+	}
+	self.Entries = out
+}
+
+// Moves comments preceding a dict into the dict, before the first entry.
+func (self *Node) hoistComments(conf Conf) {
+	var texts, rest []string
+	for _, val := range self.Comments {
+		if _, ok := commentText(conf, val); ok {
+			texts = append(texts, val)
+		} else {
+			rest = append(rest, val)
+		}
+	}
+
+	self.Comments = rest
+	if len(self.Entries) > 0 {
+		self.Entries[0].Key.Comments = append(texts, self.Entries[0].Key.Comments...)
+	} else {
+		self.Trailing = append(texts, self.Trailing...)
+	}
+}
+
+/*
+Inverse of `Node.commentsToKey`: replaces string properties with the given key
+by comments preceding the next entry, or at the end of the dict. See
+`Conf.CommentsFromKey`.
+*/
+func (self *Node) commentsFromKey(conf Conf, key string) {
+	for _, val := range self.Items {
+		val.commentsFromKey(conf, key)
+	}
+	for _, val := range self.Entries {
+		if val.Val != nil {
+			val.Val.commentsFromKey(conf, key)
+		}
+	}
+
+	if self.Kind != KindDict {
+		return
+	}
+
+	var out []Entry
+	var pending []string
+
+	for _, val := range self.Entries {
+		if val.Val != nil && val.Val.Kind == KindString && val.Key.keyText() == key {
+			comments := textComments(conf, val.Val.keyText())
+			if comments != nil {
+				pending = append(pending, val.Key.Comments...)
+				pending = append(pending, val.Val.Comments...)
+				pending = append(pending, comments...)
+				continue
+			}
+		}
+
+		if len(pending) > 0 {
+			val.Key.Comments = append(pending, val.Key.Comments...)
+			pending = nil
+		}
+		out = append(out, val)
+	}
+
+	self.Trailing = append(pending, self.Trailing...)
+	self.Entries = out
+}
+
+/*
+Returns the texts of the comments, and the remaining comments which aren't
+actual comments, such as template directives.
+*/
+func commentTexts(conf Conf, comments []string) ([]string, []string) {
+	var texts, rest []string
+	for _, val := range comments {
+		text, ok := commentText(conf, val)
+		if ok {
+			texts = append(texts, text)
+		} else {
+			rest = append(rest, val)
+		}
+	}
+	return texts, rest
+}
+
+// Text of a comment without delimiters and surrounding whitespace.
+func commentText(conf Conf, val string) (string, bool) {
+	if conf.CommentLine != `` && strings.HasPrefix(val, conf.CommentLine) {
+		return strings.TrimSpace(strings.TrimPrefix(val, conf.CommentLine)), true
+	}
+
+	start, end := conf.CommentBlockStart, conf.CommentBlockEnd
+	if start != `` && end != `` && strings.HasPrefix(val, start) {
+		body := strings.TrimSuffix(strings.TrimPrefix(val, start), end)
+		return strings.Join(commentLines(body), "\n"), true
+	}
+	return ``, false
+}
+
+/*
+Converts text into single-line comments, one per line, or into a block comment
+when single-line comments are disabled. Returns nil when neither is possible.
+*/
+func textComments(conf Conf, text string) []string {
+	var out []string
+
+	if conf.CommentLine != `` {
+		for _, line := range strings.Split(text, "\n") {
+			if line == `` {
+				out = append(out, conf.CommentLine+"\n")
+			} else {
+				out = append(out, conf.CommentLine+` `+line+"\n")
+			}
+		}
+		return out
+	}
+
+	start, end := conf.CommentBlockStart, conf.CommentBlockEnd
+	if start != `` && end != `` && !strings.Contains(text, end) {
+		return append(out, start+` `+text+` `+end)
+	}
+	return nil
+}
+
+// Encodes a JSON string without escaping HTML characters.
+func jsonString(val string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(val)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
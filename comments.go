@@ -0,0 +1,437 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Output style of comments. See `Conf.CommentStyle`.
+type CommentStyle string
+
+const (
+	// Comments keep their style.
+	CommentStyleKeep CommentStyle = ``
+
+	// Block comments are converted to single-line comments, one per line,
+	// except between a dict key and its value, where they would end the line
+	// in the middle of the entry.
+	CommentStyleLine CommentStyle = `line`
+
+	// Single-line comments are converted to block comments, unless they
+	// contain the end delimiter of block comments. Converted comments are
+	// still followed by a newline, keeping them on their own lines.
+	CommentStyleBlock CommentStyle = `block`
+)
+
+/*
+Element to which comments between elements belong when dicts and lists are
+restructured, such as when sorting keys. See `Conf.CommentAttach`.
+*/
+type CommentAttach string
+
+const (
+	// Comments belong to the following element, or to the end of the dict or
+	// list after the last element.
+	CommentAttachNext CommentAttach = ``
+
+	// Comments belong to the preceding element, except those before the first
+	// element, which belong to it.
+	CommentAttachPrevious CommentAttach = `previous`
+)
+
+/*
+Placement of comments between the last element of a multi-line dict or list
+and the closing bracket. See `Conf.ClosingComments`.
+*/
+type ClosingComments string
+
+const (
+	// Like other comments: on the line of the last element with
+	// `Conf.TrailingComments` when it's on the same line in the source,
+	// otherwise on their own lines.
+	ClosingCommentsDefault ClosingComments = ``
+
+	// On their own lines at the indentation of elements, even with
+	// `Conf.TrailingComments`.
+	ClosingCommentsOwnLine ClosingComments = `own-line`
+
+	// On the line of the last element, after its comma, if any, regardless of
+	// the source. A single-line comment ends the line, so the comments after
+	// it are on their own lines.
+	ClosingCommentsAttach ClosingComments = `attach`
+
+	// Moved before the last element, on their own lines. Comments on the line
+	// of the last element with `Conf.TrailingComments` stay there.
+	ClosingCommentsHoist ClosingComments = `hoist`
+)
+
+/*
+Spacing before comments which follow other content on the same line in
+single-line dicts and lists, and between keys and values. See
+`Conf.CommentSpace`.
+*/
+type CommentSpace string
+
+const (
+	// One space, as in `[10, /* note */ 20]` or `[10 /* note */]`.
+	CommentSpaceOne CommentSpace = ``
+
+	// No space, as in `[10,/* note */ 20]` or `[10/* note */]`.
+	CommentSpaceNone CommentSpace = `none`
+
+	// Padded with spaces up to `Conf.CommentColumn`, like trailing comments in
+	// multi-line dicts and lists. One space when the column is 0 or already
+	// passed.
+	CommentSpaceAlign CommentSpace = `align`
+)
+
+/*
+Moves comments at the end of dicts and lists before their last element,
+recursively. See `ClosingCommentsHoist`.
+*/
+func (self *Node) hoistClosingComments() {
+	for _, val := range self.Entries {
+		val.Key.hoistClosingComments()
+		if val.Val != nil {
+			val.Val.hoistClosingComments()
+		}
+	}
+	for _, val := range self.Items {
+		val.hoistClosingComments()
+	}
+
+	if len(self.Trailing) == 0 {
+		return
+	}
+
+	var last *Node
+	switch {
+	case self.Kind == KindDict && len(self.Entries) > 0:
+		last = self.Entries[len(self.Entries)-1].Key
+	case self.Kind == KindList && len(self.Items) > 0:
+		last = self.Items[len(self.Items)-1]
+	default:
+		return
+	}
+
+	last.Comments = append(last.Comments[:len(last.Comments):len(last.Comments)], self.Trailing...)
+	self.Trailing = nil
+}
+
+/*
+True if the comment at the cursor is omitted from the output: with
+`Conf.StripComments`, unless kept via `Conf.KeepComments` or `Conf.KeepRegexp`.
+*/
+func (self *fmter) stripsComment() bool {
+	return self.conf.StripComments && !self.conf.keepsComment(self.nextCommentText)
+}
+
+/*
+True if the comment is kept despite `Conf.StripComments`. The text is computed
+lazily, since markers only need the start of the comment.
+*/
+func (self *Conf) keepsComment(text func() string) bool {
+	if len(self.KeepComments) == 0 && self.KeepRegexp == `` {
+		return false
+	}
+
+	val := text()
+	for _, marker := range self.KeepComments {
+		if marker != `` && strings.HasPrefix(val, marker) {
+			return true
+		}
+	}
+	return self.KeepRegexp != `` && keepRegexp(self.KeepRegexp).MatchString(val)
+}
+
+// Source text of the comment at the cursor, without the trailing newline.
+func (self *fmter) nextCommentText() string {
+	if self.isNextCommentSingle() {
+		return self.source[self.cursor:self.lineEnd()]
+	}
+
+	scan := fmter{source: self.source, cursor: self.cursor, conf: self.conf, discard: true}
+	scan.conf.StripComments = false
+	scan.commentMulti()
+	return self.source[self.cursor:scan.cursor]
+}
+
+// Compiled `Conf.KeepRegexp`, cached since confs are passed by value.
+var keepRegexps sync.Map
+
+func keepRegexp(val string) *regexp.Regexp {
+	if out, ok := keepRegexps.Load(val); ok {
+		return out.(*regexp.Regexp)
+	}
+
+	out, err := regexp.Compile(val)
+	if err != nil {
+		panic(fmterErr{fmt.Errorf(`[jsonfmt] invalid keep regexp: %w`, err)})
+	}
+	keepRegexps.Store(val, out)
+	return out
+}
+
+// Comments kept despite `Conf.StripComments` are written as-is.
+func (self *fmter) rewritesComments() bool {
+	return !self.discard && !self.conf.StripComments && (self.conf.CommentStyle != CommentStyleKeep ||
+		self.conf.OutputCommentLine != `` ||
+		self.conf.OutputCommentBlockStart != `` ||
+		self.conf.OutputCommentBlockEnd != `` ||
+		self.conf.NormalizeComments)
+}
+
+// Output delimiters, defaulting to input delimiters, then to C-style ones.
+func (self *fmter) outputComments() (string, string, string) {
+	conf := &self.conf
+
+	line := firstNonEmpty(conf.OutputCommentLine, conf.CommentLine, `//`)
+	start, end := conf.OutputCommentBlockStart, conf.OutputCommentBlockEnd
+	if start == `` || end == `` {
+		start, end = conf.CommentBlockStart, conf.CommentBlockEnd
+	}
+	if start == `` || end == `` {
+		start, end = `/*`, `*/`
+	}
+	return line, start, end
+}
+
+/*
+With `Conf.NormalizeComments`, consecutive single-line comments are written
+together, which allows to collapse runs of empty ones.
+*/
+func (self *fmter) commentSingleRewritten(prefix string) {
+	empty := false
+
+	for ind := 0; ; ind++ {
+		body := self.commentSingleBody(prefix)
+		if self.conf.NormalizeComments {
+			body = normalizeCommentLine(body, prefix)
+		}
+
+		if !(empty && body == ``) {
+			if ind > 0 {
+				self.writeIndent()
+			}
+			self.writeCommentSingle(body)
+		}
+		empty = body == ``
+
+		if !self.conf.NormalizeComments || !self.skippedToCommentSingle() {
+			return
+		}
+		prefix = self.nextCommentSingle()
+	}
+}
+
+// Scans a single-line comment, returning the text between the prefix and the newline.
+func (self *fmter) commentSingleBody(prefix string) string {
+	self.skipString(prefix)
+	start := self.cursor
+	for self.more() && !self.isNextByte('\n') && !self.isNextByte('\r') {
+		self.skipChar()
+	}
+	body := self.source[start:self.cursor]
+
+	if self.isNextPrefix("\r\n") {
+		self.skipString("\r\n")
+	} else if self.more() {
+		self.skipByte()
+	}
+	return body
+}
+
+func (self *fmter) writeCommentSingle(body string) {
+	line, blockStart, blockEnd := self.outputComments()
+	text := strings.TrimSpace(body)
+
+	if self.conf.CommentStyle != CommentStyleBlock || strings.Contains(body, blockEnd) {
+		self.writeString(line)
+		self.writeString(body)
+		self.writeNewline()
+		return
+	}
+
+	self.writeString(blockStart)
+	if text != `` {
+		self.writeByte(separator)
+		self.writeString(text)
+		self.writeByte(separator)
+	}
+	self.writeString(blockEnd)
+	self.writeNewline()
+}
+
+// Skips whitespace if a single-line comment follows.
+func (self *fmter) skippedToCommentSingle() bool {
+	cursor := self.cursor
+	for self.isNextSpace() {
+		self.skipByte()
+	}
+	if self.isNextCommentSingle() {
+		return true
+	}
+	self.cursor = cursor
+	return false
+}
+
+func (self *fmter) commentMultiRewritten(prefix, suffix string) {
+	start := self.cursor
+	self.setDiscard(true)
+	self.commentMulti()
+	self.setDiscard(false)
+
+	body := strings.TrimPrefix(self.source[start:self.cursor], prefix)
+	if strings.HasSuffix(body, suffix) {
+		body = strings.TrimSuffix(body, suffix)
+	}
+
+	if self.conf.NormalizeComments && self.conf.CommentStyle != CommentStyleLine {
+		body = normalizeCommentBlock(body)
+	}
+
+	line, blockStart, blockEnd := self.outputComments()
+
+	if self.conf.CommentStyle != CommentStyleLine {
+		self.writeString(blockStart)
+		self.writeLines(body)
+		self.writeString(blockEnd)
+		return
+	}
+
+	lines := commentLines(body)
+	for ind, val := range lines {
+		if ind > 0 {
+			self.writeIndent()
+		}
+		self.writeString(line)
+		if val != `` {
+			self.writeByte(separator)
+			self.writeString(val)
+		}
+		self.writeNewline()
+	}
+}
+
+/*
+True if a block comment at the cursor is written on one line, see
+`Conf.CollapseComments`: in single-line attempts, and when there's no
+multi-line mode.
+*/
+func (self *fmter) collapsesComment() bool {
+	return self.conf.CollapseComments && !self.discard && (len(self.snapshots) > 0 || !self.whitespace())
+}
+
+/*
+Writes the block comment at the cursor as usual, then replaces each line break
+and the spaces around it with one space.
+*/
+func (self *fmter) commentMultiCollapsed() {
+	sub := fmter{source: self.source, cursor: self.cursor, conf: self.conf}
+	sub.conf.CollapseComments = false
+	sub.commentMulti()
+	self.cursor = sub.cursor
+	self.writeString(collapseLines(sub.buf.String()))
+}
+
+func collapseLines(src string) string {
+	var out []string
+	for _, val := range strings.Split(src, "\n") {
+		if val = strings.Trim(val, " \t\r"); val != `` {
+			out = append(out, val)
+		}
+	}
+	return strings.Join(out, ` `)
+}
+
+/*
+Lines of a block comment, without surrounding whitespace, leading "*" of
+continuation lines, and blank first and last lines. Always at least one.
+*/
+func commentLines(body string) []string {
+	body = strings.TrimPrefix(body, `*`)
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+
+	for ind, val := range lines {
+		val = strings.TrimSpace(val)
+		if ind > 0 && (val == `*` || strings.HasPrefix(val, `* `)) {
+			val = strings.TrimSpace(val[1:])
+		}
+		lines[ind] = val
+	}
+
+	for len(lines) > 1 && lines[0] == `` {
+		lines = lines[1:]
+	}
+	for len(lines) > 1 && lines[len(lines)-1] == `` {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+/*
+Exactly one space after the prefix, and no trailing whitespace. Comments which
+repeat the last character of the prefix, such as "///" or "##", are left
+unchanged, except for trailing whitespace.
+*/
+func normalizeCommentLine(body, prefix string) string {
+	body = strings.TrimRight(body, " \t")
+	if body == `` || body[0] == prefix[len(prefix)-1] {
+		return body
+	}
+	return ` ` + strings.TrimLeft(body, " \t")
+}
+
+/*
+One space after the opening delimiter and before the closing one, unless they
+are on separate lines, no trailing whitespace, and no consecutive empty lines.
+Doc comments such as "/**" are left unchanged at the start.
+*/
+func normalizeCommentBlock(body string) string {
+	lines := strings.Split(body, "\n")
+	out := lines[:0]
+
+	for ind, val := range lines {
+		last := ind == len(lines)-1
+		if !(last && len(lines) > 1 && strings.TrimSpace(val) == ``) {
+			val = strings.TrimRight(val, " \t\r")
+		}
+
+		if ind > 0 && !last && isEmptyCommentLine(val) && isEmptyCommentLine(out[len(out)-1]) {
+			continue
+		}
+		out = append(out, val)
+	}
+
+	first := out[0]
+	if first != `` && first[0] != '*' && first[0] != '!' {
+		first = strings.TrimLeft(first, " \t")
+		if first != `` {
+			first = ` ` + first
+		}
+	}
+	out[0] = first
+
+	if len(out) == 1 && first != `` {
+		out[0] += ` `
+	} else if len(out) > 1 && strings.TrimSpace(out[len(out)-1]) != `` {
+		out[len(out)-1] += ` `
+	}
+	return strings.Join(out, "\n")
+}
+
+func isEmptyCommentLine(val string) bool {
+	val = strings.TrimSpace(val)
+	return val == `` || val == `*`
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, val := range vals {
+		if val != `` {
+			return val
+		}
+	}
+	return ``
+}
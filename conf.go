@@ -0,0 +1,613 @@
+package jsonfmt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Prefix of environment variables read by `Conf.LoadEnv`.
+const EnvPrefix = `JSONFMT_`
+
+/*
+Shortcut for parsing a compact config string on top of `Default`. See
+`Conf.Parse`:
+
+	conf, err := jsonfmt.ParseConf(`indent=2,width=100,trailing-comma`)
+*/
+func ParseConf(src string) (Conf, error) {
+	conf := Default
+	return conf, conf.Parse(src)
+}
+
+/*
+Applies a compact config string: comma-separated "key=value" pairs, where a
+bare key means "key=true". See `Conf.Set` for keys and values. The key
+"preset" replaces the entire config, and should come first.
+*/
+func (self *Conf) Parse(src string) error {
+	for _, pair := range strings.Split(src, `,`) {
+		pair = strings.TrimSpace(pair)
+		if pair == `` {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, `=`)
+		if !ok {
+			val = `true`
+		}
+
+		err := self.Set(strings.TrimSpace(key), val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Applies environment variables named after config keys with the prefix
+`EnvPrefix`, such as "JSONFMT_WIDTH" or "JSONFMT_TRAILING_COMMA". See
+`Conf.Set` for keys and values. "JSONFMT_PRESET" is applied first. Variables
+with unknown keys are ignored, since other tools may use the same prefix, such
+as "JSONFMT_TOKEN".
+*/
+func (self *Conf) LoadEnv() error {
+	if val, ok := os.LookupEnv(EnvPrefix + `PRESET`); ok {
+		err := self.Set(`preset`, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pair := range os.Environ() {
+		key, val, _ := strings.Cut(pair, `=`)
+		if !strings.HasPrefix(key, EnvPrefix) || key == EnvPrefix+`PRESET` {
+			continue
+		}
+
+		err := self.Set(strings.TrimPrefix(key, EnvPrefix), val)
+		if err != nil && !errors.Is(err, errUnknownKey) {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Sets a config field by key. Keys are case-insensitive and ignore "-" and "_",
+so "trailing-comma", "trailingComma" and "TRAILING_COMMA" are equivalent.
+Supported keys and values:
+
+	preset                      name of a preset, see `Preset`; replaces the config
+	indent                      number of spaces, "tab", or literal indentation
+	width                       non-negative integer
+	comment-line                string
+	comment-block-start         string
+	comment-block-end           string
+	output-comment-line         string
+	output-comment-block-start  string
+	output-comment-block-end    string
+	comment-style               "line", "block", or empty to keep
+	comment-attach              "next", or "previous" for the preceding element
+	closing-comments            "own-line", "attach", "hoist", or empty for the default
+	comment-space               "one", "none", or "align", see `Conf.CommentSpace`
+	comments-to-key             string, such as "$comment"
+	comments-from-key           string, such as "$comment"
+	trailing-comma              boolean
+	trailing-comments           boolean
+	strip-comments              boolean
+	keep-comments               "//!|// KEEP:": markers separated by "|", see `Conf.KeepComments`
+	keep-regexp                 regular expression, see `Conf.KeepRegexp`
+	comment-column              non-negative integer, 0 for no alignment
+	normalize-comments          boolean
+	collapse-comments           boolean
+	sort-keys                   boolean
+	key-sort                    "natural", "ignore-case", "natural-ignore-case", or empty for lexical
+	preserve-multiline          boolean
+	brace-spacing               boolean
+	close-same-line             boolean
+	open-newline                boolean
+	expand-empty                boolean
+	inline-single               boolean
+	break-after-key             boolean
+	strict-punctuation          boolean
+	fix-smart-quotes            boolean
+	repair                      boolean
+	reindent                    boolean
+	strict-output               boolean
+	self-check                  boolean
+	key-order                   ":name version|/server:host port": paths and keys, see `Conf.KeyOrder`
+	sort-lists                  "/one|/two/*": paths separated by "|", see `Conf.SortLists`
+	fold-lists                  "/one|/two/*": paths separated by "|", see `Conf.FoldLists`
+	fold-strings                boolean
+	multi-word-atoms            boolean
+	convert-to                  "json", "jsonc", "json5", "hjson", or empty to keep the dialect
+	elide-blobs                 non-negative integer, 0 to disable, see `Conf.ElideBlobs`
+	skeleton                    boolean
+	exempt-urls                 non-negative integer, 0 to disable, see `Conf.ExemptURLs`
+	normalize-times             "/one|/two/*": paths separated by "|", see `Conf.NormalizeTimes`
+	dedupe-lists                boolean
+	sort-dicts                  "/one|/two/*": paths separated by "|", see `Conf.SortDicts`
+	separator                   "newline", "blank", "array", "rs", or empty for default
+	line-ending                 "lf", "crlf", "preserve", or empty for default
+	atoms                       "warn", "strict", or empty for permissive, see `Conf.Atoms`
+	noise                       "skip", "reject", or empty for "preserve", see `Conf.Noise`
+	colon                       ":" with optional spaces, such as " : "
+	comma                       "," with optional spaces, such as ", "
+	placeholders                "{{ }}|${ }": pairs of delimiters separated by "|"
+	max-output-bytes            non-negative integer, 0 for no limit
+	max-depth                   non-negative integer, 0 for the default
+	workers                     non-negative integer, 0 or 1 to format sequentially
+	layout-version              non-negative integer, 0 for version 1, see `Conf.LayoutVersion`
+
+Compact config strings can't contain commas, and trim spaces around values, so
+"comma" and spaced "colon" must be set via the environment or flags.
+*/
+func (self *Conf) Set(key, val string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf(`[jsonfmt] invalid config value %q for key %q: %w`, val, key, err)
+		}
+	}()
+
+	switch confKey(key) {
+	case `preset`:
+		conf, ok := Preset(val)
+		if !ok {
+			return fmt.Errorf(`unknown preset`)
+		}
+		*self = conf.clone()
+		return nil
+
+	case `indent`:
+		self.Indent, err = parseIndent(val)
+		return
+
+	case `width`:
+		self.Width, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `commentcolumn`:
+		self.CommentColumn, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `maxoutputbytes`:
+		self.MaxOutputBytes, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `maxdepth`:
+		self.MaxDepth, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `workers`:
+		self.Workers, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `layoutversion`:
+		self.LayoutVersion, err = parseLayoutVersion(val)
+		return
+
+	case `commentline`:
+		self.CommentLine = val
+		return nil
+
+	case `commentblockstart`:
+		self.CommentBlockStart = val
+		return nil
+
+	case `commentblockend`:
+		self.CommentBlockEnd = val
+		return nil
+
+	case `outputcommentline`:
+		self.OutputCommentLine = val
+		return nil
+
+	case `outputcommentblockstart`:
+		self.OutputCommentBlockStart = val
+		return nil
+
+	case `outputcommentblockend`:
+		self.OutputCommentBlockEnd = val
+		return nil
+
+	case `convertto`:
+		self.ConvertTo, err = parseDialect(val)
+		return
+
+	case `commentstyle`:
+		self.CommentStyle, err = parseCommentStyle(val)
+		return
+
+	case `commentattach`:
+		self.CommentAttach, err = parseCommentAttach(val)
+		return
+
+	case `closingcomments`:
+		self.ClosingComments, err = parseClosingComments(val)
+		return
+
+	case `commentspace`:
+		self.CommentSpace, err = parseCommentSpace(val)
+		return
+
+	case `commentstokey`:
+		self.CommentsToKey = val
+		return nil
+
+	case `commentsfromkey`:
+		self.CommentsFromKey = val
+		return nil
+
+	case `keepcomments`:
+		self.KeepComments = parseMarkers(val)
+		return nil
+
+	case `keepregexp`:
+		_, err = regexp.Compile(val)
+		if err == nil {
+			self.KeepRegexp = val
+		}
+		return
+
+	case `trailingcomma`:
+		self.TrailingComma, err = strconv.ParseBool(val)
+		return
+
+	case `trailingcomments`:
+		self.TrailingComments, err = strconv.ParseBool(val)
+		return
+
+	case `stripcomments`:
+		self.StripComments, err = strconv.ParseBool(val)
+		return
+
+	case `normalizecomments`:
+		self.NormalizeComments, err = strconv.ParseBool(val)
+		return
+
+	case `collapsecomments`:
+		self.CollapseComments, err = strconv.ParseBool(val)
+		return
+
+	case `sortkeys`:
+		self.SortKeys, err = strconv.ParseBool(val)
+		return
+
+	case `keysort`:
+		self.KeySort, err = parseKeySort(val)
+		return
+
+	case `preservemultiline`:
+		self.PreserveMultiline, err = strconv.ParseBool(val)
+		return
+
+	case `bracespacing`:
+		self.BraceSpacing, err = strconv.ParseBool(val)
+		return
+
+	case `closesameline`:
+		self.CloseSameLine, err = strconv.ParseBool(val)
+		return
+
+	case `opennewline`:
+		self.OpenNewline, err = strconv.ParseBool(val)
+		return
+
+	case `expandempty`:
+		self.ExpandEmpty, err = strconv.ParseBool(val)
+		return
+
+	case `inlinesingle`:
+		self.InlineSingle, err = strconv.ParseBool(val)
+		return
+
+	case `breakafterkey`:
+		self.BreakAfterKey, err = strconv.ParseBool(val)
+		return
+
+	case `strictpunctuation`:
+		self.StrictPunctuation, err = strconv.ParseBool(val)
+		return
+
+	case `fixsmartquotes`:
+		self.FixSmartQuotes, err = strconv.ParseBool(val)
+		return
+
+	case `repair`:
+		self.Repair, err = strconv.ParseBool(val)
+		return
+
+	case `reindent`:
+		self.Reindent, err = strconv.ParseBool(val)
+		return
+
+	case `strictoutput`:
+		self.StrictOutput, err = strconv.ParseBool(val)
+		return
+
+	case `selfcheck`:
+		self.SelfCheck, err = strconv.ParseBool(val)
+		return
+
+	case `keyorder`:
+		self.KeyOrder, err = parseKeyOrder(val)
+		return
+
+	case `sortlists`:
+		self.SortLists, err = parsePaths(val)
+		return
+
+	case `foldlists`:
+		self.FoldLists, err = parsePaths(val)
+		return
+
+	case `foldstrings`:
+		self.FoldStrings, err = strconv.ParseBool(val)
+		return
+
+	case `multiwordatoms`:
+		self.MultiWordAtoms, err = strconv.ParseBool(val)
+		return
+
+	case `skeleton`:
+		self.Skeleton, err = strconv.ParseBool(val)
+		return
+
+	case `elideblobs`:
+		self.ElideBlobs, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `exempturls`:
+		self.ExemptURLs, err = strconv.ParseUint(val, 10, 64)
+		return
+
+	case `normalizetimes`:
+		self.NormalizeTimes, err = parsePaths(val)
+		return
+
+	case `sortdicts`:
+		self.SortDicts, err = parsePaths(val)
+		return
+
+	case `dedupelists`:
+		self.DedupeLists, err = strconv.ParseBool(val)
+		return
+
+	case `separator`:
+		self.Separator, err = parseSeparator(val)
+		return
+
+	case `lineending`:
+		self.LineEnding, err = parseLineEnding(val)
+		return
+
+	case `atoms`:
+		self.Atoms, err = parseStrictness(val)
+		return
+
+	case `noise`:
+		self.Noise, err = parseNoise(val)
+		return
+
+	case `colon`:
+		self.Colon, err = parsePunctuation(val, ':')
+		return
+
+	case `comma`:
+		self.Comma, err = parsePunctuation(val, ',')
+		return
+
+	case `placeholders`:
+		self.Placeholders, err = parsePlaceholders(val)
+		return
+
+	default:
+		return errUnknownKey
+	}
+}
+
+var errUnknownKey = errors.New(`unknown key`)
+
+func confKey(key string) string {
+	return strings.ToLower(strings.NewReplacer(`-`, ``, `_`, ``).Replace(key))
+}
+
+func parseIndent(val string) (string, error) {
+	if val == `tab` || val == `\t` {
+		return "\t", nil
+	}
+
+	if val != `` && strings.Trim(val, `0123456789`) == `` {
+		size, err := strconv.Atoi(val)
+		if err != nil {
+			return ``, err
+		}
+		return strings.Repeat(` `, size), nil
+	}
+	return val, nil
+}
+
+func parseCommentStyle(val string) (CommentStyle, error) {
+	switch out := CommentStyle(strings.ToLower(val)); out {
+	case CommentStyleKeep, CommentStyleLine, CommentStyleBlock:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown comment style`)
+	}
+}
+
+func parseDialect(val string) (Dialect, error) {
+	switch out := Dialect(strings.ToLower(val)); out {
+	case ``, DialectJSON, DialectJSONC, DialectJSON5, DialectHJSON:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown dialect`)
+	}
+}
+
+func parseCommentAttach(val string) (CommentAttach, error) {
+	switch out := CommentAttach(strings.ToLower(val)); out {
+	case CommentAttachNext, `next`:
+		return CommentAttachNext, nil
+	case CommentAttachPrevious:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown comment attachment`)
+	}
+}
+
+func parseClosingComments(val string) (ClosingComments, error) {
+	switch out := ClosingComments(strings.ToLower(val)); out {
+	case ClosingCommentsDefault, ClosingCommentsOwnLine, ClosingCommentsAttach, ClosingCommentsHoist:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown placement of closing comments`)
+	}
+}
+
+func parseCommentSpace(val string) (CommentSpace, error) {
+	switch out := CommentSpace(strings.ToLower(val)); out {
+	case CommentSpaceOne, CommentSpaceNone, CommentSpaceAlign:
+		return out, nil
+	case `one`:
+		return CommentSpaceOne, nil
+	default:
+		return ``, fmt.Errorf(`unknown spacing before comments`)
+	}
+}
+
+func parseKeySort(val string) (KeySort, error) {
+	switch out := KeySort(strings.ToLower(val)); out {
+	case KeySortLexical, KeySortNatural, KeySortIgnoreCase, KeySortNaturalIgnoreCase:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown key sort`)
+	}
+}
+
+func parseSeparator(val string) (Separator, error) {
+	switch out := Separator(strings.ToLower(val)); out {
+	case SeparatorDefault, SeparatorNewline, SeparatorBlank, SeparatorArray, SeparatorRS:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown separator`)
+	}
+}
+
+func parseLineEnding(val string) (LineEnding, error) {
+	switch out := LineEnding(strings.ToLower(val)); out {
+	case LineEndingDefault, LineEndingLF, LineEndingCRLF, LineEndingPreserve:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown line ending`)
+	}
+}
+
+func parseLayoutVersion(val string) (uint64, error) {
+	out, err := strconv.ParseUint(val, 10, 64)
+	if err == nil && out > latestLayoutVersion {
+		err = fmt.Errorf(`latest supported version is %v`, latestLayoutVersion)
+	}
+	return out, err
+}
+
+func parseStrictness(val string) (Strictness, error) {
+	switch out := Strictness(strings.ToLower(val)); out {
+	case StrictnessPermissive, StrictnessWarn, StrictnessStrict:
+		return out, nil
+	default:
+		return ``, fmt.Errorf(`unknown strictness`)
+	}
+}
+
+func parseNoise(val string) (Noise, error) {
+	switch out := Noise(strings.ToLower(val)); out {
+	case NoisePreserve, NoiseSkip, NoiseReject:
+		return out, nil
+	case `preserve`:
+		return NoisePreserve, nil
+	default:
+		return ``, fmt.Errorf(`unknown handling of noise`)
+	}
+}
+
+/*
+Parses "path:key key|path:key". Keys are separated by spaces, and paths are
+separated from keys by the first colon.
+*/
+func parseKeyOrder(val string) (map[string][]string, error) {
+	if val == `` {
+		return nil, nil
+	}
+
+	out := map[string][]string{}
+	for _, pair := range strings.Split(val, `|`) {
+		path, keys, ok := strings.Cut(strings.TrimSpace(pair), `:`)
+		if !ok {
+			return nil, fmt.Errorf(`expected path and keys separated by ":", got %q`, pair)
+		}
+		_, err := pathSegments(path)
+		if err != nil {
+			return nil, err
+		}
+		out[path] = strings.Fields(keys)
+	}
+	return out, nil
+}
+
+func parsePaths(val string) ([]string, error) {
+	if val == `` {
+		return nil, nil
+	}
+
+	var out []string
+	for _, path := range strings.Split(val, `|`) {
+		path = strings.TrimSpace(path)
+		_, err := pathSegments(path)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, path)
+	}
+	return out, nil
+}
+
+// Splits "//!|// KEEP:" into markers, dropping empty ones.
+func parseMarkers(val string) []string {
+	var out []string
+	for _, marker := range strings.Split(val, `|`) {
+		marker = strings.TrimSpace(marker)
+		if marker != `` {
+			out = append(out, marker)
+		}
+	}
+	return out
+}
+
+// Punctuation with optional spaces around it. Empty means default.
+func parsePunctuation(val string, char byte) (string, error) {
+	if val != `` && (strings.Count(val, string(char)) != 1 || strings.Trim(val, ` `+string(char)) != ``) {
+		return ``, fmt.Errorf(`expected %q with optional spaces`, char)
+	}
+	return val, nil
+}
+
+func parsePlaceholders(val string) ([]Placeholder, error) {
+	var out []Placeholder
+	for _, pair := range strings.Split(val, `|`) {
+		delims := strings.Fields(pair)
+		if len(delims) == 0 {
+			continue
+		}
+		if len(delims) != 2 {
+			return nil, fmt.Errorf(`expected start and end delimiters separated by space, got %q`, pair)
+		}
+		out = append(out, Placeholder{Start: delims[0], End: delims[1]})
+	}
+	return out, nil
+}
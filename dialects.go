@@ -0,0 +1,245 @@
+package jsonfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect of JSON. See `Conf.ConvertTo` and `WithInputDialect`.
+type Dialect string
+
+const (
+	// Plain JSON: no comments, trailing commas or unquoted keys.
+	DialectJSON Dialect = `json`
+
+	// JSON with comments, as used by VS Code and TypeScript.
+	DialectJSONC Dialect = `jsonc`
+
+	// JSON5: comments, trailing commas, unquoted keys, single-quoted strings,
+	// and JavaScript numbers such as `0x10` or `Infinity`.
+	DialectJSON5 Dialect = `json5`
+
+	// HJSON: `#` comments, and unquoted strings, such as `name: John Smith`.
+	// Multi-line strings are not supported.
+	DialectHJSON Dialect = `hjson`
+)
+
+/*
+Adjusts the settings of parsing to the given input dialect, for use with
+`Conf.ConvertTo`. For JSON5 and HJSON, single-quoted strings are recognized
+via `Conf.Recognizers`. For HJSON, line comments start with `#`, and
+`Conf.MultiWordAtoms` keeps unquoted strings whole.
+*/
+func WithInputDialect(val Dialect) Option {
+	return func(tar *Conf) {
+		switch val {
+		case DialectJSON5:
+			tar.Recognizers = append(tar.Recognizers, RecognizerFunc(singleQuotedLen))
+		case DialectHJSON:
+			tar.Recognizers = append(tar.Recognizers, RecognizerFunc(singleQuotedLen))
+			tar.CommentLine = `#`
+			tar.MultiWordAtoms = true
+		}
+	}
+}
+
+/*
+Adjusts the settings of output to `Conf.ConvertTo`: JSON is strict, see
+`Conf.StrictOutput`; JSONC omits trailing commas; JSON5 adds them; HJSON omits
+them and uses `#` for line comments. Line comments of other dialects use `//`.
+*/
+func (self Conf) dialect() Conf {
+	switch self.ConvertTo {
+	case DialectJSON:
+		self = self.strict()
+	case DialectJSONC:
+		self.TrailingComma = false
+	case DialectJSON5:
+		self.TrailingComma = true
+	case DialectHJSON:
+		self.TrailingComma = false
+		if self.OutputCommentLine == `` && self.CommentLine != `#` {
+			self.OutputCommentLine = `#`
+		}
+		return self
+	default:
+		return self
+	}
+
+	if self.OutputCommentLine == `` && self.CommentLine != `//` {
+		self.OutputCommentLine = `//`
+	}
+	return self
+}
+
+/*
+Rewrites keys and atoms which aren't valid in the target dialect, where
+possible. See `Conf.ConvertTo`.
+*/
+func (self *Node) convert(conf Conf) {
+	switch self.Kind {
+	case KindTop, KindList:
+		for _, val := range self.Items {
+			val.convert(conf)
+		}
+
+	case KindDict:
+		for _, val := range self.Entries {
+			val.Key.convertKey(conf)
+			if val.Val != nil {
+				val.Val.convert(conf)
+			}
+		}
+
+	case KindAtom:
+		self.convertAtom(conf)
+	}
+}
+
+func (self *Node) convertKey(conf Conf) {
+	if self.Kind != KindAtom || hasPlaceholder(conf, self.Text) {
+		return
+	}
+
+	if isSingleQuoted(self.Text) {
+		if conf.ConvertTo == DialectJSON || conf.ConvertTo == DialectJSONC {
+			self.requote()
+		}
+		return
+	}
+
+	switch conf.ConvertTo {
+	case DialectJSON, DialectJSONC:
+	case DialectJSON5:
+		if isWord(self.Text) {
+			return
+		}
+	default:
+		return
+	}
+	self.Kind = KindString
+	self.Text = jsonString(self.Text)
+}
+
+func (self *Node) convertAtom(conf Conf) {
+	text := self.Text
+	if hasPlaceholder(conf, text) {
+		return
+	}
+
+	if isSingleQuoted(text) {
+		if conf.ConvertTo == DialectJSON || conf.ConvertTo == DialectJSONC {
+			self.requote()
+		}
+		return
+	}
+
+	switch classifyAtom(text) {
+	case atomNumber, atomLiteral:
+		return
+	}
+
+	if conf.MultiWordAtoms && conf.ConvertTo != DialectHJSON {
+		if _, ok := json5Number(text); !ok {
+			self.Kind = KindString
+			self.Text = jsonString(text)
+			return
+		}
+	}
+
+	if conf.ConvertTo != DialectJSON5 {
+		if out, ok := json5Number(text); ok {
+			self.Text = out
+		}
+	}
+}
+
+/*
+Converts JSON5 numbers which aren't valid JSON, such as `0x10`, `+1`, `.5` or
+`5.`, into JSON numbers. `Infinity` and `NaN` have no JSON equivalent.
+*/
+func json5Number(src string) (string, bool) {
+	sign := ``
+	text := src
+	if strings.HasPrefix(text, `+`) {
+		text = text[1:]
+	} else if strings.HasPrefix(text, `-`) {
+		sign = `-`
+		text = text[1:]
+	}
+
+	lower := strings.ToLower(text)
+	if strings.HasPrefix(lower, `0x`) {
+		val, err := strconv.ParseUint(text[2:], 16, 64)
+		if err != nil {
+			return ``, false
+		}
+		return sign + strconv.FormatUint(val, 10), true
+	}
+
+	if strings.HasPrefix(text, `.`) {
+		text = `0` + text
+	}
+	text = strings.Replace(text, `.e`, `.0e`, 1)
+	text = strings.Replace(text, `.E`, `.0E`, 1)
+	text = strings.TrimSuffix(text, `.`)
+
+	out := sign + text
+	if classifyAtom(out) != atomNumber {
+		return ``, false
+	}
+	return out, true
+}
+
+// Converts a single-quoted string into a double-quoted JSON string.
+func (self *Node) requote() {
+	src := self.Text[1 : len(self.Text)-1]
+	var buf strings.Builder
+	buf.WriteByte('"')
+
+	for ind := 0; ind < len(src); ind++ {
+		char := src[ind]
+		switch {
+		case char == '\\' && ind+1 < len(src) && src[ind+1] == '\'':
+			buf.WriteByte('\'')
+			ind++
+		case char == '\\' && ind+1 < len(src):
+			buf.WriteByte(char)
+			buf.WriteByte(src[ind+1])
+			ind++
+		case char == '"':
+			buf.WriteString(`\"`)
+		default:
+			buf.WriteByte(char)
+		}
+	}
+
+	buf.WriteByte('"')
+	self.Kind = KindString
+	self.Text = buf.String()
+}
+
+// Length of the single-quoted string at the start of the source, or 0.
+func singleQuotedLen(src string) int {
+	if !strings.HasPrefix(src, `'`) {
+		return 0
+	}
+	end := quotedEnd(src, 0, '\'')
+	if end >= len(src) {
+		return 0
+	}
+	return end + 1
+}
+
+func isSingleQuoted(src string) bool {
+	return len(src) >= 2 && singleQuotedLen(src) == len(src)
+}
+
+func hasPlaceholder(conf Conf, src string) bool {
+	for _, delim := range conf.Placeholders {
+		if delim.Start != `` && strings.Contains(src, delim.Start) {
+			return true
+		}
+	}
+	return false
+}
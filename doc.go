@@ -0,0 +1,676 @@
+package jsonfmt
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Document model, produced by `Parse`. Used for transformations that the
+single-pass formatter can't perform, such as sorting keys, and by tools that
+need the structure of a document, such as viewers. Transformations operate on
+the model, which is then converted back to text via `Node.String` and formatted
+as usual. This keeps layout and comment placement consistent with the
+formatter.
+
+`Comments` are the comments preceding the node, and `Trailing` are the comments
+after the last element of a dict, list or document. With
+`Conf.TrailingComments`, comments on the line of a dict value or list element,
+after it and its comma, are in `Inline`, and move together with the element.
+With `Conf.CommentAttach` set to `CommentAttachPrevious`, comments on their own
+lines after an element, up to the next element or the end, are in `After`, and
+also move together with the element. Comments between a dict key and the
+colon are in `After` of the key. Single-line comments include their trailing
+newline. `Text` is the source text of strings and atoms,
+which are numbers, booleans, null and any other unrecognized content.
+
+`Pos` is the position of the node in the parsed source: the opening bracket of
+dicts and lists, or the text of strings and atoms. Positions aren't updated by
+transformations, and nodes created by them have no position.
+*/
+type Node struct {
+	Kind     Kind
+	Text     string
+	Pos      Pos
+	Comments []string
+	Entries  []Entry
+	Items    []*Node
+	Trailing []string
+	Inline   []string
+	After    []string
+}
+
+/*
+Kind of `Node`. `Node.Kind` is one of `KindTop`, `KindDict`, `KindList`,
+`KindString` or `KindAtom`. `Node.Type` further distinguishes atoms which are
+valid JSON.
+*/
+type Kind byte
+
+const (
+	// Entire document. Top-level values are in `Node.Items`.
+	KindTop Kind = iota
+	// Entries are in `Node.Entries`.
+	KindDict
+	// Elements are in `Node.Items`.
+	KindList
+	KindString
+	KindAtom
+
+	// Atoms distinguished by `Node.Type`.
+	KindNumber
+	KindBool
+	KindNull
+)
+
+var kindNames = [...]string{
+	KindTop:    `top`,
+	KindDict:   `dict`,
+	KindList:   `list`,
+	KindString: `string`,
+	KindAtom:   `atom`,
+	KindNumber: `number`,
+	KindBool:   `bool`,
+	KindNull:   `null`,
+}
+
+// Name of the kind, such as "dict", for messages.
+func (self Kind) String() string {
+	if int(self) < len(kindNames) {
+		return kindNames[self]
+	}
+	return `Kind(` + strconv.Itoa(int(self)) + `)`
+}
+
+/*
+Like `Node.Kind`, but atoms which are valid JSON numbers, booleans or null
+have the kind `KindNumber`, `KindBool` or `KindNull`. Other atoms, such as
+`NaN` or placeholders, remain `KindAtom`.
+*/
+func (self *Node) Type() Kind {
+	if self.Kind != KindAtom {
+		return self.Kind
+	}
+
+	switch self.Text {
+	case `true`, `false`:
+		return KindBool
+	case `null`:
+		return KindNull
+	}
+	if classifyAtom(self.Text) == atomNumber {
+		return KindNumber
+	}
+	return KindAtom
+}
+
+/*
+Dict entry. The value is nil when the key is the last element of a dict with
+an odd number of elements.
+*/
+type Entry struct {
+	Key *Node
+	Val *Node
+}
+
+// True if `transform` parses the entire source into a document.
+func (self Conf) restructures() bool {
+	return self.SortKeys || len(self.KeyOrder) > 0 || len(self.SortLists) > 0 || len(self.SortDicts) > 0 ||
+		len(self.FoldLists) > 0 || len(self.NormalizeTimes) > 0 || self.ConvertTo != `` ||
+		self.CommentsToKey != `` || self.CommentsFromKey != `` || self.ClosingComments == ClosingCommentsHoist ||
+		self.Skeleton
+}
+
+/*
+Applies document-level transformations enabled in the config, if any. Wrapping
+top-level values into a list copies the source. The values are wrapped after
+parsing, so that stray closing brackets between them can't end the list early.
+*/
+func transform(conf Conf, src string) string {
+	if conf.Repair || conf.Reindent {
+		return src
+	}
+
+	if conf.restructures() || conf.Separator == SeparatorArray {
+		doc := parse(conf, src)
+		if conf.Skeleton {
+			doc.skeleton()
+		}
+		if conf.ConvertTo != `` {
+			doc.convert(conf)
+		}
+		if conf.CommentsFromKey != `` {
+			doc.commentsFromKey(conf, conf.CommentsFromKey)
+		}
+		if conf.CommentsToKey != `` {
+			doc.commentsToKey(conf, conf.CommentsToKey)
+		}
+		if conf.SortKeys {
+			doc.sortKeys(conf.KeySort)
+		}
+		for _, path := range conf.SortDicts {
+			segments, _ := pathSegments(path)
+			doc.eachAt(segments, func(node *Node) { node.sortEntries(conf.KeySort) })
+		}
+		if len(conf.KeyOrder) > 0 {
+			doc.orderKeysAt(conf.KeyOrder)
+		}
+		for _, path := range conf.SortLists {
+			segments, _ := pathSegments(path)
+			doc.eachAt(segments, func(node *Node) { node.sortList(conf.DedupeLists) })
+		}
+		for _, path := range conf.FoldLists {
+			segments, _ := pathSegments(path)
+			doc.eachAt(segments, (*Node).foldToList)
+		}
+		for _, path := range conf.NormalizeTimes {
+			segments, _ := pathSegments(path)
+			doc.eachAt(segments, (*Node).normalizeTime)
+		}
+		if conf.ClosingComments == ClosingCommentsHoist {
+			doc.hoistClosingComments()
+		}
+		if conf.Separator == SeparatorArray {
+			doc.Kind = KindList
+		}
+		src = doc.String()
+	}
+	return src
+}
+
+/*
+Parses the source into a document, using the same permissive rules as the
+formatter: missing or broken punctuation doesn't prevent parsing. Comments are
+always preserved, regardless of `Conf.StripComments`. Top-level values are
+stored as items of a `KindTop` node. Panics with `ErrMaxDepth` when dicts and
+lists are nested deeper than `Conf.MaxDepth`. Bytes are copied, since the texts
+of nodes refer to the source.
+*/
+func Parse[Src Text](conf Conf, src Src) *Node {
+	defer repanicErr()
+	return parse(conf, string(src))
+}
+
+func parse(conf Conf, src string) *Node {
+	conf.StripComments = false
+	fmter := fmter{source: src, conf: conf, discard: true}
+	return fmter.parseTop()
+}
+
+func (self *fmter) parseTop() *Node {
+	out := &Node{Kind: KindTop, Pos: self.pos(self.cursor)}
+	var comments []string
+
+	for self.more() {
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			if len(out.Items) > 0 && self.attachesPrevious(comments) {
+				self.parseAfter(out.Items[len(out.Items)-1])
+				continue
+			}
+			comments = append(comments, self.parseComment())
+			continue
+		}
+
+		val := self.parseAny()
+		if val == nil {
+			self.skipChar()
+			continue
+		}
+
+		val.Comments, comments = comments, nil
+		out.Items = append(out.Items, val)
+	}
+
+	out.Trailing = comments
+	return out
+}
+
+func (self *fmter) parseAny() *Node {
+	start := self.cursor
+	pos := self.pos(start)
+	kind := KindAtom
+
+	if self.isNextPlaceholder() {
+		self.placeholder()
+	} else if self.isNextByte('{') {
+		out := self.parseDict()
+		out.Pos = pos
+		return out
+	} else if self.isNextByte('[') {
+		out := self.parseList()
+		out.Pos = pos
+		return out
+	} else if self.isNextByte('"') {
+		self.string()
+		kind = KindString
+	} else {
+		self.atom()
+	}
+
+	if self.cursor == start {
+		return nil
+	}
+	return &Node{Kind: kind, Text: self.source[start:self.cursor], Pos: pos}
+}
+
+func (self *fmter) parseDict() *Node {
+	self.nest()
+	defer self.unnest()
+
+	assert(self.isNextByte('{'))
+	self.skipByte()
+
+	out := &Node{Kind: KindDict}
+	var comments []string
+	key := true
+	colon := false
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.skipByte()
+			break
+		}
+
+		if !key && self.isNextByte(':') {
+			colon = true
+		}
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			if !key && !colon {
+				self.parseAfter(out.Entries[len(out.Entries)-1].Key)
+				continue
+			}
+			if key && len(out.Entries) > 0 && self.isNextInline(comments) {
+				self.parseInline(out.Entries[len(out.Entries)-1].last())
+				continue
+			}
+			if key && len(out.Entries) > 0 && self.attachesPrevious(comments) {
+				self.parseAfter(out.Entries[len(out.Entries)-1].last())
+				continue
+			}
+			comments = append(comments, self.parseComment())
+			continue
+		}
+
+		if key && self.isNextDirective() {
+			start := self.cursor
+			self.placeholder()
+			comments = append(comments, self.source[start:self.cursor])
+			continue
+		}
+
+		val := self.parseAny()
+		if val == nil {
+			self.skipChar()
+			continue
+		}
+
+		val.Comments, comments = comments, nil
+		if key {
+			out.Entries = append(out.Entries, Entry{Key: val})
+		} else {
+			out.Entries[len(out.Entries)-1].Val = val
+		}
+		key = !key
+		colon = false
+	}
+
+	out.Trailing = comments
+	return out
+}
+
+func (self *fmter) parseList() *Node {
+	self.nest()
+	defer self.unnest()
+
+	assert(self.isNextByte('['))
+	self.skipByte()
+
+	out := &Node{Kind: KindList}
+	var comments []string
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.skipByte()
+			break
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			if len(out.Items) > 0 && self.isNextInline(comments) {
+				self.parseInline(out.Items[len(out.Items)-1])
+				continue
+			}
+			if len(out.Items) > 0 && self.attachesPrevious(comments) {
+				self.parseAfter(out.Items[len(out.Items)-1])
+				continue
+			}
+			comments = append(comments, self.parseComment())
+			continue
+		}
+
+		val := self.parseAny()
+		if val == nil {
+			self.skipChar()
+			continue
+		}
+
+		val.Comments, comments = comments, nil
+		out.Items = append(out.Items, val)
+	}
+
+	out.Trailing = comments
+	return out
+}
+
+// True if the comment at the cursor belongs in `Node.Inline` of the preceding element.
+func (self *fmter) isNextInline(comments []string) bool {
+	return self.conf.TrailingComments && len(comments) == 0 && self.isNextCommentTrailing()
+}
+
+/*
+True if the comment at the cursor belongs in `Node.After` of the preceding
+element, see `Conf.CommentAttach`. Comments after a directive precede the next
+element, to keep their order.
+*/
+func (self *fmter) attachesPrevious(comments []string) bool {
+	return self.conf.CommentAttach == CommentAttachPrevious && len(comments) == 0
+}
+
+func (self *fmter) parseAfter(node *Node) {
+	node.After = append(node.After, self.parseComment())
+}
+
+// Single-line comments are stored without their newline, which is implied.
+func (self *fmter) parseInline(node *Node) {
+	node.Inline = append(node.Inline, strings.TrimRight(self.parseComment(), "\r\n"))
+}
+
+// The value, or the key of an entry without a value.
+func (self Entry) last() *Node {
+	if self.Val != nil {
+		return self.Val
+	}
+	return self.Key
+}
+
+/*
+Single-line comments are stored with their trailing newline, which is added
+when missing at the end of the source. This allows to tell them apart without
+the config.
+*/
+func (self *fmter) parseComment() string {
+	start := self.cursor
+	if !self.isNextCommentSingle() {
+		self.commentMulti()
+		return self.source[start:self.cursor]
+	}
+
+	self.commentSingle()
+	out := self.source[start:self.cursor]
+	if !strings.HasSuffix(out, "\n") && !strings.HasSuffix(out, "\r") {
+		out += "\n"
+	}
+	return out
+}
+
+/*
+Converts the node back to text, with explicit punctuation and without layout,
+which is left to the formatter. Formatting the result produces the same output
+as formatting the original source.
+*/
+func (self *Node) String() string {
+	var buf strings.Builder
+	self.appendTo(&buf)
+	return buf.String()
+}
+
+func (self *Node) appendTo(buf *strings.Builder) {
+	for _, val := range self.Comments {
+		appendComment(buf, val)
+	}
+
+	switch self.Kind {
+	case KindTop:
+		for _, val := range self.Items {
+			val.appendTo(buf)
+			buf.WriteByte('\n')
+			appendAfter(buf, val.After)
+		}
+		for _, val := range self.Trailing {
+			appendComment(buf, val)
+		}
+
+	case KindDict:
+		buf.WriteByte('{')
+		for ind, val := range self.Entries {
+			val.Key.appendTo(buf)
+			for _, val := range val.Key.After {
+				appendComment(buf, val)
+			}
+			if val.Val != nil {
+				buf.WriteByte(':')
+				val.Val.appendTo(buf)
+			}
+			if ind < len(self.Entries)-1 {
+				buf.WriteByte(',')
+			}
+			appendInline(buf, val.last().Inline)
+			if val.Val != nil {
+				appendAfter(buf, val.Val.After)
+			}
+		}
+		for _, val := range self.Trailing {
+			appendComment(buf, val)
+		}
+		buf.WriteByte('}')
+
+	case KindList:
+		buf.WriteByte('[')
+		for ind, val := range self.Items {
+			val.appendTo(buf)
+			if ind < len(self.Items)-1 {
+				buf.WriteByte(',')
+			}
+			appendInline(buf, val.Inline)
+			appendAfter(buf, val.After)
+		}
+		for _, val := range self.Trailing {
+			appendComment(buf, val)
+		}
+		buf.WriteByte(']')
+
+	default:
+		buf.WriteString(self.Text)
+		buf.WriteByte(' ')
+	}
+}
+
+/*
+Block comments are followed by a space, to avoid merging with the next token.
+Single-line comments start on a new line, to avoid attaching them to the
+preceding element, see `Conf.TrailingComments`.
+*/
+func appendComment(buf *strings.Builder, val string) {
+	if !isCommentSingle(val) {
+		buf.WriteString(val)
+		buf.WriteByte(' ')
+		return
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(val)
+}
+
+// Inline comments end the line, and are written after the comma.
+func appendInline(buf *strings.Builder, vals []string) {
+	for _, val := range vals {
+		buf.WriteByte(' ')
+		buf.WriteString(val)
+	}
+	if len(vals) > 0 {
+		buf.WriteByte('\n')
+	}
+}
+
+// Comments following an element and its comma, on their own lines.
+func appendAfter(buf *strings.Builder, vals []string) {
+	for _, val := range vals {
+		if !isCommentSingle(val) {
+			buf.WriteByte('\n')
+		}
+		appendComment(buf, val)
+	}
+}
+
+// Single-line comments are stored with their newline. See `parseComment`.
+func isCommentSingle(val string) bool {
+	return strings.HasSuffix(val, "\n") || strings.HasSuffix(val, "\r")
+}
+
+// Sorts dict entries by key, recursively. The sort is stable.
+func (self *Node) sortKeys(order KeySort) {
+	for _, val := range self.Entries {
+		if val.Val != nil {
+			val.Val.sortKeys(order)
+		}
+	}
+	for _, val := range self.Items {
+		val.sortKeys(order)
+	}
+
+	self.sortEntries(order)
+}
+
+// Sorts the entries of a dict by key, without affecting nested dicts.
+func (self *Node) sortEntries(order KeySort) {
+	if self.Kind == KindDict {
+		sort.SliceStable(self.Entries, func(one, two int) bool {
+			return order.less(self.Entries[one].Key.keyText(), self.Entries[two].Key.keyText())
+		})
+	}
+}
+
+func (self KeySort) less(one, two string) bool {
+	switch self {
+	case KeySortNatural:
+		return compareNatural(one, two) < 0
+	case KeySortIgnoreCase:
+		if cmp := strings.Compare(strings.ToLower(one), strings.ToLower(two)); cmp != 0 {
+			return cmp < 0
+		}
+	case KeySortNaturalIgnoreCase:
+		if cmp := compareNatural(strings.ToLower(one), strings.ToLower(two)); cmp != 0 {
+			return cmp < 0
+		}
+		return compareNatural(one, two) < 0
+	}
+	return one < two
+}
+
+/*
+Compares strings so that runs of digits are ordered by numeric value, such as
+"item2" before "item10". Numbers which differ only in leading zeros are ordered
+by length.
+*/
+func compareNatural(one, two string) int {
+	for one != `` && two != `` {
+		num0, rest0 := cutDigits(one)
+		num1, rest1 := cutDigits(two)
+
+		if num0 == `` || num1 == `` {
+			if one[0] != two[0] {
+				return int(one[0]) - int(two[0])
+			}
+			one, two = one[1:], two[1:]
+			continue
+		}
+
+		trim0, trim1 := strings.TrimLeft(num0, `0`), strings.TrimLeft(num1, `0`)
+		if len(trim0) != len(trim1) {
+			return len(trim0) - len(trim1)
+		}
+		if cmp := strings.Compare(trim0, trim1); cmp != 0 {
+			return cmp
+		}
+		if len(num0) != len(num1) {
+			return len(num0) - len(num1)
+		}
+		one, two = rest0, rest1
+	}
+	return len(one) - len(two)
+}
+
+// Splits the leading run of ASCII digits from the rest of the string.
+func cutDigits(src string) (string, string) {
+	ind := 0
+	for ind < len(src) && src[ind] >= '0' && src[ind] <= '9' {
+		ind++
+	}
+	return src[:ind], src[ind:]
+}
+
+/*
+Sorts the items of a list of strings and atoms, optionally removing duplicates.
+Numbers are compared numerically, anything else by text. Lists containing
+dicts or lists are left unchanged. Comments preceding an item move together
+with it, and are removed together with duplicates.
+*/
+func (self *Node) sortList(dedupe bool) {
+	if self.Kind != KindList {
+		return
+	}
+	for _, val := range self.Items {
+		if val.Kind != KindString && val.Kind != KindAtom {
+			return
+		}
+	}
+
+	sort.SliceStable(self.Items, func(one, two int) bool {
+		return lessScalar(self.Items[one], self.Items[two])
+	})
+
+	if !dedupe {
+		return
+	}
+
+	out := self.Items[:0]
+	for ind, val := range self.Items {
+		if ind > 0 && out[len(out)-1].Kind == val.Kind && !lessScalar(out[len(out)-1], val) {
+			continue
+		}
+		out = append(out, val)
+	}
+	self.Items = out
+}
+
+func lessScalar(one, two *Node) bool {
+	num0, err0 := strconv.ParseFloat(one.Text, 64)
+	num1, err1 := strconv.ParseFloat(two.Text, 64)
+	if err0 == nil && err1 == nil && one.Kind == KindAtom && two.Kind == KindAtom {
+		return num0 < num1
+	}
+	return one.keyText() < two.keyText()
+}
+
+// Decoded text of string keys, or the raw text of anything else.
+func (self *Node) keyText() string {
+	if self.Kind == KindString {
+		var out string
+		if json.Unmarshal([]byte(self.Text), &out) == nil {
+			return out
+		}
+	}
+	return self.Text
+}
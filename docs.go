@@ -0,0 +1,201 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Documentation of a config, derived from its comments by `DocsOf`: one entry per
+dict entry and list element, in source order.
+*/
+type Docs []DocEntry
+
+/*
+Documented value of a config. `Path` is a JSON pointer, such as "/server/port",
+and is empty for top-level values. `Value` is the source text of strings, atoms
+and lists of scalars, and is empty for other dicts and lists, whose contents
+have their own entries. `Comment` is the text of the comments attached to the
+value, without delimiters, with several comments joined by newlines.
+*/
+type DocEntry struct {
+	Path    string
+	Kind    Kind
+	Value   string
+	Comment string
+}
+
+/*
+Collects the values of the source with their comments. Comments belong to an
+entry when they precede it, follow it on the same line, regardless of
+`Conf.TrailingComments`, or are attached to it via `Conf.CommentAttach`.
+Comments preceding a top-level value are its description, with the empty
+path, and are included only when present. Lists of scalars without comments are single entries.
+Comments which aren't recognized by the config, such as template directives,
+are ignored. Like `Parse`, panics on hard errors such as `ErrMaxDepth`.
+*/
+func DocsOf[Src Text](conf Conf, src Src) Docs {
+	defer repanicErr()
+	conf.TrailingComments = true
+
+	// Copied, since the entries keep parts of the source.
+	var out Docs
+	for _, val := range parse(conf, string(src)).Items {
+		if comment := commentsText(conf, val.Comments); comment != `` {
+			out = append(out, DocEntry{Kind: val.Type(), Value: docValue(val), Comment: comment})
+		}
+		out.collect(conf, ``, val)
+	}
+	return out
+}
+
+func (self *Docs) collect(conf Conf, path string, node *Node) {
+	if docValue(node) != `` {
+		return
+	}
+
+	for _, val := range node.Entries {
+		comments := append(val.Key.Comments[:len(val.Key.Comments):len(val.Key.Comments)], val.Key.After...)
+		if val.Val != nil {
+			comments = append(comments, val.Val.Comments...)
+		}
+		last := val.last()
+		comments = append(comments, last.Inline...)
+		comments = append(comments, last.After...)
+
+		self.add(conf, path+`/`+escapePath(val.Key.keyText()), val.Val, comments)
+	}
+
+	for ind, val := range node.Items {
+		comments := append(val.Comments[:len(val.Comments):len(val.Comments)], val.Inline...)
+		comments = append(comments, val.After...)
+		self.add(conf, path+`/`+fmt.Sprint(ind), val, comments)
+	}
+}
+
+func (self *Docs) add(conf Conf, path string, node *Node, comments []string) {
+	entry := DocEntry{Path: path, Kind: KindAtom, Comment: commentsText(conf, comments)}
+	if node != nil {
+		entry.Kind = node.Type()
+		entry.Value = docValue(node)
+	}
+
+	*self = append(*self, entry)
+	if node != nil {
+		self.collect(conf, path, node)
+	}
+}
+
+/*
+Source text of a scalar, or of a list of scalars without comments on a single
+line, otherwise empty.
+*/
+func docValue(node *Node) string {
+	switch node.Kind {
+	case KindString, KindAtom:
+		return node.Text
+
+	case KindList:
+		if len(node.Trailing) > 0 {
+			return ``
+		}
+
+		var texts []string
+		for _, val := range node.Items {
+			if (val.Kind != KindString && val.Kind != KindAtom) ||
+				len(val.Comments) > 0 || len(val.Inline) > 0 || len(val.After) > 0 {
+				return ``
+			}
+			texts = append(texts, val.Text)
+		}
+		return `[` + strings.Join(texts, `, `) + `]`
+
+	default:
+		return ``
+	}
+}
+
+// Texts of the comments without delimiters, joined by newlines.
+func commentsText(conf Conf, comments []string) string {
+	var texts []string
+	for _, val := range comments {
+		if text, ok := commentText(conf, val); ok && text != `` {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+/*
+Returns the number of documented entries, which have comments, and the total
+number of entries, excluding descriptions of top-level values.
+*/
+func (self Docs) Coverage() (documented, total int) {
+	for _, val := range self {
+		if val.Path == `` {
+			continue
+		}
+		total++
+		if val.Comment != `` {
+			documented++
+		}
+	}
+	return
+}
+
+/*
+Renders the documentation as Markdown: descriptions of top-level values as
+paragraphs, followed by a table of paths, values and comments, and the
+coverage, such as "Documented: 3 of 4 entries (75%).". With `redact`, values
+are replaced with "*redacted*", which is useful for configs with secrets.
+*/
+func (self Docs) Markdown(redact bool) string {
+	var buf strings.Builder
+
+	for _, val := range self {
+		if val.Path == `` {
+			buf.WriteString(val.Comment)
+			buf.WriteString("\n\n")
+		}
+	}
+
+	documented, total := self.Coverage()
+	if total == 0 {
+		return buf.String()
+	}
+
+	buf.WriteString("| Path | Value | Description |\n| --- | --- | --- |\n")
+	for _, val := range self {
+		if val.Path == `` {
+			continue
+		}
+
+		value := ``
+		if val.Value != `` && redact {
+			value = `*redacted*`
+		} else if val.Value != `` {
+			value = markdownCode(val.Value)
+		}
+		fmt.Fprintf(&buf, "| %v | %v | %v |\n", markdownCode(val.Path), value, markdownCell(val.Comment))
+	}
+
+	fmt.Fprintf(&buf, "\nDocumented: %v of %v entries (%v%%).\n", documented, total, documented*100/total)
+	return buf.String()
+}
+
+// Code span for a table cell, with enough backticks for the content.
+func markdownCode(src string) string {
+	fence := "`"
+	for strings.Contains(src, fence) {
+		fence += "`"
+	}
+	if strings.Contains(src, "`") {
+		return fence + ` ` + markdownCell(src) + ` ` + fence
+	}
+	return fence + markdownCell(src) + fence
+}
+
+// Escapes pipes and replaces line breaks, which would end a table row.
+func markdownCell(src string) string {
+	return strings.NewReplacer(`|`, `\|`, "\r\n", `<br>`, "\n", `<br>`).Replace(src)
+}
@@ -0,0 +1,38 @@
+package jsonfmt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Exempts the string at the cursor from `Conf.Width` when it looks like a URL or
+a file path and is at least as long as `Conf.ExemptURLs`. The exemption lasts
+until the end of the line. Only one string per line is exempt, so that several
+of them are still spread over several lines.
+*/
+func (self *fmter) exemptString() {
+	end := stringEnd(self.source, self.cursor)
+	if end < 0 || self.exempt > 0 {
+		return
+	}
+
+	src := self.source[self.cursor:end]
+	width := utf8.RuneCountInString(src)
+	if uint64(width) >= self.conf.ExemptURLs && isUnbreakable(src[1:len(src)-1]) {
+		self.exempt += width
+	}
+}
+
+/*
+True if the content of a string looks like a URL or a file path: it has no
+whitespace, and contains a scheme such as "https://", or a slash, or an escaped
+backslash, as in Windows paths.
+*/
+func isUnbreakable(src string) bool {
+	if src == `` || strings.ContainsAny(src, " \t") ||
+		strings.Contains(src, `\n`) || strings.Contains(src, `\t`) {
+		return false
+	}
+	return strings.Contains(src, `/`) || strings.Contains(src, `\\`)
+}
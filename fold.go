@@ -0,0 +1,200 @@
+package jsonfmt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+True if the string at the cursor is written via `fmter.stringFolded`, see
+`Conf.FoldStrings`. Scans for widths and offset tracking for `Unmarshal` copy
+strings as-is.
+*/
+func (self *fmter) foldsString() bool {
+	return self.conf.FoldStrings && self.whitespace() && self.conf.Width > 0 && !self.discard && !self.track
+}
+
+/*
+Writes the string at the cursor on one line when it fits into the width,
+otherwise splits it into lines joined by JSON5 line continuations: a backslash
+before the line break. Lines end after "\n" escapes or spaces where possible.
+Existing continuations are removed first, so that folding is repeatable.
+*/
+func (self *fmter) stringFolded() {
+	end := stringEnd(self.source, self.cursor)
+	if end < 0 {
+		self.stringPlain()
+		return
+	}
+
+	body := unfold(self.source[self.cursor+1 : end-1])
+	self.cursor = end
+	width := int(self.conf.Width)
+
+	if self.col+utf8.RuneCountInString(body)+2 <= width {
+		self.writeByte('"')
+		self.writeString(body)
+		self.writeByte('"')
+		return
+	}
+
+	lines := foldLines(body, width-self.col-2, width-2)
+	self.writeByte('"')
+	for ind, val := range lines {
+		if ind > 0 {
+			self.writeByte('\\')
+			self.writeLineEnd()
+		}
+		self.writeString(val)
+	}
+	self.writeByte('"')
+}
+
+/*
+Offset after the closing quote of the string at the offset, or -1 when the
+string isn't terminated.
+*/
+func stringEnd(src string, start int) int {
+	for ind := start + 1; ind < len(src); ind++ {
+		switch src[ind] {
+		case '\\':
+			ind++
+		case '"':
+			return ind + 1
+		}
+	}
+	return -1
+}
+
+// Removes JSON5 line continuations from the content of a string.
+func unfold(src string) string {
+	if !strings.Contains(src, "\\\n") && !strings.Contains(src, "\\\r") {
+		return src
+	}
+
+	var buf strings.Builder
+	for ind := 0; ind < len(src); ind++ {
+		char := src[ind]
+		if char != '\\' || ind+1 >= len(src) {
+			buf.WriteByte(char)
+			continue
+		}
+
+		next := src[ind+1]
+		switch {
+		case next == '\r' && ind+2 < len(src) && src[ind+2] == '\n':
+			ind += 2
+		case next == '\n' || next == '\r':
+			ind++
+		default:
+			buf.WriteByte(char)
+			buf.WriteByte(next)
+			ind++
+		}
+	}
+	return buf.String()
+}
+
+/*
+Splits the content of a string into lines no wider than the limits, except for
+escapes and words which don't fit. The first line has its own limit, and is
+empty when there's no room for it.
+*/
+func foldLines(src string, first, rest int) []string {
+	var out []string
+	limit := first
+	if limit <= 0 {
+		out = append(out, ``)
+		limit = rest
+	}
+
+	for src != `` {
+		cut := foldCut(src, limit)
+		out = append(out, src[:cut])
+		src = src[cut:]
+		limit = rest
+	}
+	return out
+}
+
+/*
+Length of the next line: up to and including the first "\n" escape, otherwise
+up to the last space within the limit, otherwise up to the limit. Always at
+least one character or escape.
+*/
+func foldCut(src string, limit int) int {
+	space := 0
+	width := 0
+
+	for ind := 0; ind < len(src); {
+		size := 1
+		if src[ind] == '\\' && ind+1 < len(src) {
+			size = 2
+			if src[ind+1] == 'u' {
+				size = 6
+			}
+		} else {
+			_, size = utf8.DecodeRuneInString(src[ind:])
+		}
+		if ind+size > len(src) {
+			size = len(src) - ind
+		}
+
+		if width >= limit && ind > 0 {
+			if space > 0 {
+				return space
+			}
+			return ind
+		}
+
+		width += utf8.RuneCountInString(src[ind : ind+size])
+		ind += size
+
+		if size == 2 && src[ind-1] == 'n' {
+			return ind
+		}
+		if src[ind-1] == ' ' {
+			space = ind
+		}
+	}
+	return len(src)
+}
+
+/*
+Converts a string containing "\n" escapes into a list of its lines, see
+`Conf.FoldLists`. Escapes in each line are kept as-is. Joining the lines with
+line breaks restores the string.
+*/
+func (self *Node) foldToList() {
+	if self.Kind != KindString || len(self.Text) < 2 {
+		return
+	}
+
+	lines := splitEscapedLines(self.Text[1 : len(self.Text)-1])
+	if len(lines) < 2 {
+		return
+	}
+
+	self.Kind = KindList
+	self.Text = ``
+	for _, val := range lines {
+		self.Items = append(self.Items, &Node{Kind: KindString, Text: `"` + val + `"`})
+	}
+}
+
+func splitEscapedLines(src string) []string {
+	var out []string
+	start := 0
+
+	for ind := 0; ind < len(src); ind++ {
+		if src[ind] != '\\' || ind+1 >= len(src) {
+			continue
+		}
+		if src[ind+1] == 'n' {
+			out = append(out, src[start:ind])
+			start = ind + 2
+		}
+		ind++
+	}
+	return append(out, src[start:])
+}
@@ -0,0 +1,221 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Infers a rough JSON Schema from sample documents, for exploring APIs and
+bootstrapping schemas. Every top-level value of every source is a sample, so
+newline-delimited JSON is a list of samples. The output is formatted according
+to the config, and has comments noting the number of samples and optional
+properties, unless the config strips them.
+
+Infers "type", with a list for values of several types, such as
+["string", "null"]; "properties" of dicts, whose keys are "required" when
+present in every sample; "items" of lists, inferred from all elements; and
+"enum" for strings with a few distinct values which repeat across samples.
+Numbers are "integer" unless some have fractions or exponents. Atoms which
+aren't valid JSON, such as NaN, have no type. Like `Parse`, panics on hard
+errors such as `ErrMaxDepth`.
+*/
+func InferSchema[Out, Src Text](conf Conf, srcs ...Src) Out {
+	defer repanicErr()
+
+	var root sketch
+	for _, src := range srcs {
+		for _, val := range parse(conf, text[string](src)).Items {
+			root.add(val)
+		}
+	}
+
+	out := root.node(conf)
+	out.Entries = append([]Entry{schemaEntry(`$schema`, jsonNode(`https://json-schema.org/draft/2020-12/schema`))}, out.Entries...)
+	out.Comments = textComments(conf, fmt.Sprintf(`Inferred by jsonfmt from %v.`, plural(root.count, `sample`)))
+	return Format[Out](conf, out.String())
+}
+
+// Strings with more distinct values than this don't get an "enum".
+const maxEnum = 5
+
+// Values observed at one path of the samples. See `InferSchema`.
+type sketch struct {
+	count    int
+	kinds    map[Kind]int
+	fraction bool
+	dicts    int
+	keys     []string
+	props    map[string]*sketch
+	items    *sketch
+	strings  []string
+}
+
+func (self *sketch) add(node *Node) {
+	self.count++
+	if self.kinds == nil {
+		self.kinds = map[Kind]int{}
+	}
+
+	kind := node.Type()
+	self.kinds[kind]++
+
+	switch kind {
+	case KindDict:
+		self.dicts++
+		seen := map[string]bool{}
+
+		for _, val := range node.Entries {
+			key := val.Key.keyText()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			prop := self.prop(key)
+			if val.Val == nil {
+				prop.count++
+			} else {
+				prop.add(val.Val)
+			}
+		}
+
+	case KindList:
+		if self.items == nil {
+			self.items = &sketch{}
+		}
+		for _, val := range node.Items {
+			self.items.add(val)
+		}
+
+	case KindString:
+		text := node.keyText()
+		if len(self.strings) <= maxEnum && !containsString(self.strings, text) {
+			self.strings = append(self.strings, text)
+		}
+
+	case KindNumber:
+		if strings.ContainsAny(node.Text, `.eE`) {
+			self.fraction = true
+		}
+	}
+}
+
+func (self *sketch) prop(key string) *sketch {
+	if self.props == nil {
+		self.props = map[string]*sketch{}
+	}
+	out := self.props[key]
+	if out == nil {
+		out = &sketch{}
+		self.props[key] = out
+		self.keys = append(self.keys, key)
+	}
+	return out
+}
+
+func (self *sketch) node(conf Conf) *Node {
+	out := &Node{Kind: KindDict}
+
+	types := self.types()
+	if len(types) == 1 {
+		out.Entries = append(out.Entries, schemaEntry(`type`, types[0]))
+	} else if len(types) > 1 {
+		out.Entries = append(out.Entries, schemaEntry(`type`, &Node{Kind: KindList, Items: types}))
+	}
+
+	if self.isEnum() {
+		list := &Node{Kind: KindList}
+		for _, val := range self.strings {
+			list.Items = append(list.Items, jsonNode(val))
+		}
+		out.Entries = append(out.Entries, schemaEntry(`enum`, list))
+	}
+
+	if self.dicts > 0 {
+		props := &Node{Kind: KindDict}
+		required := &Node{Kind: KindList}
+
+		for _, key := range self.keys {
+			prop := self.props[key]
+			entry := schemaEntry(key, prop.node(conf))
+			if prop.count < self.dicts {
+				entry.Key.Comments = textComments(conf, fmt.Sprintf(`Optional: present in %v of %v.`, prop.count, plural(self.dicts, `sample`)))
+			} else {
+				required.Items = append(required.Items, jsonNode(key))
+			}
+			props.Entries = append(props.Entries, entry)
+		}
+
+		out.Entries = append(out.Entries, schemaEntry(`properties`, props))
+		if len(required.Items) > 0 {
+			out.Entries = append(out.Entries, schemaEntry(`required`, required))
+		}
+	}
+
+	if self.items != nil && self.items.count > 0 {
+		out.Entries = append(out.Entries, schemaEntry(`items`, self.items.node(conf)))
+	}
+	return out
+}
+
+// Names of the observed types, in a fixed order.
+func (self *sketch) types() (out []*Node) {
+	for _, kind := range []Kind{KindDict, KindList, KindString, KindNumber, KindBool, KindNull} {
+		if self.kinds[kind] == 0 {
+			continue
+		}
+
+		switch kind {
+		case KindDict:
+			out = append(out, jsonNode(`object`))
+		case KindList:
+			out = append(out, jsonNode(`array`))
+		case KindNumber:
+			if self.fraction {
+				out = append(out, jsonNode(`number`))
+			} else {
+				out = append(out, jsonNode(`integer`))
+			}
+		case KindBool:
+			out = append(out, jsonNode(`boolean`))
+		default:
+			out = append(out, jsonNode(kind.String()))
+		}
+	}
+	return
+}
+
+/*
+True when all values are strings, with a few distinct values, some of which
+repeat. Otherwise every string seen once, such as a name, would be an enum.
+*/
+func (self *sketch) isEnum() bool {
+	distinct := len(self.strings)
+	return distinct > 0 && distinct <= maxEnum && self.kinds[KindString] == self.count &&
+		self.count > distinct
+}
+
+func schemaEntry(key string, val *Node) Entry {
+	return Entry{Key: jsonNode(key), Val: val}
+}
+
+func jsonNode(val string) *Node {
+	return &Node{Kind: KindString, Text: jsonString(val)}
+}
+
+func containsString(list []string, val string) bool {
+	for _, elem := range list {
+		if elem == val {
+			return true
+		}
+	}
+	return false
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf(`%v %v`, count, noun)
+	}
+	return fmt.Sprintf(`%v %vs`, count, noun)
+}
@@ -1,19 +1,26 @@
 /*
 Flexible JSON formatter. Features:
 
-	* Preserves order.
-	* Fits dicts and lists on a single line until a certain width (configurable).
-	* Supports comments (configurable).
-	* Supports trailing commas (configurable).
-	* Fixes missing or broken punctuation.
-	* Tiny Go library + optional tiny CLI.
+  - Preserves order.
+  - Fits dicts and lists on a single line until a certain width (configurable).
+  - Supports comments (configurable).
+  - Supports trailing commas (configurable).
+  - Fixes missing or broken punctuation.
+  - Terminates on any input: every scanning step consumes input, and
+    mismatched closing brackets end the current dict or list.
+  - Go library without dependencies, plus an optional CLI which also formats
+    files in place.
 
 Current limitations:
 
-	* Always permissive. Unrecognized non-whitespace is treated as arbitrary
-	  content on par with strings, numbers, etc.
-	* Slower than `json.Indent` from the Go standard library.
-	* Input must be UTF-8.
+  - Permissive by default. Unrecognized non-whitespace is treated as arbitrary
+    content on par with strings, numbers, etc. Stricter handling is opt-in and
+    partial: see `Conf.Atoms`, `Conf.StrictPunctuation` and `NoiseReject`.
+  - Streaming via `NewReader` and `NewWriter` buffers each top-level value
+    entirely, so a single large document is held in memory.
+  - Slower than `json.Indent` from the Go standard library, by about 9 times on
+    pure JSON: see `BenchmarkFormat` and `Benchmark_json_Indent` in the tests.
+  - Input must be UTF-8.
 
 Source and readme: https://github.com/mitranim/jsonfmt.
 */
@@ -21,10 +28,18 @@ package jsonfmt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"unicode/utf8"
-	"unsafe"
 )
 
 /*
@@ -46,6 +61,240 @@ var Default = Conf{
 	StripComments:     false,
 }
 
+// Single-line output without comments, like `json.Compact`.
+var Compact = Conf{
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	StripComments:     true,
+}
+
+// Like `Default`, but with trailing commas, which are valid in JSON5.
+var JSON5 = Conf{
+	Indent:            `  `,
+	Width:             80,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	TrailingComma:     true,
+}
+
+/*
+For JSONC files such as "settings.json" of VS Code: 4-space indentation, one
+entry per line, comments preserved, and comments after an entry kept on its
+line. Trailing commas are removed.
+*/
+var JSONC = Conf{
+	Indent:            `    `,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	TrailingComments:  true,
+}
+
+/*
+Single-line output without comments, with sorted keys, suitable for hashing and
+comparison.
+*/
+var Canonical = Conf{
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	StripComments:     true,
+	SortKeys:          true,
+}
+
+// Like `Default`, but indented with tabs.
+var Tabs = Conf{
+	Indent:            "\t",
+	Width:             80,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+}
+
+/*
+Matches the output of npm for "package.json": 2-space indentation, one entry
+per line, no comments, and dependencies sorted by name. Top-level keys are
+ordered like in the npm documentation, with well-known keys first, followed by
+other keys sorted by name, similar to the "sort-package-json" tool.
+*/
+var PackageJSON = Conf{
+	Indent:            `  `,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	StripComments:     true,
+	SortDicts: []string{
+		``,
+		`/dependencies`,
+		`/devDependencies`,
+		`/peerDependencies`,
+		`/optionalDependencies`,
+	},
+	KeyOrder: map[string][]string{``: {
+		`$schema`, `name`, `displayName`, `version`, `private`, `description`,
+		`categories`, `keywords`, `homepage`, `bugs`, `repository`, `funding`,
+		`license`, `author`, `maintainers`, `contributors`, `publisher`,
+		`sideEffects`, `type`, `imports`, `exports`, `main`, `module`, `browser`,
+		`types`, `typesVersions`, `typings`, `bin`, `man`, `directories`, `files`,
+		`workspaces`, `scripts`, `config`, `dependencies`, `devDependencies`,
+		`peerDependencies`, `peerDependenciesMeta`, `optionalDependencies`,
+		`bundleDependencies`, `bundledDependencies`, `overrides`, `resolutions`,
+		`packageManager`, `engines`, `os`, `cpu`, `publishConfig`,
+	}},
+}
+
+/*
+For "tsconfig.json" and similar files, which allow comments and trailing
+commas. Like `Default`, but with trailing commas, and comments after an entry
+kept on its line.
+*/
+var TSConfig = Conf{
+	Indent:            `  `,
+	Width:             80,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	TrailingComma:     true,
+	TrailingComments:  true,
+}
+
+/*
+Minimizes diffs in version control, for lockfiles and other documents edited
+one entry at a time: one entry per line regardless of width, so that adding,
+removing or changing an entry touches only its own lines. Empty dicts and lists,
+and those with one entry which isn't a dict or list, are still written on one
+line, see `Conf.InlineSingle`.
+*/
+var Lines = Conf{
+	Indent:            `  `,
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	LayoutVersion:     2,
+	InlineSingle:      true,
+}
+
+// Guards `presets` and `filePresets`.
+var presetsLock sync.RWMutex
+
+/*
+Named configurations, looked up by `Preset`. Keys are lowercase. Presets other
+than `Default` pin `Conf.LayoutVersion`, which keeps their output stable across
+upgrades.
+*/
+var presets = map[string]Conf{
+	`default`:   Default,
+	`compact`:   Compact,
+	`json5`:     JSON5,
+	`jsonc`:     JSONC,
+	`canonical`: Canonical,
+	`tabs`:      Tabs,
+	`package`:   PackageJSON,
+	`tsconfig`:  TSConfig,
+	`lines`:     Lines,
+}
+
+/*
+Registers a named configuration, replacing any preset with the same name,
+ignoring case. Safe for concurrent use with `Preset`.
+*/
+func RegisterPreset(name string, conf Conf) {
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+	presets[strings.ToLower(name)] = conf.clone()
+}
+
+/*
+Returns the preset registered under the given name, ignoring case. The result
+is a copy, which may be modified without affecting the preset.
+*/
+func Preset(name string) (Conf, bool) {
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+	conf, ok := presets[strings.ToLower(name)]
+	return conf.clone(), ok
+}
+
+// Returns the names of registered presets, sorted.
+func PresetNames() []string {
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+
+	out := make([]string, 0, len(presets))
+	for key := range presets {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+Names of presets for well-known files, keyed by patterns of file names in the
+syntax of `filepath.Match`, such as "tsconfig.*.json". Used by `FilePreset`.
+*/
+var filePresets = map[string]string{
+	`package.json`:    `package`,
+	`tsconfig.json`:   `tsconfig`,
+	`tsconfig.*.json`: `tsconfig`,
+	`jsconfig.json`:   `tsconfig`,
+}
+
+/*
+Registers the name of the preset for files whose base name matches the given
+pattern, in the syntax of `filepath.Match`. Safe for concurrent use with
+`FilePreset`.
+*/
+func RegisterFilePreset(pattern, name string) {
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+	filePresets[pattern] = name
+}
+
+/*
+Returns a copy of the registered file patterns, with the names of their
+presets. See `RegisterFilePreset`.
+*/
+func FilePresets() map[string]string {
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+
+	out := make(map[string]string, len(filePresets))
+	for key, val := range filePresets {
+		out[key] = val
+	}
+	return out
+}
+
+/*
+Returns the name of the preset for the given file, matching its base name
+against registered patterns, ignoring case. When several patterns match, the
+longest one wins.
+*/
+func FilePreset(path string) (string, bool) {
+	name := strings.ToLower(filepath.Base(path))
+	var out, match string
+
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+
+	for pattern, preset := range filePresets {
+		ok, _ := filepath.Match(strings.ToLower(pattern), name)
+		if ok && (len(pattern) > len(match) || len(pattern) == len(match) && pattern < match) {
+			out, match = preset, pattern
+		}
+	}
+	return out, match != ``
+}
+
 /*
 Configuration passed to `Format`. See the variable `Default`.
 
@@ -64,21 +313,455 @@ is set, the other is ignored. Nested block comments are supported. If unset,
 block comments will not be detected, and will be treated as arbitrary content
 surrounded by punctuation.
 
+`OutputCommentLine`, `OutputCommentBlockStart` and `OutputCommentBlockEnd`
+replace comment delimiters in the output, for example to convert "#" comments
+to "//". When empty, input delimiters are kept. `CommentStyle` converts
+between single-line and block comments, see the `CommentStyle` constants.
+
 `TrailingComma` controls trailing commas for last elements in dicts and lists in
 multi-line mode. In single-line mode, trailing commas are always omitted.
 
+`TrailingComments` keeps a comment on the line of the preceding element of a
+multi-line dict or list, when it's on the same line in the source, as in
+`"one": 10, // note`. Otherwise, comments in multi-line dicts and lists start
+on a new line.
+
+`ClosingComments` places comments between the last element of a multi-line
+dict or list and its closing bracket, see the `ClosingComments` constants. By
+default, they're placed like other comments, which depends on
+`TrailingComments` and on their position in the source. The placement doesn't
+depend on `TrailingComma`: a trailing comma always precedes them.
+
+`CommentAttach` decides whether comments on their own lines between entries of
+dicts and elements of lists belong to the following one, which is the default,
+or to the preceding one, see the `CommentAttach` constants. Comments move
+together with their element when it's moved, such as by `SortKeys`, and in the
+document model, see `Node.After`. This doesn't affect formatting otherwise.
+
+`CommentColumn` aligns trailing comments, see `TrailingComments`, like a
+table: they're padded with spaces so that at least this many characters precede
+them on the line, counting a tab as one. Lines which are longer keep a single
+space before the comment. 0 means no alignment.
+
+`CommentSpace` controls the space before block comments which follow other
+content on the same line, such as in single-line dicts and lists and between
+keys and values: one space by default, none, or padding up to `CommentColumn`,
+see the `CommentSpace` constants. The space after such comments is unaffected.
+
+`CollapseComments` writes block comments which span several lines on one line
+when their dict or list is single-line, replacing each line break and the
+spaces around it with one space, so that they don't prevent single-line
+layout. By default, such comments make their dict or list multi-line, or with
+`Indent: ""`, are written as-is.
+
 `StripComments` omits all comments from the output. To enforce single-line mode,
 specify this together with `Indent: ""`. Otherwise, single-line comments are
 always followed by a newline.
+
+`KeepComments` and `KeepRegexp` exempt some comments from `StripComments`, such
+as directives for other tools. A comment is kept when its source text, with
+delimiters and without the trailing newline, starts with one of the
+`KeepComments` markers, such as "//!" or "// KEEP:", or matches the regular
+expression `KeepRegexp`. Kept comments are written as-is, without
+`CommentStyle`, `NormalizeComments` or output delimiters, so that they still
+match when formatted again. An invalid `KeepRegexp` fails formatting.
+
+`NormalizeComments` normalizes spacing in comments: exactly one space after
+the comment prefix, and around the text of single-line block comments, no
+trailing whitespace, and no runs of empty comment lines. Comments such as
+"///" or "/**", which repeat the delimiter, are left unchanged at the start.
+
+`CommentsToKey` moves the comments of each dict into one string property with
+this key, such as "$comment" or "//", inserted before the first commented
+entry, with the comments joined by newlines. An existing string property with
+this key is merged into it, and dicts where the key has another type keep their
+comments. This allows to feed commented configs to strict consumers, such as
+JSON Schema validators, without losing the commentary. `CommentsFromKey` does
+the reverse, replacing string properties with this key by comments. Comments in
+lists are left unchanged.
+
+`SortKeys` sorts the entries of every dict by key. Comments preceding an entry
+move together with it. `KeySort` selects the order, see the `KeySort`
+constants.
+
+`KeyOrder` orders the keys of dicts at the given paths, such as to mirror
+documentation or the fields of a Go struct. Keys listed for a path come first,
+in the listed order, followed by other keys, which are sorted if `SortKeys` is
+set, or if the dict is listed in `SortDicts`. Paths are like in `SortLists`.
+When several paths match a dict, they're applied in lexical order of paths, so
+the last one takes precedence. See `KeyOrderOf` and `KeyOrderOfType` for
+deriving the order from a reference document or a Go type.
+
+`SortLists` lists paths of lists of strings and other scalars which are sorted,
+such as lists of file globs in config files. Paths are JSON pointers, such as
+"/permissions/allow", where "*" matches any key or index, and the empty path
+matches top-level values. Numbers are compared numerically, anything else by
+text. Lists containing dicts or lists, and invalid paths, are ignored.
+`DedupeLists` additionally removes duplicates from these lists.
+
+`FoldStrings` folds strings which don't fit into `Width`, such as PEM blobs and
+SQL queries, into several lines joined by line continuations: a backslash
+before the line break, which JSON5 and JavaScript drop from the string. Lines
+end after "\n" escapes, or after spaces, where possible. Continuation lines
+start at the beginning of the line, since indentation would become part of the
+string. Folded strings are unfolded and folded again when formatting. The
+output is valid JSON5, but not JSON.
+
+`FoldLists` lists paths of strings which are converted into lists of their
+lines, split at "\n" escapes, for dialects without line continuations. Joining
+the lines with line breaks restores the string, but consumers must do that
+themselves, so this changes the data. Paths are like in `SortLists`.
+
+`ExemptURLs`, when non-zero, exempts strings which look like URLs or file paths
+and are at least this long, including quotes, from `Width`: a dict or list
+containing such a string stays single-line if everything else fits, instead of
+being expanded by a single token which can't be broken anyway. Such strings
+have no spaces, and contain a scheme such as "https://", or a slash or an
+escaped backslash. Only the first such string on a line is exempt, and other
+content on the line still counts.
+
+`ElideBlobs`, when non-zero, replaces base64 and hex strings at least this long
+with placeholders such as "<base64: 48000 chars>", keeping the prefixes of data
+URLs such as "data:image/png;base64,". Meant for previewing and logging
+payloads with embedded images or keys, which would otherwise flood the
+terminal. The output loses data, and must not be saved over the source.
+
+`Skeleton` outputs only the structure of the document, for exploring unfamiliar
+giant payloads: dict keys are kept, strings and atoms are replaced with
+placeholders of their types, such as "<string>", "<number>", "<bool>" or
+"<null>", and lists and top-level values are sampled, keeping only the first
+element, followed by the number of the others, such as "<99 more>". Like
+`ElideBlobs`, the output loses data.
+
+`NormalizeTimes` lists paths of strings which are rewritten into RFC 3339 when
+they contain timestamps in common formats, such as "2024-05-01 12:30:00",
+"2024-05-01T12:30:00+0200", or "Wed, 01 May 2024 12:30:00 GMT". Offsets and
+fractional seconds are kept, and timestamps without offsets are assumed to be
+in UTC. Other strings are left as-is. Paths are like in `SortLists`. Useful for
+canonicalizing exported data before diffing.
+
+`SortDicts` lists paths of dicts whose entries are sorted by key, in the order
+of `KeySort`, such as the dependencies in "package.json". Unlike `SortKeys`,
+this doesn't affect nested dicts. Paths are like in `SortLists`.
+
+`Overrides` apply different layout settings to the dicts and lists at the
+given paths, and everything inside them, such as `{"width": 0}` to expand every
+dict and list under "/dependencies" while other parts stay compact. Paths are
+like in `SortLists`. Each `Override.Conf` is a JSON object with the keys of
+`Conf`, applied on top of the settings around the path, so nested overrides
+combine, and overrides matching the same path apply in order. Only layout and
+comment output settings, such as `Width`, `Indent`, `TrailingComma` or
+`InlineSingle`, can be overridden; other keys, and invalid paths, fail
+formatting. Overrides disable `Workers`.
+
+`PreserveMultiline` keeps dicts and lists multi-line when they span several
+lines in the source, even when they would fit within `Width`. Dicts and lists
+written on one line are formatted as usual. This respects structures which the
+author deliberately expanded. The source layout is not available to `SortKeys`,
+which disables this.
+
+`BraceSpacing` adds spaces inside the braces of non-empty single-line dicts, as
+in `{ "one": 10 }`. Lists are unaffected.
+
+`CloseSameLine` places the closing bracket of multi-line dicts and lists on
+the same line as the last element, unless it's followed by a single-line
+comment. Trailing commas are omitted in this style.
+
+`OpenNewline` places the opening bracket of multi-line dicts and lists which
+are dict values on a new line, indented like the key, rather than after the
+colon.
+
+Since layout version 2, see `LayoutVersion`, empty dicts and lists are always
+written as "{}" and "[]", even in multi-line mode, and even when they contain
+whitespace or stripped comments in the source. `ExpandEmpty` restores the
+layout of version 1, which writes them like other multi-line dicts and lists,
+with the closing bracket on a new line.
+
+`InlineSingle` always writes dicts and lists with exactly one entry on a single
+line when the entry's value is not a dict or list, regardless of `Width`. This
+avoids exploding small wrappers such as `{"id": 10}`.
+
+`BreakAfterKey` breaks the line after the colon when a scalar dict value, such
+as a long string, would exceed `Width`, and indents the value on its own line.
+Only applies to multi-line dicts.
+
+By default, the formatter inserts missing commas and colons and drops extra
+ones, since punctuation is rewritten anyway. `StrictPunctuation` makes such
+mistakes fail formatting with `*PunctuationError` like `MaxOutputBytes` below,
+instead of being fixed silently, which is useful for catching malformed files
+in CI. Trailing commas are always accepted. Ignored by `Repair` and `Reindent`.
+
+`FixSmartQuotes` replaces typographic quotes around keys and values, such as
+“one” or ‘one’, with ASCII quotes, and non-breaking and other Unicode spaces
+between values with ASCII spaces, before formatting. Such characters are common
+in JSON pasted from word processors and chat apps, and otherwise end up in
+atoms. The content of strings is never changed, ASCII quotes in it are escaped,
+and a closing quote must be followed by punctuation, a bracket, another quote or
+the end of the line, so that apostrophes don't end strings. Also applies with
+`Repair` and `Reindent`. Positions in errors refer to the replaced source.
+
+`Noise` handles ASCII control characters other than whitespace, such as NULs
+and form feeds, and byte order marks, outside of strings. Log streams and
+concatenated documents often contain such stray bytes between values, which
+are otherwise copied as-is, and end up in adjacent atoms, as with
+`NoisePreserve` (default). `NoiseSkip` replaces them with spaces before
+formatting, which drops them like other whitespace, and `NoiseReject` fails
+formatting with `*NoiseError`, which has the position in the original source.
+Comments and placeholders are left alone. Also applies with `Repair` and
+`Reindent`.
+
+`Repair` only fixes punctuation: missing commas and colons are inserted after
+the preceding value, and extra ones are removed, while whitespace, line breaks
+and comments are preserved exactly. Other settings, except comment delimiters
+and placeholders, are ignored. Useful for making a file parse again without
+restyling it.
+
+`Reindent` only replaces the indentation of each line according to nesting,
+using `Indent`. Line breaks, punctuation and comments are preserved exactly,
+and other settings are ignored. Useful for files managed by other tools which
+are picky about token-level changes. Ignored when `Repair` is set.
+
+`Separator` controls how multiple top-level values are separated, for example in
+newline-delimited JSON. See the `Separator` constants.
+
+`LineEnding` controls line breaks in the output. See the `LineEnding`
+constants. Line breaks inside strings are never changed, and `Repair` and
+`Reindent` keep line breaks as-is.
+
+`Colon` and `Comma` override the punctuation between keys and values and
+between elements, including the surrounding spaces, such as " : " or ",". They
+must consist of the punctuation character and spaces. When empty, they default
+to ": " and ", ", without spaces when `Indent` is empty. When set, they apply
+even when `Indent` is empty. In multi-line mode, spaces after the comma are
+replaced by the newline.
+
+`StrictOutput` overrides settings which would produce output which isn't
+valid JSON: comments are stripped, trailing commas are omitted, and
+`CommentsFromKey` and `SeparatorRS` are ignored. The input must still consist
+of valid JSON values, apart from comments and punctuation. Unquoted atoms,
+such as `undefined` or JSON5 keys, are kept as-is.
+
+`SelfCheck` checks that formatting the output again doesn't change it, and
+with `StrictOutput`, that the output is valid JSON, failing with
+`ErrSelfCheck` otherwise, like `MaxOutputBytes` below. Meant for catching
+formatter bugs before they corrupt files, for example in staging. Roughly
+doubles the cost of formatting, and makes `FormatTo` accumulate the output
+before writing it.
+
+`MaxOutputBytes`, when non-zero, aborts formatting once the output exceeds the
+given number of bytes, guarding services against inputs which expand greatly
+when pretty-printed. Functions which return errors, such as `FormatTo` and
+`FormatCtx`, return `ErrMaxOutputBytes`. Functions which don't, such as
+`Format`, panic with it.
+
+`MaxDepth` limits the nesting of dicts and lists, failing with `ErrMaxDepth`
+like `MaxOutputBytes` above. When 0, `DefaultMaxDepth` is used. The formatter
+is recursive, and adversarial inputs with millions of nested brackets would
+otherwise exhaust the stack, which crashes the process instead of panicking.
+
+`Workers`, when above 1, formats top-level values, such as lines of
+newline-delimited JSON, or the elements of a top-level list, concurrently in
+this many goroutines, and stitches the results in order. The output is the
+same as without it. Sources under 256 KiB are formatted sequentially. Useful
+for huge inputs such as log dumps. `FormatAll` instead formats its sources
+concurrently.
+
+`LayoutVersion` pins the version of the layout algorithm. Changes to the layout
+of existing settings, such as better line breaking, are introduced under a new
+version, while older versions keep producing byte-identical output. When 0,
+version 1 is used, see `DefaultLayoutVersion`, so newer layouts require opting
+in, and upgrading jsonfmt never changes the output of an unset version. The
+latest version is returned by `LatestLayoutVersion`. Versions above the latest
+fail with `ErrLayoutVersion` like `MaxOutputBytes` above. Version 2 writes empty
+dicts and lists as "{}" and "[]" in multi-line mode, separates block comments
+from adjacent elements of single-line dicts and lists by spaces, and keeps
+block comments between dict keys and values on the line of the key.
+
+`Atoms` controls unknown atoms: content other than strings, dicts, lists and
+punctuation which isn't a number, "true", "false" or "null", such as "NaN",
+"undefined", or a typo like "ture". `StrictnessPermissive`, the default, copies
+them as-is. `StrictnessWarn` reports them via `Warnings`. `StrictnessStrict`
+fails formatting with `*AtomError` like `MaxOutputBytes` above. Dict keys
+followed by a colon, such as unquoted JSON5 keys, and atoms containing
+placeholders, are always accepted.
+
+`MultiWordAtoms` keeps runs of unquoted words separated by spaces or tabs in a
+single atom, such as `one two` in `{name: one two}`, instead of splitting them
+into separate atoms. Atoms end at line breaks, punctuation, quotes and
+comments, and trailing spaces are dropped. Useful for HJSON-like input with
+unquoted strings, which is otherwise garbled. Unlike in HJSON, such strings
+can't contain commas, colons or brackets, and aren't converted to quoted
+strings.
+
+`Placeholders` lists delimiters of template placeholders, such as `{{ .val }}`
+or `${var}`, which are treated as opaque atoms, even when they contain
+whitespace or punctuation. Brackets and strings inside placeholders must be
+balanced. In dict key positions, placeholders not followed by `:` are treated as
+template directives, such as `{{ if .val }}`, and placed between entries.
+
+`Recognizers` lists custom tokens treated as opaque atoms like placeholders,
+such as `ISODate("...")` or `new Date(2024, 4, 1)`, which the permissive atom
+rule would split at whitespace and punctuation. See `CallRecognizer`. Atoms
+without whitespace or punctuation, such as `10L`, need no recognizers. Not
+serialized, since recognizers are code.
+
+`ConvertTo` converts the output to the given dialect, see `Dialect`, via the
+document model. Unquoted keys and single-quoted strings are quoted where the
+dialect requires it, and JSON5 numbers such as `0x10` are converted into JSON
+numbers. With `MultiWordAtoms`, unquoted strings are quoted, unless converting
+to HJSON. Comments, trailing commas and comment delimiters are adjusted to the
+dialect: converting to JSON implies `StrictOutput`. Values without an
+equivalent, such as `NaN`, are kept as-is. The input dialect is configured
+separately, see `WithInputDialect`.
 */
 type Conf struct {
-	Indent            string `json:"indent"`
-	Width             uint64 `json:"width"`
-	CommentLine       string `json:"commentLine"`
-	CommentBlockStart string `json:"commentBlockStart"`
-	CommentBlockEnd   string `json:"commentBlockEnd"`
-	TrailingComma     bool   `json:"trailingComma"`
-	StripComments     bool   `json:"stripComments"`
+	Indent                  string `json:"indent"`
+	Width                   uint64 `json:"width"`
+	CommentLine             string `json:"commentLine"`
+	CommentBlockStart       string `json:"commentBlockStart"`
+	CommentBlockEnd         string `json:"commentBlockEnd"`
+	OutputCommentLine       string `json:"outputCommentLine"`
+	OutputCommentBlockStart string `json:"outputCommentBlockStart"`
+	OutputCommentBlockEnd   string `json:"outputCommentBlockEnd"`
+	TrailingComma           bool   `json:"trailingComma"`
+	TrailingComments        bool   `json:"trailingComments"`
+	StripComments           bool   `json:"stripComments"`
+	NormalizeComments       bool   `json:"normalizeComments"`
+	CollapseComments        bool   `json:"collapseComments"`
+	FoldStrings             bool   `json:"foldStrings"`
+	SortKeys                bool   `json:"sortKeys"`
+	PreserveMultiline       bool   `json:"preserveMultiline"`
+	BraceSpacing            bool   `json:"braceSpacing"`
+	CloseSameLine           bool   `json:"closeSameLine"`
+	OpenNewline             bool   `json:"openNewline"`
+	ExpandEmpty             bool   `json:"expandEmpty"`
+	InlineSingle            bool   `json:"inlineSingle"`
+	BreakAfterKey           bool   `json:"breakAfterKey"`
+	StrictPunctuation       bool   `json:"strictPunctuation"`
+	FixSmartQuotes          bool   `json:"fixSmartQuotes"`
+	Skeleton                bool   `json:"skeleton"`
+	Repair                  bool   `json:"repair"`
+	Reindent                bool   `json:"reindent"`
+	MultiWordAtoms          bool   `json:"multiWordAtoms"`
+	StrictOutput            bool   `json:"strictOutput"`
+	SelfCheck               bool   `json:"selfCheck"`
+
+	CommentStyle    CommentStyle        `json:"commentStyle"`
+	CommentAttach   CommentAttach       `json:"commentAttach"`
+	ClosingComments ClosingComments     `json:"closingComments"`
+	CommentSpace    CommentSpace        `json:"commentSpace"`
+	CommentsToKey   string              `json:"commentsToKey"`
+	CommentsFromKey string              `json:"commentsFromKey"`
+	KeepComments    []string            `json:"keepComments"`
+	KeepRegexp      string              `json:"keepRegexp"`
+	CommentColumn   uint64              `json:"commentColumn"`
+	Overrides       []Override          `json:"overrides"`
+	KeySort         KeySort             `json:"keySort"`
+	KeyOrder        map[string][]string `json:"keyOrder"`
+	SortLists       []string            `json:"sortLists"`
+	FoldLists       []string            `json:"foldLists"`
+	ElideBlobs      uint64              `json:"elideBlobs"`
+	ExemptURLs      uint64              `json:"exemptUrls"`
+	NormalizeTimes  []string            `json:"normalizeTimes"`
+	DedupeLists     bool                `json:"dedupeLists"`
+	SortDicts       []string            `json:"sortDicts"`
+	Separator       Separator           `json:"separator"`
+	LineEnding      LineEnding          `json:"lineEnding"`
+	Atoms           Strictness          `json:"atoms"`
+	Noise           Noise               `json:"noise"`
+	Colon           string              `json:"colon"`
+	Comma           string              `json:"comma"`
+	Placeholders    []Placeholder       `json:"placeholders"`
+	Recognizers     []Recognizer        `json:"-"`
+	ConvertTo       Dialect             `json:"convertTo"`
+	MaxOutputBytes  uint64              `json:"maxOutputBytes"`
+	MaxDepth        uint64              `json:"maxDepth"`
+	Workers         uint64              `json:"workers"`
+	LayoutVersion   uint64              `json:"layoutVersion"`
+}
+
+// Order of keys sorted via `Conf.SortKeys`.
+type KeySort string
+
+const (
+	// Lexicographic order of bytes: "B" before "a", "item10" before "item2".
+	KeySortLexical KeySort = ``
+
+	// Runs of digits are compared by numeric value: "item2" before "item10".
+	KeySortNatural KeySort = `natural`
+
+	// Like `KeySortLexical`, ignoring case. Keys which differ only in case are
+	// ordered lexicographically.
+	KeySortIgnoreCase KeySort = `ignore-case`
+
+	// Combination of `KeySortNatural` and `KeySortIgnoreCase`.
+	KeySortNaturalIgnoreCase KeySort = `natural-ignore-case`
+)
+
+// How top-level values are separated. See `Conf.Separator`.
+type Separator string
+
+const (
+	// Newline after each value, unless `Conf.Indent` is empty.
+	SeparatorDefault Separator = ``
+
+	// Newline after each value, even when `Conf.Indent` is empty. With
+	// `Compact`, this produces newline-delimited JSON.
+	SeparatorNewline Separator = `newline`
+
+	// Like `SeparatorNewline`, with a blank line between values.
+	SeparatorBlank Separator = `blank`
+
+	// Wraps all top-level values into one list, even when there's only one.
+	SeparatorArray Separator = `array`
+
+	// JSON text sequences (RFC 7464): each value is preceded by the record
+	// separator character (0x1E) and followed by a newline. Record separators
+	// between top-level values of the input are treated as whitespace with any
+	// separator, so such sequences can be read back.
+	SeparatorRS Separator = `rs`
+)
+
+// Line breaks of the output. See `Conf.LineEnding`.
+type LineEnding string
+
+const (
+	// "\n" between lines written by the formatter. Line breaks inside block
+	// comments are kept as-is.
+	LineEndingDefault LineEnding = ``
+
+	// "\n" everywhere, including inside block comments.
+	LineEndingLF LineEnding = `lf`
+
+	// "\r\n" everywhere, including inside block comments.
+	LineEndingCRLF LineEnding = `crlf`
+
+	// Like `LineEndingCRLF` when "\r\n" is the dominant line ending of the
+	// source, otherwise like `LineEndingLF`. Avoids churn in repositories with
+	// files checked out with different line endings.
+	LineEndingPreserve LineEnding = `preserve`
+)
+
+// Replaces `LineEndingPreserve` with the dominant line ending of the source.
+func (self Conf) lineEnding(src string) Conf {
+	if self.LineEnding == LineEndingPreserve {
+		crlf := strings.Count(src, "\r\n")
+		if crlf > strings.Count(src, "\n")-crlf {
+			self.LineEnding = LineEndingCRLF
+		} else {
+			self.LineEnding = LineEndingLF
+		}
+	}
+	return self
+}
+
+// Record separator of JSON text sequences. See `SeparatorRS`.
+const recordSeparator = 0x1e
+
+// Delimiters of a template placeholder. See `Conf.Placeholders`.
+type Placeholder struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 const (
@@ -91,11 +774,132 @@ type Text interface{ ~string | ~[]byte }
 
 // Formats JSON according to the config. See `Conf`.
 func Format[Out, Src Text](conf Conf, src Src) Out {
-	fmter := fmter{source: text[string](src), conf: conf}
-	fmter.top()
-	return text[Out](fmter.buf.Bytes())
+	defer repanicErr()
+	return text[Out](format(nil, conf, nil, text[string](src)))
+}
+
+/*
+Formats JSON according to the config, writing the output to the given writer.
+Unlike `Format`, this doesn't accumulate the entire output in memory. Output is
+written in chunks as formatting progresses, which bounds memory usage roughly
+by the largest dict or list that's still being laid out on a single line.
+Returns the first write error, if any.
+*/
+func FormatTo[Src Text](conf Conf, out io.Writer, src Src) (err error) {
+	defer recoverErr(&err)
+	format(nil, conf, out, text[string](src))
+	return
+}
+
+/*
+Like `Format`, but checks the context for cancellation periodically, returning
+the context error once it's done, for example when a request deadline
+expires. Useful when formatting untrusted and potentially huge inputs.
+*/
+func FormatCtx[Out, Src Text](ctx context.Context, conf Conf, src Src) (_ Out, err error) {
+	defer recoverErr(&err)
+	return text[Out](format(ctx, conf, nil, text[string](src))), nil
 }
 
+// Like `FormatTo`, but checks the context for cancellation like `FormatCtx`.
+func FormatToCtx[Src Text](ctx context.Context, conf Conf, out io.Writer, src Src) (err error) {
+	defer recoverErr(&err)
+	format(ctx, conf, out, text[string](src))
+	return
+}
+
+/*
+Shared by the formatting functions. The context and the writer are optional.
+Errors are panicked as `fmterErr`. With `Conf.SelfCheck`, the output is
+accumulated and checked before being written.
+*/
+func format(ctx context.Context, conf Conf, out io.Writer, src string) []byte {
+	var fmter fmter
+	fmter.format(ctx, conf.prepare(), out, src)
+	return fmter.buf.Bytes()
+}
+
+// Settings derived from other settings, independent of the source.
+func (self Conf) prepare() Conf {
+	if self.ConvertTo != `` {
+		self = self.dialect()
+	}
+	if self.StrictOutput {
+		self = self.strict()
+	}
+	return self.layoutVersion()
+}
+
+/*
+Formats the source with a config from `Conf.prepare`, resetting the formatter
+but keeping its buffer, which lets `FormatAll` reuse it between sources. The
+buffer must be empty.
+*/
+func (self *fmter) format(ctx context.Context, conf Conf, out io.Writer, src string) {
+	src = fixNoise(conf, src)
+	if conf.FixSmartQuotes {
+		src = fixSmartQuotes(conf, src)
+	}
+	conf = conf.lineEnding(src)
+	checkPunctuation(conf, src)
+
+	*self = fmter{source: transform(conf, src), conf: conf, ctx: ctx, buf: self.buf}
+	self.overrides = overrides(conf, self.source)
+	if !conf.SelfCheck {
+		self.out = out
+	}
+	self.preallocate()
+	if ctx != nil {
+		self.checkCtx()
+	}
+	if conf.Workers > 1 && !conf.Repair && !conf.Reindent && self.overrides == nil && len(self.source) > batchSize {
+		self.startParallel(int(conf.Workers))
+		defer self.par.stop()
+	}
+
+	self.top()
+
+	if conf.SelfCheck {
+		self.selfCheck()
+		self.out = out
+	}
+	if self.out != nil {
+		self.flush()
+	}
+}
+
+// Returned or panicked when the output exceeds `Conf.MaxOutputBytes`.
+var ErrMaxOutputBytes = errors.New(`[jsonfmt] output exceeds the configured max size`)
+
+// Returned or panicked when dicts and lists are nested deeper than `Conf.MaxDepth`.
+var ErrMaxDepth = errors.New(`[jsonfmt] dicts and lists are nested too deeply`)
+
+// Used when `Conf.MaxDepth` is 0. Same as in "encoding/json".
+const DefaultMaxDepth = 10000
+
+// Returned or panicked when `Conf.LayoutVersion` is above the latest version.
+var ErrLayoutVersion = errors.New(`[jsonfmt] unsupported layout version`)
+
+// Layout version used when `Conf.LayoutVersion` is 0.
+const defaultLayoutVersion = 1
+
+// Latest layout version supported by this release.
+const latestLayoutVersion = 2
+
+/*
+Returns the version of the layout algorithm used when `Conf.LayoutVersion` is
+0. It never changes, so that upgrades don't change the output of callers who
+don't set `Conf.LayoutVersion`.
+*/
+func DefaultLayoutVersion() uint64 { return defaultLayoutVersion }
+
+/*
+Returns the latest version of the layout algorithm supported by this release.
+Setting `Conf.LayoutVersion` to this value opts into the latest layout, and
+keeps the output stable across later upgrades.
+*/
+func LatestLayoutVersion() uint64 { return latestLayoutVersion }
+
 // Formats JSON text according to config, returning a string.
 func FormatString[Src Text](conf Conf, src Src) string {
 	return Format[string](conf, src)
@@ -111,36 +915,277 @@ Shortcut that combines formatting with `json.Unmarshal`. Allows to decode JSON
 with comments or invalid punctuation, such as trailing commas. Slower than
 simply using `json.Unmarshal`. Avoid this when your input is guaranteed to be
 valid JSON, or when you should be enforcing valid JSON.
+
+Errors with positions, such as syntax errors and type mismatches, are returned
+as `*UnmarshalError`, with the position in the given source rather than in the
+formatted intermediary.
 */
-func Unmarshal[Src Text](src Src, out any) error {
-	return json.Unmarshal(Format[[]byte](Conf{}, src), out)
+func Unmarshal[Src Text](src Src, out any) (err error) {
+	defer recoverErr(&err)
+	source := text[string](src)
+	plain := fmter{source: source, conf: unmarshalConf}
+	plain.top()
+
+	err = json.Unmarshal(plain.buf.Bytes(), out)
+	if _, ok := errorOffset(err); !ok {
+		return err
+	}
+
+	// Source offsets take several times more memory than the output, so they're
+	// tracked only to translate the position of an error.
+	tracked := fmter{source: source, conf: unmarshalConf, track: true}
+	tracked.top()
+	return unmarshalError(source, tracked.offsets, err)
+}
+
+/*
+Config of the intermediary of `Unmarshal`. Like `Conf{}`, but recognizes and
+strips comments, which `json.Unmarshal` would reject.
+*/
+var unmarshalConf = Conf{
+	CommentLine:       `//`,
+	CommentBlockStart: `/*`,
+	CommentBlockEnd:   `*/`,
+	StripComments:     true,
+}
+
+/*
+Like `Unmarshal`, but the source must contain exactly one value. Content after
+the first value, other than whitespace, comments and stray commas or colons,
+causes an `*UnmarshalError` wrapping `ErrLeftover`, with the position of that
+content. `Unmarshal` would instead merge or drop it, for example decoding
+"10 20" as 1020.
+*/
+func UnmarshalSingle[Src Text](src Src, out any) error {
+	source := text[string](src)
+	fmter := fmter{source: source, conf: Compact, discard: true}
+	if pos := fmter.leftover(); pos >= 0 {
+		line, col := lineCol(source, pos)
+		return &UnmarshalError{Offset: pos, Line: line, Column: col, Err: ErrLeftover}
+	}
+	return Unmarshal(source, out)
+}
+
+/*
+Formats every file in the given FS for which `match` returns true, walking the
+FS recursively. When `match` is nil, all files are formatted. Returns formatted
+contents keyed by path. Stops at the first file which fails to format, for
+example with `ErrMaxDepth`, returning an error which names its path. To write
+the results back into a real directory, use `WriteDir`:
+
+	dir := `config`
+	files, err := jsonfmt.FormatFS(conf, os.DirFS(dir), isJson)
+	if err != nil {
+		return err
+	}
+	return jsonfmt.WriteDir(dir, files)
+*/
+func FormatFS(conf Conf, fsys fs.FS, match func(string) bool) (map[string][]byte, error) {
+	out := map[string][]byte{}
+
+	err := fs.WalkDir(fsys, `.`, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || match != nil && !match(path) {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+
+		out[path], err = formatFile(conf, content)
+		if err != nil {
+			return fmt.Errorf(`[jsonfmt] failed to format %q: %w`, path, err)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// Like `FormatBytes`, but returns errors such as `ErrMaxDepth` instead of panicking.
+func formatFile(conf Conf, src []byte) (_ []byte, err error) {
+	defer recoverErr(&err)
+	return format(nil, conf, nil, text[string](src)), nil
+}
+
+/*
+Writes files into the directory at the given path, where each key is a
+slash-separated path relative to that directory, as returned by `FormatFS`.
+Files whose content is unchanged are not rewritten. Existing files keep their
+permissions.
+*/
+func WriteDir(dir string, files map[string][]byte) error {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		mode := fs.FileMode(0o666)
+
+		prev, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(prev, content) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err == nil {
+			mode = info.Mode().Perm()
+		}
+
+		err = os.WriteFile(path, content, mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type fmter struct {
-	source   string
-	cursor   int
-	conf     Conf
-	buf      bytes.Buffer
-	indent   int
-	row      int
-	col      int
-	discard  bool
-	snapshot *fmter
+	source    string
+	cursor    int
+	conf      Conf
+	buf       bytes.Buffer
+	indent    int
+	row       int
+	col       int
+	discard   bool
+	snapshots []mark
+	colLimit  int
+	comments  map[int]bool
+	depth     int
+	blank     bool
+	par       *parallel
+	value     bool
+	out       io.Writer
+	tail      byte
+	offsets   []int
+	track     bool
+	ctx       context.Context
+	steps     uint
+	flushed   uint64
+	overrides map[int]Conf
+
+	// Width of strings on the current line exempt from `Conf.Width`. See
+	// `Conf.ExemptURLs`.
+	exempt int
+
+	// Position of the last node of `Parse`. See `fmter.pos`.
+	lastPos Pos
+
+	// Output length after the space following a comment in a single-line dict
+	// or list. See `fmter.commentInline`.
+	commentSpace int
+}
+
+// Minimum amount of buffered output flushed to `fmter.out`.
+const flushSize = 1 << 16
+
+// Amount of source sampled by `fmter.sizeHint`.
+const sampleSize = 1 << 16
+
+/*
+Grows the buffer up front, avoiding repeated regrowth and copying on large
+inputs. When writing to `fmter.out`, the buffer only needs to fit a chunk.
+*/
+func (self *fmter) preallocate() {
+	size := self.sizeHint()
+	if self.out != nil && size > flushSize*2 {
+		size = flushSize * 2
+	}
+	self.buf.Grow(size)
+}
+
+/*
+Estimated output size. Output consists mostly of the non-whitespace content of
+the source, which is estimated from a sample, and indentation, which roughly
+doubles it in typical documents.
+*/
+func (self *fmter) sizeHint() int {
+	sample := self.source
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+	if len(sample) == 0 {
+		return 0
+	}
+
+	content := 0
+	for ind := 0; ind < len(sample); ind++ {
+		if byteClasses[sample[ind]]&classSpace == 0 {
+			content++
+		}
+	}
+
+	size := content * len(self.source) / len(sample)
+	if self.whitespace() {
+		size *= 2
+	}
+	return size + size/16
+}
+
+// How many scanning steps to take between checks for context cancellation.
+const ctxSteps = 1 << 12
+
+// Used for errors that abort formatting. See `recoverErr`.
+type fmterErr struct{ error }
+
+func recoverErr(ptr *error) {
+	val := recover()
+	if err, ok := val.(fmterErr); ok {
+		*ptr = err.error
+	} else if val != nil {
+		panic(val)
+	}
+}
+
+// Used by functions which can't return errors, such as `Format`.
+func repanicErr() {
+	val := recover()
+	if err, ok := val.(fmterErr); ok {
+		panic(err.error)
+	} else if val != nil {
+		panic(val)
+	}
 }
 
 func (self *fmter) top() {
+	if self.conf.Repair {
+		self.repairTop()
+		return
+	}
+	if self.conf.Reindent {
+		self.reindentTop()
+		return
+	}
+
 	for self.more() {
 		if self.skipped() {
 			continue
 		}
 
+		if self.isNextByte('}') || self.isNextByte(']') {
+			self.skipChar()
+			continue
+		}
+
+		if self.blank {
+			self.writeLineEnd()
+			self.blank = false
+		}
+
 		if self.isNextComment() {
 			assert(self.scannedAny())
 			continue
 		}
 
-		if self.scannedAny() {
-			self.writeMaybeNewline()
+		if self.conf.Separator == SeparatorRS {
+			self.writeByte(recordSeparator)
+		}
+
+		if self.pasted() || self.scannedAny() {
+			self.writeTopSeparator()
+			self.blank = self.conf.Separator == SeparatorBlank
+			self.maybeFlush()
 			continue
 		}
 
@@ -148,8 +1193,21 @@ func (self *fmter) top() {
 	}
 }
 
+func (self *fmter) writeTopSeparator() {
+	switch self.conf.Separator {
+	case SeparatorNewline, SeparatorBlank, SeparatorRS:
+		if !self.hasNewlineSuffix() {
+			self.writeLineEnd()
+		}
+	default:
+		self.writeMaybeNewline()
+	}
+}
+
 func (self *fmter) any() {
-	if self.isNextByte('{') {
+	if self.isNextPlaceholder() {
+		self.placeholder()
+	} else if self.isNextByte('{') {
 		self.dict()
 	} else if self.isNextByte('[') {
 		self.list()
@@ -168,23 +1226,136 @@ func (self *fmter) scannedAny() bool {
 	return self.scanned((*fmter).any)
 }
 
+func (self *fmter) scannedValue() bool {
+	self.value = true
+	defer self.setValue(false)
+	return self.scannedAny()
+}
+
 func (self *fmter) dict() {
-	if !self.preferSingle() || !self.scanned((*fmter).dictSingle) {
+	self.nest()
+	defer self.unnest()
+	if conf, ok := self.overrides[self.cursor]; ok {
+		defer self.setConf(self.conf)
+		self.conf = conf
+	}
+
+	value := self.takeValue()
+	if self.empty('}') || self.inlined('}', (*fmter).dictSingle) {
+		return
+	}
+	if !self.preferSingle() || self.isNextTooWide() || !self.scanned((*fmter).dictSingle) {
+		self.writeMaybeOpenerNewline(value)
 		self.dictMulti()
 	}
 }
 
+/*
+Writes an empty dict or list compactly, regardless of layout, and returns true.
+Contains nothing but whitespace, punctuation, or stripped comments. See
+`Conf.ExpandEmpty`. Since layout version 2.
+*/
+func (self *fmter) empty(closer byte) bool {
+	if self.conf.ExpandEmpty || self.conf.LayoutVersion < 2 {
+		return false
+	}
+
+	prev := self.mark()
+	self.byte()
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.closer(closer)
+			return true
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() && self.stripsComment() {
+			self.any()
+			continue
+		}
+		break
+	}
+
+	self.reset(prev)
+	return false
+}
+
+/*
+Writes a dict or list with one scalar entry on a single line, regardless of
+width, and returns true. Comments may still prevent this. See
+`Conf.InlineSingle`.
+*/
+func (self *fmter) inlined(closer byte, fun func(*fmter)) bool {
+	if !self.conf.InlineSingle || !self.isNextSingleScalar(closer) {
+		return false
+	}
+
+	defer self.setWidth(self.conf.Width)
+	self.conf.Width = 0
+	return self.scanned(fun)
+}
+
+// True if the next dict or list has one entry, which isn't a dict or list.
+func (self *fmter) isNextSingleScalar(closer byte) bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	limit := 1
+	if closer == '}' {
+		limit = 2
+	}
+
+	self.skipByte()
+	count := 0
+
+	for self.more() {
+		if self.isNextCloser() {
+			return count == limit
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			self.any()
+			continue
+		}
+
+		if self.isNextByte('{') || self.isNextByte('[') || !self.scanned((*fmter).any) {
+			return false
+		}
+
+		count++
+		if count > limit {
+			return false
+		}
+	}
+	return false
+}
+
 func (self *fmter) dictSingle() {
-	prev := self.snap()
-	defer self.maybeRollback(prev)
+	self.snap()
+	defer self.maybeRollback()
 
 	assert(self.isNextByte('{'))
 	self.byte()
 	key := true
+	spaced := false
 
 	for self.more() {
-		if self.isNextByte('}') {
-			self.byte()
+		if self.isNextCloser() {
+			self.unwriteCommentSpace()
+			if spaced {
+				self.writeByte(separator)
+			}
+			self.closer('}')
 			return
 		}
 
@@ -192,23 +1363,35 @@ func (self *fmter) dictSingle() {
 			continue
 		}
 
+		if !spaced && self.braceSpacing() && !(self.isNextComment() && self.stripsComment()) {
+			self.writeByte(separator)
+			spaced = true
+		}
+
 		if self.isNextComment() {
-			assert(self.scannedAny())
+			self.commentInline()
+			continue
+		}
+
+		if key && self.isNextDirective() {
+			self.placeholder()
+			if self.hasNonCommentsBefore() {
+				self.writeMaybeSeparator()
+			}
 			continue
 		}
 
 		if key {
 			assert(self.scannedAny())
-			self.writeByte(':')
-			self.writeMaybeSeparator()
+			self.keyComments()
+			self.writeColon()
 			key = false
 			continue
 		}
 
-		assert(self.scannedAny())
-		if self.hasNonCommentsBefore('}') {
-			self.writeByte(',')
-			self.writeMaybeSeparator()
+		assert(self.scannedValue())
+		if self.hasNonCommentsBefore() {
+			self.writeComma()
 		}
 		key = true
 	}
@@ -222,58 +1405,210 @@ func (self *fmter) dictMulti() {
 	key := true
 
 	for self.more() {
-		if self.isNextByte('}') {
+		self.maybeFlush()
+
+		if self.isNextCloser() {
 			self.indent--
+			self.writeMaybeCloserIndent()
+			self.closer('}')
+			return
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			if !key && self.isNextValueComment() {
+				self.commentInlineBlock()
+				continue
+			}
+			self.writeMaybeCommentNewlineIndent()
+			if key {
+				assert(self.scannedAny())
+			} else {
+				self.commentValueBlock()
+			}
+			continue
+		}
+
+		if key && self.isNextDirective() {
 			self.writeMaybeNewlineIndent()
-			self.byte()
+			self.placeholder()
+			continue
+		}
+
+		if key {
+			self.writeMaybeNewlineIndent()
+			assert(self.scannedAny())
+			self.keyComments()
+			self.writeColon()
+			key = false
+			continue
+		}
+
+		self.writeMaybeValueBreak()
+		assert(self.scannedValue())
+		if self.hasNonCommentsBefore() {
+			self.writeCommaMulti()
+		} else {
+			self.writeMaybeTrailingComma()
+		}
+		key = true
+	}
+}
+
+/*
+Writes a comment in a single-line dict or list. Since layout version 2, block
+comments are separated from adjacent elements by spaces, see
+`fmter.spacesComments`. Multi-line block comments roll back the single-line
+attempt, unless collapsed, see `Conf.CollapseComments`.
+*/
+func (self *fmter) commentInline() {
+	if !self.spacesComments() || self.stripsComment() {
+		assert(self.scannedAny())
+		return
+	}
+
+	self.writeCommentSpace()
+	assert(self.scannedAny())
+	self.writeByte(separator)
+	self.commentSpace = self.buf.Len()
+}
+
+// Writes the space before an inline comment. See `Conf.CommentSpace`.
+func (self *fmter) writeCommentSpace() {
+	if self.conf.CommentSpace == CommentSpaceNone {
+		self.unwriteSeparators()
+		return
+	}
+
+	if !self.hasSpaceSuffix() {
+		self.writeByte(separator)
+	}
+	if self.conf.CommentSpace == CommentSpaceAlign {
+		self.writeCommentPadding()
+	}
+}
+
+/*
+Writes block comments between a dict key and the colon on the line of the key,
+before the colon. Since layout version 2. Previously, they were written after
+the colon, and in multi-line dicts, on their own lines.
+*/
+func (self *fmter) keyComments() {
+	if !self.spacesComments() {
+		return
+	}
+
+	for self.more() {
+		prev := self.mark()
+		self.skippedSpace()
+		if !self.isNextComment() || self.isNextCommentSingle() {
+			self.reset(prev)
 			return
 		}
+		self.commentInlineBlock()
+		self.unwriteCommentSpace()
+	}
+}
+
+/*
+Writes a block comment between a dict key and its value. With
+`CommentStyleLine`, it stays a block comment, since a single-line comment would
+end the line in the middle of the entry.
+*/
+func (self *fmter) commentInlineBlock() {
+	defer self.keepBlockComments()()
+	self.commentInline()
+}
+
+/*
+Writes a comment between a dict key and its value in layout version 1, which
+writes it on its own line, followed by the value. Like `commentInlineBlock`, a
+block comment stays a block comment, since the value follows on the same line.
+*/
+func (self *fmter) commentValueBlock() {
+	defer self.keepBlockComments()()
+	assert(self.scannedAny())
+}
 
-		if self.skipped() {
-			continue
-		}
+/*
+Suspends `CommentStyleLine`, returning a function which restores it. Other
+styles are unaffected.
+*/
+func (self *fmter) keepBlockComments() func() {
+	style := self.conf.CommentStyle
+	if style != CommentStyleLine {
+		return func() {}
+	}
+	self.conf.CommentStyle = CommentStyleKeep
+	return func() { self.conf.CommentStyle = style }
+}
 
-		if self.isNextComment() {
-			self.writeMaybeCommentNewlineIndent()
-			assert(self.scannedAny())
-			continue
-		}
+/*
+True if the comment at the cursor, between the colon and the value of an entry
+of a multi-line dict, stays on the line of the key. Since layout version 2.
+Single-line comments always end the line.
+*/
+func (self *fmter) isNextValueComment() bool {
+	return self.spacesComments() && !self.isNextCommentSingle()
+}
 
-		if key {
-			self.writeMaybeNewlineIndent()
-			assert(self.scannedAny())
-			self.writeByte(':')
-			self.writeMaybeSeparator()
-			key = false
-			continue
-		}
+// Removes the space after a comment which precedes a closing bracket.
+func (self *fmter) unwriteCommentSpace() {
+	if self.spacesComments() && self.commentSpace == self.buf.Len() {
+		self.unwriteSeparators()
+	}
+}
 
-		assert(self.scannedAny())
-		if self.hasNonCommentsBefore('}') {
-			self.writeByte(',')
-		} else {
-			self.writeMaybeTrailingComma()
-		}
-		key = true
+func (self *fmter) spacesComments() bool {
+	return self.conf.LayoutVersion >= 2 && self.whitespace()
+}
+
+// True if the output ends with a space or an opening bracket.
+func (self *fmter) hasSpaceSuffix() bool {
+	content := self.buf.Bytes()
+	if len(content) == 0 {
+		return true
+	}
+	switch content[len(content)-1] {
+	case separator, '[', '{':
+		return true
+	default:
+		return false
 	}
 }
 
 func (self *fmter) list() {
-	if !self.preferSingle() || !self.scanned((*fmter).listSingle) {
+	self.nest()
+	defer self.unnest()
+	if conf, ok := self.overrides[self.cursor]; ok {
+		defer self.setConf(self.conf)
+		self.conf = conf
+	}
+
+	value := self.takeValue()
+	if self.empty(']') || self.inlined(']', (*fmter).listSingle) {
+		return
+	}
+	if !self.preferSingle() || self.isNextTooWide() || !self.scanned((*fmter).listSingle) {
+		self.writeMaybeOpenerNewline(value)
 		self.listMulti()
 	}
 }
 
 func (self *fmter) listSingle() {
-	prev := self.snap()
-	defer self.maybeRollback(prev)
+	self.snap()
+	defer self.maybeRollback()
 
 	assert(self.isNextByte('['))
 	self.byte()
 
 	for self.more() {
-		if self.isNextByte(']') {
-			self.byte()
+		if self.isNextCloser() {
+			self.unwriteCommentSpace()
+			self.closer(']')
 			return
 		}
 
@@ -282,14 +1617,13 @@ func (self *fmter) listSingle() {
 		}
 
 		if self.isNextComment() {
-			assert(self.scannedAny())
+			self.commentInline()
 			continue
 		}
 
 		assert(self.scannedAny())
-		if self.hasNonCommentsBefore(']') {
-			self.writeByte(',')
-			self.writeMaybeSeparator()
+		if self.hasNonCommentsBefore() {
+			self.writeComma()
 		}
 	}
 }
@@ -301,10 +1635,12 @@ func (self *fmter) listMulti() {
 	self.writeMaybeNewline()
 
 	for self.more() {
-		if self.isNextByte(']') {
+		self.maybeFlush()
+
+		if self.isNextCloser() {
 			self.indent--
-			self.writeMaybeNewlineIndent()
-			self.byte()
+			self.writeMaybeCloserIndent()
+			self.closer(']')
 			return
 		}
 
@@ -319,20 +1655,60 @@ func (self *fmter) listMulti() {
 		}
 
 		self.writeMaybeNewlineIndent()
-		assert(self.scannedAny())
-		if self.hasNonCommentsBefore(']') {
-			self.writeByte(',')
+		if !self.pasted() {
+			assert(self.scannedAny())
+		}
+		if self.hasNonCommentsBefore() {
+			self.writeCommaMulti()
 		} else {
 			self.writeMaybeTrailingComma()
 		}
 	}
 }
 
+/*
+Any closing bracket ends the current dict or list, and is replaced with the
+right one. Like in `Conf.Repair`, a mismatched bracket is most likely a typo.
+*/
+func (self *fmter) closer(char byte) {
+	self.skipByte()
+	self.writeByte(char)
+}
+
 func (self *fmter) string() {
+	if self.elidesStrings() && self.elidedString() {
+		return
+	}
+	if self.foldsString() {
+		self.stringFolded()
+		return
+	}
+	if self.conf.ExemptURLs > 0 {
+		self.exemptString()
+	}
+	self.stringPlain()
+}
+
+/*
+Copies runs between escapes in bulk. The closing quote is found once, and
+`strings.IndexByte` is much faster than decoding rune by rune.
+*/
+func (self *fmter) stringPlain() {
 	assert(self.isNextByte('"'))
 	self.byte()
+	quote := -1
 
 	for self.more() {
+		if quote < self.cursor {
+			quote = indexFrom(self.source, self.cursor, '"')
+		}
+
+		end := quote
+		if esc := strings.IndexByte(self.source[self.cursor:end], '\\'); esc >= 0 {
+			end = self.cursor + esc
+		}
+		self.copyTo(end)
+
 		if self.isNextByte('"') {
 			self.byte()
 			return
@@ -343,10 +1719,7 @@ func (self *fmter) string() {
 			if self.more() {
 				self.char()
 			}
-			continue
 		}
-
-		self.char()
 	}
 }
 
@@ -354,27 +1727,25 @@ func (self *fmter) commentSingle() {
 	prefix := self.nextCommentSingle()
 	assert(prefix != ``)
 
-	if self.conf.StripComments {
+	if self.stripsComment() {
+		defer self.setDiscard(self.discard)
 		self.setDiscard(true)
-		defer self.setDiscard(false)
 	}
 
-	self.strInc(prefix)
-
-	for self.more() {
-		if self.isNextPrefix("\r\n") {
-			self.skipString("\r\n")
-			self.writeNewline()
-			return
-		}
+	if self.rewritesComments() {
+		self.commentSingleRewritten(prefix)
+		return
+	}
 
-		if self.isNextByte('\n') || self.isNextByte('\r') {
-			self.skipByte()
-			self.writeNewline()
-			return
-		}
+	self.strInc(prefix)
+	self.copyTo(self.lineEnd())
 
-		self.char()
+	if self.isNextPrefix("\r\n") {
+		self.skipString("\r\n")
+		self.writeNewline()
+	} else if self.more() {
+		self.skipByte()
+		self.writeNewline()
 	}
 }
 
@@ -382,9 +1753,19 @@ func (self *fmter) commentMulti() {
 	prefix, suffix := self.nextCommentMulti()
 	assert(prefix != `` && suffix != ``)
 
-	if self.conf.StripComments {
+	if self.stripsComment() {
+		defer self.setDiscard(self.discard)
 		self.setDiscard(true)
-		defer self.setDiscard(false)
+	}
+
+	if self.collapsesComment() {
+		self.commentMultiCollapsed()
+		return
+	}
+
+	if self.rewritesComments() {
+		self.commentMultiRewritten(prefix, suffix)
+		return
 	}
 
 	self.strInc(prefix)
@@ -400,106 +1781,500 @@ func (self *fmter) commentMulti() {
 			continue
 		}
 
-		if self.isNextPrefix(prefix) {
-			self.strInc(prefix)
-			level++
-			continue
+		if self.isNextPrefix(prefix) {
+			self.strInc(prefix)
+			level++
+			continue
+		}
+
+		if self.conf.LineEnding == LineEndingDefault {
+			self.copyTo(self.delimEnd(prefix, suffix))
+		} else {
+			end := self.delimEnd(prefix, suffix)
+			self.writeLines(self.source[self.cursor:end])
+			self.cursor = end
+		}
+	}
+}
+
+// Placeholders may be embedded in atoms, such as `prefix-${var}`.
+func (self *fmter) atom() {
+	start := self.cursor
+	for self.more() {
+		if self.isNextPlaceholder() {
+			self.placeholder()
+			continue
+		}
+		if self.isNextAtomSpace() {
+			for self.isNextByte(' ') || self.isNextByte('\t') {
+				self.byte()
+			}
+			continue
+		}
+		if self.isNextSpace() || self.isNextTerminal() {
+			break
+		}
+		self.char()
+	}
+	self.checkAtom(start)
+}
+
+/*
+True if the spaces or tabs at the cursor are followed by more of the same atom
+on the same line, rather than by punctuation, a comment or a line break. Only
+with `Conf.MultiWordAtoms`.
+*/
+func (self *fmter) isNextAtomSpace() bool {
+	if !self.conf.MultiWordAtoms {
+		return false
+	}
+
+	src := self.source
+	ind := self.cursor
+	for ind < len(src) && (src[ind] == ' ' || src[ind] == '\t') {
+		ind++
+	}
+	if ind == self.cursor || ind >= len(src) {
+		return false
+	}
+
+	defer self.setCursor(self.cursor)
+	self.cursor = ind
+	return !self.isNextSpace() && !self.isNextTerminal()
+}
+
+/*
+Brackets are tracked so that placeholders such as `${fun({a = 1})}` end at the
+right delimiter. Strings are skipped because they may contain anything.
+*/
+func (self *fmter) placeholder() {
+	delim := self.nextPlaceholder()
+	if delim.Start == `` {
+		self.token()
+		return
+	}
+	assert(delim.End != ``)
+
+	self.strInc(delim.Start)
+	depth := 0
+
+	for self.more() {
+		if depth == 0 && self.isNextPrefix(delim.End) {
+			self.strInc(delim.End)
+			return
+		}
+
+		if self.isNextByte('"') {
+			self.string()
+			continue
+		}
+
+		if self.isNextByte('{') || self.isNextByte('[') || self.isNextByte('(') {
+			depth++
+		} else if depth > 0 && (self.isNextByte('}') || self.isNextByte(']') || self.isNextByte(')')) {
+			depth--
+		}
+		self.char()
+	}
+}
+
+func (self *fmter) char() {
+	if self.more() && self.headByte() < utf8.RuneSelf {
+		self.byte()
+		return
+	}
+
+	char, size := utf8.DecodeRuneInString(self.rest())
+	assert(size > 0)
+	self.writeRune(char)
+	self.cursor += size
+}
+
+func (self *fmter) byte() {
+	self.writeByte(self.source[self.cursor])
+	self.cursor++
+}
+
+/*
+Copies the source up to the given offset in bulk. When tracking offsets for
+`Unmarshal`, each output byte is attributed to its own source byte.
+*/
+func (self *fmter) copyTo(end int) {
+	str := self.source[self.cursor:end]
+	self.writeString(str)
+
+	if self.track && !self.discard {
+		offsets := self.offsets[len(self.offsets)-len(str):]
+		for ind := range offsets {
+			offsets[ind] = self.cursor + ind
+		}
+	}
+	self.cursor = end
+}
+
+// Offset of the next newline or the end of the source.
+func (self *fmter) lineEnd() int {
+	return self.cursor + indexAnyOrLen(self.rest(), "\n\r")
+}
+
+/*
+Offset of the next delimiter of a multi-line comment, which may be nested, or
+the end of the source.
+*/
+func (self *fmter) delimEnd(prefix, suffix string) int {
+	rest := self.rest()
+	end := indexOrLen(rest, suffix)
+	if ind := strings.Index(rest[:end], prefix); ind >= 0 {
+		end = ind
+	}
+	return self.cursor + end
+}
+
+func (self *fmter) writeByte(char byte) {
+	if char >= utf8.RuneSelf {
+		self.writeRune(rune(char))
+		return
+	}
+	if self.discard {
+		return
+	}
+
+	self.advance(char == '\n' || char == '\r')
+	self.buf.WriteByte(char)
+	self.afterWrite(1)
+}
+
+func (self *fmter) writeRune(char rune) {
+	if self.discard {
+		return
+	}
+
+	self.advance(char == '\n' || char == '\r')
+	size, _ := self.buf.WriteRune(char)
+	self.afterWrite(size)
+}
+
+// Writes in bulk, which is much faster than writing rune by rune.
+func (self *fmter) writeString(str string) {
+	if self.discard || str == `` {
+		return
+	}
+
+	// Single pass, since most writes are short: indents, punctuation, atoms.
+	rows, last, wide := 0, -1, false
+	for ind := 0; ind < len(str); ind++ {
+		char := str[ind]
+		if char == '\n' || char == '\r' {
+			rows++
+			last = ind
+		} else if char >= utf8.RuneSelf {
+			wide = true
+		}
+	}
+
+	col := len(str) - last - 1
+	if wide {
+		col = utf8.RuneCountInString(str[last+1:])
+	}
+
+	if rows == 0 {
+		self.col += col
+	} else {
+		self.newline()
+		self.row += rows
+		self.col = col
+		self.exempt = 0
+	}
+
+	self.buf.WriteString(str)
+	self.afterWrite(len(str))
+}
+
+func (self *fmter) advance(newline bool) {
+	if newline {
+		self.newline()
+		self.row++
+		self.col = 0
+		self.exempt = 0
+	} else {
+		self.col++
+	}
+}
+
+// ALL writes must call this function after writing the given number of bytes.
+func (self *fmter) afterWrite(size int) {
+	self.checkOutputSize()
+	if self.track {
+		for ; size > 0; size-- {
+			self.offsets = append(self.offsets, self.cursor)
+		}
+	}
+
+	if self.colLimit > 0 && self.col-self.exempt > self.colLimit {
+		panic(rollback)
+	}
+}
+
+// Called before writing a newline, which ends any single-line attempt.
+func (self *fmter) newline() {
+	if len(self.snapshots) > 0 {
+		panic(rollback)
+	}
+}
+
+func (self *fmter) writeMaybeSeparator() {
+	if self.whitespace() {
+		self.writeByte(separator)
+	}
+}
+
+func (self *fmter) writeColon() {
+	if self.conf.Colon == `` {
+		self.writeByte(':')
+		self.writeMaybeSeparator()
+	} else {
+		self.writeString(self.conf.Colon)
+	}
+}
+
+func (self *fmter) writeComma() {
+	if self.conf.Comma == `` {
+		self.writeByte(',')
+		self.writeMaybeSeparator()
+	} else {
+		self.writeString(self.conf.Comma)
+	}
+}
+
+// In multi-line mode, a newline follows instead of the spaces after the comma.
+func (self *fmter) writeCommaMulti() {
+	if self.conf.Comma == `` {
+		self.writeByte(',')
+	} else if self.whitespace() {
+		self.writeString(strings.TrimRight(self.conf.Comma, ` `))
+	} else {
+		self.writeString(self.conf.Comma)
+	}
+}
+
+func (self *fmter) writeMaybeTrailingComma() {
+	if self.conf.TrailingComma && !self.conf.CloseSameLine {
+		self.writeString(strings.TrimRight(self.conf.Comma, ` `))
+		if self.conf.Comma == `` {
+			self.writeByte(',')
+		}
+	}
+}
+
+func (self *fmter) writeMaybeNewline() {
+	if self.whitespace() && !self.hasNewlineSuffix() {
+		self.writeLineEnd()
+	}
+}
+
+func (self *fmter) writeNewline() {
+	if !self.wrote((*fmter).writeMaybeNewline) {
+		self.writeLineEnd()
+	}
+}
+
+// All line breaks written by the formatter go through this. See `Conf.LineEnding`.
+func (self *fmter) writeLineEnd() {
+	if self.conf.LineEnding == LineEndingCRLF {
+		self.writeByte('\r')
+	}
+	self.writeByte(newline)
+}
+
+/*
+Writes text from the source, such as a block comment, replacing its line breaks
+according to `Conf.LineEnding`. By default, the text is written as-is.
+*/
+func (self *fmter) writeLines(str string) {
+	if self.conf.LineEnding == LineEndingDefault {
+		self.writeString(str)
+		return
+	}
+
+	for {
+		ind := strings.IndexAny(str, "\r\n")
+		if ind < 0 {
+			self.writeString(str)
+			return
 		}
 
-		self.char()
+		self.writeString(str[:ind])
+		if strings.HasPrefix(str[ind:], "\r\n") {
+			ind++
+		}
+		str = str[ind+1:]
+		self.writeLineEnd()
 	}
 }
 
-func (self *fmter) atom() {
-	for self.more() && !self.isNextSpace() && !self.isNextTerminal() {
-		self.char()
+func (self *fmter) writeIndent() {
+	for i := 0; i < self.indent; i++ {
+		self.writeString(self.conf.Indent)
 	}
 }
 
-func (self *fmter) char() {
-	char, size := utf8.DecodeRuneInString(self.rest())
-	assert(size > 0)
-	self.writeRune(char)
-	self.cursor += size
+func (self *fmter) writeMaybeNewlineIndent() {
+	if self.whitespace() {
+		self.writeMaybeNewline()
+		self.writeIndent()
+	}
 }
 
-func (self *fmter) byte() {
-	self.writeByte(self.source[self.cursor])
-	self.cursor++
+/*
+Precedes the closing bracket of a multi-line dict or list. With
+`Conf.CloseSameLine`, the bracket follows the last element, unless that's a
+single-line comment.
+*/
+func (self *fmter) writeMaybeCloserIndent() {
+	if self.conf.CloseSameLine && !self.hasNewlineSuffix() {
+		return
+	}
+	self.writeMaybeNewlineIndent()
 }
 
-// Performance seems fine, probably because `bytes.Buffer` short-circuits ASCII runes.
-func (self *fmter) writeByte(char byte) {
-	self.writeRune(rune(char))
+/*
+Precedes the opening bracket of a multi-line dict or list. With
+`Conf.OpenNewline`, dict values start on a new line, replacing the separator
+after the colon.
+*/
+func (self *fmter) writeMaybeOpenerNewline(value bool) {
+	if !value || !self.conf.OpenNewline || !self.whitespace() {
+		return
+	}
+	self.unwriteSeparators()
+	self.writeMaybeNewlineIndent()
 }
 
-// ALL writes must call this function.
-func (self *fmter) writeRune(char rune) {
-	if self.discard {
+/*
+Precedes a dict value in multi-line mode. With `Conf.BreakAfterKey`, a scalar
+value which would exceed the width starts on a new line, indented one level
+deeper than the key.
+*/
+func (self *fmter) writeMaybeValueBreak() {
+	if !self.conf.BreakAfterKey || !self.preferSingle() || !self.whitespace() ||
+		self.isNextByte('{') || self.isNextByte('[') || self.isNextComment() {
 		return
 	}
 
-	if char == '\n' || char == '\r' {
-		self.row++
-		self.col = 0
-	} else {
-		self.col++
+	if self.col+self.nextWidth() <= int(self.conf.Width) {
+		return
 	}
 
-	self.buf.WriteRune(char)
+	self.unwriteSeparators()
+	self.indent++
+	self.writeMaybeNewlineIndent()
+	self.indent--
+}
+
+// Width of the next value in runes, assuming it's a scalar.
+func (self *fmter) nextWidth() int {
+	defer self.reset(self.mark())
 
-	if self.snapshot != nil && self.exceedsLine(self.snapshot) {
-		panic(rollback)
-	}
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	start := self.cursor
+	self.any()
+	return utf8.RuneCountInString(self.source[start:self.cursor])
 }
 
-func (self *fmter) writeString(str string) {
-	for _, char := range str {
-		self.writeRune(char)
+// Removes trailing separator spaces, such as after a colon.
+func (self *fmter) unwriteSeparators() {
+	for bytes.HasSuffix(self.buf.Bytes(), []byte{separator}) {
+		self.buf.Truncate(self.buf.Len() - 1)
+		self.truncateOffsets()
+		self.col--
 	}
 }
 
-func (self *fmter) writeMaybeSeparator() {
-	if self.whitespace() {
-		self.writeByte(separator)
+func (self *fmter) writeMaybeCommentNewlineIndent() {
+	if self.stripsComment() {
+		return
+	}
+	if self.isTrailingComment() {
+		self.writeMaybeSeparator()
+		self.writeCommentPadding()
+		return
 	}
+	self.writeMaybeNewlineIndent()
 }
 
-func (self *fmter) writeMaybeTrailingComma() {
-	if self.conf.TrailingComma {
-		self.writeByte(',')
+// Aligns a trailing comment. See `Conf.CommentColumn`.
+func (self *fmter) writeCommentPadding() {
+	for self.col < int(self.conf.CommentColumn) {
+		self.writeByte(separator)
 	}
 }
 
-func (self *fmter) writeMaybeNewline() {
-	if self.whitespace() && !self.hasNewlineSuffix() {
-		self.writeByte(newline)
+/*
+True if the comment at the cursor follows an element on the same line, both in
+the source and in the output, and ends the line. See `Conf.TrailingComments`.
+Comments before a closing bracket may differ, see `Conf.ClosingComments`.
+*/
+func (self *fmter) isTrailingComment() bool {
+	if !self.whitespace() || self.hasNewlineSuffix() {
+		return false
 	}
-}
 
-func (self *fmter) writeNewline() {
-	if !self.wrote((*fmter).writeMaybeNewline) {
-		self.writeByte(newline)
+	switch self.conf.ClosingComments {
+	case ClosingCommentsOwnLine:
+		if self.isNextClosingComment() {
+			return false
+		}
+	case ClosingCommentsAttach:
+		if self.isNextClosingComment() {
+			return true
+		}
 	}
+	return self.conf.TrailingComments && self.isNextCommentTrailing()
 }
 
-func (self *fmter) writeIndent() {
-	for i := 0; i < self.indent; i++ {
-		self.writeString(self.conf.Indent)
+// True if only comments follow the cursor until the closing bracket.
+func (self *fmter) isNextClosingComment() bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	for self.more() {
+		if self.skipped() {
+			continue
+		}
+		if !self.isNextComment() {
+			return self.isNextCloser()
+		}
+		self.any()
 	}
+	return false
 }
 
-func (self *fmter) writeMaybeNewlineIndent() {
-	if self.whitespace() {
-		self.writeMaybeNewline()
-		self.writeIndent()
+/*
+True if the comment at the cursor follows other content on the same line in the
+source, and ends the line.
+*/
+func (self *fmter) isNextCommentTrailing() bool {
+	prev := strings.TrimRight(self.source[:self.cursor], " \t")
+	if prev == `` || strings.HasSuffix(prev, "\n") || strings.HasSuffix(prev, "\r") {
+		return false
 	}
+	return self.isNextCommentSingle() || self.isLineEndAfter((*fmter).commentMulti)
 }
 
-func (self *fmter) writeMaybeCommentNewlineIndent() {
-	if !self.conf.StripComments {
-		self.writeMaybeNewlineIndent()
-	}
+// True if only spaces follow the given function's content until the end of the line.
+func (self *fmter) isLineEndAfter(fun func(*fmter)) bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	fun(self)
+	rest := strings.TrimLeft(self.rest(), " \t")
+	return rest == `` || rest[0] == '\n' || rest[0] == '\r'
 }
 
 func (self *fmter) nextCommentSingle() string {
@@ -519,53 +2294,121 @@ func (self *fmter) nextCommentMulti() (string, string) {
 	return ``, ``
 }
 
-func (self *fmter) hasNonCommentsBefore(char byte) bool {
-	prev := *self
-	defer self.reset(&prev)
+func (self *fmter) nextPlaceholder() Placeholder {
+	for _, val := range self.conf.Placeholders {
+		if val.Start != `` && val.End != `` && strings.HasPrefix(self.rest(), val.Start) {
+			return val
+		}
+	}
+	return Placeholder{}
+}
+
+/*
+True if the current dict or list has more entries, other than comments.
+Called after every value, and again for the same values when single-line
+attempts are rolled back, so results which required scanning comments are
+cached by source position during such attempts. Plain JSON and multi-line
+output don't pay for the cache.
+*/
+func (self *fmter) hasNonCommentsBefore() bool {
+	if val, ok := self.comments[self.cursor]; ok {
+		return val
+	}
+
+	// Nothing is written, so restoring the state doesn't need `defer`, which
+	// is measurably slower when called for every element.
+	prev := self.mark()
+	discard := self.discard
+	self.discard = true
+
+	found := false
+	commented := false
 
 	for self.more() {
-		if self.isNextByte(char) {
-			return false
+		if self.isNextCloser() {
+			break
 		}
 
 		if self.skipped() {
 			continue
 		}
 
+		if self.isNextCommentSingle() {
+			self.cursor = self.lineEnd()
+			commented = true
+			continue
+		}
+
 		if self.isNextComment() {
 			assert(self.scannedAny())
+			commented = true
 			continue
 		}
 
-		return true
+		found = true
+		break
 	}
 
-	return false
+	start := prev.cursor
+	self.discard = discard
+	self.reset(prev)
+
+	if commented && len(self.snapshots) > 0 {
+		if self.comments == nil {
+			self.comments = map[int]bool{}
+		}
+		self.comments[start] = found
+	}
+	return found
+}
+
+/*
+Position in the source and the output. Lookaheads and single-line attempts
+reset to a mark, which is much cheaper than copying the entire state.
+*/
+type mark struct {
+	cursor int
+	indent int
+	row    int
+	col    int
+	exempt int
+	len    int
+}
+
+func (self *fmter) mark() mark {
+	return mark{self.cursor, self.indent, self.row, self.col, self.exempt, self.buf.Len()}
 }
 
-func (self *fmter) reset(prev *fmter) {
+func (self *fmter) reset(prev mark) {
 	self.cursor = prev.cursor
 	self.indent = prev.indent
 	self.row = prev.row
 	self.col = prev.col
-	self.buf.Truncate(prev.buf.Len())
+	self.exempt = prev.exempt
+	self.buf.Truncate(prev.len)
+	self.truncateOffsets()
 }
 
-// Causes an escape and a minor heap allocation, but this isn't our bottleneck.
-// Ensuring stack allocation in this particular case seems to have no effect on
-// performance.
-func (self *fmter) snap() *fmter {
-	prev := self.snapshot
-	snapshot := *self
-	self.snapshot = &snapshot
-	return prev
+/*
+Starts a single-line attempt, which is rolled back when a write exceeds the
+line. Snapshots are stacked because attempts may be nested. Instead of
+comparing every write against the snapshot, newlines roll back immediately,
+and writes only compare the column against `fmter.colLimit`, which is the same
+for all nested attempts.
+*/
+func (self *fmter) snap() {
+	self.snapshots = append(self.snapshots, self.mark())
+	self.colLimit = int(self.conf.Width)
 }
 
 var rollback = new(struct{})
 
-func (self *fmter) maybeRollback(prev *fmter) {
-	snapshot := self.snapshot
-	self.snapshot = prev
+func (self *fmter) maybeRollback() {
+	snapshot := self.snapshots[len(self.snapshots)-1]
+	self.snapshots = self.snapshots[:len(self.snapshots)-1]
+	if len(self.snapshots) == 0 {
+		self.colLimit = 0
+	}
 
 	val := recover()
 	if val == rollback {
@@ -575,15 +2418,138 @@ func (self *fmter) maybeRollback(prev *fmter) {
 	}
 }
 
+/*
+Replaces 0 in `Conf.LayoutVersion` with the default version, and rejects
+unsupported versions. Layout changes check the resolved version.
+*/
+func (self Conf) layoutVersion() Conf {
+	if self.LayoutVersion == 0 {
+		self.LayoutVersion = defaultLayoutVersion
+	} else if self.LayoutVersion > latestLayoutVersion {
+		panic(fmterErr{ErrLayoutVersion})
+	}
+	return self
+}
+
+func (self Conf) maxDepth() uint64 {
+	if self.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+	return self.MaxDepth
+}
+
+/*
+Called when entering a dict or list. Nesting is limited by `Conf.MaxDepth`,
+because exhausting the stack crashes the process, rather than panicking.
+*/
+func (self *fmter) nest() {
+	self.depth++
+	if uint64(self.depth) > self.conf.maxDepth() {
+		panic(fmterErr{ErrMaxDepth})
+	}
+}
+
+// Used for `defer`.
+func (self *fmter) unnest() {
+	self.depth--
+}
+
 // Used for `defer`.
 func (self *fmter) setDiscard(val bool) {
 	self.discard = val
 }
 
+func (self *fmter) setConf(val Conf) {
+	self.conf = val
+}
+
+// Used for `defer`.
+func (self *fmter) setWidth(val uint64) {
+	self.conf.Width = val
+}
+
+// Used for `defer`.
+func (self *fmter) setCursor(val int) {
+	self.cursor = val
+}
+
+// Used for `defer`.
+func (self *fmter) setValue(val bool) {
+	self.value = val
+}
+
+// True if the next value is a dict value. Resets the flag for nested values.
+func (self *fmter) takeValue() bool {
+	val := self.value
+	self.value = false
+	return val
+}
+
+/*
+Output may be flushed only when it can't be rolled back: outside of
+single-line attempts, and not after a separator, which may still be removed,
+such as the space after a colon, see `fmter.unwriteSeparators`.
+*/
+func (self *fmter) maybeFlush() {
+	if self.out != nil && len(self.snapshots) == 0 && self.buf.Len() >= flushSize &&
+		!bytes.HasSuffix(self.buf.Bytes(), []byte{separator}) {
+		self.flush()
+	}
+}
+
+/*
+Writes in single-line attempts may be rolled back, and are
+bounded by `Conf.Width`, so only the remaining writes are checked.
+*/
+func (self *fmter) checkOutputSize() {
+	limit := self.conf.MaxOutputBytes
+	if limit > 0 && len(self.snapshots) == 0 && self.flushed+uint64(self.buf.Len()) > limit {
+		panic(fmterErr{ErrMaxOutputBytes})
+	}
+}
+
+func (self *fmter) flush() {
+	content := self.buf.Bytes()
+	if len(content) == 0 {
+		return
+	}
+
+	self.tail = content[len(content)-1]
+	self.flushed += uint64(len(content))
+	self.buf.Reset()
+
+	_, err := self.out.Write(content)
+	if err != nil {
+		panic(fmterErr{err})
+	}
+}
+
 func (self *fmter) more() bool {
+	if self.ctx != nil {
+		self.maybeCheckCtx()
+	}
 	return self.left() > 0
 }
 
+/*
+Checking on every step would be noticeably slower. Not inlined, which keeps
+`fmter.more` small enough to be inlined.
+*/
+//go:noinline
+func (self *fmter) maybeCheckCtx() {
+	self.steps++
+	if self.steps%ctxSteps == 0 {
+		self.checkCtx()
+	}
+}
+
+func (self *fmter) checkCtx() {
+	err := self.ctx.Err()
+	if err != nil {
+		panic(fmterErr{fmt.Errorf(`[jsonfmt] failed to format: %w`, err)})
+	}
+}
+
 func (self *fmter) left() int {
 	return len(self.source) - self.cursor
 }
@@ -611,8 +2577,7 @@ func (self *fmter) isNextByte(char byte) bool {
 }
 
 func (self *fmter) isNextSpace() bool {
-	return self.isNextByte(' ') || self.isNextByte('\t') || self.isNextByte('\v') ||
-		self.isNextByte('\n') || self.isNextByte('\r')
+	return byteClasses[self.headByte()]&classSpace != 0
 }
 
 /*
@@ -625,7 +2590,7 @@ Skipping `:` in lists also assists in the edge case of converting between lists
 and dicts.
 */
 func (self *fmter) isNextPunctuation() bool {
-	return self.isNextByte(',') || self.isNextByte(':')
+	return byteClasses[self.headByte()]&classPunctuation != 0
 }
 
 func (self *fmter) isNextCommentSingle() bool {
@@ -637,15 +2602,35 @@ func (self *fmter) isNextCommentMulti() bool {
 	return prefix != `` && suffix != ``
 }
 
+func (self *fmter) isNextCloser() bool {
+	return self.isNextByte('}') || self.isNextByte(']')
+}
+
 func (self *fmter) isNextTerminal() bool {
-	return self.isNextByte('{') ||
-		self.isNextByte('}') ||
-		self.isNextByte('[') ||
-		self.isNextByte(']') ||
-		self.isNextByte(',') ||
-		self.isNextByte(':') ||
-		self.isNextByte('"') ||
-		self.isNextComment()
+	return byteClasses[self.headByte()]&classTerminal != 0 || self.isNextComment()
+}
+
+// Includes tokens of `Conf.Recognizers`, which are scanned like placeholders.
+func (self *fmter) isNextPlaceholder() bool {
+	return self.nextPlaceholder().Start != `` || self.nextToken() > 0
+}
+
+// A placeholder in a dict key position which is not followed by `:`.
+func (self *fmter) isNextDirective() bool {
+	if self.nextPlaceholder().Start == `` {
+		return false
+	}
+
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	self.placeholder()
+	for self.more() && self.isNextSpace() {
+		self.skipByte()
+	}
+	return !self.isNextByte(':')
 }
 
 func (self *fmter) isNextComment() bool {
@@ -659,13 +2644,12 @@ var (
 
 func (self *fmter) hasNewlineSuffix() bool {
 	content := self.buf.Bytes()
+	if len(content) == 0 {
+		return self.tail == '\n' || self.tail == '\r'
+	}
 	return bytes.HasSuffix(content, bytesLf) || bytes.HasSuffix(content, bytesCr)
 }
 
-func (self *fmter) exceedsLine(prev *fmter) bool {
-	return self.row > prev.row || self.conf.Width > 0 && self.col > int(self.conf.Width)
-}
-
 func (self *fmter) skipByte() {
 	self.cursor++
 }
@@ -700,24 +2684,182 @@ func (self *fmter) wrote(fun func(*fmter)) bool {
 	return self.buf.Len() > start
 }
 
+/*
+Skips a whole run of whitespace at once, but only one punctuation character,
+because some lookaheads look for specific punctuation.
+*/
 func (self *fmter) skipped() bool {
-	if self.isNextSpace() || self.isNextPunctuation() {
+	if self.isNextPunctuation() {
 		self.skipByte()
 		return true
 	}
-	return false
+	return self.skippedSpace()
+}
+
+func (self *fmter) skippedSpace() bool {
+	start := self.cursor
+	for self.cursor < len(self.source) && byteClasses[self.source[self.cursor]]&classSpace != 0 {
+		self.cursor++
+	}
+	return self.cursor > start
 }
 
 func (self *fmter) preferSingle() bool {
-	return self.conf.Width > 0
+	return self.conf.Width > 0 && !(self.conf.PreserveMultiline && self.isNextMultiline())
+}
+
+/*
+True if the next dict or list certainly doesn't fit into the remaining width on
+a single line. Counts only brackets, strings and atoms, which are copied to the
+output as-is, and gives up on comments and placeholders. Much cheaper than a
+failed single-line attempt, which writes up to the width, then rolls back. Not
+used with `Conf.ExemptURLs` and `Conf.ElideBlobs`, where the width of strings
+differs from their source.
+*/
+func (self *fmter) isNextTooWide() bool {
+	if self.conf.ExemptURLs > 0 || self.conf.ElideBlobs > 0 {
+		return false
+	}
+
+	budget := int(self.conf.Width) - self.col
+	src := self.source
+	depth := 0
+
+	for ind := self.cursor; ind < len(src); ind++ {
+		char := src[ind]
+
+		switch {
+		case char == '"':
+			budget--
+			for ind++; ind < len(src) && src[ind] != '"'; ind++ {
+				if src[ind] == '\\' {
+					ind++
+					budget--
+				}
+				if isRuneStart(src, ind) {
+					budget--
+				}
+			}
+			budget--
+
+		case char == '{' || char == '[':
+			depth++
+			budget--
+
+		case char == '}' || char == ']':
+			depth--
+			budget--
+			if depth == 0 {
+				return budget < 0
+			}
+
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == '\v' ||
+			char == ',' || char == ':':
+
+		case self.isDelimStart(char):
+			return false
+
+		case isRuneStart(src, ind):
+			budget--
+		}
+
+		if budget < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// True if the byte may start a comment or placeholder delimiter.
+func (self *fmter) isDelimStart(char byte) bool {
+	conf := &self.conf
+	if isByteStart(conf.CommentLine, char) || isByteStart(conf.CommentBlockStart, char) {
+		return true
+	}
+	for _, val := range conf.Placeholders {
+		if isByteStart(val.Start, char) {
+			return true
+		}
+	}
+	return false
+}
+
+func isByteStart(str string, char byte) bool {
+	return str != `` && str[0] == char
+}
+
+func isRuneStart(src string, ind int) bool {
+	return ind < len(src) && utf8.RuneStart(src[ind])
+}
+
+// True if the next dict or list spans several lines in the source.
+func (self *fmter) isNextMultiline() bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	start := self.cursor
+	self.skipValue()
+
+	// Unterminated values may include whitespace at the end of the source.
+	return strings.ContainsAny(strings.TrimRight(self.source[start:self.cursor], " \t\v\n\r"), "\n\r")
+}
+
+func (self *fmter) braceSpacing() bool {
+	return self.conf.BraceSpacing && self.whitespace()
 }
 
 func (self *fmter) whitespace() bool {
 	return self.conf.Indent != ``
 }
 
-// Allocation-free conversion between two text types.
-func text[Out, Src Text](src Src) Out { return *(*Out)(unsafe.Pointer(&src)) }
+// Classes of ASCII bytes, for scanning via table lookups.
+const (
+	classSpace = 1 << iota
+	classPunctuation
+	classTerminal
+)
+
+var byteClasses = [256]byte{
+	' ':  classSpace,
+	'\t': classSpace,
+	'\v': classSpace,
+	'\n': classSpace,
+	'\r': classSpace,
+	',':  classPunctuation | classTerminal,
+	':':  classPunctuation | classTerminal,
+	'{':  classTerminal,
+	'}':  classTerminal,
+	'[':  classTerminal,
+	']':  classTerminal,
+	'"':  classTerminal,
+}
+
+// Like `strings.IndexByte` starting at the given offset, or the length if not found.
+func indexFrom(src string, from int, char byte) int {
+	ind := strings.IndexByte(src[from:], char)
+	if ind < 0 {
+		return len(src)
+	}
+	return from + ind
+}
+
+func indexOrLen(src, sub string) int {
+	ind := strings.Index(src, sub)
+	if ind < 0 {
+		return len(src)
+	}
+	return ind
+}
+
+func indexAnyOrLen(src, chars string) int {
+	ind := strings.IndexAny(src, chars)
+	if ind < 0 {
+		return len(src)
+	}
+	return ind
+}
 
 func assert(ok bool) {
 	if !ok {
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+/*
+Set by "-clipboard": which of stdin and stdout are replaced by the system
+clipboard. As a boolean flag, "-clipboard" means both.
+*/
+type clipboardFlag string
+
+const (
+	clipboardIn   clipboardFlag = `in`
+	clipboardOut  clipboardFlag = `out`
+	clipboardBoth clipboardFlag = `both`
+)
+
+func (self clipboardFlag) String() string { return string(self) }
+
+func (self clipboardFlag) IsBoolFlag() bool { return true }
+
+func (self *clipboardFlag) Set(src string) error {
+	switch val := clipboardFlag(strings.ToLower(src)); val {
+	case clipboardIn, clipboardOut, clipboardBoth:
+		*self = val
+	case `true`:
+		*self = clipboardBoth
+	case `false`:
+		*self = ``
+	default:
+		return fmt.Errorf(`expected "in", "out" or "both", got %q`, src)
+	}
+	return nil
+}
+
+func (self clipboardFlag) reads() bool  { return self == clipboardIn || self == clipboardBoth }
+func (self clipboardFlag) writes() bool { return self == clipboardOut || self == clipboardBoth }
+
+/*
+Commands which read and write the clipboard, in order of preference. The
+clipboard is accessed via the tools of each platform, which avoids
+platform-specific syscalls and dependencies. On Linux, Wayland is preferred
+when available, followed by "xclip" and "xsel" for X11.
+*/
+func clipboardCommands(write bool) [][]string {
+	switch runtime.GOOS {
+	case `darwin`:
+		if write {
+			return [][]string{{`pbcopy`}}
+		}
+		return [][]string{{`pbpaste`}}
+
+	case `windows`:
+		// PowerShell uses the system code page unless told otherwise.
+		if write {
+			return [][]string{{`powershell`, `-NoProfile`, `-Command`, `[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())`}}
+		}
+		return [][]string{{`powershell`, `-NoProfile`, `-Command`, `[Console]::OutputEncoding = [Text.Encoding]::UTF8; Get-Clipboard -Raw`}}
+
+	default:
+		var out [][]string
+		if os.Getenv(`WAYLAND_DISPLAY`) != `` {
+			if write {
+				out = append(out, []string{`wl-copy`})
+			} else {
+				out = append(out, []string{`wl-paste`, `--no-newline`})
+			}
+		}
+		if write {
+			return append(out, []string{`xclip`, `-selection`, `clipboard`, `-in`}, []string{`xsel`, `--clipboard`, `--input`})
+		}
+		return append(out, []string{`xclip`, `-selection`, `clipboard`, `-out`}, []string{`xsel`, `--clipboard`, `--output`})
+	}
+}
+
+// First of the commands which is installed.
+func clipboardCommand(write bool) (*exec.Cmd, error) {
+	var names []string
+	for _, args := range clipboardCommands(write) {
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return exec.Command(args[0], args[1:]...), nil
+		}
+		names = append(names, args[0])
+	}
+	return nil, fmt.Errorf(`no clipboard tool found, expected one of: %v`, strings.Join(names, `, `))
+}
+
+/*
+Reads the clipboard, refusing content larger than the limit, if any. See
+`readLimited`.
+*/
+func readClipboard(limit int64) ([]byte, error) {
+	cmd, err := clipboardCommand(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, clipboardError(err, stderr.String())
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, errInputLimit(limit)
+	}
+	return out, nil
+}
+
+func writeClipboard(src []byte) error {
+	cmd, err := clipboardCommand(true)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stderr = &stderr
+	return clipboardError(cmd.Run(), stderr.String())
+}
+
+func clipboardError(err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if err == nil || stderr == `` {
+		return err
+	}
+	return fmt.Errorf(`%w: %v`, err, stderr)
+}
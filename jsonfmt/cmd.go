@@ -14,67 +14,902 @@ Source and readme: https://github.com/mitranim/jsonfmt.
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/mitranim/jsonfmt"
 )
 
-const help = `jsonfmt is a command-line JSON formatter. It reads from stdin and
-writes to stdout. For files, use pipe and redirect:
+const help = `jsonfmt is a command-line JSON formatter. Without sources, it reads
+from stdin and writes to stdout. Given file paths, it formats the files in
+place instead, as described below:
 
 	cat <src_file>.json | jsonfmt <flags>
-	cat <src_file>.json | jsonfmt <flags> > <out_file>.json
+	jsonfmt <flags> <file> ...
+
+Gzipped input is detected and decompressed. Use "-z" to gzip the output of
+stdin and URLs; files keep their own compression. Zstd is detected, but not
+supported.
+
+With "-clipboard", the system clipboard replaces stdin and stdout, which
+formats copied JSON in place; "-clipboard=in" and "-clipboard=out" replace only
+one of them. Requires "pbcopy" on macOS, PowerShell on Windows, and "wl-copy",
+"xclip" or "xsel" on Linux:
+
+	jsonfmt -clipboard
+
+Editor plugins and build daemons may format many sources in one process via
+"-server", which reads JSON-RPC 2.0 requests, one per line, from stdin, and
+writes responses, one per line, to stdout. Methods "format", "check" and
+"minify" take params "source", and optionally "path", like "-stdin-filepath",
+and "conf", like "-conf-file":
+
+	{"jsonrpc": "2.0", "id": 1, "method": "format", "params": {"source": "[1,2]"}}
+
+To keep the original input of a pipeline next to the formatted output, such as
+for debugging, "-tee" also writes the raw input to a file, before formatting:
+
+	curl <url> | jsonfmt -tee original.json | less
+
+Given URLs, it fetches them and writes the formatted bodies to stdout:
+
+	jsonfmt <flags> https://<host>/<path>.json
+
+Given file paths, it formats them in place, concurrently. Gzipped files stay
+gzipped. In Markdown files (or any input with "-md"), only JSON code fences are
+formatted. In Go files, only string literals preceded by a "// jsonfmt" or
+"/* jsonfmt */" comment are formatted. Errors are reported for all failed files
+at the end. Use "-n" to only print the files that would change, and "-backup"
+to keep the originals:
+
+	jsonfmt <flags> <file> ...
+
+As a pre-commit hook, it formats the given files (or the files staged in git)
+in place. Changed files are printed, and the exit code is 3, unless restaging
+is enabled via "-a":
+
+	jsonfmt <flags> hook [-a] [<file> ...]
+
+To explore a document interactively, with folding, search, and toggling of the
+width ("w") and comments ("c"), use:
+
+	jsonfmt <flags> view [<source>]
+
+To deep-merge layered configs, such as a base file and environment-specific
+overrides, into stdout:
+
+	jsonfmt <flags> merge <source> <source> ...
+
+To merge two versions of a document changed independently from a common base,
+resolving reordered keys and non-overlapping changes, into stdout, with Git-style
+conflict markers for the rest, exiting with 1 on conflicts:
+
+	jsonfmt <flags> merge3 <base> <ours> <theirs>
+
+To split a huge list, or newline-delimited values, into formatted files of
+"-size" records each, numbered from 1 via "{n}" in the "-o" path, or "%d" in
+the output argument, use "-" for stdin. Missing directories are created:
+
+	jsonfmt <flags> split -o <output>/{n}.json <source>
+	jsonfmt <flags> split <source> <output>_%d.json
+
+To document a commented config, such as a JSONC file, as a Markdown table of
+key paths, values and comments, with the share of documented keys, use
+"-redact" to hide values such as secrets:
+
+	jsonfmt <flags> docs -redact <source>
+
+To sketch a JSON Schema from sample responses or configs, with types, required
+keys and enums for small sets of strings, as commented JSON:
+
+	jsonfmt <flags> schema <source> ...
+
+Other commands take the same flags and sources as the default "fmt" command,
+with stdin used when no sources are given. Flags specific to a command, such as
+"-to" of "convert" or "-a" of "hook", are accepted only after its name, and
+listed by "jsonfmt <command> -h":
+
+	jsonfmt <command> <flags> [<source> ...]
+
+Sources may also be given as a list separated by NUL bytes, which is safe for
+paths with spaces and newlines, via "-files0-from" with a file, or "-" for
+stdin:
+
+	git ls-files -z '*.json' | jsonfmt -files0-from -
+
+With "-o", "fmt", "convert" and "minify" write each top-level value of the
+sources, such as each line of newline-delimited JSON, to a separate file named
+by the template, numbered from 1, instead of stdout or in place:
+
+	jsonfmt convert -to json -o 'out/{n}.json' data.ndjson
+
+Well-known files, such as "package.json" and "tsconfig.json", are formatted
+with a matching preset, as if given via "-preset", unless "-preset" is given
+or "-file-presets=false". For files, ".editorconfig" settings override the
+environment and presets, but not "-conf-file", "-conf" or other flags. When
+used as a filter by editors, "-stdin-filepath" tells which file stdin belongs
+to, for its preset, ".editorconfig", file type, and messages.
+
+With "-json-errors", problems such as unformatted files, lint errors and
+failures are also written to stderr as JSON objects, one per line, with the
+fields "file", "line", "column", "message" and "fix".
+
+With "-warn", non-fatal problems in JSON sources, such as duplicate keys,
+values too wide for "-w", mixed comment styles, and numbers formatted for a
+locale, such as "1,5" or 1.234.567, are written to stderr as
+"file:line:column: warning: message", or as JSON with "-json-errors". Warnings
+don't affect the exit code. Unknown atoms, such as "NaN" or a typo like "ture",
+are accepted by default; "-atoms warn" reports them as warnings, and
+"-atoms strict" fails like invalid syntax.
+
+With "-show-fixes", each punctuation fix, such as a comma inserted between two
+values, is written to stderr with the offending line and a caret under the
+position, or as JSON with "-json-errors", to help fix hand-edited files.
+
+With "-long-lines", lines of the output wider than "-w", which formatting
+couldn't break, such as long strings, are written to stderr as
+"file:line:column: message", with the column of the first character past the
+width, or as JSON with "-json-errors". This doesn't affect the exit code,
+except in "check", which reports such lines of the sources and fails like for
+unformatted sources, so that CI can flag them.
+
+With "-stats", files and stdin formatted by "fmt" get statistics written to
+stderr at the end of the run: bytes before and after, comments stripped,
+punctuation repairs, and elapsed time, followed by totals, or as JSON objects
+under the key "stats" with "-json-errors".
+
+Multiple sources are processed even when some fail, and a summary is printed
+at the end. Exit codes, from least to most severe, where the most severe wins:
+
+	0  success; files may have been formatted in place
+	3  files were changed ("hook") or would be changed ("-n")
+	1  sources aren't formatted ("check", "diff")
+	4  invalid syntax ("lint", "-strict-punctuation"), unknown atoms
+	   ("-atoms strict"), control characters ("-noise reject"), or undecodable
+	   input, such as broken gzip
+	2  invalid flags, arguments or settings
+	5  failure to read, fetch or write, or exceeding "-max-input-bytes" or
+	   "-timeout"
+
+Settings may also come from environment variables such as JSONFMT_WIDTH or
+JSONFMT_TRAILING_COMMA, overridden by "-preset", then "-conf-file", then
+"-conf", then other flags. A "-conf-file" is a JSON object with the keys of the
+Go type "Conf", and may contain "overrides", which apply different layout
+settings to different paths:
+
+	{"width": 100, "overrides": [{"path": "/dependencies", "conf": {"width": 0}}]}
 
 In addition to CLI, it's also available as a Go library:
 
 	https://github.com/mitranim/jsonfmt
 
-Settings:
+Commands:
 
 `
 
+type fileConf struct {
+	Jobs          int
+	Backup        bool
+	DryRun        bool
+	Gzip          bool
+	Timeout       time.Duration
+	MaxInputBytes int64
+	Markdown      bool
+	EditorConfig  bool
+	FilePresets   bool
+	Exts          string
+	Restage       bool
+	From          string
+	To            string
+	Lists         string
+	Size          int
+	Warn          bool
+	ShowFixes     bool
+	Output        string
+	Files0From    string
+	Stats         bool
+	LongLines     bool
+	Redact        bool
+	Clipboard     clipboardFlag
+	Tee           string
+	Server        bool
+
+	// Reapplies "-conf" and explicit flags over per-file settings.
+	override func(*jsonfmt.Conf)
+
+	// Resolved presets for well-known files. See `filePresets`.
+	presets map[string]jsonfmt.Conf
+
+	// Ends single-line output to stdout with a newline. Set by "minify".
+	finalNewline bool
+}
+
 func main() {
 	conf := jsonfmt.Default
+	preset := ``
+	file := ``
+	pairs := ``
 
+	err := conf.LoadEnv()
+	if err != nil {
+		fail(usageError{err})
+	}
+	fileConf := fileConf{
+		Jobs:         runtime.NumCPU(),
+		EditorConfig: true,
+		FilePresets:  true,
+		Exts:         `.json,.jsonc`,
+		To:           `json`,
+		Lists:        `replace`,
+		Size:         1000,
+	}
+
+	flag.StringVar(&preset, `preset`, preset, `base settings: `+presetNames()+`; other flags override`)
+	flag.StringVar(&file, `conf-file`, file, `JSON file with settings, such as {"width": 100, "overrides": [{"path": "/scripts", "conf": {"width": 0}}]}`)
+	flag.StringVar(&pairs, `conf`, pairs, `compact settings such as "indent=2,width=100,trailing-comma"; other flags override`)
 	flag.StringVar(&conf.Indent, `i`, conf.Indent, `indentation`)
 	flag.Uint64Var(&conf.Width, `w`, conf.Width, `line width`)
 	flag.StringVar(&conf.CommentLine, `l`, conf.CommentLine, `beginning of line comment`)
 	flag.StringVar(&conf.CommentBlockStart, `b`, conf.CommentBlockStart, `beginning of block comment`)
 	flag.StringVar(&conf.CommentBlockEnd, `e`, conf.CommentBlockEnd, `end of block comment`)
+	flag.StringVar(&conf.OutputCommentLine, `out-l`, conf.OutputCommentLine, `beginning of line comment in output, if different from -l`)
+	flag.StringVar(&conf.OutputCommentBlockStart, `out-b`, conf.OutputCommentBlockStart, `beginning of block comment in output, if different from -b`)
+	flag.StringVar(&conf.OutputCommentBlockEnd, `out-e`, conf.OutputCommentBlockEnd, `end of block comment in output, if different from -e`)
+	flag.Var((*commentStyleFlag)(&conf.CommentStyle), `comment-style`, `convert comments to style: line, block`)
+	flag.Var((*commentAttachFlag)(&conf.CommentAttach), `comment-attach`, `comments between entries move with the "next" or "previous" one when restructuring, such as with -sort-keys`)
+	flag.Var((*closingCommentsFlag)(&conf.ClosingComments), `closing-comments`, `comments before closing brackets: "own-line", "attach" to the last element, or "hoist" above it`)
+	flag.Var((*commentSpaceFlag)(&conf.CommentSpace), `comment-space`, `space before block comments after other content on the line: "none", "align" to -comment-column, or empty for one`)
+	flag.StringVar(&conf.CommentsToKey, `comments-to-key`, conf.CommentsToKey, `move comments into properties with this key, such as "$comment"`)
+	flag.StringVar(&conf.CommentsFromKey, `comments-from-key`, conf.CommentsFromKey, `turn properties with this key into comments`)
 	flag.BoolVar(&conf.TrailingComma, `t`, conf.TrailingComma, `trailing commas when multiline`)
+	flag.BoolVar(&conf.TrailingComments, `trailing-comments`, conf.TrailingComments, `keep comments on the line of the preceding element`)
+	flag.Uint64Var(&conf.CommentColumn, `comment-column`, conf.CommentColumn, `with -trailing-comments, align trailing comments to this column by padding with spaces`)
 	flag.BoolVar(&conf.StripComments, `s`, conf.StripComments, `strip comments`)
+	flag.Var((*markersFlag)(&conf.KeepComments), `keep-comments`, `with -s, keep comments starting with markers separated by "|", such as "//!|// KEEP:"`)
+	flag.Var(punctuationFlag{`keep-regexp`, &conf.KeepRegexp}, `keep-regexp`, `with -s, keep comments matching this regular expression`)
+	flag.BoolVar(&conf.NormalizeComments, `normalize-comments`, conf.NormalizeComments, `normalize spaces in comments and collapse empty comment lines`)
+	flag.BoolVar(&conf.CollapseComments, `collapse-comments`, conf.CollapseComments, `write multi-line block comments on one line in single-line dicts and lists, instead of making them multi-line`)
+	flag.BoolVar(&conf.SortKeys, `sort-keys`, conf.SortKeys, `sort dict keys`)
+	flag.BoolVar(&conf.PreserveMultiline, `preserve-multiline`, conf.PreserveMultiline, `keep dicts and lists multi-line when they are in the source`)
+	flag.BoolVar(&conf.BraceSpacing, `brace-spacing`, conf.BraceSpacing, `spaces inside single-line braces: { "a": 1 }`)
+	flag.BoolVar(&conf.CloseSameLine, `close-same-line`, conf.CloseSameLine, `closing brackets on the line of the last element`)
+	flag.BoolVar(&conf.OpenNewline, `open-newline`, conf.OpenNewline, `opening brackets of multi-line dict values on a new line`)
+	flag.BoolVar(&conf.ExpandEmpty, `expand-empty`, conf.ExpandEmpty, `write empty multi-line dicts and lists on two lines`)
+	flag.BoolVar(&conf.InlineSingle, `inline-single`, conf.InlineSingle, `keep dicts and lists with one scalar entry on one line, regardless of width`)
+	flag.BoolVar(&conf.BreakAfterKey, `break-after-key`, conf.BreakAfterKey, `put long scalar dict values on their own line`)
+	flag.BoolVar(&conf.StrictPunctuation, `strict-punctuation`, conf.StrictPunctuation, `fail on missing and extra commas and colons instead of fixing them`)
+	flag.BoolVar(&conf.FixSmartQuotes, `fix-smart-quotes`, conf.FixSmartQuotes, `replace typographic quotes around keys and values, and non-breaking spaces between values, with ASCII`)
+	flag.BoolVar(&conf.Repair, `repair`, conf.Repair, `only fix punctuation, preserving layout and comments`)
+	flag.BoolVar(&conf.Reindent, `reindent`, conf.Reindent, `only change indentation, preserving line breaks, punctuation and comments`)
+	flag.BoolVar(&conf.StrictOutput, `strict`, conf.StrictOutput, `override settings which would produce invalid JSON, such as comments`)
+	flag.BoolVar(&conf.SelfCheck, `self-check`, conf.SelfCheck, `fail instead of writing output which isn't stable, or with -strict, isn't valid JSON`)
+	flag.Var((*keyOrderFlag)(&conf.KeyOrder), `key-order`, `order of keys at JSON pointer paths, such as ":name version|/server:host port"`)
+	flag.Var(&keyOrderFileFlag{tar: &conf.KeyOrder}, `key-order-from`, `order keys like in the given reference file`)
+	flag.Var(&schemaFlag{tar: &conf}, `schema`, `take key order and layout overrides from a JSON Schema file; see "x-jsonfmt" in the readme`)
+	flag.Var((*pathsFlag)(&conf.SortLists), `sort-lists`, `sort scalar lists at JSON pointer paths separated by "|", such as "/files|/perms/*"`)
+	flag.Var((*pathsFlag)(&conf.FoldLists), `fold-lists`, `convert strings at JSON pointer paths separated by "|" into lists of their lines`)
+	flag.BoolVar(&conf.FoldStrings, `fold-strings`, conf.FoldStrings, `fold strings wider than -w into lines joined by JSON5 line continuations`)
+	flag.Uint64Var(&conf.ExemptURLs, `exempt-urls`, conf.ExemptURLs, `don't expand dicts and lists for URLs and paths at least this long, which exceed -w anyway; 0 to disable`)
+	flag.BoolVar(&conf.Skeleton, `skeleton`, conf.Skeleton, `output only keys and the shapes of dicts and lists, with type placeholders for values and the first element of each list, for exploring large payloads`)
+	flag.Uint64Var(&conf.ElideBlobs, `elide-blobs`, conf.ElideBlobs, `replace base64 and hex strings at least this long with placeholders, for previews; 0 to disable`)
+	flag.Var((*pathsFlag)(&conf.NormalizeTimes), `normalize-times`, `rewrite timestamps in strings at JSON pointer paths separated by "|" into RFC 3339`)
+	flag.BoolVar(&conf.DedupeLists, `dedupe-lists`, conf.DedupeLists, `remove duplicates from lists sorted via -sort-lists`)
+	flag.Var((*pathsFlag)(&conf.SortDicts), `sort-dicts`, `sort keys of dicts at JSON pointer paths separated by "|", such as "/dependencies"`)
+	flag.Var((*keySortFlag)(&conf.KeySort), `key-sort`, `order of -sort-keys: natural, ignore-case, natural-ignore-case`)
+	flag.Var((*separatorFlag)(&conf.Separator), `sep`, `separator of top-level values: newline, blank, array, rs`)
+	flag.Var((*lineEndingFlag)(&conf.LineEnding), `eol`, `line endings of the output: lf, crlf, preserve`)
+	flag.Var((*strictnessFlag)(&conf.Atoms), `atoms`, `unknown atoms such as "NaN" or "ture": warn (to stderr), strict (fail), or empty to accept`)
+	flag.Var((*noiseFlag)(&conf.Noise), `noise`, `control characters such as NULs and form feeds, and byte order marks, outside of strings: skip, reject (fail), or empty to preserve`)
+	flag.Var(punctuationFlag{`colon`, &conf.Colon}, `colon`, `colon with optional spaces, such as " : "`)
+	flag.Var(punctuationFlag{`comma`, &conf.Comma}, `comma`, `comma with optional spaces, such as ","`)
+	flag.BoolVar(&conf.MultiWordAtoms, `multi-word-atoms`, conf.MultiWordAtoms, `keep unquoted words separated by spaces in one atom, for HJSON-like input`)
+	flag.Var(&callsFlag{tar: &conf.Recognizers}, `calls`, `treat calls such as ISODate("...") or new Date(1, 2) as atoms: names separated by "|", or "*" for any`)
+	flag.Var((*placeholdersFlag)(&conf.Placeholders), `p`, `template placeholder delimiters, such as "{{ }},${ }"`)
+	flag.Uint64Var(&conf.MaxOutputBytes, `max-output-bytes`, conf.MaxOutputBytes, `abort when the output of a source exceeds this size; 0 for no limit`)
+	flag.Uint64Var(&conf.MaxDepth, `max-depth`, conf.MaxDepth, `abort when dicts and lists are nested deeper than this; 0 for the default of 10000`)
+	flag.Uint64Var(&conf.Workers, `workers`, conf.Workers, `goroutines formatting the values of one huge source concurrently; see -j for files`)
+	flag.Uint64Var(&conf.LayoutVersion, `layout-version`, conf.LayoutVersion, `version of the layout algorithm, for stable output across upgrades; 0 for version 1`)
+	flag.IntVar(&fileConf.Jobs, `j`, fileConf.Jobs, `max files to format concurrently`)
+	flag.BoolVar(&fileConf.Backup, `backup`, fileConf.Backup, `write a ".bak" copy of each changed file`)
+	flag.BoolVar(&fileConf.DryRun, `n`, fileConf.DryRun, `print files that would change, without writing`)
+	flag.Var(&fileConf.Clipboard, `clipboard`, `read from and write to the system clipboard instead of stdin and stdout; "-clipboard=in" or "-clipboard=out" for one of them`)
+	flag.StringVar(&fileConf.Tee, `tee`, fileConf.Tee, `also write the raw input from stdin to this file, for keeping the original payload in pipelines`)
+	flag.BoolVar(&fileConf.Server, `server`, fileConf.Server, `serve JSON-RPC requests "format", "check" and "minify" over stdin and stdout, one per line, for editors and daemons`)
+	flag.BoolVar(&fileConf.Gzip, `z`, fileConf.Gzip, `gzip the output of stdin and URLs; files keep their compression`)
+	flag.DurationVar(&fileConf.Timeout, `timeout`, fileConf.Timeout, `abort fetching or formatting a source after this duration; 0 for no limit, except 30s for URLs`)
+	flag.Int64Var(&fileConf.MaxInputBytes, `max-input-bytes`, fileConf.MaxInputBytes, `refuse sources larger than this, also after decompression; 0 for no limit, except 64 MiB for URLs`)
+	flag.BoolVar(&fileConf.EditorConfig, `editorconfig`, fileConf.EditorConfig, `apply ".editorconfig" to files, unless overridden by flags`)
+	flag.BoolVar(&fileConf.FilePresets, `file-presets`, fileConf.FilePresets, `apply presets to well-known files such as package.json, unless -preset is given`)
+	flag.BoolVar(&fileConf.Warn, `warn`, fileConf.Warn, `write warnings, such as duplicate keys, to stderr, without failing`)
+	flag.BoolVar(&fileConf.LongLines, `long-lines`, fileConf.LongLines, `write lines of the output wider than -w to stderr, or with "check", lines of the sources, failing if any`)
+	flag.BoolVar(&fileConf.ShowFixes, `show-fixes`, fileConf.ShowFixes, `write each punctuation fix, such as an inserted comma, with its line to stderr`)
+	flag.StringVar(&fileConf.Output, `o`, fileConf.Output, `write each top-level value ("fmt", "convert", "minify") or chunk ("split") to a file named by this template, with "{n}" for the number from 1, such as "out/{n}.json"; creates directories`)
+	flag.StringVar(&fileConf.Files0From, `files0-from`, fileConf.Files0From, `read more sources from this file, or stdin for "-", separated by NUL bytes, as written by "find -print0" or "git ls-files -z"`)
+	flag.BoolVar(&fileConf.Stats, `stats`, fileConf.Stats, `write per-file and total statistics to stderr at the end: bytes before and after, comments stripped, repairs, elapsed time`)
+	flag.StringVar(&stdinPath, `stdin-filepath`, stdinPath, `path of the file given via stdin, for its preset, ".editorconfig", file type and messages`)
+	flag.BoolVar(&jsonErrors, `json-errors`, jsonErrors, `write diagnostics and errors to stderr as JSON, one per line`)
+	flag.BoolVar(&fileConf.Markdown, `md`, fileConf.Markdown, `format JSON code fences in Markdown (default for .md files)`)
 
 	flag.Usage = func() {
-		fmt.Fprint(flag.CommandLine.Output(), help)
+		_, _ = io.WriteString(flag.CommandLine.Output(), help)
+		fmt.Fprint(flag.CommandLine.Output(), commandHelp())
+		fmt.Fprint(flag.CommandLine.Output(), "\nSettings:\n\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
-	args()
+	if fileConf.Stats {
+		runStats = &statsLog{start: time.Now()}
+	}
+	cmd := args(&fileConf)
+	settings := readConfFile(file)
+	if fileConf.FilePresets {
+		fileConf.presets = filePresets(&conf, preset, settings, pairs)
+	}
+	resolveConf(&conf, preset, settings, pairs)
+	fileConf.override = overrides(conf, settings, pairs)
+	if fileConf.Server {
+		serve(conf, fileConf)
+		return
+	}
+	cmd.run(conf, fileConf, sources(fileConf))
+}
 
-	source, err := io.ReadAll(os.Stdin)
+func stdio(conf jsonfmt.Conf, fileConf fileConf) {
+	start := time.Now()
+	source, unmap, err := readStdin(fileConf)
 	if err != nil {
 		fail(fmt.Errorf(`[jsonfmt] failed to read: %w`, err))
 	}
+	defer unmap()
+
+	err = teeStdin(fileConf, source)
+	if err != nil {
+		fail(err)
+	}
+
+	var final *bool
+	if stdinPath != `` {
+		conf, final, err = pathConf(conf, fileConf, stdinPath)
+		if err != nil {
+			fail(fmt.Errorf(`[jsonfmt] failed to read settings for %q: %w`, stdinPath, err))
+		}
+	}
+
+	format := formatter(conf, fileConf, stdinPath)
+	if final != nil {
+		format = withFinalNewline(format, *final)
+	} else if fileConf.finalNewline {
+		format = withFinalNewline(format, true)
+	}
+	plain := format
+	if reports(conf, fileConf, stdinPath) {
+		format = withReport(format, conf, fileConf, sourceName(``))
+	}
+	if checksLongLines(fileConf, stdinPath) {
+		format = withLongLines(format, conf, sourceName(``))
+	}
+
+	err = writeStdout(format, fileConf, source)
+	if err != nil {
+		fail(err)
+	}
+	runStats.add(conf, fileConf, stdinPath, plain, source, start)
+	runStats.print()
+}
+
+/*
+Reads stdin, or the clipboard with "-clipboard" or "-clipboard=in". The returned
+function releases the content. See `mapFile`.
+*/
+func readStdin(fileConf fileConf) ([]byte, func(), error) {
+	if !fileConf.Clipboard.reads() {
+		return mapFile(os.Stdin, fileConf.MaxInputBytes)
+	}
+	content, err := readClipboard(fileConf.MaxInputBytes)
+	return content, func() {}, err
+}
+
+/*
+Writes the raw stdin content to the file given via "-tee", before formatting,
+so that the original is kept even when formatting fails.
+*/
+func teeStdin(fileConf fileConf, content []byte) error {
+	if fileConf.Tee == `` {
+		return nil
+	}
+	err := os.WriteFile(fileConf.Tee, content, 0o666)
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to write %q: %w`, fileConf.Tee, err)
+	}
+	return nil
+}
+
+/*
+Formats the source and writes it to stdout, or to the clipboard with
+"-clipboard" or "-clipboard=out", decompressing gzipped input and optionally
+compressing the output.
+*/
+func writeStdout(format formatFunc, fileConf fileConf, source []byte) error {
+	if !fileConf.Clipboard.writes() {
+		return writeOutput(os.Stdout, format, fileConf, source)
+	}
+
+	var buf bytes.Buffer
+	err := writeOutput(&buf, format, fileConf, source)
+	if err != nil {
+		return err
+	}
+
+	err = writeClipboard(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to write to the clipboard: %w`, err)
+	}
+	return nil
+}
+
+func writeOutput(out io.Writer, format formatFunc, fileConf fileConf, source []byte) error {
+	var err error
+
+	source, err = decompress(source, fileConf.MaxInputBytes)
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to decompress: %w`, err)
+	}
+
+	if !fileConf.Gzip {
+		err = format(out, source)
+	} else {
+		zip := gzip.NewWriter(out)
+		err = format(zip, source)
+		if err == nil {
+			err = zip.Close()
+		}
+	}
+
+	if isSyntaxError(err) {
+		return syntaxError{err}
+	}
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to write: %w`, err)
+	}
+	return nil
+}
+
+/*
+Settings are applied in the order of increasing precedence: defaults,
+environment variables (see `jsonfmt.EnvPrefix`), "-preset" which replaces
+everything before it, "-conf-file", "-conf", and finally other flags, which are
+reapplied to override the rest regardless of their order on the command line.
+*/
+func resolveConf(conf *jsonfmt.Conf, preset string, settings []byte, pairs string) {
+	if preset == `` && settings == nil && pairs == `` {
+		return
+	}
+
+	explicit := map[string]string{}
+	flag.Visit(func(val *flag.Flag) { explicit[val.Name] = val.Value.String() })
+	delete(explicit, `preset`)
+	delete(explicit, `conf-file`)
+	delete(explicit, `conf`)
+
+	if preset != `` {
+		val, ok := jsonfmt.Preset(preset)
+		if !ok {
+			fail(usageError{fmt.Errorf(`[jsonfmt] unknown preset %q, expected one of: %v`, preset, presetNames())})
+		}
+		*conf = val
+	}
+
+	err := applyConfFile(conf, settings)
+	if err != nil {
+		fail(usageError{err})
+	}
+
+	err = conf.Parse(pairs)
+	if err != nil {
+		fail(usageError{err})
+	}
+
+	for key, val := range explicit {
+		err := flag.Set(key, val)
+		if err != nil {
+			fail(usageError{err})
+		}
+	}
+}
+
+/*
+Resolves the presets of `jsonfmt.FilePresets` as if each was given via
+"-preset", with "-conf" and other flags on top. Returns nil when a preset is
+chosen explicitly, via "-preset" or the environment. Must be called before
+`resolveConf`, since flags are bound to the given config, which is restored
+afterwards.
+*/
+func filePresets(conf *jsonfmt.Conf, preset string, settings []byte, pairs string) map[string]jsonfmt.Conf {
+	if preset != `` || os.Getenv(jsonfmt.EnvPrefix+`PRESET`) != `` {
+		return nil
+	}
+
+	prev := *conf
+	defer func() { *conf = prev }()
+
+	out := map[string]jsonfmt.Conf{}
+	for _, name := range jsonfmt.FilePresets() {
+		if _, ok := out[name]; !ok {
+			resolveConf(conf, name, settings, pairs)
+			out[name] = *conf
+		}
+	}
+	return out
+}
+
+/*
+Returns a function which reapplies "-conf-file", "-conf" and explicit flags, for
+settings that may be overridden per file. See `pathConf`.
+*/
+func overrides(conf jsonfmt.Conf, settings []byte, pairs string) func(*jsonfmt.Conf) {
+	explicit := map[string]bool{}
+	flag.Visit(func(val *flag.Flag) { explicit[val.Name] = true })
+
+	return func(tar *jsonfmt.Conf) {
+		_ = applyConfFile(tar, settings)
+		_ = tar.Parse(pairs)
+		if explicit[`i`] {
+			tar.Indent = conf.Indent
+		}
+		if explicit[`w`] {
+			tar.Width = conf.Width
+		}
+		if explicit[`eol`] {
+			tar.LineEnding = conf.LineEnding
+		}
+	}
+}
+
+// Reads the "-conf-file", if any. See `applyConfFile`.
+func readConfFile(path string) []byte {
+	if path == `` {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fail(usageError{fmt.Errorf(`[jsonfmt] failed to read settings: %w`, err)})
+	}
+	return content
+}
+
+/*
+Applies the settings of a JSON file, which may have comments, on top of the
+config. Keys are the JSON names of `jsonfmt.Conf` fields, such as "width" or
+"overrides"; missing keys keep their values. Settings which are only checked
+when formatting, such as overrides, are validated up front.
+*/
+func applyConfFile(conf *jsonfmt.Conf, settings []byte) error {
+	if settings == nil {
+		return nil
+	}
+
+	err := jsonfmt.UnmarshalSingle(settings, conf)
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] invalid settings in "-conf-file": %w`, err)
+	}
+	return jsonfmt.FormatTo(*conf, io.Discard, `null`)
+}
+
+func presetNames() string {
+	return strings.Join(jsonfmt.PresetNames(), `, `)
+}
+
+// Validates like the "comment-style" key of `jsonfmt.Conf.Set`.
+type commentStyleFlag jsonfmt.CommentStyle
+
+func (self commentStyleFlag) String() string { return string(self) }
+
+func (self *commentStyleFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`comment-style`, src)
+	*self = commentStyleFlag(conf.CommentStyle)
+	return err
+}
+
+type commentAttachFlag jsonfmt.CommentAttach
+
+func (self commentAttachFlag) String() string { return string(self) }
+
+func (self *commentAttachFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`comment-attach`, src)
+	*self = commentAttachFlag(conf.CommentAttach)
+	return err
+}
+
+type closingCommentsFlag jsonfmt.ClosingComments
+
+func (self closingCommentsFlag) String() string { return string(self) }
+
+func (self *closingCommentsFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`closing-comments`, src)
+	*self = closingCommentsFlag(conf.ClosingComments)
+	return err
+}
+
+type commentSpaceFlag jsonfmt.CommentSpace
+
+func (self commentSpaceFlag) String() string { return string(self) }
+
+func (self *commentSpaceFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`comment-space`, src)
+	*self = commentSpaceFlag(conf.CommentSpace)
+	return err
+}
+
+type keySortFlag jsonfmt.KeySort
+
+func (self keySortFlag) String() string { return string(self) }
+
+func (self *keySortFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`key-sort`, src)
+	*self = keySortFlag(conf.KeySort)
+	return err
+}
+
+// Validates like the "separator" key of `jsonfmt.Conf.Set`.
+type separatorFlag jsonfmt.Separator
+
+func (self separatorFlag) String() string { return string(self) }
+
+func (self *separatorFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`separator`, src)
+	*self = separatorFlag(conf.Separator)
+	return err
+}
+
+type lineEndingFlag jsonfmt.LineEnding
+
+func (self lineEndingFlag) String() string { return string(self) }
+
+func (self *lineEndingFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`line-ending`, src)
+	*self = lineEndingFlag(conf.LineEnding)
+	return err
+}
+
+type strictnessFlag jsonfmt.Strictness
+
+func (self strictnessFlag) String() string { return string(self) }
+
+func (self *strictnessFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`atoms`, src)
+	*self = strictnessFlag(conf.Atoms)
+	return err
+}
+
+type noiseFlag jsonfmt.Noise
+
+func (self noiseFlag) String() string { return string(self) }
+
+func (self *noiseFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`noise`, src)
+	*self = noiseFlag(conf.Noise)
+	return err
+}
+
+// Parses ":one two|/three:four" like the "key-order" key of `jsonfmt.Conf.Set`.
+type keyOrderFlag map[string][]string
+
+func (self keyOrderFlag) String() string {
+	var out []string
+	for path, keys := range self {
+		out = append(out, path+`:`+strings.Join(keys, ` `))
+	}
+	sort.Strings(out)
+	return strings.Join(out, `|`)
+}
+
+func (self *keyOrderFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`key-order`, src)
+	*self = conf.KeyOrder
+	return err
+}
+
+// Reads the key order from a reference file. See `jsonfmt.KeyOrderOf`.
+type keyOrderFileFlag struct {
+	path string
+	tar  *map[string][]string
+}
+
+func (self *keyOrderFileFlag) String() string { return self.path }
+
+func (self *keyOrderFileFlag) Set(src string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	self.path = src
+	*self.tar = jsonfmt.KeyOrderOf(jsonfmt.Default, content)
+	return nil
+}
 
-	_, err = os.Stdout.Write(jsonfmt.FormatBytes(conf, source))
+// Reads layout hints from a JSON Schema file. See `jsonfmt.SchemaLayout`.
+type schemaFlag struct {
+	path string
+	tar  *jsonfmt.Conf
+}
+
+func (self *schemaFlag) String() string { return self.path }
+
+func (self *schemaFlag) Set(src string) error {
+	content, err := os.ReadFile(src)
 	if err != nil {
-		fail(fmt.Errorf(`[jsonfmt] failed to write: %w`, err))
+		return err
+	}
+	self.path = src
+	*self.tar, err = jsonfmt.SchemaLayout(*self.tar, content)
+	return err
+}
+
+// Parses "/one|/two" like the "sort-lists" and "sort-dicts" keys of `jsonfmt.Conf.Set`.
+type pathsFlag []string
+
+func (self pathsFlag) String() string { return strings.Join(self, `|`) }
+
+func (self *pathsFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`sort-lists`, src)
+	*self = conf.SortLists
+	return err
+}
+
+// Parses "//!|// KEEP:" like the "keep-comments" key of `jsonfmt.Conf.Set`.
+type markersFlag []string
+
+func (self markersFlag) String() string { return strings.Join(self, `|`) }
+
+func (self *markersFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(`keep-comments`, src)
+	*self = conf.KeepComments
+	return err
+}
+
+// Validates like the corresponding key of `jsonfmt.Conf.Set`.
+type punctuationFlag struct {
+	key string
+	val *string
+}
+
+func (self punctuationFlag) String() string {
+	if self.val == nil {
+		return ``
 	}
+	return *self.val
 }
 
+func (self punctuationFlag) Set(src string) error {
+	var conf jsonfmt.Conf
+	err := conf.Set(self.key, src)
+	if err == nil {
+		*self.val = src
+	}
+	return err
+}
+
+// Parses "{{ }},${ }" into start and end delimiters of each placeholder.
+type placeholdersFlag []jsonfmt.Placeholder
+
+func (self placeholdersFlag) String() string {
+	var out []string
+	for _, val := range self {
+		out = append(out, val.Start+` `+val.End)
+	}
+	return strings.Join(out, `,`)
+}
+
+func (self *placeholdersFlag) Set(src string) error {
+	*self = nil
+	for _, pair := range strings.Split(src, `,`) {
+		delims := strings.Fields(pair)
+		if len(delims) != 2 {
+			return fmt.Errorf(`expected start and end delimiters separated by space, got %q`, pair)
+		}
+		*self = append(*self, jsonfmt.Placeholder{Start: delims[0], End: delims[1]})
+	}
+	return nil
+}
+
+/*
+Parses "ISODate|ObjectId|Date" into `jsonfmt.CallRecognizer`, which replaces
+other recognizers. "*" accepts calls of any name.
+*/
+type callsFlag struct {
+	names string
+	tar   *[]jsonfmt.Recognizer
+}
+
+func (self *callsFlag) String() string { return self.names }
+
+func (self *callsFlag) Set(src string) error {
+	var names []string
+	for _, val := range strings.Split(src, `|`) {
+		val = strings.TrimSpace(val)
+		if val == `*` {
+			names = nil
+			break
+		}
+		if val == `` {
+			return fmt.Errorf(`expected names separated by "|", such as "ISODate|ObjectId", or "*", got %q`, src)
+		}
+		names = append(names, val)
+	}
+
+	self.names = src
+	*self.tar = []jsonfmt.Recognizer{jsonfmt.CallRecognizer(names...)}
+	return nil
+}
+
+// Reports the error and exits with the corresponding code. See `exit`.
 func fail(err error) {
-	fmt.Fprintf(flag.CommandLine.Output(), `%+v`, err)
-	os.Exit(1)
+	exit(exitOk, err, summary{})
 }
 
-func args() {
+/*
+Sources from the arguments, followed by those from "-files0-from". When the
+list is given but empty, such as when "find" matched nothing, exits without
+falling back to stdin.
+*/
+func sources(fileConf fileConf) []string {
+	srcs := flag.Args()
+	if fileConf.Files0From == `` {
+		return srcs
+	}
+
+	var content []byte
+	var err error
+	if fileConf.Files0From == `-` {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(fileConf.Files0From)
+	}
+	if err != nil {
+		fail(fmt.Errorf(`[jsonfmt] failed to read sources from %q: %w`, fileConf.Files0From, err))
+	}
+
+	for _, src := range strings.Split(string(content), "\x00") {
+		if src != `` {
+			srcs = append(srcs, src)
+		}
+	}
+	if len(srcs) == 0 {
+		exit(exitOk, nil, summary{})
+	}
+	return srcs
+}
+
+/*
+Detects the command, leaving its arguments in `flag.Args()`. Without a known
+command name, all arguments are sources for "fmt", which keeps the original
+behavior of formatting stdin or files.
+*/
+func args(fileConf *fileConf) command {
 	args := flag.Args()
 	if len(args) == 0 {
-		return
+		return commands[`fmt`]
 	}
 
 	if args[0] == `help` {
@@ -82,5 +917,21 @@ func args() {
 		os.Exit(0)
 	}
 
-	fail(fmt.Errorf(`[jsonfmt] unexpected arguments %q`, args))
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		return commands[`fmt`]
+	}
+
+	// Allows flags after the command, which is how hook configs pass them.
+	// Flags of the command are only accepted there.
+	own := commandFlags(name, fileConf)
+	own.VisitAll(func(val *flag.Flag) { flag.Var(val.Value, val.Name, val.Usage) })
+	flag.Usage = func() { commandUsage(name, cmd, own) }
+
+	err := flag.CommandLine.Parse(args[1:])
+	if err != nil {
+		fail(usageError{err})
+	}
+	return cmd
 }
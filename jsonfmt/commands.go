@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Subcommand. Every command takes the resolved config, CLI settings, and the
+remaining arguments, which are usually sources: file paths or URLs. No sources
+means stdin.
+*/
+type command struct {
+	run  func(jsonfmt.Conf, fileConf, []string)
+	help string
+}
+
+var commands = map[string]command{
+	`fmt`:     {cmdFmt, `format stdin to stdout, or files in place (default)`},
+	`check`:   {cmdCheck, `print sources that aren't formatted; fail if any`},
+	`diff`:    {cmdDiff, `print a unified diff of formatting changes; fail if any`},
+	`minify`:  {cmdMinify, `like "fmt", but single-line and without comments`},
+	`convert`: {cmdConvert, `like "fmt", but converting to the dialect given by "-to"`},
+	`merge`:   {cmdMerge, `deep-merge sources left to right into stdout; see "-lists"`},
+	`merge3`:  {cmdMerge3, `three-way merge of base, ours and theirs into stdout; fail on conflicts`},
+	`split`:   {cmdSplit, `split a list or NDJSON into files of "-size" records`},
+	`lint`:    {cmdLint, `report positions of invalid JSON syntax, ignoring comments`},
+	`hook`:    {cmdHook, `pre-commit hook: format the given or staged files`},
+	`view`:    {cmdView, `interactive tree viewer for one source, with folding and search`},
+	`docs`:    {cmdDocs, `Markdown table of key paths, values and comments; see "-redact"`},
+	`schema`:  {cmdSchema, `infer a rough JSON Schema from sample documents into stdout`},
+
+	// Hidden: meant for validating upgrades of jsonfmt rather than daily use.
+	`verify`: {cmdVerify, ``},
+}
+
+func commandNames() string {
+	var out []string
+	for key, val := range commands {
+		if val.help != `` {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, `, `)
+}
+
+func commandHelp() string {
+	var buf strings.Builder
+	for _, name := range strings.Split(commandNames(), `, `) {
+		fmt.Fprintf(&buf, "\t%-8s %v\n", name, commands[name].help)
+	}
+	return buf.String()
+}
+
+/*
+Flags specific to the command, which are accepted only after its name, bound to
+the given CLI settings. Other flags are global.
+*/
+func commandFlags(name string, fileConf *fileConf) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+	switch name {
+	case `convert`:
+		flags.StringVar(&fileConf.From, `from`, fileConf.From, `source dialect: `+dialectNames)
+		flags.StringVar(&fileConf.To, `to`, fileConf.To, `target dialect: `+dialectNames)
+	case `merge`:
+		flags.StringVar(&fileConf.Lists, `lists`, fileConf.Lists, `how to merge lists: replace, append, index`)
+	case `docs`:
+		flags.BoolVar(&fileConf.Redact, `redact`, fileConf.Redact, `replace values with "*redacted*"`)
+	case `split`:
+		flags.IntVar(&fileConf.Size, `size`, fileConf.Size, `records per file`)
+	case `hook`:
+		flags.StringVar(&fileConf.Exts, `x`, fileConf.Exts, `comma-separated file extensions to format`)
+		flags.BoolVar(&fileConf.Restage, `a`, fileConf.Restage, `restage changed files instead of failing`)
+	case `verify`:
+		flags.StringVar(&fileConf.Exts, `x`, fileConf.Exts, `comma-separated file extensions to format in directories`)
+	}
+	return flags
+}
+
+func commandUsage(name string, cmd command, own *flag.FlagSet) {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage:\n\n\tjsonfmt <flags> %v <flags> [<source> ...]\n\n", name)
+	if cmd.help != `` {
+		fmt.Fprintf(out, "%v.\n\n", strings.ToUpper(cmd.help[:1])+cmd.help[1:])
+	}
+
+	var has bool
+	own.VisitAll(func(*flag.Flag) { has = true })
+	if has {
+		fmt.Fprintf(out, "Flags of %q:\n\n", name)
+		own.SetOutput(out)
+		own.PrintDefaults()
+		fmt.Fprint(out, "\n")
+	}
+	fmt.Fprint(out, "For other flags, see \"jsonfmt -h\".\n")
+}
+
+func cmdFmt(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	switch {
+	case fileConf.Output != ``:
+		if !strings.Contains(fileConf.Output, `{n}`) {
+			fail(usageError{fmt.Errorf(`[jsonfmt] output path template must contain "{n}", got %q`, fileConf.Output)})
+		}
+		writeValues(conf, fileConf, srcs)
+	case len(srcs) == 0:
+		stdio(conf, fileConf)
+	default:
+		files(conf, fileConf, srcs)
+	}
+}
+
+func cmdHook(conf jsonfmt.Conf, fileConf fileConf, paths []string) {
+	hook(conf, fileConf, paths)
+}
+
+/*
+Always single-line and without comments. ".editorconfig" and presets for
+well-known files are ignored. Like "fmt", output to stdout ends with a newline.
+*/
+func cmdMinify(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	minifying(&conf, &fileConf)
+	fileConf.finalNewline = true
+	cmdFmt(conf, fileConf, srcs)
+}
+
+func minifying(conf *jsonfmt.Conf, fileConf *fileConf) {
+	conf.Indent = ``
+	conf.Width = 0
+	conf.StripComments = true
+	conf.TrailingComma = false
+	fileConf.EditorConfig = false
+	fileConf.presets = nil
+}
+
+/*
+Converts via `jsonfmt.Conf.ConvertTo`: "json" strips comments and trailing
+commas and quotes keys, "jsonc" keeps comments but not trailing commas, "json5"
+keeps comments and adds trailing commas, and "hjson" uses "#" comments. "-from"
+sets the input dialect, see `jsonfmt.WithInputDialect`. Presets for well-known
+files are ignored, since they would override the dialect.
+*/
+func cmdConvert(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	fileConf.presets = nil
+	to := parseDialect(`-to`, fileConf.To)
+	if to == `` {
+		fail(usageError{fmt.Errorf(`[jsonfmt] expected a dialect via "-to", one of: %v`, dialectNames)})
+	}
+
+	if fileConf.From != `` {
+		jsonfmt.WithInputDialect(parseDialect(`-from`, fileConf.From))(&conf)
+	}
+	if to != jsonfmt.DialectJSON {
+		conf.StripComments = false
+	}
+	conf.ConvertTo = to
+	cmdFmt(conf, fileConf, srcs)
+}
+
+const dialectNames = `json, jsonc, json5, hjson`
+
+func parseDialect(name, src string) jsonfmt.Dialect {
+	var conf jsonfmt.Conf
+	if conf.Set(`convert-to`, src) != nil {
+		fail(usageError{fmt.Errorf(`[jsonfmt] unknown dialect %q in %q, expected one of: %v`, src, name, dialectNames)})
+	}
+	return conf.ConvertTo
+}
+
+/*
+Writes the merged sources to stdout, compressed with "-z". Unlike other
+commands, doesn't apply ".editorconfig", since the output isn't a file.
+*/
+func cmdMerge(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	if len(srcs) == 0 {
+		fail(usageError{fmt.Errorf(`[jsonfmt] expected sources to merge`)})
+	}
+
+	var lists jsonfmt.ListMerge
+	switch strings.ToLower(fileConf.Lists) {
+	case `replace`:
+		lists = jsonfmt.ListReplace
+	case `append`:
+		lists = jsonfmt.ListAppend
+	case `index`:
+		lists = jsonfmt.ListByIndex
+	default:
+		fail(usageError{fmt.Errorf(`[jsonfmt] unknown list strategy %q, expected one of: replace, append, index`, fileConf.Lists)})
+	}
+
+	var contents [][]byte
+	var errs []error
+	for _, src := range srcs {
+		_, err := withSource(conf, fileConf, src, func(_ string, _ formatFunc, content []byte) (bool, error) {
+			contents = append(contents, append([]byte(nil), content...))
+			return false, nil
+		})
+		errs = append(errs, withSourceError(sourceName(src), err))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		fail(err)
+	}
+
+	merged := jsonfmt.Merge[[]byte](conf, lists, contents...)
+	err = writeStdout(func(out io.Writer, src []byte) error {
+		_, err := out.Write(src)
+		return err
+	}, fileConf, merged)
+	if err != nil {
+		fail(err)
+	}
+}
+
+/*
+Writes the three-way merge of base, ours and theirs to stdout, with conflict
+markers for conflicting changes, and fails with `exitCheck` if there are any.
+Like "merge", doesn't apply ".editorconfig".
+*/
+func cmdMerge3(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	if len(srcs) != 3 {
+		fail(usageError{fmt.Errorf(`[jsonfmt] expected base, ours and theirs sources`)})
+	}
+
+	var contents [][]byte
+	var errs []error
+	for _, src := range srcs {
+		_, err := withSource(conf, fileConf, src, func(_ string, _ formatFunc, content []byte) (bool, error) {
+			contents = append(contents, append([]byte(nil), content...))
+			return false, nil
+		})
+		errs = append(errs, withSourceError(sourceName(src), err))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		fail(err)
+	}
+
+	merged, ok := jsonfmt.Merge3[[]byte](conf, contents[0], contents[1], contents[2])
+	err = writeStdout(func(out io.Writer, src []byte) error {
+		_, err := out.Write(src)
+		return err
+	}, fileConf, merged)
+	if err != nil {
+		fail(err)
+	}
+	if !ok {
+		exit(exitCheck, nil, summary{})
+	}
+}
+
+/*
+With "-long-lines", lines of the sources wider than "-w" flag them too, since
+formatting couldn't break them. They're reported for unformatted sources as
+well, with positions in the source.
+*/
+func cmdCheck(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	eachSource(conf, fileConf, srcs, exitCheck, `unformatted`, func(name string, format formatFunc, content []byte) (bool, error) {
+		pos, changed, err := changes(format, content)
+		if err != nil {
+			return false, err
+		}
+
+		long := checksLongLines(fileConf, name) && reportLongLines(sourceConf(conf, fileConf, name), name, content)
+		if !changed {
+			return long, nil
+		}
+		fmt.Println(name)
+		diagnoseAt(name, content, pos, `not formatted`, ``)
+		return true, nil
+	})
+}
+
+func cmdDiff(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	eachSource(conf, fileConf, srcs, exitCheck, `unformatted`, func(name string, format formatFunc, content []byte) (bool, error) {
+		var buf bytes.Buffer
+		err := format(&buf, content)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(content, buf.Bytes()) {
+			return false, nil
+		}
+		diagnoseAt(name, content, commonPrefix(content, buf.Bytes()), `not formatted`, ``)
+
+		_, err = io.WriteString(os.Stdout, unifiedDiff(name, content, buf.Bytes()))
+		return true, err
+	})
+}
+
+/*
+Reports syntax which isn't valid JSON, such as missing or trailing commas,
+unquoted keys or unbalanced brackets, as "<source>:<line>:<column>: <message>".
+Comments are ignored. Newline-delimited and concatenated values are allowed.
+*/
+func cmdLint(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	eachSource(conf, fileConf, srcs, exitParse, `invalid`, func(name string, _ formatFunc, content []byte) (bool, error) {
+		pos, msg, ok := lint(conf, content)
+		if ok {
+			return false, nil
+		}
+		line, col := lineCol(content, pos)
+		fmt.Printf("%v:%v:%v: %v\n", name, line, col, msg)
+		diagnoseAt(name, content, pos, msg, ``)
+		return true, nil
+	})
+}
+
+/*
+Writes Markdown documentation of each source to stdout, derived from its
+comments, with a heading for each source when there are several. See
+`jsonfmt.DocsOf`.
+*/
+func cmdDocs(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	eachSource(conf, fileConf, srcs, exitOk, `documented`, func(name string, _ formatFunc, content []byte) (bool, error) {
+		if len(srcs) > 1 {
+			fmt.Printf("## %v\n\n", name)
+		}
+		_, err := io.WriteString(os.Stdout, jsonfmt.DocsOf(sourceConf(conf, fileConf, name), content).Markdown(fileConf.Redact))
+		if err == nil && len(srcs) > 1 {
+			_, err = io.WriteString(os.Stdout, "\n")
+		}
+		return false, err
+	})
+}
+
+/*
+Writes a JSON Schema inferred from the sources, or stdin, to stdout. Every
+top-level value is a sample. Like "merge", doesn't apply ".editorconfig".
+*/
+func cmdSchema(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	if len(srcs) == 0 {
+		srcs = []string{``}
+	}
+
+	var contents [][]byte
+	var errs []error
+	for _, src := range srcs {
+		_, err := withSource(conf, fileConf, src, func(_ string, _ formatFunc, content []byte) (bool, error) {
+			contents = append(contents, append([]byte(nil), content...))
+			return false, nil
+		})
+		errs = append(errs, withSourceError(sourceName(src), err))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		fail(err)
+	}
+
+	err = writeStdout(func(out io.Writer, src []byte) error {
+		_, err := out.Write(src)
+		return err
+	}, fileConf, jsonfmt.InferSchema[[]byte](conf, contents...))
+	if err != nil {
+		fail(err)
+	}
+}
+
+/*
+Reads each source, decompressing gzipped content, and calls the function with
+the source name, the formatter for that source (see `pathConf`), and the
+content. The function returns true to flag the source. Stdin is named
+"<stdin>". Doesn't stop on failure. Exits with the given code if any source was
+flagged, or with the code for the errors, whichever is more severe. See `exit`.
+*/
+func eachSource(
+	conf jsonfmt.Conf, fileConf fileConf, srcs []string, code int, label string,
+	fun func(string, formatFunc, []byte) (bool, error),
+) {
+	if len(srcs) == 0 {
+		srcs = []string{``}
+	}
+
+	sum := summary{total: len(srcs), label: label}
+	var errs []error
+
+	for _, src := range srcs {
+		ok, err := withSource(conf, fileConf, src, fun)
+		if ok {
+			sum.flagged++
+		}
+		if err != nil {
+			sum.failed++
+			errs = append(errs, withSourceError(sourceName(src), err))
+		}
+	}
+
+	if sum.flagged == 0 {
+		code = exitOk
+	}
+	exit(code, errors.Join(errs...), sum)
+}
+
+func withSource(
+	conf jsonfmt.Conf, fileConf fileConf, src string,
+	fun func(string, formatFunc, []byte) (bool, error),
+) (bool, error) {
+	name := sourceName(src)
+	var content []byte
+	var err error
+
+	switch {
+	case src == ``:
+		var unmap func()
+		content, unmap, err = readStdin(fileConf)
+		if err != nil {
+			return false, fmt.Errorf(`[jsonfmt] failed to read: %w`, err)
+		}
+		defer unmap()
+
+		err = teeStdin(fileConf, content)
+		if err != nil {
+			return false, err
+		}
+
+	case isUrl(src):
+		content, err = fetch(fileConf, src)
+		if err != nil {
+			return false, err
+		}
+
+	default:
+		file, err := os.Open(src)
+		if err != nil {
+			return false, fmt.Errorf(`[jsonfmt] failed to open %q: %w`, src, err)
+		}
+		defer file.Close()
+
+		var unmap func()
+		content, unmap, err = mapFile(file, fileConf.MaxInputBytes)
+		if err != nil {
+			return false, fmt.Errorf(`[jsonfmt] failed to read %q: %w`, src, err)
+		}
+		defer unmap()
+	}
+
+	content, err = decompress(content, fileConf.MaxInputBytes)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to decompress %q: %w`, name, err)
+	}
+
+	path := src
+	if src == `` {
+		path = stdinPath
+	}
+
+	var final *bool
+	if path != `` && !isUrl(path) {
+		conf, final, err = pathConf(conf, fileConf, path)
+		if err != nil {
+			return false, fmt.Errorf(`[jsonfmt] failed to read settings for %q: %w`, path, err)
+		}
+	}
+
+	format := formatter(conf, fileConf, path)
+	if final != nil {
+		format = withFinalNewline(format, *final)
+	}
+	if reports(conf, fileConf, path) {
+		report(conf, fileConf, name, content)
+	}
+
+	ok, err := fun(name, format, content)
+	if err != nil {
+		return ok, fmt.Errorf(`[jsonfmt] failed to process %q: %w`, name, err)
+	}
+	return ok, nil
+}
+
+/*
+Path of the file being formatted via stdin, given by "-stdin-filepath", for
+example by editors. Used for the per-file config, the file type, and messages.
+The file doesn't need to exist.
+*/
+var stdinPath string
+
+func sourceName(src string) string {
+	if src == `` && stdinPath != `` {
+		return stdinPath
+	}
+	if src == `` {
+		return `<stdin>`
+	}
+	return src
+}
+
+/*
+Per-file config of the source with the given name, as used by `withSource`, for
+callbacks which need more than the formatter. Errors were already reported by
+`withSource`.
+*/
+func sourceConf(conf jsonfmt.Conf, fileConf fileConf, name string) jsonfmt.Conf {
+	if name == `<stdin>` || isUrl(name) {
+		return conf
+	}
+	out, _, err := pathConf(conf, fileConf, name)
+	if err != nil {
+		return conf
+	}
+	return out
+}
+
+/*
+Validates the content as a sequence of JSON values, with comments replaced by
+whitespace. Returns the byte offset and description of the first error.
+*/
+func lint(conf jsonfmt.Conf, content []byte) (int, string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(blankComments(conf, content)))
+
+	for {
+		var val json.RawMessage
+		err := dec.Decode(&val)
+		if errors.Is(err, io.EOF) {
+			return 0, ``, true
+		}
+
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			// The offset is past the offending byte.
+			return int(syntax.Offset) - 1, syntax.Error(), false
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return len(content), `unexpected end of input`, false
+		}
+		if err != nil {
+			return int(dec.InputOffset()), err.Error(), false
+		}
+	}
+}
+
+/*
+Replaces comments with spaces, keeping newlines, so that offsets, lines and
+columns stay the same. Understands strings, but not placeholders.
+*/
+func blankComments(conf jsonfmt.Conf, src []byte) []byte {
+	out := append([]byte(nil), src...)
+	line := []byte(conf.CommentLine)
+	start := []byte(conf.CommentBlockStart)
+	end := []byte(conf.CommentBlockEnd)
+
+	blank := func(from, to int) {
+		for ind := from; ind < to; ind++ {
+			if out[ind] != '\n' && out[ind] != '\r' {
+				out[ind] = ' '
+			}
+		}
+	}
+
+	for ind := 0; ind < len(out); {
+		rest := out[ind:]
+
+		switch {
+		case rest[0] == '"':
+			ind++
+			for ind < len(out) && out[ind] != '"' && out[ind] != '\n' {
+				if out[ind] == '\\' {
+					ind++
+				}
+				ind++
+			}
+			ind++
+
+		case len(line) > 0 && bytes.HasPrefix(rest, line):
+			size := bytes.IndexByte(rest, '\n')
+			if size < 0 {
+				size = len(rest)
+			}
+			blank(ind, ind+size)
+			ind += size
+
+		case len(start) > 0 && len(end) > 0 && bytes.HasPrefix(rest, start):
+			size := bytes.Index(rest[len(start):], end)
+			if size < 0 {
+				size = len(rest)
+			} else {
+				size += len(start) + len(end)
+			}
+			blank(ind, ind+size)
+			ind += size
+
+		default:
+			ind++
+		}
+	}
+	return out
+}
+
+func commonPrefix(one, two []byte) int {
+	var ind int
+	for ind < len(one) && ind < len(two) && one[ind] == two[ind] {
+		ind++
+	}
+	return ind
+}
+
+// 1-based line and column of the byte offset. See `jsonfmt.PosAt`.
+func lineCol(src []byte, pos int) (int, int) {
+	val := jsonfmt.PosAt(src, pos)
+	return val.Line, val.Column
+}
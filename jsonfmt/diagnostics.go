@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Set by "-json-errors". Global because `fail` may be called from anywhere.
+Diagnostics are written to stderr as JSON, one per line, in addition to the
+regular output, and errors are written as diagnostics instead of text.
+*/
+var jsonErrors bool
+
+/*
+Problem found in a source, for "-json-errors". Line and column are 1-based,
+columns count bytes, and both are omitted when unknown. "Fix" describes the
+fix applied to the source, if any.
+*/
+type diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+var diagnosticLock sync.Mutex
+
+// Writes the diagnostic to stderr with "-json-errors", otherwise does nothing.
+func diagnose(val diagnostic) {
+	if !jsonErrors {
+		return
+	}
+
+	out, err := json.Marshal(val)
+	if err != nil {
+		panic(err)
+	}
+
+	diagnosticLock.Lock()
+	defer diagnosticLock.Unlock()
+	_, _ = os.Stderr.Write(append(out, '\n'))
+}
+
+// Diagnostic at the given byte offset in the content.
+func diagnoseAt(file string, content []byte, pos int, msg, fix string) {
+	if !jsonErrors {
+		return
+	}
+	line, col := lineCol(content, pos)
+	diagnose(diagnostic{File: file, Line: line, Column: col, Message: msg, Fix: fix})
+}
+
+// Error associated with a source, for reporting it in diagnostics.
+type sourceError struct {
+	source string
+	err    error
+}
+
+func (self sourceError) Error() string { return self.err.Error() }
+func (self sourceError) Unwrap() error { return self.err }
+
+func withSourceError(source string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return sourceError{source, err}
+}
+
+// Writes each of the possibly joined errors as a diagnostic.
+func diagnoseErrors(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			diagnoseErrors(err)
+		}
+		return
+	}
+
+	var src sourceError
+	errors.As(err, &src)
+	diagnose(diagnostic{File: src.source, Message: err.Error()})
+}
+
+/*
+True if the source gets reports: warnings with "-warn", or "-atoms warn" for
+atoms only, and punctuation fixes with "-show-fixes". See `report`.
+*/
+func reports(conf jsonfmt.Conf, fileConf fileConf, path string) bool {
+	return (fileConf.Warn || fileConf.ShowFixes || conf.Atoms == jsonfmt.StrictnessWarn) && isJSON(fileConf, path)
+}
+
+/*
+Writes warnings and punctuation fixes for the JSON content to stderr, as text,
+or as diagnostics with "-json-errors". Doesn't affect the exit code. Hard
+errors, such as excessive nesting, are left to formatting, which reports them.
+*/
+func report(conf jsonfmt.Conf, fileConf fileConf, file string, content []byte) {
+	defer recoverLibraryErr()
+
+	if fileConf.Warn || conf.Atoms == jsonfmt.StrictnessWarn {
+		warn(conf, fileConf, file, content)
+	}
+	if fileConf.ShowFixes && (!conf.StrictPunctuation || conf.Repair) && !conf.Reindent {
+		showFixes(conf, file, content)
+	}
+}
+
+/*
+Recovers errors which library functions such as `jsonfmt.Warnings` panic with,
+such as `jsonfmt.ErrMaxDepth`, for callers which leave reporting them to
+formatting. Other panics, including runtime errors, are bugs and propagate.
+*/
+func recoverLibraryErr() {
+	val := recover()
+	if err, ok := val.(error); ok && isLibraryErr(err) {
+		return
+	}
+	if val != nil {
+		panic(val)
+	}
+}
+
+// Library errors are prefixed with "[jsonfmt]", see `syntaxError`.
+func isLibraryErr(err error) bool {
+	_, ok := err.(runtime.Error)
+	return !ok && strings.HasPrefix(err.Error(), `[jsonfmt] `)
+}
+
+func warn(conf jsonfmt.Conf, fileConf fileConf, file string, content []byte) {
+	for _, val := range jsonfmt.Warnings(conf, content) {
+		if !fileConf.Warn && val.Kind != jsonfmt.WarningAtom {
+			continue
+		}
+		if jsonErrors {
+			diagnose(diagnostic{File: file, Line: val.Line, Column: val.Column, Message: `warning: ` + val.Msg})
+			continue
+		}
+
+		diagnosticLock.Lock()
+		fmt.Fprintf(os.Stderr, "%v:%v:%v: warning: %v\n", file, val.Line, val.Column, val.Msg)
+		diagnosticLock.Unlock()
+	}
+}
+
+/*
+Prints each punctuation fix with the offending line and a caret, so that authors
+of hand-edited files learn what was wrong, rather than having it silently fixed.
+*/
+func showFixes(conf jsonfmt.Conf, file string, content []byte) {
+	for _, val := range jsonfmt.PunctuationErrors(conf, content) {
+		if jsonErrors {
+			diagnose(diagnostic{File: file, Line: val.Line, Column: val.Column, Message: val.Msg, Fix: val.Fix})
+			continue
+		}
+
+		fix := val.Fix
+		if fix == `` {
+			fix = `not fixed`
+		}
+
+		diagnosticLock.Lock()
+		fmt.Fprintf(os.Stderr, "%v:%v:%v: %v (%v)\n%v", file, val.Line, val.Column, val.Msg, fix, snippet(content, val.Offset))
+		diagnosticLock.Unlock()
+	}
+}
+
+/*
+Line of the content at the given byte offset, and a caret under the offset on
+the next line, both indented with a tab and ending with a newline. Tabs before
+the offset are kept in the caret line for alignment.
+*/
+func snippet(content []byte, pos int) string {
+	start := bytes.LastIndexByte(content[:pos], '\n') + 1
+	end := bytes.IndexByte(content[pos:], '\n')
+	if end < 0 {
+		end = len(content)
+	} else {
+		end += pos
+	}
+
+	line := strings.TrimRight(string(content[start:end]), "\r")
+	caret := []rune(string(content[start:pos]))
+	for ind, char := range caret {
+		if char != '\t' {
+			caret[ind] = ' '
+		}
+	}
+	return "\t" + line + "\n\t" + string(caret) + "^\n"
+}
+
+// Reports on the content before formatting it. See `report`.
+func withReport(format formatFunc, conf jsonfmt.Conf, fileConf fileConf, file string) formatFunc {
+	return func(out io.Writer, src []byte) error {
+		report(conf, fileConf, file, src)
+		return format(out, src)
+	}
+}
+
+// True if "-long-lines" applies to the source. Only JSON is checked.
+func checksLongLines(fileConf fileConf, path string) bool {
+	return fileConf.LongLines && isJSON(fileConf, path)
+}
+
+/*
+Writes lines of the content wider than "-w" to stderr, as
+"file:line:column: message" with the column of the first character past the
+width, or as diagnostics with "-json-errors". Returns true if there were any.
+See `jsonfmt.LongLines`.
+*/
+func reportLongLines(conf jsonfmt.Conf, file string, content []byte) bool {
+	lines := jsonfmt.LongLines(conf, content)
+
+	for _, val := range lines {
+		msg := fmt.Sprintf(`line is %v characters wide, past the width of %v`, val.Width, conf.Width)
+		if jsonErrors {
+			diagnose(diagnostic{File: file, Line: val.Pos.Line, Column: val.Pos.Column, Message: msg})
+			continue
+		}
+
+		diagnosticLock.Lock()
+		fmt.Fprintf(os.Stderr, "%v:%v:%v: %v\n", file, val.Pos.Line, val.Pos.Column, msg)
+		diagnosticLock.Unlock()
+	}
+	return len(lines) > 0
+}
+
+// Reports long lines of the output after formatting it. See `reportLongLines`.
+func withLongLines(format formatFunc, conf jsonfmt.Conf, file string) formatFunc {
+	return func(out io.Writer, src []byte) error {
+		var buf bytes.Buffer
+		err := format(io.MultiWriter(out, &buf), src)
+		if err == nil {
+			reportLongLines(conf, file, buf.Bytes())
+		}
+		return err
+	}
+}
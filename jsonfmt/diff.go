@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Lines of unchanged context around each hunk of `unifiedDiff`.
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+/*
+Returns a unified diff between the original and formatted content, with "a/"
+and "b/" prefixes like git. Returns an empty string if there's no difference.
+*/
+func unifiedDiff(name string, prev, next []byte) string {
+	ops := diffLines(lineStrings(prev), lineStrings(next))
+
+	var buf strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk while changes are close enough to share context.
+		end := start
+		for ind := start; ind < len(ops) && ind <= end+2*diffContext; ind++ {
+			if ops[ind].kind != ' ' {
+				end = ind
+			}
+		}
+
+		if buf.Len() == 0 {
+			path := strings.TrimPrefix(filepath.ToSlash(name), `/`)
+			fmt.Fprintf(&buf, "--- a/%v\n+++ b/%v\n", path, path)
+		}
+		writeHunk(&buf, ops, start-diffContext, end+1+diffContext)
+		start = end + 1
+	}
+	return buf.String()
+}
+
+func lineStrings(src []byte) []string {
+	var out []string
+	for _, line := range splitLines(src) {
+		out = append(out, string(line))
+	}
+	return out
+}
+
+func writeHunk(buf *strings.Builder, ops []diffOp, start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(ops) {
+		end = len(ops)
+	}
+
+	prevLine, nextLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			prevLine++
+		}
+		if op.kind != '-' {
+			nextLine++
+		}
+	}
+
+	var prevCount, nextCount int
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			prevCount++
+		}
+		if op.kind != '-' {
+			nextCount++
+		}
+	}
+
+	// Empty ranges are numbered after the preceding line, like GNU diff.
+	if prevCount == 0 {
+		prevLine--
+	}
+	if nextCount == 0 {
+		nextLine--
+	}
+
+	fmt.Fprintf(buf, "@@ -%v,%v +%v,%v @@\n", prevLine, prevCount, nextLine, nextCount)
+	for _, op := range ops[start:end] {
+		buf.WriteByte(op.kind)
+		buf.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+/*
+Myers' diff algorithm, over lines. Lines should include their line endings, so
+that a missing final newline counts as a difference. Common prefix and suffix
+are trimmed first, which keeps typical formatting diffs cheap.
+*/
+func diffLines(prev, next []string) []diffOp {
+	var head, tail []diffOp
+
+	for len(prev) > 0 && len(next) > 0 && prev[0] == next[0] {
+		head = append(head, diffOp{' ', prev[0]})
+		prev, next = prev[1:], next[1:]
+	}
+	for len(prev) > 0 && len(next) > 0 && prev[len(prev)-1] == next[len(next)-1] {
+		tail = append(tail, diffOp{' ', prev[len(prev)-1]})
+		prev, next = prev[:len(prev)-1], next[:len(next)-1]
+	}
+
+	out := append(head, myers(prev, next)...)
+	for ind := len(tail) - 1; ind >= 0; ind-- {
+		out = append(out, tail[ind])
+	}
+	return out
+}
+
+func myers(prev, next []string) []diffOp {
+	size := len(prev) + len(next)
+	if size == 0 {
+		return nil
+	}
+
+	off := size + 1
+	vec := make([]int, 2*size+3)
+	var trace [][]int
+
+outer:
+	for dist := 0; dist <= size; dist++ {
+		trace = append(trace, append([]int(nil), vec...))
+
+		for diag := -dist; diag <= dist; diag += 2 {
+			var x int
+			if diag == -dist || (diag != dist && vec[off+diag-1] < vec[off+diag+1]) {
+				x = vec[off+diag+1]
+			} else {
+				x = vec[off+diag-1] + 1
+			}
+			y := x - diag
+
+			for x < len(prev) && y < len(next) && prev[x] == next[y] {
+				x++
+				y++
+			}
+			vec[off+diag] = x
+
+			if x >= len(prev) && y >= len(next) {
+				break outer
+			}
+		}
+	}
+
+	var out []diffOp
+	x, y := len(prev), len(next)
+
+	for dist := len(trace) - 1; dist >= 0; dist-- {
+		vec := trace[dist]
+		diag := x - y
+
+		var prevDiag int
+		if diag == -dist || (diag != dist && vec[off+diag-1] < vec[off+diag+1]) {
+			prevDiag = diag + 1
+		} else {
+			prevDiag = diag - 1
+		}
+		prevX := vec[off+prevDiag]
+		prevY := prevX - prevDiag
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			out = append(out, diffOp{' ', prev[x]})
+		}
+
+		if dist > 0 {
+			if x == prevX {
+				y--
+				out = append(out, diffOp{'+', next[y]})
+			} else {
+				x--
+				out = append(out, diffOp{'-', prev[x]})
+			}
+		}
+	}
+
+	for one, two := 0, len(out)-1; one < two; one, two = one+1, two-1 {
+		out[one], out[two] = out[two], out[one]
+	}
+	return out
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+const editorConfigName = `.editorconfig`
+
+// Parsed ".editorconfig" file.
+type editorConfig struct {
+	dir      string
+	root     bool
+	sections []editorSection
+}
+
+type editorSection struct {
+	pattern *regexp.Regexp
+	props   map[string]string
+}
+
+var editorConfigCache struct {
+	sync.Mutex
+	files map[string]*editorConfig
+}
+
+/*
+Applies the ".editorconfig" properties for the given file on top of the config:
+"indent_style", "indent_size", "tab_width", "max_line_length" and
+"end_of_line", except "cr", which isn't supported. The returned
+pointer reflects "insert_final_newline", and is nil when unspecified. Files are
+looked up from the directory of the target file upwards, until one declares
+"root = true". Properties in closer files take priority.
+*/
+func applyEditorConfig(conf jsonfmt.Conf, path string) (jsonfmt.Conf, *bool, error) {
+	props, err := editorProps(path)
+	if err != nil || len(props) == 0 {
+		return conf, nil, err
+	}
+
+	style := props[`indent_style`]
+	size := props[`indent_size`]
+	if size == `tab` || size == `` {
+		size = props[`tab_width`]
+	}
+
+	if style == `tab` {
+		conf.Indent = "\t"
+	} else if count, err := strconv.Atoi(size); err == nil && count >= 0 &&
+		(style == `space` || conf.Indent != "\t") {
+		conf.Indent = strings.Repeat(` `, count)
+	}
+
+	if width, err := strconv.ParseUint(props[`max_line_length`], 10, 64); err == nil {
+		conf.Width = width
+	}
+
+	switch props[`end_of_line`] {
+	case `lf`:
+		conf.LineEnding = jsonfmt.LineEndingLF
+	case `crlf`:
+		conf.LineEnding = jsonfmt.LineEndingCRLF
+	}
+
+	var final *bool
+	if val, err := strconv.ParseBool(props[`insert_final_newline`]); err == nil {
+		final = &val
+	}
+	return conf, final, nil
+}
+
+// Merged properties of all sections matching the given file.
+func editorProps(path string) (map[string]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var confs []*editorConfig
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		conf, err := readEditorConfig(dir)
+		if err != nil {
+			return nil, err
+		}
+		if conf != nil {
+			confs = append(confs, conf)
+			if conf.root {
+				break
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	props := map[string]string{}
+	for ind := len(confs) - 1; ind >= 0; ind-- {
+		conf := confs[ind]
+		rel, err := filepath.Rel(conf.dir, path)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, sect := range conf.sections {
+			if sect.pattern.MatchString(rel) {
+				for key, val := range sect.props {
+					props[key] = val
+				}
+			}
+		}
+	}
+	return props, nil
+}
+
+// Cached. Returns nil if the directory has no ".editorconfig".
+func readEditorConfig(dir string) (*editorConfig, error) {
+	cache := &editorConfigCache
+	cache.Lock()
+	defer cache.Unlock()
+
+	if conf, ok := cache.files[dir]; ok {
+		return conf, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, editorConfigName))
+	if os.IsNotExist(err) {
+		content, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conf *editorConfig
+	if content != nil {
+		conf = parseEditorConfig(dir, content)
+	}
+
+	if cache.files == nil {
+		cache.files = map[string]*editorConfig{}
+	}
+	cache.files[dir] = conf
+	return conf, nil
+}
+
+func parseEditorConfig(dir string, content []byte) *editorConfig {
+	out := &editorConfig{dir: dir}
+	var sect *editorSection
+
+	scan := bufio.NewScanner(bytes.NewReader(content))
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == `` || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			out.sections = append(out.sections, editorSection{
+				pattern: editorGlob(line[1 : len(line)-1]),
+				props:   map[string]string{},
+			})
+			sect = &out.sections[len(out.sections)-1]
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, `=`)
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.ToLower(strings.TrimSpace(val))
+
+		if sect == nil {
+			out.root = out.root || key == `root` && val == `true`
+		} else {
+			sect.props[key] = val
+		}
+	}
+	return out
+}
+
+/*
+Converts an EditorConfig glob into a regexp matching slash-separated paths
+relative to the directory of the ".editorconfig". Patterns without a slash
+match file names in any subdirectory.
+*/
+func editorGlob(glob string) *regexp.Regexp {
+	var buf strings.Builder
+	buf.WriteString(`^`)
+
+	if strings.Contains(glob, `/`) {
+		glob = strings.TrimPrefix(glob, `/`)
+	} else {
+		buf.WriteString(`(?:.*/)?`)
+	}
+
+	depth := 0
+	for ind := 0; ind < len(glob); ind++ {
+		char := glob[ind]
+
+		switch {
+		case char == '\\' && ind+1 < len(glob):
+			ind++
+			buf.WriteString(regexp.QuoteMeta(glob[ind : ind+1]))
+		case char == '*' && strings.HasPrefix(glob[ind:], `**`):
+			ind++
+			buf.WriteString(`.*`)
+		case char == '*':
+			buf.WriteString(`[^/]*`)
+		case char == '?':
+			buf.WriteString(`[^/]`)
+		case char == '[':
+			end := strings.IndexByte(glob[ind:], ']')
+			if end < 0 {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := glob[ind+1 : ind+end]
+			if strings.HasPrefix(class, `!`) {
+				class = `^` + class[1:]
+			}
+			buf.WriteString(`[` + class + `]`)
+			ind += end
+		case char == '{':
+			depth++
+			buf.WriteString(`(?:`)
+		case char == '}' && depth > 0:
+			depth--
+			buf.WriteString(`)`)
+		case char == ',' && depth > 0:
+			buf.WriteString(`|`)
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	for ; depth > 0; depth-- {
+		buf.WriteString(`)`)
+	}
+	buf.WriteString(`$`)
+
+	out, err := regexp.Compile(buf.String())
+	if err != nil {
+		return regexp.MustCompile(`^$.`)
+	}
+	return out
+}
+
+/*
+Enforces the presence or absence of a final newline in streamed output.
+Trailing newlines are held back until more content arrives or the writer is
+closed. Closing doesn't close the underlying writer.
+*/
+type finalNewlineWriter struct {
+	out     io.Writer
+	insert  bool
+	pending []byte
+	wrote   bool
+}
+
+func (self *finalNewlineWriter) Write(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+
+	body := bytes.TrimRight(src, "\r\n")
+	if len(body) > 0 {
+		_, err := self.out.Write(self.pending)
+		if err != nil {
+			return 0, err
+		}
+		self.pending = self.pending[:0]
+
+		_, err = self.out.Write(body)
+		if err != nil {
+			return 0, err
+		}
+		self.wrote = true
+	}
+
+	self.pending = append(self.pending, src[len(body):]...)
+	return len(src), nil
+}
+
+func (self *finalNewlineWriter) Close() error {
+	if !self.insert || !self.wrote {
+		return nil
+	}
+
+	newline := []byte("\n")
+	if bytes.HasPrefix(self.pending, []byte("\r\n")) {
+		newline = []byte("\r\n")
+	}
+	_, err := self.out.Write(newline)
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Exit codes. When several apply, the most severe one wins, see `exitSeverity`.
+Multi-source runs don't stop on failure, so the exit code reflects every source.
+*/
+const (
+	// Nothing to report. Files may have been formatted in place.
+	exitOk = 0
+
+	// "check" or "diff" found unformatted sources, or "merge3" found conflicts.
+	exitCheck = 1
+
+	// Invalid flags, arguments or settings. Also used by the "flag" package.
+	exitUsage = 2
+
+	// Files were changed, or would be changed with "-n", in modes that report
+	// changes: "hook" and "-n".
+	exitChanged = 3
+
+	// "lint" found invalid syntax, or a source couldn't be decoded, for example
+	// broken gzip or a malformed Go string literal, or "-atoms strict" found
+	// unknown atoms, or "-strict-punctuation" found broken punctuation, or
+	// "-noise reject" found control characters.
+	exitParse = 4
+
+	// Failure to read, fetch or write a source, including sources exceeding
+	// "-max-input-bytes" or "-timeout".
+	exitIO = 5
+)
+
+// Exit codes from least to most severe.
+var exitSeverity = []int{exitOk, exitChanged, exitCheck, exitParse, exitUsage, exitIO}
+
+func worseExit(one, two int) int {
+	for _, code := range exitSeverity {
+		if code == one {
+			return two
+		}
+		if code == two {
+			return one
+		}
+	}
+	return one
+}
+
+// Source content that couldn't be decoded. See `exitParse`.
+type parseError struct{ error }
+
+func (self parseError) Unwrap() error { return self.error }
+
+/*
+Source rejected by formatting due to its syntax, such as broken punctuation with
+"-strict-punctuation". Reported as a parse error, without repeating the prefix
+of the library error.
+*/
+type syntaxError struct{ error }
+
+func (self syntaxError) Error() string {
+	return `[jsonfmt] failed to parse: ` + strings.TrimPrefix(self.error.Error(), `[jsonfmt] `)
+}
+
+func (self syntaxError) Unwrap() error { return self.error }
+
+// True for errors which the library returns for invalid source syntax.
+func isSyntaxError(err error) bool {
+	return errors.As(err, new(*jsonfmt.AtomError)) ||
+		errors.As(err, new(*jsonfmt.NoiseError)) ||
+		errors.As(err, new(*jsonfmt.PunctuationError))
+}
+
+// Invalid flags, arguments or settings. See `exitUsage`.
+type usageError struct{ error }
+
+func (self usageError) Unwrap() error { return self.error }
+
+// Exit code for the error, joined or not. Unclassified errors count as IO errors.
+func errorExit(err error) int {
+	if err == nil {
+		return exitOk
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		code := exitOk
+		for _, err := range joined.Unwrap() {
+			code = worseExit(code, errorExit(err))
+		}
+		return code
+	}
+
+	if errors.As(err, new(usageError)) {
+		return exitUsage
+	}
+	if errors.As(err, new(parseError)) || isSyntaxError(err) {
+		return exitParse
+	}
+	return exitIO
+}
+
+// Number of errors, counting each joined error separately.
+func countErrors(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var count int
+		for _, err := range joined.Unwrap() {
+			count += countErrors(err)
+		}
+		return count
+	}
+	return 1
+}
+
+/*
+Outcome of a multi-source run. Printed to stderr when some sources failed,
+unless "-json-errors" is set, since diagnostics already cover every source.
+*/
+type summary struct {
+	total   int
+	flagged int
+	failed  int
+	label   string // Describes flagged sources, for example "changed".
+}
+
+func (self summary) String() string {
+	return fmt.Sprintf(`[jsonfmt] %v sources: %v %v, %v failed`, self.total, self.flagged, self.label, self.failed)
+}
+
+/*
+Reports the error, if any, and the summary, then exits with the most severe of
+the given code and the code for the error.
+*/
+func exit(code int, err error, sum summary) {
+	if err != nil {
+		if jsonErrors {
+			diagnoseErrors(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+		}
+		code = worseExit(code, errorExit(err))
+	}
+
+	if sum.total > 1 && sum.failed > 0 && !jsonErrors {
+		fmt.Fprintln(os.Stderr, sum)
+	}
+	runStats.print()
+	os.Exit(code)
+}
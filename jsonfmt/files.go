@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Files mode. Formats the given files in place, failing after all are done. In
+dry-run mode, prints the paths that would change, without writing, and exits
+with `exitChanged` if any. URLs are fetched and formatted to stdout, in order,
+before any files.
+*/
+func files(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	var paths []string
+	var errs []error
+
+	for _, src := range srcs {
+		if !isUrl(src) {
+			paths = append(paths, src)
+			continue
+		}
+
+		format := formatter(conf, fileConf, src)
+		if reports(conf, fileConf, src) {
+			format = withReport(format, conf, fileConf, src)
+		}
+		if checksLongLines(fileConf, src) {
+			format = withLongLines(format, conf, src)
+		}
+
+		body, err := fetch(fileConf, src)
+		if err == nil {
+			err = writeStdout(format, fileConf, body)
+		}
+		errs = append(errs, withSourceError(src, err))
+	}
+
+	changed, err := formatFiles(conf, fileConf, paths)
+	errs = append(errs, err)
+
+	code := exitOk
+	if fileConf.DryRun {
+		for _, path := range changed {
+			fmt.Println(path)
+		}
+		if len(changed) > 0 {
+			code = exitChanged
+		}
+	}
+
+	err = errors.Join(errs...)
+	exit(code, err, summary{
+		total:   len(srcs),
+		flagged: len(changed),
+		failed:  countErrors(err),
+		label:   `changed`,
+	})
+}
+
+/*
+Formats the given files in place, using a pool of at most `fileConf.Jobs`
+workers. Doesn't stop on failure. Returns the changed paths in their original
+order, and the errors of all failed files joined together.
+*/
+func formatFiles(conf jsonfmt.Conf, fileConf fileConf, paths []string) ([]string, error) {
+	changed := make([]bool, len(paths))
+	errs := make([]error, len(paths))
+	indexes := make(chan int)
+	jobs := fileConf.Jobs
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(paths) {
+		jobs = len(paths)
+	}
+
+	var group sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			var err error
+			for ind := range indexes {
+				changed[ind], err = formatFile(conf, fileConf, paths[ind])
+				errs[ind] = withSourceError(paths[ind], err)
+			}
+		}()
+	}
+
+	for ind := range paths {
+		indexes <- ind
+	}
+	close(indexes)
+	group.Wait()
+
+	var out []string
+	for ind, ok := range changed {
+		if ok {
+			out = append(out, paths[ind])
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+/*
+Formats the given file in place. Returns true if the file was changed, or would
+be changed in dry-run mode. Files that are already formatted are not rewritten.
+With `fileConf.Backup`, the original content is preserved in a ".bak" file next
+to the original. Gzipped files are decompressed for formatting and compressed
+again for writing.
+
+The input is memory-mapped where possible, and the output is streamed into a
+temporary file which then replaces the original, which keeps memory usage low
+even for huge files.
+*/
+func formatFile(conf jsonfmt.Conf, fileConf fileConf, path string) (bool, error) {
+	start := time.Now()
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to open %q: %w`, path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to stat %q: %w`, path, err)
+	}
+
+	source, unmap, err := mapFile(file, fileConf.MaxInputBytes)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to read %q: %w`, path, err)
+	}
+	defer unmap()
+
+	compressed := isGzip(source)
+	content, err := decompress(source, fileConf.MaxInputBytes)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to decompress %q: %w`, path, err)
+	}
+
+	conf, final, err := pathConf(conf, fileConf, path)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to read settings for %q: %w`, path, err)
+	}
+
+	format := formatter(conf, fileConf, path)
+	if final != nil {
+		format = withFinalNewline(format, *final)
+	}
+	if reports(conf, fileConf, path) {
+		report(conf, fileConf, path, content)
+	}
+
+	pos, changed, err := changes(format, content)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to format %q: %w`, path, err)
+	}
+	if !changed {
+		if checksLongLines(fileConf, path) {
+			reportLongLines(conf, path, content)
+		}
+		runStats.add(conf, fileConf, path, format, content, start)
+		return false, nil
+	}
+	if fileConf.DryRun {
+		diagnoseAt(path, content, pos, `not formatted`, ``)
+		runStats.add(conf, fileConf, path, format, content, start)
+		return true, nil
+	}
+
+	if fileConf.Backup {
+		err = os.WriteFile(path+`.bak`, source, info.Mode().Perm())
+		if err != nil {
+			return false, fmt.Errorf(`[jsonfmt] failed to write backup of %q: %w`, path, err)
+		}
+	}
+
+	write := format
+	if checksLongLines(fileConf, path) {
+		write = withLongLines(format, conf, path)
+	}
+
+	err = replaceFile(path, info.Mode().Perm(), func(out io.Writer) error {
+		if !compressed {
+			return write(out, content)
+		}
+
+		zip := gzip.NewWriter(out)
+		err := write(zip, content)
+		if err != nil {
+			return err
+		}
+		return zip.Close()
+	})
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to write %q: %w`, path, err)
+	}
+	diagnoseAt(path, content, pos, `not formatted`, `formatted`)
+	runStats.add(conf, fileConf, path, format, content, start)
+	return true, nil
+}
+
+// Formats the source into the writer. See `formatter`.
+type formatFunc func(io.Writer, []byte) error
+
+/*
+Chooses how to format the given file: Markdown files, or any input with "-md",
+get their JSON code fences formatted; Go files get their marked string literals
+formatted; everything else is formatted as JSON.
+*/
+func formatter(conf jsonfmt.Conf, fileConf fileConf, path string) formatFunc {
+	if isGo(path) {
+		return func(out io.Writer, src []byte) error {
+			return formatGo(conf, out, src)
+		}
+	}
+	if fileConf.Markdown || isMarkdown(path) {
+		return func(out io.Writer, src []byte) error {
+			return formatMarkdown(conf, out, src)
+		}
+	}
+	return func(out io.Writer, src []byte) error {
+		return formatJSON(conf, fileConf, out, src)
+	}
+}
+
+// Aborts after "-timeout", if any.
+func formatJSON(conf jsonfmt.Conf, fileConf fileConf, out io.Writer, src []byte) error {
+	if fileConf.Timeout <= 0 {
+		return jsonfmt.FormatTo(conf, out, src)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fileConf.Timeout)
+	defer cancel()
+
+	err := jsonfmt.FormatToCtx(ctx, conf, out, src)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf(`formatting exceeded the timeout of %v, see "-timeout"`, fileConf.Timeout)
+	}
+	return err
+}
+
+// True if `formatter` formats the entire source as JSON.
+func isJSON(fileConf fileConf, path string) bool {
+	return !isGo(path) && !fileConf.Markdown && !isMarkdown(path)
+}
+
+/*
+Per-file config: the preset for well-known files, if any, replaces the given
+config, followed by ".editorconfig" properties, overridden by "-conf" and
+explicit flags. The returned pointer reflects "insert_final_newline", and is
+nil when unspecified.
+*/
+func pathConf(conf jsonfmt.Conf, fileConf fileConf, path string) (jsonfmt.Conf, *bool, error) {
+	if name, ok := jsonfmt.FilePreset(path); ok {
+		if val, ok := fileConf.presets[name]; ok {
+			conf = val
+		}
+	}
+
+	if !fileConf.EditorConfig {
+		return conf, nil, nil
+	}
+
+	conf, final, err := applyEditorConfig(conf, path)
+	if err != nil {
+		return conf, nil, err
+	}
+
+	if fileConf.override != nil {
+		fileConf.override(&conf)
+	}
+	return conf, final, nil
+}
+
+func withFinalNewline(format formatFunc, insert bool) formatFunc {
+	return func(out io.Writer, src []byte) error {
+		writer := finalNewlineWriter{out: out, insert: insert}
+		err := format(&writer, src)
+		if err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+}
+
+/*
+True if formatting would change the content. Stops at the first difference,
+returning its byte offset in the content. Failures of formatting, such as
+"-timeout", are returned rather than counted as changes.
+*/
+func changes(format formatFunc, content []byte) (int, bool, error) {
+	out := cmpWriter{expected: content}
+	err := format(&out, content)
+	if err != nil && !errors.Is(err, errChanged) {
+		return out.pos, false, err
+	}
+	return out.pos, err != nil || out.pos != len(content), nil
+}
+
+var errChanged = errors.New(`changed`)
+
+// Compares written content with the expected content, without storing it.
+type cmpWriter struct {
+	expected []byte
+	pos      int
+}
+
+func (self *cmpWriter) Write(src []byte) (int, error) {
+	if !bytes.HasPrefix(self.expected[self.pos:], src) {
+		rest := self.expected[self.pos:]
+		for ind := 0; ind < len(src) && ind < len(rest) && src[ind] == rest[ind]; ind++ {
+			self.pos++
+		}
+		return 0, errChanged
+	}
+	self.pos += len(src)
+	return len(src), nil
+}
+
+/*
+Writes into a temporary file in the same directory, then renames it to the
+target path. Symlinks are followed, replacing their targets.
+*/
+func replaceFile(path string, perm fs.FileMode, fun func(io.Writer) error) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), `.`+filepath.Base(path)+`.*`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	buf := bufio.NewWriter(temp)
+	err = fun(buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = temp.Chmod(perm)
+	}
+	if err == nil {
+		err = temp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+func isGo(path string) bool { return filepath.Ext(path) == `.go` }
+
+/*
+Formats JSON in Go string literals marked with a preceding "jsonfmt" comment,
+leaving everything else untouched. The marker may be a line comment on the preceding
+line, or a block comment right before the literal, and applies to the string
+literal which immediately follows it, or which starts the value of the
+declaration, assignment or field which follows it:
+
+	// jsonfmt
+	const src = `{"one": 10}`
+
+Any other token cancels the marker, so it never applies to strings further in
+the file. Raw strings keep their layout relative to the surrounding code: when
+the content starts on the next line, lines are indented like the first line of
+the original content; otherwise, continuation lines are indented like the line
+where the literal starts. Interpreted strings are re-escaped.
+*/
+func formatGo(conf jsonfmt.Conf, out io.Writer, src []byte) error {
+	fset := token.NewFileSet()
+	file := fset.AddFile(``, fset.Base(), len(src))
+
+	var errs scanner.ErrorList
+	var scan scanner.Scanner
+	scan.Init(file, src, func(pos token.Position, msg string) { errs.Add(pos, msg) }, scanner.ScanComments)
+
+	var buf bytes.Buffer
+	prev := 0
+	marked := false
+
+	for {
+		pos, tok, lit := scan.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if tok == token.COMMENT {
+			marked = marked || isGoMarker(lit)
+			continue
+		}
+
+		if tok != token.STRING {
+			marked = marked && isGoMarkerGap(tok)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		marked = false
+
+		start := file.Offset(pos)
+		output, err := formatGoString(conf, lit, lineIndent(src, start))
+		if err != nil {
+			return parseError{fmt.Errorf(`%v: %w`, fset.Position(pos), err)}
+		}
+
+		buf.Write(src[prev:start])
+		buf.WriteString(output)
+		prev = start + len(lit)
+	}
+
+	if errs.Len() > 0 {
+		return errs.Err()
+	}
+
+	buf.Write(src[prev:])
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func isGoMarker(comment string) bool {
+	comment = strings.TrimPrefix(comment, `//`)
+	comment = strings.TrimPrefix(comment, `/*`)
+	comment = strings.TrimSuffix(comment, `*/`)
+	return strings.TrimSpace(comment) == `jsonfmt`
+}
+
+/*
+Tokens which may separate a marker from its string literal, as in
+"const src = `...`", "src := `...`" or "Src: `...`".
+*/
+func isGoMarkerGap(tok token.Token) bool {
+	switch tok {
+	case token.CONST, token.VAR, token.IDENT, token.PERIOD, token.ASSIGN, token.DEFINE, token.COLON:
+		return true
+	default:
+		return false
+	}
+}
+
+func formatGoString(conf jsonfmt.Conf, lit string, indent string) (string, error) {
+	if lit[0] == '"' {
+		content, err := strconv.Unquote(lit)
+		if err != nil {
+			return ``, err
+		}
+		return strconv.Quote(strings.TrimSuffix(jsonfmt.FormatString(conf, content), "\n")), nil
+	}
+
+	content := lit[1 : len(lit)-1]
+	body := strings.TrimSpace(content)
+	if body == `` {
+		return lit, nil
+	}
+
+	lead := content[:strings.Index(content, body[:1])]
+	trail := content[len(lead)+len(body):]
+	output := strings.TrimSuffix(jsonfmt.FormatString(conf, body), "\n")
+
+	if strings.Contains(lead, "\n") {
+		lineStart := lead[strings.LastIndex(lead, "\n")+1:]
+		return "`" + lead + strings.ReplaceAll(output, "\n", "\n"+lineStart) + trail + "`", nil
+	}
+	return "`" + lead + strings.ReplaceAll(output, "\n", "\n"+indent) + trail + "`", nil
+}
+
+// Leading whitespace of the line containing the given offset.
+func lineIndent(src []byte, offset int) string {
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	end := start
+	for end < offset && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return string(src[start:end])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+)
+
+// Magic number at the start of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isGzip(src []byte) bool { return bytes.HasPrefix(src, gzipMagic) }
+
+// Magic number at the start of every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+/*
+Decompresses gzipped sources, see `gunzip`, and returns other sources as-is.
+Zstd is detected but not supported, since decoding it would need a dependency,
+so it fails with a `parseError` instead of being formatted as garbage.
+*/
+func decompress(src []byte, limit int64) ([]byte, error) {
+	if isGzip(src) {
+		return gunzip(src, limit)
+	}
+	if bytes.HasPrefix(src, zstdMagic) {
+		return nil, parseError{errors.New(`zstd is not supported, decompress with "zstd -dc" first`)}
+	}
+	return src, nil
+}
+
+/*
+Concatenated gzip streams, common in rotated logs, are read as one. The
+decompressed size is limited like other input, see "-max-input-bytes", since a
+small gzip stream can expand without bound. Other errors are `parseError`,
+since the source is already read.
+*/
+func gunzip(src []byte, limit int64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, parseError{err}
+	}
+	defer reader.Close()
+
+	out, err := readLimited(reader, limit)
+	if errors.As(err, new(inputLimitError)) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, parseError{err}
+	}
+	return out, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Pre-commit hook mode. Formats the given files in place, or the files staged in
+git when none are given, skipping files whose extensions don't match. Changed
+files are either restaged, or printed to stdout with `exitChanged`, which is
+what the pre-commit framework expects from hooks that modify files. Failed files
+don't prevent the others from being formatted and restaged.
+
+Restaging never stages unstaged edits: files which differ from the index before
+formatting are formatted separately in the index, see `restageIndex`.
+*/
+func hook(conf jsonfmt.Conf, fileConf fileConf, paths []string) {
+	if len(paths) == 0 {
+		paths = stagedFiles()
+	}
+
+	var matching []string
+	for _, path := range paths {
+		if fileConf.match(path) {
+			matching = append(matching, path)
+		}
+	}
+
+	restage := fileConf.Restage && !fileConf.DryRun
+	partial := map[string]bool{}
+	if restage {
+		for _, path := range matching {
+			partial[path] = hasUnstaged(path)
+		}
+	}
+
+	changed, err := formatFiles(conf, fileConf, matching)
+	code := exitOk
+
+	if restage {
+		var whole []string
+		for _, path := range changed {
+			if !partial[path] {
+				whole = append(whole, path)
+			}
+		}
+		if len(whole) > 0 {
+			git(append([]string{`add`, `--`}, whole...)...)
+		}
+
+		errs := []error{err}
+		for _, path := range matching {
+			if partial[path] {
+				_, err := restageIndex(conf, fileConf, path)
+				errs = append(errs, err)
+			}
+		}
+		err = errors.Join(errs...)
+	} else {
+		for _, path := range changed {
+			fmt.Println(path)
+		}
+		if len(changed) > 0 {
+			code = exitChanged
+		}
+	}
+
+	exit(code, err, summary{
+		total:   len(matching),
+		flagged: len(changed),
+		failed:  countErrors(err),
+		label:   `changed`,
+	})
+}
+
+// Matches the file extension against `fileConf.Exts`.
+func (self fileConf) match(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == `` {
+		return false
+	}
+
+	for _, val := range strings.Split(self.Exts, `,`) {
+		val = strings.TrimSpace(val)
+		if val != `` && strings.EqualFold(`.`+strings.TrimPrefix(val, `.`), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func stagedFiles() []string {
+	out := git(`diff`, `--cached`, `--name-only`, `--relative`, `--diff-filter=ACMR`, `-z`)
+
+	var paths []string
+	for _, path := range bytes.Split(out, []byte{0}) {
+		if len(path) > 0 {
+			paths = append(paths, string(path))
+		}
+	}
+	return paths
+}
+
+// True if the working tree copy of the file differs from the index.
+func hasUnstaged(path string) bool {
+	return len(git(`diff`, `--name-only`, `-z`, `--`, path)) > 0
+}
+
+/*
+Formats the staged content of the file, rather than its working tree copy, and
+writes the result back to the index, leaving the working tree alone. Used for
+partially staged files. Returns true if the staged content changed. Gzipped
+files and files missing from the index are left as-is.
+*/
+func restageIndex(conf jsonfmt.Conf, fileConf fileConf, path string) (bool, error) {
+	entry, err := runGit(nil, `ls-files`, `--stage`, `--full-name`, `-z`, `--`, path)
+	if err != nil {
+		return false, err
+	}
+
+	// "<mode> <object> <stage>\t<path>\x00"
+	head, name, ok := strings.Cut(strings.TrimSuffix(string(entry), "\x00"), "\t")
+	fields := strings.Fields(head)
+	if !ok || len(fields) != 3 {
+		return false, nil
+	}
+	mode, object := fields[0], fields[1]
+
+	content, err := runGit(nil, `cat-file`, `blob`, object)
+	if err != nil || isGzip(content) {
+		return false, err
+	}
+
+	conf, final, err := pathConf(conf, fileConf, path)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to read settings for %q: %w`, path, err)
+	}
+
+	format := formatter(conf, fileConf, path)
+	if final != nil {
+		format = withFinalNewline(format, *final)
+	}
+
+	var out bytes.Buffer
+	err = format(&out, content)
+	if err != nil {
+		return false, fmt.Errorf(`[jsonfmt] failed to format staged %q: %w`, path, err)
+	}
+	if bytes.Equal(out.Bytes(), content) {
+		return false, nil
+	}
+
+	object, err = gitOutput(out.Bytes(), `hash-object`, `-w`, `--stdin`)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = runGit(nil, `update-index`, `--cacheinfo`, mode+`,`+object+`,`+name)
+	return err == nil, err
+}
+
+func git(args ...string) []byte {
+	out, err := runGit(nil, args...)
+	if err != nil {
+		fail(err)
+	}
+	return out
+}
+
+// Like `runGit`, but returns the output without the trailing newline.
+func gitOutput(input []byte, args ...string) (string, error) {
+	out, err := runGit(input, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func runGit(input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(`git`, args...)
+	cmd.Stderr = os.Stderr
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] failed to run git %q: %w`, args, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+func TestEditorGlob(t *testing.T) {
+	test := func(glob, path string, exp bool) {
+		t.Helper()
+		eq(t, exp, editorGlob(glob).MatchString(path))
+	}
+
+	test(`*.json`, `one.json`, true)
+	test(`*.json`, `one/two.json`, true)
+	test(`*.json`, `one.jsonc`, false)
+
+	test(`*.{json,jsonc}`, `one.json`, true)
+	test(`*.{json,jsonc}`, `one/two.jsonc`, true)
+	test(`*.{json,jsonc}`, `one.json5`, false)
+	test(`{package,tsconfig}.json`, `tsconfig.json`, true)
+	test(`*.{json`, `one.json`, true)
+
+	test(`one/*.json`, `one/two.json`, true)
+	test(`one/*.json`, `one/two/three.json`, false)
+	test(`one/*.json`, `zero/one/two.json`, false)
+	test(`/one.json`, `one.json`, true)
+	test(`/one.json`, `two/one.json`, false)
+
+	test(`one/**.json`, `one/two/three.json`, true)
+	test(`**/two.json`, `one/two.json`, true)
+	test(`**/two.json`, `one/three/two.json`, true)
+
+	test(`?.json`, `a.json`, true)
+	test(`?.json`, `ab.json`, false)
+
+	test(`[ab].json`, `a.json`, true)
+	test(`[ab].json`, `c.json`, false)
+	test(`[!ab].json`, `a.json`, false)
+	test(`[!ab].json`, `c.json`, true)
+	test(`[ab.json`, `[ab.json`, true)
+
+	test(`one\*.json`, `one*.json`, true)
+	test(`one\*.json`, `onetwo.json`, false)
+	test(`one.json`, `oneXjson`, false)
+}
+
+func TestOpeningFence(t *testing.T) {
+	test := func(line, fence, lang, indent string, ok bool) {
+		t.Helper()
+		actFence, actLang, actIndent, actOk := openingFence([]byte(line))
+		eq(t, [4]interface{}{fence, lang, indent, ok}, [4]interface{}{actFence, actLang, actIndent, actOk})
+	}
+
+	test("```json\n", "```", `json`, ``, true)
+	test("```JSONC\r\n", "```", `jsonc`, ``, true)
+	test("~~~~ json {.one}\n", `~~~~`, `json`, ``, true)
+	test("  ```json\n", "```", `json`, `  `, true)
+	test("\t```\n", "```", ``, "\t", true)
+	test("``json\n", ``, ``, ``, false)
+	test("```json`\n", ``, ``, ``, false)
+	test("~~~ json`\n", `~~~`, "json`", ``, true)
+	test("text\n", ``, ``, ``, false)
+}
+
+func TestFormatMarkdown(t *testing.T) {
+	test := func(src, exp string) {
+		t.Helper()
+		var out bytes.Buffer
+		try(t, formatMarkdown(jsonfmt.Default, &out, []byte(src)))
+		eq(t, exp, out.String())
+	}
+
+	test("text\n```json\n{\"one\":10}\n```\ntext\n", "text\n```json\n{\"one\": 10}\n```\ntext\n")
+	test("```js\n{\"one\":10}\n```\n", "```js\n{\"one\":10}\n```\n")
+	test("```json\n{\"one\":10}\n````\n", "```json\n{\"one\": 10}\n````\n")
+	test("~~~json\n{\"one\":10}\n```\n", "~~~json\n{\"one\":10}\n```\n")
+	test("- item\n  ~~~json\n  [10,20]\n  ~~~\n", "- item\n  ~~~json\n  [10, 20]\n  ~~~\n")
+	test("```json\r\n{\"one\":10}\r\n```\r\n", "```json\r\n{\"one\": 10}\r\n```\r\n")
+	test("```json\n{\"one\":10}\n", "```json\n{\"one\":10}\n")
+	test("```json\n\n```\n", "```json\n\n```\n")
+}
+
+func TestFormatGo(t *testing.T) {
+	test := func(src, exp string) {
+		t.Helper()
+		var out bytes.Buffer
+		try(t, formatGo(jsonfmt.Default, &out, []byte(src)))
+		eq(t, exp, out.String())
+	}
+
+	test(
+		"package one\n\n// jsonfmt\nconst src = `{\"one\":10}`\n",
+		"package one\n\n// jsonfmt\nconst src = `{\"one\": 10}`\n",
+	)
+
+	test(
+		"package one\n\nvar src = /* jsonfmt */ \"[10,20]\"\n",
+		"package one\n\nvar src = /* jsonfmt */ \"[10, 20]\"\n",
+	)
+
+	test(
+		"package one\n\nvar val = Val{\n\t// jsonfmt\n\tSrc: `[10,20]`,\n}\n",
+		"package one\n\nvar val = Val{\n\t// jsonfmt\n\tSrc: `[10, 20]`,\n}\n",
+	)
+
+	test(
+		"package one\n\nfunc one() {\n\t// jsonfmt\n\tsrc := `[10,20]`\n\t_ = src\n}\n",
+		"package one\n\nfunc one() {\n\t// jsonfmt\n\tsrc := `[10, 20]`\n\t_ = src\n}\n",
+	)
+
+	// The marker applies only to the next literal.
+	test(
+		"package one\n\n// jsonfmt\nconst one = `[10,20]`\n\nconst two = `[30,40]`\n",
+		"package one\n\n// jsonfmt\nconst one = `[10, 20]`\n\nconst two = `[30,40]`\n",
+	)
+
+	// Other tokens cancel the marker.
+	test(
+		"package one\n\n// jsonfmt\nfunc one() string { return `[10,20]` }\n",
+		"package one\n\n// jsonfmt\nfunc one() string { return `[10,20]` }\n",
+	)
+	test(
+		"package one\n\n// jsonfmt\nvar one = two(`[10,20]`)\n",
+		"package one\n\n// jsonfmt\nvar one = two(`[10,20]`)\n",
+	)
+	test(
+		"package one\n\n// not jsonfmt\nconst src = `[10,20]`\n",
+		"package one\n\n// not jsonfmt\nconst src = `[10,20]`\n",
+	)
+
+	test(
+		"package one\n\n// jsonfmt\nconst src = `\n\t{\"one\":[10,20],\"two\":\"three four five six seven eight nine ten eleven twelve\"}\n`\n",
+		"package one\n\n// jsonfmt\nconst src = `\n\t{\n\t  \"one\": [10, 20],\n\t  \"two\": \"three four five six seven eight nine ten eleven twelve\"\n\t}\n`\n",
+	)
+}
+
+func TestFinalNewlineWriter(t *testing.T) {
+	test := func(insert bool, chunks []string, exp string) {
+		t.Helper()
+		var out bytes.Buffer
+		writer := &finalNewlineWriter{out: &out, insert: insert}
+
+		for _, chunk := range chunks {
+			size, err := writer.Write([]byte(chunk))
+			try(t, err)
+			eq(t, len(chunk), size)
+		}
+		try(t, writer.Close())
+		eq(t, exp, out.String())
+	}
+
+	test(true, []string{`{}`}, "{}\n")
+	test(true, []string{"{}\n\n"}, "{}\n")
+	test(true, []string{"{}\r\n"}, "{}\r\n")
+	test(false, []string{"{}\n"}, `{}`)
+	test(true, []string{"[\n", "\n", "10]\n"}, "[\n\n10]\n")
+	test(false, []string{"[10]\n", "\n"}, `[10]`)
+	test(true, []string{"\n"}, ``)
+	test(true, nil, ``)
+}
+
+func TestDecompress(t *testing.T) {
+	var zipped bytes.Buffer
+	zip := gzip.NewWriter(&zipped)
+	_, err := zip.Write([]byte(`{"one":10}`))
+	try(t, err)
+	try(t, zip.Close())
+
+	out, err := decompress(zipped.Bytes(), 0)
+	try(t, err)
+	eq(t, `{"one":10}`, string(out))
+
+	out, err = decompress([]byte(`[10]`), 0)
+	try(t, err)
+	eq(t, `[10]`, string(out))
+
+	_, err = decompress([]byte("\x28\xb5\x2f\xfd\x00"), 0)
+	eq(t, exitParse, errorExit(err))
+}
+
+func TestDecodeUtf16(t *testing.T) {
+	test := func(charset, src, exp string) {
+		t.Helper()
+		out, err := decodeUtf16(charset, []byte(src))
+		try(t, err)
+		eq(t, exp, string(out))
+	}
+
+	test(`utf-16`, "\x00[\x001\x00]", `[1]`)
+	test(`utf-16`, "\xfe\xff\x00[\x001\x00]", `[1]`)
+	test(`utf-16`, "\xff\xfe[\x001\x00]\x00", `[1]`)
+	test(`utf-16be`, "\x00[\x001\x00]", `[1]`)
+	test(`utf-16le`, "[\x001\x00]\x00", `[1]`)
+	test(`utf-16`, "\xd8\x3d\xde\x00", "\U0001f600")
+
+	_, err := decodeUtf16(`utf-16`, []byte("\x00[\x00"))
+	eq(t, true, err != nil)
+}
+
+func TestRecoverLibraryErr(t *testing.T) {
+	conf := jsonfmt.Default
+	conf.MaxDepth = 1
+
+	// Hard errors are left to formatting.
+	func() {
+		defer recoverLibraryErr()
+		jsonfmt.Warnings(conf, `[[10]]`)
+	}()
+
+	// Other panics are bugs.
+	var val interface{}
+	func() {
+		defer func() { val = recover() }()
+		defer recoverLibraryErr()
+		var list []int
+		_ = list[len(list)]
+	}()
+	_, ok := val.(runtime.Error)
+	eq(t, true, ok)
+}
+
+func TestRestageIndex(t *testing.T) {
+	if _, err := exec.LookPath(`git`); err != nil {
+		t.Skip(`requires git`)
+	}
+
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	try(t, err)
+	try(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := runGit(nil, args...)
+		try(t, err)
+		return string(out)
+	}
+
+	run(`init`, `-q`)
+	try(t, os.WriteFile(`one.json`, []byte(`{"a":2}`), os.ModePerm))
+	run(`add`, `one.json`)
+	try(t, os.WriteFile(`one.json`, []byte(`{"a":2,"secret":3}`), os.ModePerm))
+
+	eq(t, true, hasUnstaged(`one.json`))
+
+	conf := fileConf{EditorConfig: true, FilePresets: true}
+	changed, err := restageIndex(jsonfmt.Default, conf, `one.json`)
+	try(t, err)
+	eq(t, true, changed)
+
+	// Only the staged content is formatted; unstaged edits stay unstaged.
+	eq(t, "{\"a\": 2}\n", run(`show`, `:one.json`))
+	content, err := os.ReadFile(`one.json`)
+	try(t, err)
+	eq(t, `{"a":2,"secret":3}`, string(content))
+
+	changed, err = restageIndex(jsonfmt.Default, conf, `one.json`)
+	try(t, err)
+	eq(t, false, changed)
+}
+
+func eq(t testing.TB, exp, act interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(exp, act) {
+		t.Fatalf(`
+expected (detailed):
+	%#[1]v
+actual (detailed):
+	%#[2]v
+`, exp, act)
+	}
+}
+
+func try(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf(`%+v`, err)
+	}
+}
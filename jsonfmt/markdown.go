@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+func isMarkdown(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case `.md`, `.mdx`, `.markdown`:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Formats the contents of JSON code fences in Markdown, leaving everything else
+untouched. Fences may be indented, for example in list items; their content is
+dedented for formatting and indented back afterwards. Unclosed fences are left
+as-is.
+*/
+func formatMarkdown(conf jsonfmt.Conf, out io.Writer, src []byte) error {
+	var buf bytes.Buffer
+	lines := splitLines(src)
+
+	for ind := 0; ind < len(lines); {
+		line := lines[ind]
+		ind++
+
+		fence, lang, indent, ok := openingFence(line)
+		buf.Write(line)
+		if !ok {
+			continue
+		}
+
+		end := ind
+		for end < len(lines) && !isClosingFence(lines[end], fence) {
+			end++
+		}
+		if end == len(lines) {
+			continue
+		}
+
+		body := lines[ind:end]
+		if lang == `json` || lang == `jsonc` {
+			formatFence(conf, &buf, body, indent, bytes.HasSuffix(line, []byte("\r\n")))
+		} else {
+			for _, line := range body {
+				buf.Write(line)
+			}
+		}
+
+		buf.Write(lines[end])
+		ind = end + 1
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func formatFence(conf jsonfmt.Conf, buf *bytes.Buffer, body [][]byte, indent string, crlf bool) {
+	var content bytes.Buffer
+	for _, line := range body {
+		content.Write(dedent(line, len(indent)))
+	}
+
+	if len(bytes.TrimSpace(content.Bytes())) == 0 {
+		for _, line := range body {
+			buf.Write(line)
+		}
+		return
+	}
+
+	output := jsonfmt.FormatBytes(conf, content.Bytes())
+	for _, line := range splitLines(output) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			buf.WriteString(indent)
+		}
+		if crlf && bytes.HasSuffix(line, []byte("\n")) && !bytes.HasSuffix(line, []byte("\r\n")) {
+			buf.Write(line[:len(line)-1])
+			buf.WriteString("\r\n")
+		} else {
+			buf.Write(line)
+		}
+	}
+}
+
+/*
+Parses a fence opener such as "```json" or "~~~~ jsonc", returning the fence
+marker, the lowercased language, and the indentation.
+*/
+func openingFence(line []byte) (string, string, string, bool) {
+	text := strings.TrimRight(string(line), "\r\n")
+	rest := strings.TrimLeft(text, " \t")
+	indent := text[:len(text)-len(rest)]
+
+	fence := fenceMarker(rest)
+	if fence == `` {
+		return ``, ``, ``, false
+	}
+
+	info := strings.TrimSpace(rest[len(fence):])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return ``, ``, ``, false
+	}
+
+	lang := info
+	if ind := strings.IndexAny(lang, " \t{"); ind >= 0 {
+		lang = lang[:ind]
+	}
+	return fence, strings.ToLower(lang), indent, true
+}
+
+func isClosingFence(line []byte, fence string) bool {
+	text := strings.TrimSpace(string(line))
+	marker := fenceMarker(text)
+	return marker != `` && marker[0] == fence[0] && len(marker) >= len(fence) &&
+		len(marker) == len(text)
+}
+
+func fenceMarker(text string) string {
+	if text == `` || (text[0] != '`' && text[0] != '~') {
+		return ``
+	}
+
+	size := 0
+	for size < len(text) && text[size] == text[0] {
+		size++
+	}
+	if size < 3 {
+		return ``
+	}
+	return text[:size]
+}
+
+// Removes up to the given amount of leading spaces or tabs.
+func dedent(line []byte, size int) []byte {
+	for size > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		line = line[1:]
+		size--
+	}
+	return line
+}
+
+// Splits after each "\n", preserving line endings.
+func splitLines(src []byte) [][]byte {
+	var out [][]byte
+	for len(src) > 0 {
+		ind := bytes.IndexByte(src, '\n')
+		if ind < 0 {
+			out = append(out, src)
+			break
+		}
+		out = append(out, src[:ind+1])
+		src = src[ind+1:]
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+Memory-maps the given file for reading, which avoids copying huge inputs into
+the heap. Falls back on reading for anything that can't be mapped, such as
+empty files and pipes. The returned function releases the content, which must
+not be used afterwards. Content larger than the limit is refused, see
+`readLimited`; regular files are checked before reading.
+*/
+func mapFile(file *os.File, limit int64) ([]byte, func(), error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := info.Size()
+	if !info.Mode().IsRegular() || size <= 0 || int64(int(size)) != size {
+		content, err := readLimited(file, limit)
+		return content, func() {}, err
+	}
+	if limit > 0 && size > limit {
+		return nil, nil, errInputLimit(limit)
+	}
+
+	content, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, func() { _ = syscall.Munmap(content) }, nil
+}
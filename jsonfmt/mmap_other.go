@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "os"
+
+// Fallback for platforms without `syscall.Mmap`. See the other implementation.
+func mapFile(file *os.File, limit int64) ([]byte, func(), error) {
+	content, err := readLimited(file, limit)
+	return content, func() {}, err
+}
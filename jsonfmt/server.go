@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+// Error codes of JSON-RPC 2.0, and ours in the range reserved for servers.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFormatError    = -32000
+)
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  rpcParams       `json:"params"`
+}
+
+/*
+Params of all methods. "path" names the file the source belongs to, for its
+preset, ".editorconfig" and file type, like "-stdin-filepath", and needn't
+exist. "conf" is a JSON object with the keys of `jsonfmt.Conf`, like
+"-conf-file", applied over the settings of the server.
+*/
+type rpcParams struct {
+	Source *string         `json:"source"`
+	Path   string          `json:"path"`
+	Conf   json.RawMessage `json:"conf"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcFormatResult struct {
+	Output string `json:"output"`
+}
+
+// For "check". The position of the first difference is given when unformatted.
+type rpcCheckResult struct {
+	Formatted bool `json:"formatted"`
+	Line      int  `json:"line,omitempty"`
+	Column    int  `json:"column,omitempty"`
+}
+
+/*
+Runs "-server": reads JSON-RPC 2.0 requests from stdin, one per line, and writes
+responses to stdout, one per line, in order, until stdin is closed. Methods are
+"format", "check" and "minify", with the params described by `rpcParams`.
+Requests without an id are notifications, and get no response. Lines larger than
+"-max-input-bytes" are refused. Settings are resolved once, at startup.
+*/
+func serve(conf jsonfmt.Conf, fileConf fileConf) {
+	read := bufio.NewReader(os.Stdin)
+	write := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(write)
+	enc.SetEscapeHTML(false)
+
+	for {
+		line, err := read.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			res, ok := serveLine(conf, fileConf, line)
+			if ok {
+				if err := enc.Encode(res); err != nil {
+					fail(fmt.Errorf(`[jsonfmt] failed to write: %w`, err))
+				}
+				if err := write.Flush(); err != nil {
+					fail(fmt.Errorf(`[jsonfmt] failed to write: %w`, err))
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			fail(fmt.Errorf(`[jsonfmt] failed to read: %w`, err))
+		}
+	}
+}
+
+// Returns the response, and false for notifications.
+func serveLine(conf jsonfmt.Conf, fileConf fileConf, line []byte) (rpcResponse, bool) {
+	res := rpcResponse{Version: `2.0`, Id: json.RawMessage(`null`)}
+
+	if fileConf.MaxInputBytes > 0 && int64(len(line)) > fileConf.MaxInputBytes {
+		res.Error = &rpcError{rpcInvalidRequest, errInputLimit(fileConf.MaxInputBytes).Error()}
+		return res, true
+	}
+
+	var req rpcRequest
+	err := json.Unmarshal(line, &req)
+	if err != nil {
+		res.Error = &rpcError{rpcParseError, err.Error()}
+		return res, true
+	}
+	if req.Id != nil {
+		res.Id = req.Id
+	}
+	if req.Version != `2.0` || req.Method == `` {
+		res.Error = &rpcError{rpcInvalidRequest, `expected "jsonrpc": "2.0" and "method"`}
+		return res, true
+	}
+
+	res.Result, res.Error = serveRequest(conf, fileConf, req)
+	return res, req.Id != nil
+}
+
+func serveRequest(conf jsonfmt.Conf, fileConf fileConf, req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case `format`, `check`:
+	case `minify`:
+		minifying(&conf, &fileConf)
+	default:
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf(`unknown method %q, expected one of: format, check, minify`, req.Method)}
+	}
+
+	params := req.Params
+	if params.Source == nil {
+		return nil, &rpcError{rpcInvalidParams, `expected "source"`}
+	}
+	source := []byte(*params.Source)
+
+	var final *bool
+	if params.Path != `` {
+		var err error
+		conf, final, err = pathConf(conf, fileConf, params.Path)
+		if err != nil {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf(`failed to read settings for %q: %v`, params.Path, err)}
+		}
+	}
+	if params.Conf != nil {
+		err := jsonfmt.UnmarshalSingle(params.Conf, &conf)
+		if err == nil {
+			err = jsonfmt.FormatTo(conf, io.Discard, `null`)
+		}
+		if err != nil {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf(`invalid "conf": %v`, err)}
+		}
+	}
+
+	format := formatter(conf, fileConf, params.Path)
+	if final != nil {
+		format = withFinalNewline(format, *final)
+	}
+
+	if req.Method == `check` {
+		pos, changed, err := changes(format, source)
+		if err != nil {
+			return nil, &rpcError{rpcFormatError, err.Error()}
+		}
+		if !changed {
+			return rpcCheckResult{Formatted: true}, nil
+		}
+		line, col := lineCol(source, pos)
+		return rpcCheckResult{Line: line, Column: col}, nil
+	}
+
+	var buf bytes.Buffer
+	err := format(&buf, source)
+	if err != nil {
+		return nil, &rpcError{rpcFormatError, err.Error()}
+	}
+	return rpcFormatResult{buf.String()}, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Splits the records of one source into formatted files of `fileConf.Size`
+records each, named by the template with the 1-based file number in place of
+"{n}", given via "-o" or as the second argument, where "%d" also works. When
+the source is a single list, each file is a list of its elements. Otherwise,
+such as for newline-delimited JSON, each file contains the top-level values,
+one after another. The source is memory-mapped where possible, and only one
+file's worth of records is buffered at a time.
+*/
+func cmdSplit(conf jsonfmt.Conf, fileConf fileConf, args []string) {
+	template := fileConf.Output
+	if template == `` && len(args) == 2 {
+		if strings.Count(args[1], `%d`) != 1 || strings.Count(args[1], `%`) != 1 {
+			fail(usageError{fmt.Errorf(`[jsonfmt] output path template must contain "%%d" once, got %q`, args[1])})
+		}
+		template = strings.Replace(args[1], `%d`, `{n}`, 1)
+		args = args[:1]
+	}
+	if len(args) != 1 || template == `` {
+		fail(usageError{fmt.Errorf(`[jsonfmt] expected a source and an output path template, got %q`, args)})
+	}
+	if !strings.Contains(template, `{n}`) {
+		fail(usageError{fmt.Errorf(`[jsonfmt] output path template must contain "{n}", got %q`, template)})
+	}
+	if fileConf.Size < 1 {
+		fail(usageError{fmt.Errorf(`[jsonfmt] size must be positive, got %v`, fileConf.Size)})
+	}
+
+	src := args[0]
+	if src == `-` {
+		src = ``
+	}
+
+	var count int
+	_, err := withSource(conf, fileConf, src, func(_ string, _ formatFunc, content []byte) (bool, error) {
+		var err error
+		count, err = split(conf, fileConf.Size, template, content)
+		return false, err
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	if count == 0 {
+		fmt.Fprintln(os.Stderr, `[jsonfmt] no records to split`)
+	}
+}
+
+/*
+Writes each top-level value of the sources to a separate file named by the
+"-o" template, numbered from 1 across all sources, instead of writing to stdout
+or formatting files in place. A single list is one value; see "split" for
+splitting lists. Used by "fmt" and the commands based on it, such as "convert".
+*/
+func writeValues(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	count := 0
+	eachSource(conf, fileConf, srcs, exitOk, `written`, func(_ string, format formatFunc, content []byte) (bool, error) {
+		var err error
+		jsonfmt.Records(conf, content, func(rec []byte, elem bool) bool {
+			if elem {
+				rec = content
+			}
+			count++
+			err = writeChunk(format, outputPath(fileConf.Output, count), rec)
+			return err == nil && !elem
+		})
+		return false, err
+	})
+}
+
+// Returns the number of written files.
+func split(conf jsonfmt.Conf, size int, template string, content []byte) (int, error) {
+	format := func(out io.Writer, src []byte) error { return jsonfmt.FormatTo(conf, out, src) }
+	var chunk bytes.Buffer
+	var records, files int
+	var elems bool
+	var err error
+
+	flush := func() {
+		if records == 0 {
+			return
+		}
+		if elems {
+			chunk.WriteByte(']')
+		}
+
+		files++
+		err = writeChunk(format, outputPath(template, files), chunk.Bytes())
+		chunk.Reset()
+		records = 0
+	}
+
+	jsonfmt.Records(conf, content, func(rec []byte, elem bool) bool {
+		elems = elem
+		if records == 0 && elem {
+			chunk.WriteByte('[')
+		} else if records > 0 {
+			chunk.WriteByte('\n')
+		}
+
+		chunk.Write(rec)
+		records++
+
+		if records >= size {
+			flush()
+		}
+		return err == nil
+	})
+
+	if err == nil {
+		flush()
+	}
+	return files, err
+}
+
+// Path for the 1-based file number, replacing "{n}" in the template.
+func outputPath(template string, num int) string {
+	return strings.ReplaceAll(template, `{n}`, strconv.Itoa(num))
+}
+
+// Creates the file and its missing directories.
+func writeChunk(format formatFunc, path string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to create the directory of %q: %w`, path, err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to create %q: %w`, path, err)
+	}
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	err = format(buf, content)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to write %q: %w`, path, err)
+	}
+	return nil
+}
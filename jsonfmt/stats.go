@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Statistics collected with "-stats", printed to stderr at the end of the run.
+Nil without "-stats", in which case nothing is collected.
+*/
+var runStats *statsLog
+
+// Statistics of one source formatted in place or to stdout.
+type sourceStats struct {
+	File     string        `json:"file"`
+	Changed  bool          `json:"changed"`
+	Before   int           `json:"bytesBefore"`
+	After    int           `json:"bytesAfter"`
+	Comments int           `json:"commentsStripped"`
+	Repairs  int           `json:"repairs"`
+	Elapsed  time.Duration `json:"elapsedNs"`
+}
+
+func (self *sourceStats) merge(val sourceStats) {
+	self.Before += val.Before
+	self.After += val.After
+	self.Comments += val.Comments
+	self.Repairs += val.Repairs
+}
+
+func (self sourceStats) String() string {
+	out := fmt.Sprintf(
+		`%v -> %v bytes, %v comments stripped, %v repairs`,
+		self.Before, self.After, self.Comments, self.Repairs,
+	)
+	if self.Changed {
+		out += `, changed`
+	}
+	return out + fmt.Sprintf(`, %v`, self.Elapsed)
+}
+
+// Statistics of every source, collected by concurrent workers.
+type statsLog struct {
+	lock    sync.Mutex
+	start   time.Time
+	sources []sourceStats
+}
+
+/*
+Records the statistics of a source formatted successfully, measured since
+`start`. Formats the content once more to measure the output, and JSON once
+more via `jsonfmt.FormatWithMetrics`, which is why statistics are opt-in. Only
+called after formatting the same content succeeded, so measuring can't fail on
+hard errors such as `jsonfmt.ErrMaxDepth`.
+*/
+func (self *statsLog) add(
+	conf jsonfmt.Conf, fileConf fileConf, file string, format formatFunc, content []byte, start time.Time,
+) {
+	if self == nil {
+		return
+	}
+
+	stats := sourceStats{File: file, Elapsed: time.Since(start)}
+
+	if isGzip(content) {
+		var err error
+		content, err = gunzip(content, fileConf.MaxInputBytes)
+		if err != nil {
+			return
+		}
+	}
+
+	var out bytes.Buffer
+	if format(&out, content) != nil {
+		return
+	}
+
+	stats.Before = len(content)
+	stats.After = out.Len()
+	stats.Changed = !bytes.Equal(content, out.Bytes())
+
+	if isJSON(fileConf, file) {
+		_, metrics, err := jsonfmt.FormatWithMetrics[[]byte](conf, content)
+		if err == nil {
+			stats.Comments = metrics.CommentsStripped
+			stats.Repairs = metrics.Repairs
+		}
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.sources = append(self.sources, stats)
+}
+
+/*
+Writes the statistics of each source, sorted by name, followed by the totals,
+as text, or as JSON objects with "-json-errors".
+*/
+func (self *statsLog) print() {
+	if self == nil {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.sources) == 0 {
+		return
+	}
+
+	sort.Slice(self.sources, func(one, two int) bool {
+		return self.sources[one].File < self.sources[two].File
+	})
+
+	var total sourceStats
+	var changed int
+	for _, val := range self.sources {
+		total.merge(val)
+		if val.Changed {
+			changed++
+		}
+		printStats(val.File, val)
+	}
+
+	total.Elapsed = time.Since(self.start)
+	if jsonErrors {
+		printStats(``, total)
+		return
+	}
+	fmt.Fprintf(
+		os.Stderr, "[jsonfmt] stats: %v sources, %v changed, %v\n",
+		len(self.sources), changed, total,
+	)
+}
+
+func printStats(file string, val sourceStats) {
+	if !jsonErrors {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", sourceName(file), val)
+		return
+	}
+
+	out, err := json.Marshal(struct {
+		Stats sourceStats `json:"stats"`
+	}{val})
+	if err != nil {
+		panic(err)
+	}
+
+	diagnosticLock.Lock()
+	defer diagnosticLock.Unlock()
+	_, _ = os.Stderr.Write(append(out, '\n'))
+}
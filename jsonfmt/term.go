@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+/*
+Controlling terminal, opened separately from stdin and stdout, which may be
+redirected. Raw mode is toggled via "stty", which avoids platform-specific
+syscalls. Unavailable on systems without "stty".
+*/
+type terminal struct {
+	file  *os.File
+	saved string
+}
+
+func openTerminal() (*terminal, error) {
+	file, err := os.OpenFile(`/dev/tty`, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] failed to open terminal: %w`, err)
+	}
+
+	term := &terminal{file: file}
+	saved, err := term.stty(`-g`)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	term.saved = strings.TrimSpace(saved)
+
+	_, err = term.stty(`raw`, `-echo`)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return term, nil
+}
+
+func (self *terminal) Read(buf []byte) (int, error)  { return self.file.Read(buf) }
+func (self *terminal) Write(buf []byte) (int, error) { return self.file.Write(buf) }
+
+// Restores the original terminal mode.
+func (self *terminal) Close() error {
+	_, err := self.stty(self.saved)
+	if err == nil {
+		err = self.file.Close()
+	} else {
+		self.file.Close()
+	}
+	return err
+}
+
+// Rows and columns. Falls back to 24x80 when unknown.
+func (self *terminal) size() (int, int) {
+	out, err := self.stty(`size`)
+	if err == nil {
+		fields := strings.Fields(out)
+		if len(fields) == 2 {
+			rows, err0 := strconv.Atoi(fields[0])
+			cols, err1 := strconv.Atoi(fields[1])
+			if err0 == nil && err1 == nil && rows > 0 && cols > 0 {
+				return rows, cols
+			}
+		}
+	}
+	return 24, 80
+}
+
+func (self *terminal) stty(args ...string) (string, error) {
+	cmd := exec.Command(`stty`, args...)
+	cmd.Stdin = self.file
+
+	out, err := cmd.Output()
+	if err != nil {
+		return ``, fmt.Errorf(`[jsonfmt] failed to run stty %q: %w`, args, err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func isUrl(src string) bool {
+	return strings.HasPrefix(src, `http://`) || strings.HasPrefix(src, `https://`)
+}
+
+// Defaults for URLs when "-timeout" and "-max-input-bytes" are not set.
+const (
+	urlTimeout       = 30 * time.Second
+	urlMaxInputBytes = 64 << 20
+)
+
+/*
+Fetches the body at the given URL, enforcing the configured timeout and size
+limit, and converts it to UTF-8 according to the charset in the content type.
+*/
+func fetch(fileConf fileConf, url string) ([]byte, error) {
+	if fileConf.Timeout <= 0 {
+		fileConf.Timeout = urlTimeout
+	}
+	if fileConf.MaxInputBytes <= 0 {
+		fileConf.MaxInputBytes = urlMaxInputBytes
+	}
+	client := http.Client{Timeout: fileConf.Timeout}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] failed to fetch %q: %w`, url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf(`[jsonfmt] failed to fetch %q: unexpected status %q`, url, res.Status)
+	}
+
+	body, err := readLimited(res.Body, fileConf.MaxInputBytes)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] failed to read %q: %w`, url, err)
+	}
+
+	body, err = decodeCharset(res.Header.Get(`Content-Type`), body)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] failed to decode %q: %w`, url, err)
+	}
+	return body, nil
+}
+
+// Zero limit means no limit.
+func readLimited(src io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(src)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errInputLimit(limit)
+	}
+	return out, nil
+}
+
+func errInputLimit(limit int64) error { return inputLimitError(limit) }
+
+// Input larger than "-max-input-bytes".
+type inputLimitError int64
+
+func (self inputLimitError) Error() string {
+	return fmt.Sprintf(`input exceeds the limit of %d bytes, see "-max-input-bytes"`, int64(self))
+}
+
+/*
+Supports only the charsets that can be decoded without dependencies. JSON is
+UTF-8 in practice, and the charset is usually omitted.
+*/
+func decodeCharset(contentType string, src []byte) ([]byte, error) {
+	charset := ``
+	if contentType != `` {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, err
+		}
+		charset = strings.ToLower(params[`charset`])
+	}
+
+	switch charset {
+	case ``, `utf-8`, `utf8`, `us-ascii`:
+		return src, nil
+
+	case `iso-8859-1`, `latin1`:
+		out := make([]byte, 0, len(src))
+		for _, char := range src {
+			out = utf8.AppendRune(out, rune(char))
+		}
+		return out, nil
+
+	case `utf-16`, `utf-16le`, `utf-16be`:
+		return decodeUtf16(charset, src)
+
+	default:
+		return nil, fmt.Errorf(`unsupported charset %q`, charset)
+	}
+}
+
+/*
+For "utf-16", the byte order comes from the byte order mark, which is skipped.
+Without one, the content is big-endian, as specified by RFC 2781.
+*/
+func decodeUtf16(charset string, src []byte) ([]byte, error) {
+	if len(src)%2 != 0 {
+		return nil, errors.New(`odd length of UTF-16 content`)
+	}
+
+	bigEndian := charset != `utf-16le`
+	if charset == `utf-16` && len(src) >= 2 {
+		if src[0] == 0xfe && src[1] == 0xff {
+			src = src[2:]
+		} else if src[0] == 0xff && src[1] == 0xfe {
+			bigEndian = false
+			src = src[2:]
+		}
+	}
+
+	units := make([]uint16, len(src)/2)
+	for ind := range units {
+		if bigEndian {
+			units[ind] = uint16(src[ind*2])<<8 | uint16(src[ind*2+1])
+		} else {
+			units[ind] = uint16(src[ind*2+1])<<8 | uint16(src[ind*2])
+		}
+	}
+	return []byte(string(utf16.Decode(units))), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+/*
+Hidden command for validating an upgrade of jsonfmt against a corpus of samples
+before rolling it out. Sources may be directories, which are searched
+recursively for files matching "-x". Each source is formatted without writing,
+then checked for idempotency: formatting the output again must not change it.
+Sources which are valid JSON are also decoded before and after formatting via
+"encoding/json", and must have equal values. Prints a report with one line per
+source and totals, and fails if any source fails a check.
+
+Settings which intentionally change values, such as "sort-lists", cause
+differences. Sources which aren't valid JSON, for example with comments, are
+only checked for idempotency.
+*/
+func cmdVerify(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	srcs = corpus(fileConf, srcs)
+	if len(srcs) == 0 {
+		srcs = []string{``}
+	}
+
+	sum := summary{total: len(srcs), label: `failed verification`}
+	counts := map[string]int{}
+	var errs []error
+
+	for _, src := range srcs {
+		failed, err := withSource(conf, fileConf, src, func(name string, format formatFunc, content []byte) (bool, error) {
+			status, err := verify(format, content)
+			if err != nil {
+				return false, err
+			}
+			counts[status.kind]++
+			fmt.Printf("%v\t%v\n", name, status)
+			return status.failed(), nil
+		})
+		if failed {
+			sum.flagged++
+		}
+		if err != nil {
+			sum.failed++
+			errs = append(errs, withSourceError(sourceName(src), err))
+		}
+	}
+
+	var kinds []string
+	for key := range counts {
+		kinds = append(kinds, key)
+	}
+	sort.Strings(kinds)
+	for _, key := range kinds {
+		fmt.Printf("[jsonfmt] %v: %v\n", key, counts[key])
+	}
+
+	code := exitCheck
+	if sum.flagged == 0 {
+		code = exitOk
+	}
+	exit(code, errors.Join(errs...), sum)
+}
+
+// Result of verifying one source. See `cmdVerify`.
+type verifyStatus struct {
+	kind   string
+	detail string
+}
+
+const (
+	verifyOk       = `ok`
+	verifyRelaxed  = `ok, not JSON`
+	verifyUnstable = `unstable`
+	verifyValues   = `values differ`
+)
+
+func (self verifyStatus) failed() bool {
+	return self.kind == verifyUnstable || self.kind == verifyValues
+}
+
+func (self verifyStatus) String() string {
+	if self.detail == `` {
+		return self.kind
+	}
+	return self.kind + `: ` + self.detail
+}
+
+func verify(format formatFunc, content []byte) (verifyStatus, error) {
+	var once bytes.Buffer
+	err := format(&once, content)
+	if err != nil {
+		return verifyStatus{}, err
+	}
+
+	var twice bytes.Buffer
+	err = format(&twice, once.Bytes())
+	if err != nil {
+		return verifyStatus{}, err
+	}
+
+	if !bytes.Equal(once.Bytes(), twice.Bytes()) {
+		pos := commonPrefix(once.Bytes(), twice.Bytes())
+		line, col := lineCol(once.Bytes(), pos)
+		return verifyStatus{
+			kind:   verifyUnstable,
+			detail: fmt.Sprintf(`output changes when formatted again, at %v:%v of the output`, line, col),
+		}, nil
+	}
+
+	if !json.Valid(content) {
+		return verifyStatus{kind: verifyRelaxed}, nil
+	}
+
+	prev, err := decodeJSON(content)
+	if err != nil {
+		return verifyStatus{}, err
+	}
+
+	// The output may have comments or trailing commas, depending on settings.
+	var raw json.RawMessage
+	err = jsonfmt.Unmarshal(once.Bytes(), &raw)
+	if err == nil {
+		var next any
+		next, err = decodeJSON(raw)
+		if err == nil && reflect.DeepEqual(prev, next) {
+			return verifyStatus{kind: verifyOk}, nil
+		}
+	}
+
+	detail := `decoded output isn't equal to decoded source`
+	if err != nil {
+		detail = fmt.Sprintf(`failed to decode output: %v`, err)
+	}
+	return verifyStatus{kind: verifyValues, detail: detail}, nil
+}
+
+// Decodes JSON, keeping numbers as written, so that changes in precision count.
+func decodeJSON(src []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+
+	var out any
+	err := dec.Decode(&out)
+	return out, err
+}
+
+/*
+Replaces directories with the files inside them, recursively, which match
+"-x". Other sources are kept as-is.
+*/
+func corpus(fileConf fileConf, srcs []string) []string {
+	var out []string
+
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if isUrl(src) || err != nil || !info.IsDir() {
+			out = append(out, src)
+			continue
+		}
+
+		err = filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && fileConf.match(path) {
+				out = append(out, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fail(fmt.Errorf(`[jsonfmt] failed to list %q: %w`, src, err))
+		}
+	}
+	return out
+}
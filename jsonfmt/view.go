@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+const viewHelp = `q quit, ↑↓ jk move, ←→ hl fold, enter toggle, / search, n N next, e z expand collapse all, w width, c comments`
+
+// Widths cycled by the "w" key. Zero disables inlining of dicts and lists.
+var viewWidths = []uint64{40, 80, 120, 0}
+
+// Depth from which dicts and lists are initially collapsed.
+const viewFoldDepth = 3
+
+/*
+Interactive tree viewer over the document model. Dicts and lists which the
+formatter would place on a single line, according to the current width, are
+shown inline; others can be collapsed and expanded. Search matches keys and
+values, including those in collapsed nodes, which are expanded to show the
+match.
+*/
+type viewer struct {
+	conf      jsonfmt.Conf
+	doc       *jsonfmt.Node
+	name      string
+	folds     map[*jsonfmt.Node]bool
+	foldDepth int
+	rows      []viewRow
+	cursor    int
+	offset    int
+	height    int
+	width     int
+	query     string
+	input     *string
+	status    string
+}
+
+type viewRow struct {
+	node   *jsonfmt.Node // Nil for comments and closing brackets.
+	parent int           // Row of the enclosing dict or list, or -1.
+	depth  int
+	text   string
+	fold   bool // Dict or list which can be collapsed or expanded.
+}
+
+func cmdView(conf jsonfmt.Conf, fileConf fileConf, srcs []string) {
+	if len(srcs) > 1 {
+		fail(usageError{fmt.Errorf(`[jsonfmt] expected at most one source to view, got %v`, len(srcs))})
+	}
+
+	var src string
+	if len(srcs) > 0 {
+		src = srcs[0]
+	}
+
+	_, err := withSource(conf, fileConf, src, func(name string, _ formatFunc, content []byte) (bool, error) {
+		return false, view(conf, name, content)
+	})
+	exit(exitOk, err, summary{})
+}
+
+func view(conf jsonfmt.Conf, name string, content []byte) error {
+	term, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer term.Close()
+
+	// Alternate screen, hidden cursor.
+	fmt.Fprint(term, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(term, "\x1b[?25h\x1b[?1049l")
+
+	self := viewer{
+		conf:      conf,
+		doc:       jsonfmt.Parse(conf, content),
+		name:      name,
+		folds:     map[*jsonfmt.Node]bool{},
+		foldDepth: viewFoldDepth,
+	}
+	self.build()
+
+	buf := make([]byte, 64)
+	for {
+		self.height, self.width = term.size()
+
+		var out bytes.Buffer
+		self.render(&out)
+		_, err := term.Write(out.Bytes())
+		if err != nil {
+			return err
+		}
+
+		size, err := term.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, key := range splitKeys(string(buf[:size])) {
+			if !self.key(key) {
+				return nil
+			}
+		}
+	}
+}
+
+// Handles a key press. Returns false to quit.
+func (self *viewer) key(key string) bool {
+	self.status = ``
+
+	if self.input != nil {
+		self.searchKey(key)
+		return true
+	}
+
+	switch key {
+	case `q`, "\x03", "\x04":
+		return false
+	case `k`, "\x1b[A", "\x1bOA":
+		self.move(-1)
+	case `j`, "\x1b[B", "\x1bOB":
+		self.move(1)
+	case "\x1b[5~":
+		self.move(-self.pageSize())
+	case "\x1b[6~", ` `:
+		self.move(self.pageSize())
+	case `g`, "\x1b[H", "\x1bOH":
+		self.move(-len(self.rows))
+	case `G`, "\x1b[F", "\x1bOF":
+		self.move(len(self.rows))
+	case `h`, "\x1b[D", "\x1bOD":
+		self.left()
+	case `l`, "\x1b[C", "\x1bOC":
+		self.right()
+	case "\r", "\n":
+		self.toggle()
+	case `/`:
+		input := ``
+		self.input = &input
+	case `n`:
+		self.find(true)
+	case `N`:
+		self.find(false)
+	case `e`:
+		self.foldAll(int(^uint(0) >> 1))
+	case `z`:
+		self.foldAll(1)
+	case `w`:
+		self.conf.Width = nextWidth(self.conf.Width)
+		self.rebuild()
+	case `c`:
+		self.conf.StripComments = !self.conf.StripComments
+		self.rebuild()
+	}
+	return true
+}
+
+func (self *viewer) searchKey(key string) {
+	switch key {
+	case "\r", "\n":
+		self.query = *self.input
+		self.input = nil
+		self.find(true)
+	case "\x1b", "\x03":
+		self.input = nil
+	case "\x7f", "\b":
+		_, size := utf8.DecodeLastRuneInString(*self.input)
+		*self.input = (*self.input)[:len(*self.input)-size]
+	default:
+		if key >= ` ` && key != string(utf8.RuneError) && !strings.HasPrefix(key, "\x1b") {
+			*self.input += key
+		}
+	}
+}
+
+/*
+Splits terminal input into keys: escape sequences such as arrows, or single
+characters. A single read may contain several keys, for example when pasting.
+*/
+func splitKeys(src string) []string {
+	var out []string
+	for len(src) > 0 {
+		size := 1
+		if src[0] == '\x1b' && len(src) > 2 && src[1] == '[' {
+			size = 2
+			for size < len(src) && (src[size] < 0x40 || src[size] > 0x7e) {
+				size++
+			}
+			size++
+		} else if src[0] == '\x1b' && len(src) > 2 && src[1] == 'O' {
+			size = 3
+		} else {
+			_, size = utf8.DecodeRuneInString(src)
+		}
+
+		if size > len(src) {
+			size = len(src)
+		}
+		out = append(out, src[:size])
+		src = src[size:]
+	}
+	return out
+}
+
+func nextWidth(width uint64) uint64 {
+	for ind, val := range viewWidths {
+		if val == width {
+			return viewWidths[(ind+1)%len(viewWidths)]
+		}
+	}
+	return viewWidths[0]
+}
+
+func (self *viewer) pageSize() int {
+	if self.height > 2 {
+		return self.height - 2
+	}
+	return 1
+}
+
+func (self *viewer) move(delta int) {
+	self.cursor += delta
+	if self.cursor >= len(self.rows) {
+		self.cursor = len(self.rows) - 1
+	}
+	if self.cursor < 0 {
+		self.cursor = 0
+	}
+}
+
+// Collapses the current dict or list, or moves to the enclosing one.
+func (self *viewer) left() {
+	row, ok := self.row()
+	if !ok {
+		return
+	}
+	if row.fold && !self.collapsed(row.node, row.depth) {
+		self.setFold(row.node, true)
+		return
+	}
+	if row.parent >= 0 {
+		self.cursor = row.parent
+	}
+}
+
+// Expands the current dict or list, or moves into it.
+func (self *viewer) right() {
+	row, ok := self.row()
+	if !ok || !row.fold {
+		return
+	}
+	if self.collapsed(row.node, row.depth) {
+		self.setFold(row.node, false)
+		return
+	}
+	self.move(1)
+}
+
+func (self *viewer) toggle() {
+	row, ok := self.row()
+	if ok && row.fold {
+		self.setFold(row.node, !self.collapsed(row.node, row.depth))
+	}
+}
+
+func (self *viewer) row() (viewRow, bool) {
+	if self.cursor >= 0 && self.cursor < len(self.rows) {
+		return self.rows[self.cursor], true
+	}
+	return viewRow{}, false
+}
+
+func (self *viewer) collapsed(node *jsonfmt.Node, depth int) bool {
+	val, ok := self.folds[node]
+	if ok {
+		return val
+	}
+	return depth >= self.foldDepth
+}
+
+func (self *viewer) setFold(node *jsonfmt.Node, val bool) {
+	self.folds[node] = val
+	self.rebuild()
+}
+
+func (self *viewer) foldAll(depth int) {
+	self.folds = map[*jsonfmt.Node]bool{}
+	self.foldDepth = depth
+	self.rebuild()
+}
+
+// Rebuilds the rows, keeping the cursor on the same node where possible.
+func (self *viewer) rebuild() {
+	row, _ := self.row()
+	self.build()
+	if row.node != nil {
+		self.cursorTo(row.node)
+	}
+	self.move(0)
+}
+
+func (self *viewer) cursorTo(node *jsonfmt.Node) bool {
+	for ind, row := range self.rows {
+		if row.node == node {
+			self.cursor = ind
+			return true
+		}
+	}
+	return false
+}
+
+func (self *viewer) build() {
+	self.rows = self.rows[:0]
+	for _, val := range self.doc.Items {
+		self.addNode(``, val, 0, -1)
+	}
+	self.addComments(self.doc.Trailing, 0, -1)
+}
+
+func (self *viewer) addComments(comments []string, depth, parent int) {
+	if self.conf.StripComments {
+		return
+	}
+	for _, val := range comments {
+		for _, line := range strings.Split(strings.TrimRight(val, "\r\n"), "\n") {
+			self.rows = append(self.rows, viewRow{parent: parent, depth: depth, text: strings.TrimSpace(line)})
+		}
+	}
+}
+
+func (self *viewer) addNode(prefix string, node *jsonfmt.Node, depth, parent int) {
+	self.addComments(node.Comments, depth, parent)
+
+	if node.Kind != jsonfmt.KindDict && node.Kind != jsonfmt.KindList {
+		self.rows = append(self.rows, viewRow{node: node, parent: parent, depth: depth, text: prefix + node.Text})
+		return
+	}
+
+	if text, ok := self.inline(prefix, node, depth); ok {
+		self.rows = append(self.rows, viewRow{node: node, parent: parent, depth: depth, text: prefix + text})
+		return
+	}
+
+	open, close, count := `{`, `}`, plural(len(node.Entries), `entry`, `entries`)
+	if node.Kind == jsonfmt.KindList {
+		open, close, count = `[`, `]`, plural(len(node.Items), `item`, `items`)
+	}
+
+	ind := len(self.rows)
+	if self.collapsed(node, depth) {
+		self.rows = append(self.rows, viewRow{node: node, parent: parent, depth: depth, text: prefix + open + `…` + close + ` ` + count, fold: true})
+		return
+	}
+	self.rows = append(self.rows, viewRow{node: node, parent: parent, depth: depth, text: prefix + open, fold: true})
+
+	for _, val := range node.Entries {
+		if val.Val == nil {
+			self.addNode(``, val.Key, depth+1, ind)
+			continue
+		}
+		self.addComments(val.Key.Comments, depth+1, ind)
+		self.addNode(val.Key.Text+`: `, val.Val, depth+1, ind)
+	}
+	for _, val := range node.Items {
+		self.addNode(``, val, depth+1, ind)
+	}
+	self.addComments(node.Trailing, depth+1, ind)
+	self.rows = append(self.rows, viewRow{parent: ind, depth: depth, text: close})
+}
+
+/*
+Single-line text of a dict or list, if it fits within the width, like in the
+formatter. Small nodes are checked before formatting, which keeps this cheap
+for large ones.
+*/
+func (self *viewer) inline(prefix string, node *jsonfmt.Node, depth int) (string, bool) {
+	empty := len(node.Entries) == 0 && len(node.Items) == 0 && len(node.Trailing) == 0
+	avail := int(self.conf.Width) - depth*len(self.conf.Indent) - len(prefix)
+	budget := avail
+	if !empty && (self.conf.Width == 0 || !fitsLine(node, &budget)) {
+		return ``, false
+	}
+
+	// Unlimited width lays out everything on one line, except line comments.
+	conf := self.conf
+	conf.Width = 1 << 30
+	out := strings.TrimRight(jsonfmt.FormatString(conf, node.String()), "\r\n")
+	if strings.ContainsAny(out, "\r\n") || !empty && len(out) > avail {
+		return ``, false
+	}
+	return out, true
+}
+
+// Decrements the budget by a lower bound of the single-line length of the node.
+func fitsLine(node *jsonfmt.Node, budget *int) bool {
+	*budget -= len(node.Text) + 2
+	for _, val := range node.Comments {
+		*budget -= len(val)
+	}
+	for _, val := range node.Entries {
+		if *budget < 0 || !fitsLine(val.Key, budget) || val.Val != nil && !fitsLine(val.Val, budget) {
+			return false
+		}
+	}
+	for _, val := range node.Items {
+		if *budget < 0 || !fitsLine(val, budget) {
+			return false
+		}
+	}
+	return *budget >= 0
+}
+
+func plural(count int, one, many string) string {
+	if count == 1 {
+		return fmt.Sprintf(`%v %v`, count, one)
+	}
+	return fmt.Sprintf(`%v %v`, count, many)
+}
+
+/*
+Moves to the next or previous node whose key or text contains the query,
+ignoring case, wrapping around. Expands the collapsed nodes containing the
+match.
+*/
+func (self *viewer) find(forward bool) {
+	if self.query == `` {
+		return
+	}
+	query := strings.ToLower(self.query)
+
+	var current *jsonfmt.Node
+	for ind := self.cursor; ind >= 0 && ind < len(self.rows); ind-- {
+		if self.rows[ind].node != nil {
+			current = self.rows[ind].node
+			break
+		}
+	}
+
+	var first, last, before, after []*jsonfmt.Node
+	passed := current == nil
+
+	walkNodes(self.doc, nil, func(path []*jsonfmt.Node, text string) {
+		node := path[len(path)-1]
+		if node == current {
+			passed = true
+			return
+		}
+		if !strings.Contains(strings.ToLower(text), query) {
+			return
+		}
+
+		path = append([]*jsonfmt.Node(nil), path...)
+		if first == nil {
+			first = path
+		}
+		last = path
+		if !passed {
+			before = path
+		} else if after == nil {
+			after = path
+		}
+	})
+
+	target := after
+	if !forward {
+		target = before
+	}
+	if target == nil && forward {
+		target = first
+	}
+	if target == nil && !forward {
+		target = last
+	}
+	if target == nil {
+		self.status = fmt.Sprintf(`not found: %v`, self.query)
+		return
+	}
+
+	for _, node := range target[:len(target)-1] {
+		self.folds[node] = false
+	}
+	self.build()
+
+	// Nodes inside inline dicts and lists have no rows of their own.
+	for ind := len(target) - 1; ind >= 0; ind-- {
+		if self.cursorTo(target[ind]) {
+			break
+		}
+	}
+	self.move(0)
+}
+
+/*
+Calls the function for every node with a row of its own when expanded: values,
+and keys without values. The path is the node with its enclosing dicts and
+lists, excluding the top node. The text includes the key of dict entries.
+*/
+func walkNodes(node *jsonfmt.Node, path []*jsonfmt.Node, fun func([]*jsonfmt.Node, string)) {
+	if node.Kind != jsonfmt.KindTop {
+		path = append(path, node)
+	}
+
+	for _, val := range node.Entries {
+		if val.Val == nil {
+			fun(append(path, val.Key), val.Key.Text)
+			continue
+		}
+		fun(append(path, val.Val), val.Key.Text+`: `+val.Val.Text)
+		walkNodes(val.Val, path, fun)
+	}
+	for _, val := range node.Items {
+		fun(append(path, val), val.Text)
+		walkNodes(val, path, fun)
+	}
+}
+
+func (self *viewer) render(out io.Writer) {
+	height := self.height - 1
+	if height < 1 {
+		height = 1
+	}
+	if self.cursor < self.offset {
+		self.offset = self.cursor
+	}
+	if self.cursor >= self.offset+height {
+		self.offset = self.cursor - height + 1
+	}
+
+	fmt.Fprint(out, "\x1b[H")
+	for ind := self.offset; ind < self.offset+height; ind++ {
+		if ind < len(self.rows) {
+			self.renderRow(out, ind)
+		}
+		fmt.Fprint(out, "\x1b[K\r\n")
+	}
+
+	var status string
+	if self.input != nil {
+		status = `/` + *self.input
+	} else {
+		comments := `on`
+		if self.conf.StripComments {
+			comments = `off`
+		}
+		status = fmt.Sprintf(`%v  %v/%v  width %v  comments %v  %v  %v`,
+			self.name, self.cursor+1, len(self.rows), self.conf.Width, comments, self.status, viewHelp)
+	}
+	fmt.Fprintf(out, "\x1b[7m%v\x1b[K\x1b[0m", truncate(status, self.width))
+}
+
+func (self *viewer) renderRow(out io.Writer, ind int) {
+	row := self.rows[ind]
+
+	marker := `  `
+	if row.fold && self.collapsed(row.node, row.depth) {
+		marker = `▸ `
+	} else if row.fold {
+		marker = `▾ `
+	}
+	line := truncate(strings.Repeat(`  `, row.depth)+marker+row.text, self.width)
+
+	switch {
+	case ind == self.cursor:
+		fmt.Fprintf(out, "\x1b[7m%v\x1b[0m", line)
+	case row.node == nil && !isBracket(row.text):
+		fmt.Fprintf(out, "\x1b[2m%v\x1b[0m", line)
+	default:
+		fmt.Fprint(out, line)
+	}
+}
+
+func isBracket(text string) bool { return text == `}` || text == `]` }
+
+// Truncates to the given number of runes, replacing control characters.
+func truncate(text string, width int) string {
+	var buf strings.Builder
+	var count int
+	for _, char := range text {
+		if count >= width {
+			break
+		}
+		if char < ' ' || char == 0x7f {
+			char = ' '
+		}
+		buf.WriteRune(char)
+		count++
+	}
+	return buf.String()
+}
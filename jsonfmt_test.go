@@ -2,13 +2,18 @@ package jsonfmt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 )
 
 const (
@@ -36,6 +41,53 @@ func BenchmarkFormat(b *testing.B) {
 	}
 }
 
+// Minified input, where writes dominate rather than skipping whitespace.
+func BenchmarkFormat_minified(b *testing.B) {
+	var buf bytes.Buffer
+	try(json.Compact(&buf, readTestFile(b, STD_COMPATIBLE_FILE)))
+	content := buf.Bytes()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = FormatBytes(Default, content)
+	}
+}
+
+// Long strings, where scanning string bodies dominates.
+func BenchmarkFormat_strings(b *testing.B) {
+	val := strings.Repeat(`lorem ipsum dolor sit amet \"quoted\" `, 64)
+	content := []byte(`[` + strings.TrimSuffix(strings.Repeat(`"`+val+`", `, 256), `, `) + `]`)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = FormatBytes(Default, content)
+	}
+}
+
+// Nested lists with comments, where single-line attempts are rolled back.
+func BenchmarkFormat_comments(b *testing.B) {
+	val := `10 /* one */ /* two */ `
+	for i := 0; i < 6; i++ {
+		val = `[` + strings.Repeat(val, 4) + `] /* three */ `
+	}
+	content := []byte(val)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = FormatBytes(Default, content)
+	}
+}
+
+// Many elements with line comments, where comments are on the hot path.
+func BenchmarkFormat_lineComments(b *testing.B) {
+	content := []byte(`[` + strings.Repeat("10, // c\n", 16000) + `]`)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = FormatBytes(Default, content)
+	}
+}
+
 func TestMain(m *testing.M) {
 	try(deleteTestFiles(`*` + FMTED_SUFFIX + `.*`))
 
@@ -140,85 +192,2507 @@ actual output:   %q
 `), input, expected, fmted)
 }
 
-func TestUnmarshal(t *testing.T) {
-	type TarGlobal struct {
-		CheckForUpdatesOnStartup bool `json:"check_for_updates_on_startup"`
-		ShowInMenuBar            bool `json:"show_in_menu_bar"`
-		ShowProfileNameInMenuBar bool `json:"show_profile_name_in_menu_bar"`
+func TestFormatTo(t *testing.T) {
+	var src bytes.Buffer
+	src.WriteString(`// Comment`)
+	src.WriteString("\n[")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&src, `{"key": "value_%[1]v", "list": [%[1]v, %[1]v]} /* comment */`, i)
+		src.WriteString("\n")
 	}
+	src.WriteString(`]`)
 
-	type TarProfile struct {
-		// Fields elided for simplicity.
+	var buf bytes.Buffer
+	try(FormatTo(Default, &buf, src.Bytes()))
+	eq(t, FormatString(Default, src.Bytes()), buf.String())
+
+	// Output is never flushed between a colon and a value which replaces the
+	// separator after the colon with a newline.
+	src.Reset()
+	src.WriteString(`{`)
+	key := strings.Repeat(`key`, 100)
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&src, `"%[1]v%[2]v": {"%[1]v": "a string which is too wide to fit on one line"}, `, key, i)
 	}
+	src.WriteString(`}`)
 
-	type Tar struct {
-		Global   TarGlobal    `json:"global"`
-		Profiles []TarProfile `json:"profiles"`
+	conf := Default
+	conf.OpenNewline = true
+	conf.BreakAfterKey = true
+	buf.Reset()
+	eq(t, true, src.Len() > flushSize)
+	try(FormatTo(conf, &buf, src.Bytes()))
+	eq(t, FormatString(conf, src.Bytes()), buf.String())
+}
+
+func TestFormatCtx(t *testing.T) {
+	var src bytes.Buffer
+	src.WriteString(`[`)
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&src, `{"key": "value_%[1]v", "list": [%[1]v, %[1]v]}`, i)
 	}
+	src.WriteString(`]`)
 
-	var tar Tar
-	try(Unmarshal(readTestFile(t, `inp_short_nopunc.json`), &tar))
+	out, err := FormatCtx[string](context.Background(), Default, src.Bytes())
+	try(err)
+	eq(t, FormatString(Default, src.Bytes()), out)
 
-	eq(t, tar, Tar{
-		Global:   TarGlobal{CheckForUpdatesOnStartup: true},
-		Profiles: []TarProfile{{}},
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FormatCtx[string](ctx, Default, src.Bytes())
+	eq(t, true, errors.Is(err, context.Canceled))
+
+	// Cancellation during formatting, rather than before.
+	ctx = &countCtx{Context: context.Background(), limit: 2}
+	var buf bytes.Buffer
+	err = FormatToCtx(ctx, Default, &buf, src.Bytes())
+	eq(t, true, errors.Is(err, context.Canceled))
 }
 
-func eq(t testing.TB, exp, act interface{}) {
-	if !reflect.DeepEqual(exp, act) {
-		t.Fatalf(`
-expected (detailed):
-	%#[1]v
-actual (detailed):
-	%#[2]v
-expected (simple):
-	%[1]v
-actual (simple):
-	%[2]v
-`, exp, act)
+func TestFormat_maxOutputBytes(t *testing.T) {
+	const src = `{"one": [10, 20], "two": {"three": 30}}`
+	exp := FormatString(Default, src)
+
+	conf := Default
+	conf.MaxOutputBytes = uint64(len(exp))
+	eqFormat(t, conf, src, exp)
+
+	conf.MaxOutputBytes--
+	var buf bytes.Buffer
+	eq(t, ErrMaxOutputBytes, FormatTo(conf, &buf, src))
+
+	conf.Width = 0
+	_, err := FormatCtx[string](context.Background(), conf, src)
+	eq(t, ErrMaxOutputBytes, err)
+
+	defer func() { eq(t, ErrMaxOutputBytes, recover()) }()
+	FormatString(conf, src)
+	t.Fatalf(`expected a panic`)
+}
+
+func TestFormat_maxDepth(t *testing.T) {
+	conf := Default
+	conf.MaxDepth = 3
+	eqFormat(t, conf, `[{"one": [10]}]`, `[{"one": [10]}]`+"\n")
+
+	var buf bytes.Buffer
+	eq(t, ErrMaxDepth, FormatTo(conf, &buf, `[{"one": [[10]]}]`))
+
+	conf.Repair = true
+	eq(t, ErrMaxDepth, FormatTo(conf, &buf, `[{"one": [[10]]}]`))
+
+	// Deep enough to exhaust the stack without the default limit.
+	src := strings.Repeat(`[`, 10000000)
+	eq(t, ErrMaxDepth, FormatTo(Compact, &buf, src))
+	eq(t, ErrMaxDepth, Unmarshal(src, new(any)))
+
+	defer func() { eq(t, ErrMaxDepth, recover()) }()
+	Parse(Default, src)
+	t.Fatalf(`expected a panic`)
+}
+
+func TestFormat_layoutVersion(t *testing.T) {
+	eq(t, uint64(1), DefaultLayoutVersion())
+	eq(t, uint64(2), LatestLayoutVersion())
+
+	const src = `{"one": [10, 20], "two": {"three": 30}, "four": {}}`
+	conf := Default
+	conf.Width = 0
+	conf.LayoutVersion = DefaultLayoutVersion()
+	exp := "{\n  \"one\": [\n    10,\n    20\n  ],\n  \"two\": {\n    \"three\": 30\n  },\n  \"four\": {\n  }\n}\n"
+	eqFormat(t, conf, src, exp)
+
+	// The zero value never opts into newer layouts.
+	conf.LayoutVersion = 0
+	eqFormat(t, conf, src, exp)
+
+	conf.LayoutVersion = LatestLayoutVersion()
+	eqFormat(t, conf, src, strings.Replace(exp, "{\n  }", `{}`, 1))
+
+	conf.LayoutVersion++
+	var buf bytes.Buffer
+	eq(t, ErrLayoutVersion, FormatTo(conf, &buf, src))
+
+	out := NewWriter(conf, &buf)
+	_, err := out.Write([]byte(src + "\n"))
+	eq(t, ErrLayoutVersion, err)
+
+	_, err = ParseConf(`layout-version=3`)
+	eq(t, true, err != nil)
+}
+
+func TestFormat_blockCommentsSingle(t *testing.T) {
+	conf := Default
+	conf.LayoutVersion = 2
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
 	}
+
+	test(`[10, /* one */ 20 /* two */]`, "[10, /* one */ 20 /* two */]\n")
+	test(`{/* one */ "two": /* three */ 20}`, "{/* one */ \"two\": /* three */ 20}\n")
+	test(`[10 /* one */, /* two */ 20]`, "[10, /* one */ /* two */ 20]\n")
+	test("[10, /* one\n   two */ 20]", "[\n  10,\n  /* one\n   two */\n  20\n]\n")
+
+	conf.CollapseComments = true
+	test("[10, /* one\n   two */ 20]", "[10, /* one two */ 20]\n")
+
+	conf.Indent = ``
+	test("[10, /* one\n   two */ 20]", "[10,/* one two */20]")
+
+	conf = Default
+	conf.LayoutVersion = 2
+	conf.BraceSpacing = true
+	test(`{"one": 10 /* two */}`, "{ \"one\": 10 /* two */ }\n")
+
+	conf.LayoutVersion = 1
+	test(`{"one": 10 /* two */}`, "{ \"one\": 10/* two */ }\n")
 }
 
-func eqFile(t testing.TB, pathSrc string, pathExpected string, fmtedContent []byte) {
-	expectedContent := readTestFile(t, pathExpected)
+func TestFormat_commentSpace(t *testing.T) {
+	conf := Default
+	conf.LayoutVersion = 2
+	conf.CommentSpace = CommentSpaceNone
 
-	if bytes.Equal(expectedContent, fmtedContent) {
-		return
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
 	}
 
-	pathFmted := appendToName(pathExpected, FMTED_SUFFIX)
-	writeTestFile(t, pathFmted, fmtedContent)
+	test(`[10, /* one */ 20 /* two */]`, "[10,/* one */ 20/* two */]\n")
+	test(`{"one" /* two */: /* three */ 10}`, "{\"one\"/* two */:/* three */ 10}\n")
+	test("[10, // one\n20]", "[\n  10,\n  // one\n  20\n]\n")
 
-	t.Fatalf(strings.TrimSpace(`
-format mismatch
-source:          %q
-expected output: %q
-actual output:   %q
-`),
-		testFilePath(pathSrc),
-		testFilePath(pathExpected),
-		testFilePath(pathFmted))
+	conf.CommentSpace = CommentSpaceAlign
+	test(`[10, /* one */ 20 /* two */]`, "[10, /* one */ 20 /* two */]\n")
+
+	conf.CommentColumn = 12
+	test(`[10, /* one */ 20 /* two */]`, "[10,        /* one */ 20 /* two */]\n")
+	test(`{"one": {"two": 10 /* three */}}`, "{\"one\": {\"two\": 10 /* three */}}\n")
+	test(`{"one": 10 /* two */}`, "{\"one\": 10  /* two */}\n")
+
+	_, err := ParseConf(`comment-space=two`)
+	eq(t, true, err != nil)
 }
 
-func deleteTestFiles(pattern string) error {
-	matches, err := filepath.Glob(testFilePath(pattern))
-	if err != nil {
-		panic(fmt.Errorf(`failed to find files by pattern %q: %w`, pattern, err))
+func TestFormat_atoms(t *testing.T) {
+	const src = `{one: 10, "two": [-1.5e3, true, null, ture], "three": Infinity}`
+
+	conf := Default
+	conf.Atoms = StrictnessWarn
+	eqFormat(t, conf, src, FormatString(Default, src))
+
+	conf.Atoms = StrictnessStrict
+	var buf bytes.Buffer
+	eq(t, error(&AtomError{Offset: 38, Line: 1, Column: 39, Text: `ture`}), FormatTo(conf, &buf, src))
+	eq(t, `[jsonfmt] unknown word "ture", did you mean "true"? at line 1, column 39`, FormatTo(conf, &buf, src).Error())
+
+	eqFormat(t, conf, `{one: 10, "two": [-1.5e3, true, null, false]}`, "{one: 10, \"two\": [-1.5e3, true, null, false]}\n")
+
+	_, err := FormatCtx[string](context.Background(), conf, `[10 20 +30]`)
+	eq(t, error(&AtomError{Offset: 7, Line: 1, Column: 8, Text: `+30`}), err)
+
+	for src, exp := range map[string]string{
+		`ture`: `true`, `True`: `true`, `flase`: `false`, `fals`: `false`,
+		`nul`: `null`, `nulll`: `null`, `NULL`: `null`, `none`: ``, `nil`: ``, `undefined`: ``,
+	} {
+		eq(t, exp, literalHint(src))
 	}
+}
 
-	for _, path := range matches {
-		err := os.Remove(path)
-		if err != nil {
-			panic(fmt.Errorf(`failed to delete %q: %w`, path, err))
+func TestWarnings(t *testing.T) {
+	test := func(conf Conf, src string, exp ...Warning) {
+		t.Helper()
+		eq(t, exp, Warnings(conf, src))
+	}
+
+	test(Default, `{"one": [10, true, null, "two"], "three": {"four": -1.5e3}}`)
+
+	test(Default, `{"one": 10, "two": {"one": 20}, "\u006fne": 30}`,
+		Warning{WarningDuplicateKey, 32, 1, 33, `duplicate key "one"`},
+	)
+
+	test(Default, `[10, undefined, NaN]`)
+
+	conf := Default
+	conf.Atoms = StrictnessWarn
+	test(conf, `[10, undefined, ture, 0x10, {one: 20}]`,
+		Warning{WarningAtom, 5, 1, 6, `unknown word "undefined"`},
+		Warning{WarningAtom, 16, 1, 17, `unknown word "ture", did you mean "true"?`},
+		Warning{WarningAtom, 22, 1, 23, `invalid atom "0x10"`},
+	)
+
+	conf.Atoms = StrictnessStrict
+	conf.Placeholders = []Placeholder{{`{{`, `}}`}}
+	test(conf, `[{{ .one }}, two{{ .three }}, NaN]`,
+		Warning{WarningAtom, 30, 1, 31, `unknown word "NaN"`},
+	)
+
+	conf = Default
+	conf.Width = 16
+	test(conf, `{"one": "two", "three": "four five", "six": ["seven eight nine ten"]}`,
+		Warning{WarningWidth, 24, 1, 25, `value ends at column 22, past the width of 16`},
+		Warning{WarningWidth, 45, 1, 46, `value ends at column 26, past the width of 16`},
+	)
+
+	conf.BreakAfterKey = true
+	test(conf, `{"one": "two", "three": "four five"}`)
+	test(Compact, `{"one": "two", "three": "four five six seven eight nine ten eleven twelve"}`)
+
+	const src = "// one\n/* two */ [10 // three\n]"
+	test(Default, src,
+		Warning{WarningMixedComments, 7, 2, 1, `block comment in a source which starts with line comments`},
+	)
+
+	conf = Default
+	conf.CommentStyle = CommentStyleLine
+	test(conf, src)
+
+	test(Default, `["1.5", "1.234", "1,2,3", "192.168.100.200", {"1,5": 10}]`)
+	test(Default, `["1,5", "1.234,56", "1 234", "-1,234.56", 1.234.567]`,
+		Warning{WarningLocaleNumber, 1, 1, 2, `string "1,5" looks like a number formatted for a locale`},
+		Warning{WarningLocaleNumber, 8, 1, 9, `string "1.234,56" looks like a number formatted for a locale`},
+		Warning{WarningLocaleNumber, 20, 1, 21, `string "1 234" looks like a number formatted for a locale`},
+		Warning{WarningLocaleNumber, 29, 1, 30, `string "-1,234.56" looks like a number formatted for a locale`},
+		Warning{WarningLocaleNumber, 42, 1, 43, `1.234.567 looks like a number formatted for a locale`},
+	)
+}
+
+func TestLongLines(t *testing.T) {
+	conf := Default
+	conf.Width = 16
+
+	src := FormatString(conf, `{"one": "two", "three": "four five six", "seven": ["ĉeĉ ĉeĉ ĉeĉ ĉeĉ"]}`)
+	eq(t, "{\n  \"one\": \"two\",\n  \"three\": \"four five six\",\n  \"seven\": [\n    \"ĉeĉ ĉeĉ ĉeĉ ĉeĉ\"\n  ]\n}\n", src)
+	eq(t, []LongLine{
+		{Pos{34, 3, 17}, 27},
+		{Pos{81, 5, 23}, 21},
+	}, LongLines(conf, src))
+
+	eq(t, []LongLine{{Pos{22, 2, 17}, 17}}, LongLines(conf, "[10,\r\n"+strings.Repeat(`1`, 17)+"\r\n]"))
+	eq(t, []LongLine(nil), LongLines(conf, "[\n  \"one\"\n]\n"))
+
+	conf.Width = 0
+	eq(t, []LongLine(nil), LongLines(conf, src))
+}
+
+func TestFormat_workers(t *testing.T) {
+	var lines []string
+	for i := 0; i < 3000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id": %[1]v, "tags": ["one", %[1]v], "val": {"list": [10, {"str": "%[2]v"}]}}`, i, strings.Repeat(`x`, i%100)))
+		if i%100 == 0 {
+			lines = append(lines, `// comment`, `/* comment */`)
 		}
 	}
+	ndjson := strings.Join(lines, "\n")
+	list := `/* head */ [` + strings.Join(lines, ",\n") + `] // tail`
+
+	test := func(conf Conf, src string) {
+		t.Helper()
+		exp := FormatString(conf, src)
+		conf.Workers = 4
+		eq(t, exp, FormatString(conf, src))
+	}
+
+	for _, conf := range []Conf{Default, Compact, JSON5} {
+		test(conf, ndjson)
+		test(conf, list)
+	}
+
+	conf := Default
+	conf.Separator = SeparatorRS
+	test(conf, ndjson)
+
+	conf = Compact
+	conf.Separator = SeparatorNewline
+	test(conf, ndjson)
 
+	conf = Default
+	conf.Workers = 4
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := FormatCtx[string](ctx, conf, list)
+	eq(t, true, errors.Is(err, context.Canceled))
+}
+
+// Context which is canceled after the given number of checks.
+type countCtx struct {
+	context.Context
+	limit int
+}
+
+func (self *countCtx) Err() error {
+	if self.limit <= 0 {
+		return context.Canceled
+	}
+	self.limit--
 	return nil
 }
 
-func readTestFile(t testing.TB, name string) []byte {
-	path := testFilePath(name)
+func TestFormat_separator(t *testing.T) {
+	const src = `{"one": 10} // comment
+[20] 30`
+
+	test := func(conf Conf, sep Separator, exp string) {
+		t.Helper()
+		conf.Separator = sep
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(Default, SeparatorDefault, "{\"one\": 10}\n// comment\n[20]\n30\n")
+	test(Compact, SeparatorDefault, `{"one":10}[20]30`)
+	test(Compact, SeparatorNewline, "{\"one\":10}\n[20]\n30\n")
+	test(Default, SeparatorBlank, "{\"one\": 10}\n\n// comment\n[20]\n\n30\n")
+	test(Compact, SeparatorRS, "\x1e{\"one\":10}\n\x1e[20]\n\x1e30\n")
+	test(Compact, SeparatorArray, `[{"one":10},[20],30]`)
+	test(Default, SeparatorArray, "[\n  {\"one\": 10},\n  // comment\n  [20],\n  30\n]\n")
+
+	// Stray closing brackets between values don't end the list early.
+	conf := Compact
+	conf.Separator = SeparatorArray
+	eqFormat(t, conf, "10]\n20 } 30", `[10,20,30]`)
+	eqFormat(t, conf, ``, `[]`)
+
+	// Record separators between top-level values are whitespace, so JSON text
+	// sequences can be read back.
+	conf = Default
+	conf.Separator = SeparatorRS
+	const seq = "\x1e{\"one\": 10}\n\x1e[20]\n\x1e30\n"
+	eqFormat(t, conf, seq, seq)
+	conf.SelfCheck = true
+	eqFormat(t, conf, src, "\x1e{\"one\": 10}\n// comment\n\x1e[20]\n\x1e30\n")
+	eqFormat(t, Default, seq, "{\"one\": 10}\n[20]\n30\n")
+	eqFormat(t, Default, "[10, \"\x1e\"]", "[10, \"\x1e\"]\n")
+}
+
+func TestFormat_lineEnding(t *testing.T) {
+	const src = "{\"one\": 10, /* two\r\nthree */\r\n\"four\": \"a\\nb\"}\r\n"
+
+	test := func(val LineEnding, src, exp string) {
+		t.Helper()
+		conf := Default
+		conf.LineEnding = val
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(LineEndingDefault, src, "{\n  \"one\": 10,\n  /* two\r\nthree */\n  \"four\": \"a\\nb\"\n}\n")
+	test(LineEndingLF, src, "{\n  \"one\": 10,\n  /* two\nthree */\n  \"four\": \"a\\nb\"\n}\n")
+	test(LineEndingCRLF, src, "{\r\n  \"one\": 10,\r\n  /* two\r\nthree */\r\n  \"four\": \"a\\nb\"\r\n}\r\n")
+	test(LineEndingPreserve, src, "{\r\n  \"one\": 10,\r\n  /* two\r\nthree */\r\n  \"four\": \"a\\nb\"\r\n}\r\n")
+	test(LineEndingPreserve, "[10,\r\n20,\n30]\n", "[10, 20, 30]\n")
+	test(LineEndingCRLF, "// one\n[10]", "// one\r\n[10]\r\n")
+}
+
+func TestFormat_trailingComments(t *testing.T) {
+	conf := Default
+	conf.Width = 0
+	conf.TrailingComments = true
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test("[10, // one\n20 // two\n]", "[\n  10, // one\n  20 // two\n]\n")
+	test("[10,\n// one\n20]", "[\n  10,\n  // one\n  20\n]\n")
+	test("[10, /* one */\n20]", "[\n  10, /* one */\n  20\n]\n")
+	test("[10, /* one */ 20]", "[\n  10,\n  /* one */\n  20\n]\n")
+	test("[ // one\n10]", "[\n  // one\n  10\n]\n")
+	test("{\"one\": 10 // two\n, \"three\": 30}", "{\n  \"one\": 10, // two\n  \"three\": 30\n}\n")
+
+	conf.StripComments = true
+	test("[10, // one\n20]", "[\n  10,\n  20\n]\n")
+}
+
+func TestFormat_overrides(t *testing.T) {
+	const src = `{"dependencies": {"one": "1", "two": {"three": 3}}, "scripts": {"four": [4, 40], "five": 5}, "meta": {"six": [6, 60], "seven": "a string which is too wide to fit on one line"}, "eight": [8, 80]}`
+
+	conf := Default
+	conf.Overrides = []Override{
+		{`/dependencies`, json.RawMessage(`{"width": 0}`)},
+		{`/scripts/*`, json.RawMessage(`{"width": 0, "trailingComma": true}`)},
+		{`/meta`, json.RawMessage(`{"width": 200}`)},
+		{`/meta`, json.RawMessage(`{"braceSpacing": true}`)},
+	}
+
+	eqFormat(t, conf, src, `{
+  "dependencies": {
+    "one": "1",
+    "two": {
+      "three": 3
+    }
+  },
+  "scripts": {
+    "four": [
+      4,
+      40,
+    ],
+    "five": 5
+  },
+  "meta": { "six": [6, 60], "seven": "a string which is too wide to fit on one line" },
+  "eight": [8, 80]
+}
+`)
+
+	// Overrides apply to top-level values via the empty path, and to list
+	// elements via indexes.
+	conf = Default
+	conf.Overrides = []Override{{``, json.RawMessage(`{"width": 0}`)}, {`/1`, json.RawMessage(`{"width": 80}`)}}
+	eqFormat(t, conf, `[[1], [2]] {"one": 1}`, "[\n  [\n    1\n  ],\n  [2]\n]\n{\n  \"one\": 1\n}\n")
+
+	// Settings which apply to the whole document can't be overridden.
+	var buf bytes.Buffer
+	conf.Overrides = []Override{{`/one`, json.RawMessage(`{"sortKeys": true}`)}}
+	eq(t, true, FormatTo(conf, &buf, `{}`) != nil)
+
+	conf.Overrides = []Override{{`one`, json.RawMessage(`{}`)}}
+	eq(t, true, FormatTo(conf, &buf, `{}`) != nil)
+
+	// Overridden slices don't modify the slices of the caller.
+	conf = Default
+	conf.StripComments = true
+	conf.KeepComments = []string{`//!`, `// KEEP:`}
+	conf.Overrides = []Override{{`/one`, json.RawMessage(`{"keepComments": ["//X"]}`)}}
+	eqFormat(t, conf, "//! top\n{\"one\": [10, // X\n20]}", "//! top\n{\"one\": [10, 20]}\n")
+	eq(t, []string{`//!`, `// KEEP:`}, conf.KeepComments)
+
+	var loaded Conf
+	try(UnmarshalSingle(`{"overrides": [{"path": "/one", "conf": {"width": 0}}]}`, &loaded))
+	eq(t, []Override{{`/one`, json.RawMessage(`{"width":0}`)}}, loaded.Overrides)
+}
+
+func TestFormat_commentColumn(t *testing.T) {
+	conf := Default
+	conf.Width = 0
+	conf.TrailingComments = true
+	conf.CommentColumn = 16
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(
+		"{\"one\": 10, // one\n\"three\": [30, // three\n300], /* four */\n\"five\": \"long enough\" // five\n}",
+		"{\n  \"one\": 10,    // one\n  \"three\": [\n    30,         // three\n    300\n  ],            /* four */\n  \"five\": \"long enough\" // five\n}\n",
+	)
+	test("{\"one\": 10 // one\n, \"two\": 20}", "{\n  \"one\": 10,    // one\n  \"two\": 20\n}\n")
+
+	// Comments on their own lines are not aligned.
+	test("[10,\n// one\n20]", "[\n  10,\n  // one\n  20\n]\n")
+
+	conf.TrailingComments = false
+	test("[10, // one\n20]", "[\n  10,\n  // one\n  20\n]\n")
+}
+
+func TestFormatWithMetrics(t *testing.T) {
+	conf := Default
+	conf.StripComments = true
+	conf.KeepComments = []string{`//!`}
+
+	src := "// one\n{\"one\": 10 \"two\": \"// two\" //! three\n/* four */}"
+	out, metrics, err := FormatWithMetrics[string](conf, src)
+	try(err)
+
+	eq(t, "{\n  \"one\": 10,\n  \"two\": \"// two\"\n  //! three\n}\n", out)
+	metrics.Elapsed = 0
+	eq(t, Metrics{InputBytes: len(src), OutputBytes: len(out), Changed: true, CommentsStripped: 2, Repairs: 1}, metrics)
+
+	_, metrics, err = FormatWithMetrics[string](Default, "[10]\n")
+	try(err)
+	metrics.Elapsed = 0
+	eq(t, Metrics{InputBytes: 5, OutputBytes: 5}, metrics)
+
+	_, _, err = FormatWithMetrics[string](Default, `[[[`+strings.Repeat(`[`, DefaultMaxDepth)+`]]]`)
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf(`expected ErrMaxDepth, got %v`, err)
+	}
+}
+
+func TestFormat_closingComments(t *testing.T) {
+	conf := Default
+	conf.Width = 0
+	conf.TrailingComments = false
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	src := "[10, 20 // twenty\n/* end */]"
+	test(src, "[\n  10,\n  20\n  // twenty\n  /* end */\n]\n")
+
+	conf.TrailingComments = true
+	test(src, "[\n  10,\n  20 // twenty\n  /* end */\n]\n")
+
+	conf.ClosingComments = ClosingCommentsOwnLine
+	test(src, "[\n  10,\n  20\n  // twenty\n  /* end */\n]\n")
+
+	conf.TrailingComma = true
+	test(src, "[\n  10,\n  20,\n  // twenty\n  /* end */\n]\n")
+
+	conf.ClosingComments = ClosingCommentsAttach
+	test("[10, 20\n/* end */ // twenty\n]", "[\n  10,\n  20, /* end */ // twenty\n]\n")
+
+	// Comments in an otherwise empty list aren't attached to anything.
+	test("[10, [\n/* one */\n]]", "[\n  10,\n  [\n    /* one */\n  ],\n]\n")
+
+	conf.ClosingComments = ClosingCommentsHoist
+	conf.TrailingComma = false
+	test("{\"one\": 10, \"two\": 20 // twenty\n// end\n}", "{\n  \"one\": 10,\n  // end\n  \"two\": 20 // twenty\n}\n")
+}
+
+func TestFormat_keyComments(t *testing.T) {
+	conf := Default
+	conf.LayoutVersion = 2
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	const src = `{"one" /* two */ : /* three */ 10}`
+	test(src, "{\"one\" /* two */: /* three */ 10}\n")
+
+	conf.Width = 0
+	test(src, "{\n  \"one\" /* two */: /* three */ 10\n}\n")
+
+	conf.SortKeys = true
+	test(`{"two": 20, "one" /* two */ : 10}`, "{\n  \"one\" /* two */: 10,\n  \"two\": 20\n}\n")
+
+	doc := Parse(conf, src)
+	eq(t, []string{`/* two */`}, doc.Items[0].Entries[0].Key.After)
+	eq(t, []string{`/* three */`}, doc.Items[0].Entries[0].Val.Comments)
+
+	conf.LayoutVersion = 1
+	conf.SortKeys = false
+	test(src, "{\n  \"one\": \n  /* two */\n  /* three */10\n}\n")
+}
+
+func TestFormat_foldStrings(t *testing.T) {
+	conf := Default
+	conf.Width = 24
+	conf.FoldStrings = true
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+		eqFormat(t, conf, exp, exp)
+	}
+
+	test(`{"one": "two three"}`, "{\"one\": \"two three\"}\n")
+	test(`{"one": "two three four five six"}`, "{\n  \"one\": \"two three \\\nfour five six\"\n}\n")
+	test(`["one\ntwo three four five"]`, "[\n  \"one\\n\\\ntwo three four five\"\n]\n")
+	test(`"abcdefghijklmnopqrstuvwxyz"`, "\"abcdefghijklmnopqrstuv\\\nwxyz\"\n")
+	test("\"two three \\\nfour\"", "\"two three four\"\n")
+
+	conf = Default
+	conf.FoldLists = []string{`/one`}
+	eqFormat(t, conf, `{"one": "two\nthree\n", "four": "five\nsix"}`, "{\"one\": [\"two\", \"three\", \"\"], \"four\": \"five\\nsix\"}\n")
+}
+
+func TestFormat_exemptURLs(t *testing.T) {
+	const url = `"https://example.com/assets/images/very/long/path/to/the/logo.png"`
+	src := `{"name": "logo", "url": ` + url + `, "size": 10}`
+
+	conf := Default
+	conf.Width = 60
+	eqFormat(t, conf, src, "{\n  \"name\": \"logo\",\n  \"url\": "+url+",\n  \"size\": 10\n}\n")
+
+	conf.ExemptURLs = 40
+	eqFormat(t, conf, src, src+"\n")
+	eqFormat(t, conf, `{"one": "`+strings.Repeat(`a`, 60)+`"}`, "{\n  \"one\": \""+strings.Repeat(`a`, 60)+"\"\n}\n")
+	eqFormat(t, conf, `{"name": "a long name which doesn't fit with the URL", "url": `+url+`}`,
+		"{\n  \"name\": \"a long name which doesn't fit with the URL\",\n  \"url\": "+url+"\n}\n")
+	eqFormat(t, conf, `[`+url+`, `+url+`]`, "[\n  "+url+",\n  "+url+"\n]\n")
+
+	conf.ExemptURLs = 100
+	eqFormat(t, conf, src, "{\n  \"name\": \"logo\",\n  \"url\": "+url+",\n  \"size\": 10\n}\n")
+
+	eq(t, true, isUnbreakable(`C:\\Users\\one`))
+	eq(t, true, isUnbreakable(`src/one.go`))
+	eq(t, false, isUnbreakable(`one two/three`))
+	eq(t, false, isUnbreakable(`one\ntwo/three`))
+	eq(t, false, isUnbreakable(`one`))
+}
+
+func TestFormat_elideBlobs(t *testing.T) {
+	conf := Default
+	conf.ElideBlobs = 16
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(`"0123456789abcd"`, "\"0123456789abcd\"\n")
+	test(`"0123456789abcdef0123456789abcdef"`, "\"<hex: 32 chars>\"\n")
+	test(`"aGVsbG8gd29ybGQgaGVsbG8="`, "\"<base64: 24 chars>\"\n")
+	test(`"a\/b+aGVsbG8gd29ybGQ"`, "\"<base64: 20 chars>\"\n")
+	test(`"data:image/png;base64,iVBORw0KGgoAAAANSUhEUg=="`, "\"data:image/png;base64,<24 chars>\"\n")
+	test(`"data:text/plain,0123456789abcdef0123"`, "\"data:text/plain,0123456789abcdef0123\"\n")
+	test(`"hello world, hello world"`, "\"hello world, hello world\"\n")
+	test(`"aGVsbG8=d29ybGQgaGVsbG8"`, "\"aGVsbG8=d29ybGQgaGVsbG8\"\n")
+	test(`{"one": "0123456789abcdef0123456789abcdef"}`, "{\"one\": \"<hex: 32 chars>\"}\n")
+
+	// Fits only after elision.
+	blob := strings.Repeat(`aGVsbG8g`, 50)
+	test(`{"one": 10, "two": "`+blob+`"}`, "{\"one\": 10, \"two\": \"<base64: 400 chars>\"}\n")
+}
+
+func TestFormat_skeleton(t *testing.T) {
+	conf := Default
+	conf.Skeleton = true
+
+	eqFormat(t, conf, `{"one": [{"two": 10, "three": "four"}, {"five": 20}], "six": [], "seven": [true, null, NaN]}`, `{
+  "one": [{"two": "<number>", "three": "<string>"}, "<1 more>"],
+  "six": [],
+  "seven": ["<bool>", "<2 more>"]
+}
+`)
+	eqFormat(t, conf, "{\"one\": 10}\n{\"one\": 20}\n{\"one\": 30}", "{\"one\": \"<number>\"}\n\"<2 more>\"\n")
+	eqFormat(t, conf, `[]`, "[]\n")
+}
+
+func TestFormat_normalizeTimes(t *testing.T) {
+	conf := Default
+	conf.NormalizeTimes = []string{`/one/*`}
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(`{"one": ["2024-05-01 12:30:00"]}`, "{\"one\": [\"2024-05-01T12:30:00Z\"]}\n")
+	test(`{"one": ["2024-05-01T12:30:00.250+0200"]}`, "{\"one\": [\"2024-05-01T12:30:00.25+02:00\"]}\n")
+	test(`{"one": ["Wed, 01 May 2024 12:30:00 GMT"]}`, "{\"one\": [\"2024-05-01T12:30:00Z\"]}\n")
+	test(`{"one": ["Wed, 01 May 2024 12:30:00 PST"]}`, "{\"one\": [\"Wed, 01 May 2024 12:30:00 PST\"]}\n")
+	test(`{"one": ["2024-05-01", "two", 10]}`, "{\"one\": [\"2024-05-01\", \"two\", 10]}\n")
+	test(`{"two": ["2024-05-01 12:30:00"]}`, "{\"two\": [\"2024-05-01 12:30:00\"]}\n")
+}
+
+func TestSchemaLayout(t *testing.T) {
+	const schema = `{
+		"title": "Config",
+		"properties": {
+			"name": {},
+			"version": {"propertyOrder": 2},
+			"id": {"propertyOrder": 1},
+			"server": {"$ref": "#/$defs/server"},
+			"tags": {"x-jsonfmt": {"expand": true}},
+			"env": {"additionalProperties": {"$ref": "#/$defs/server"}}
+		},
+		"$defs": {
+			"server": {
+				"x-jsonfmt": {"order": ["host", "port"], "conf": {"trailingComma": true}},
+				"properties": {"port": {}, "host": {}, "next": {"$ref": "#/$defs/server"}}
+			}
+		}
+	}`
+
+	conf, err := SchemaLayout(Default, schema)
+	try(err)
+
+	eq(t, map[string][]string{
+		``:        {`id`, `version`, `name`, `server`, `tags`, `env`},
+		`/server`: {`host`, `port`},
+		`/env/*`:  {`host`, `port`},
+	}, conf.KeyOrder)
+
+	eqFormat(t, conf,
+		`{"tags": [10], "server": {"port": 20, "host": "one"}, "name": "two", "id": 30}`,
+		"{\n  \"id\": 30,\n  \"name\": \"two\",\n  \"server\": {\"host\": \"one\", \"port\": 20},\n  \"tags\": [\n    10\n  ]\n}\n",
+	)
+
+	again, err := SchemaLayout(conf, schema)
+	try(err)
+	eq(t, conf.Overrides, again.Overrides)
+
+	_, err = SchemaLayout(Default, `{"x-jsonfmt": {"conf": {"sortKeys": true}}}`)
+	if err == nil {
+		t.Fatal(`expected an error for settings which can't be overridden`)
+	}
+
+	_, err = SchemaLayout(Default, `[]`)
+	if err == nil {
+		t.Fatal(`expected an error for a schema which isn't a dict`)
+	}
+}
+
+func TestFormat_preserveMultiline(t *testing.T) {
+	conf := Default
+	conf.PreserveMultiline = true
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(`{"one": [10, 20], "two": {}}`, "{\"one\": [10, 20], \"two\": {}}\n")
+	test("{\"one\": [\n10, 20], \"two\": {}}", "{\n  \"one\": [\n    10,\n    20\n  ],\n  \"two\": {}\n}\n")
+	test("[\"one // two\", {\"three\": 30}\n]", "[\n  \"one // two\",\n  {\"three\": 30}\n]\n")
+
+	conf.Width = 0
+	test(`{"one": 10}`, "{\n  \"one\": 10\n}\n")
+}
+
+func TestFormat_brackets(t *testing.T) {
+	const src = `{"one": {"two": 20}, "three": [10, {}, {"four": 40}], /* five */ "six": []}`
+
+	test := func(conf Conf, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	conf := Default
+	conf.LayoutVersion = 2
+	conf.Width = 60
+	conf.BraceSpacing = true
+	test(conf, "{\n  \"one\": { \"two\": 20 },\n  \"three\": [10, {}, { \"four\": 40 }],\n  /* five */\n  \"six\": []\n}\n")
+
+	conf.Width = 1000
+	test(conf, "{ \"one\": { \"two\": 20 }, \"three\": [10, {}, { \"four\": 40 }], /* five */ \"six\": [] }\n")
+
+	conf.StripComments = true
+	eqFormat(t, conf, `{/* one */}`, "{}\n")
+
+	conf = Default
+	conf.LayoutVersion = 2
+	conf.Width = 0
+	conf.TrailingComma = true
+	conf.CloseSameLine = true
+	test(conf, "{\n  \"one\": {\n    \"two\": 20},\n  \"three\": [\n    10,\n    {},\n    {\n      \"four\": 40}],\n  /* five */\n  \"six\": []}\n")
+	eqFormat(t, conf, "[10 // one\n]", "[\n  10\n  // one\n]\n")
+
+	conf = Default
+	conf.LayoutVersion = 2
+	conf.Width = 0
+	conf.OpenNewline = true
+	test(conf, "{\n  \"one\":\n  {\n    \"two\": 20\n  },\n  \"three\":\n  [\n    10,\n    {},\n    {\n      \"four\": 40\n    }\n  ],\n  /* five */\n  \"six\": []\n}\n")
+
+	conf.Width = 20
+	test(conf, "{\n  \"one\": {\"two\": 20},\n  \"three\":\n  [\n    10,\n    {},\n    {\"four\": 40}\n  ],\n  /* five */\n  \"six\": []\n}\n")
+}
+
+func TestFormat_empty(t *testing.T) {
+	const src = "{\"one\": { }, \"two\": [\n], \"three\": [/* four */], \"five\": {,}}"
+
+	test := func(conf Conf, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	conf := Default
+	conf.Width = 0
+	test(conf, "{\n  \"one\": {\n  },\n  \"two\": [\n  ],\n  \"three\": [\n    /* four */\n  ],\n  \"five\": {\n  }\n}\n")
+
+	conf.LayoutVersion = 2
+	test(conf, "{\n  \"one\": {},\n  \"two\": [],\n  \"three\": [\n    /* four */\n  ],\n  \"five\": {}\n}\n")
+
+	conf.StripComments = true
+	test(conf, "{\n  \"one\": {},\n  \"two\": [],\n  \"three\": [],\n  \"five\": {}\n}\n")
+
+	conf.ExpandEmpty = true
+	test(conf, "{\n  \"one\": {\n  },\n  \"two\": [\n  ],\n  \"three\": [\n  ],\n  \"five\": {\n  }\n}\n")
+
+	test(Compact, `{"one":{},"two":[],"three":[],"five":{}}`)
+}
+
+func TestFormat_inlineSingle(t *testing.T) {
+	const src = `{"one": {"two": 20}, "three": [30], "four": [{"five": 50}], "six": {"seven": 70, "eight": 80}, "nine": [10 // ten
+]}`
+
+	conf := Default
+	conf.Width = 0
+	conf.InlineSingle = true
+	eqFormat(t, conf, src, "{\n  \"one\": {\"two\": 20},\n  \"three\": [30],\n  \"four\": [\n    {\"five\": 50}\n  ],\n  \"six\": {\n    \"seven\": 70,\n    \"eight\": 80\n  },\n  \"nine\": [\n    10\n    // ten\n  ]\n}\n")
+
+	conf.Width = 10
+	eqFormat(t, conf, `[{"one": "two three four five"}]`, "[\n  {\"one\": \"two three four five\"}\n]\n")
+}
+
+func TestFormat_breakAfterKey(t *testing.T) {
+	const src = `{"one": "two three four", "five": 50, "six": {"seven": "eight nine ten"}}`
+
+	conf := Default
+	conf.Width = 20
+	conf.BreakAfterKey = true
+	eqFormat(t, conf, src, "{\n  \"one\":\n    \"two three four\",\n  \"five\": 50,\n  \"six\": {\n    \"seven\":\n      \"eight nine ten\"\n  }\n}\n")
+
+	conf.BreakAfterKey = false
+	eqFormat(t, conf, src, "{\n  \"one\": \"two three four\",\n  \"five\": 50,\n  \"six\": {\n    \"seven\": \"eight nine ten\"\n  }\n}\n")
+}
+
+func TestFormat_commentConversion(t *testing.T) {
+	const src = "# one\n{\"two\": 20, # three\n\"four\": 40, /** five\n * six\n */ \"seven\": [/* eight */]}"
+
+	test := func(conf Conf, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	conf := Default
+	conf.CommentLine = `#`
+	conf.OutputCommentLine = `//`
+	test(conf, "// one\n{\n  \"two\": 20,\n  // three\n  \"four\": 40,\n  /** five\n * six\n */\n  \"seven\": [/* eight */]\n}\n")
+
+	conf.CommentStyle = CommentStyleLine
+	test(conf, "// one\n{\n  \"two\": 20,\n  // three\n  \"four\": 40,\n  // five\n  // six\n  \"seven\": [\n    // eight\n  ]\n}\n")
+
+	// Comments between keys and values stay block comments.
+	const entries = `{"one" /* two */: 10, "three": /* four */ 30, "five": "a string which is wide enough"}`
+	eqFormat(t, conf, entries, "{\n  \"one\": \n  /* two */10,\n  \"three\": \n  /* four */30,\n  \"five\": \"a string which is wide enough\"\n}\n")
+
+	conf.LayoutVersion = 2
+	eqFormat(t, conf, entries, "{\n  \"one\" /* two */: 10,\n  \"three\": /* four */ 30,\n  \"five\": \"a string which is wide enough\"\n}\n")
+	conf.LayoutVersion = 0
+
+	conf.CommentStyle = CommentStyleBlock
+	conf.OutputCommentBlockStart = `{-`
+	conf.OutputCommentBlockEnd = `-}`
+	test(conf, "{- one -}\n{\n  \"two\": 20,\n  {- three -}\n  \"four\": 40,\n  {-* five\n * six\n -}\n  \"seven\": [{- eight -}]\n}\n")
+
+	conf = Default
+	conf.CommentStyle = CommentStyleBlock
+	eqFormat(t, conf, "[10, // has */ end\n20 //\n]", "[\n  10,\n  // has */ end\n  20\n  /**/\n]\n")
+}
+
+func TestFormat_normalizeComments(t *testing.T) {
+	conf := Default
+	conf.NormalizeComments = true
+
+	eqFormat(
+		t,
+		conf,
+		"//one  \n{\"two\": 20, //    three\n//\n//   \n//\n///  four\n\"five\": 50, /*six   */ /**  seven\n *\n *\n * eight   \n   */ \"nine\": [/*\n  ten  \n  */]}",
+		"// one\n{\n  \"two\": 20,\n  // three\n  //\n  ///  four\n  \"five\": 50,\n  /* six */\n  /**  seven\n *\n * eight\n   */\n  \"nine\": [\n    /*\n  ten\n  */\n  ]\n}\n",
+	)
+
+	conf.NormalizeComments = false
+	eqFormat(t, conf, "//one  \n10", "//one  \n10\n")
+}
+
+func TestFormat_keepComments(t *testing.T) {
+	const src = "// drop\n//! lint-disable\n{\n\t\"one\": 10, // KEEP: reason\n\t/* drop */\n\t/* @ts */ \"two\": 20 // drop\n}\n"
+
+	conf := Default
+	conf.StripComments = true
+	conf.KeepComments = []string{`//!`, `// KEEP:`}
+	conf.KeepRegexp = `^/\* @`
+
+	eqFormat(t, conf, src, "//! lint-disable\n{\n  \"one\": 10,\n  // KEEP: reason\n  /* @ts */\n  \"two\": 20\n}\n")
+
+	conf.Indent = ``
+	eqFormat(t, conf, src, "//! lint-disable\n{\"one\":10,// KEEP: reason\n/* @ts */\"two\":20}")
+
+	// Kept comments are not rewritten, so they still match when formatted again.
+	conf = Default
+	conf.StripComments = true
+	conf.NormalizeComments = true
+	conf.CommentStyle = CommentStyleBlock
+	conf.KeepComments = []string{`//!`}
+	eqFormat(t, conf, "//!  one  \n// two\n10", "//!  one  \n10\n")
+
+	// Without `StripComments`, all comments are kept.
+	conf = Default
+	conf.KeepComments = []string{`//!`}
+	eqFormat(t, conf, "// one\n10", "// one\n10\n")
+
+	conf = Default
+	conf.StripComments = true
+	conf.KeepRegexp = `(`
+	var buf bytes.Buffer
+	eq(t, true, FormatTo(conf, &buf, `// one\n10`) != nil)
+}
+
+func TestFormat_commentKeys(t *testing.T) {
+	const src = `// One.
+{
+  // Two <2>.
+  "two": 20,
+  "three": {
+    /* Four
+     * five. */
+    "six": 60 // Seven.
+  },
+  "eight": [/* nine */ 90]
+}`
+
+	const keys = `{
+  "$comment": "One.\nTwo <2>.",
+  "two": 20,
+  "three": {"$comment": "Four\nfive.\nSeven.", "six": 60},
+  "eight": [90]
+}
+`
+
+	conf := Default
+	conf.CommentsToKey = `$comment`
+	conf.StripComments = true
+	eqFormat(t, conf, src, keys)
+
+	// Existing properties with the key are merged, unless they aren't strings.
+	eqFormat(t, conf, "{\"one\": 10, // two\n\"$comment\": \"three\", \"four\": 40 // five\n}", `{"one": 10, "$comment": "two\nthree\nfive", "four": 40}`+"\n")
+	eqFormat(t, conf, "{\"$comment\": 10, // two\n\"three\": 30}", `{"$comment": 10, "three": 30}`+"\n")
+
+	conf = Default
+	conf.CommentsFromKey = `$comment`
+	eqFormat(t, conf, keys, `{
+  // One.
+  // Two <2>.
+  "two": 20,
+  "three": {
+    // Four
+    // five.
+    // Seven.
+    "six": 60
+  },
+  "eight": [90]
+}
+`)
+}
+
+func TestFormat_punctuation(t *testing.T) {
+	const src = `{"one": [10, 20], "two": 30}`
+
+	test := func(conf Conf, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	conf := Default
+	conf.Colon = ` : `
+	conf.Comma = `,`
+	test(conf, "{\"one\" : [10,20],\"two\" : 30}\n")
+
+	conf.Width = 0
+	conf.Comma = ` , `
+	conf.TrailingComma = true
+	test(conf, "{\n  \"one\" : [\n    10 ,\n    20 ,\n  ] ,\n  \"two\" : 30 ,\n}\n")
+
+	conf = Compact
+	conf.Colon = `: `
+	conf.Comma = `, `
+	test(conf, `{"one": [10, 20], "two": 30}`)
+}
+
+func TestFormat_mismatchedClosers(t *testing.T) {
+	eqFormat(t, Default, `[}]`, "[]\n")
+	eqFormat(t, Default, `{]`, "{}\n")
+	eqFormat(t, Default, `[10 }`, "[10]\n")
+	eqFormat(t, Default, `{"one": [10, 20}`, "{\"one\": [10, 20]\n")
+	eqFormat(t, Default, `{"one": ]}`, "{\"one\": }\n")
+
+	conf := Default
+	conf.SortKeys = true
+	eqFormat(t, conf, `{"one": [10, 20}`, "{\"one\": [10, 20]}\n")
+}
+
+/*
+Every scanning step must consume input, so formatting must terminate without
+failing internal assertions on any input. Enumerates short inputs made of
+syntax characters.
+*/
+func TestFormat_terminates(t *testing.T) {
+	const chars = "{}[]\",: a/*\n"
+
+	repair, reindent := Default, Default
+	repair.Repair = true
+	reindent.Reindent = true
+
+	confs := []Conf{Default, Compact, JSON5}
+	for _, conf := range confs[:len(confs):len(confs)] {
+		conf.Width = 0
+		confs = append(confs, conf)
+	}
+	confs = append(confs, repair, reindent)
+
+	var test func(string, int)
+	test = func(src string, depth int) {
+		for _, conf := range confs {
+			FormatString(conf, src)
+		}
+		if depth == 0 {
+			return
+		}
+		for _, char := range chars {
+			test(src+string(char), depth-1)
+		}
+	}
+	test(``, 4)
+}
+
+func TestFormat_selfCheck(t *testing.T) {
+	conf := JSON5
+	conf.SelfCheck = true
+	eqFormat(t, conf, `{"one": [10, 20,], /* comment */ "two": 30}`, "{\"one\": [10, 20], /* comment */ \"two\": 30}\n")
+
+	conf.StrictOutput = true
+	eqFormat(t, conf, `{"one": [10, 20,], /* comment */ "two": 30}`, "{\"one\": [10, 20], \"two\": 30}\n")
+
+	_, err := FormatCtx[string](context.Background(), conf, `{one: undefined}`)
+	eq(t, true, errors.Is(err, ErrSelfCheck))
+}
+
+/*
+Formatting must not panic on any input. For lax JSON input, which becomes valid
+JSON when formatted, checks the invariants of `Conf.SelfCheck`, and that strict
+output decodes to the same value. Run with:
+
+	go test -fuzz=FuzzFormat
+*/
+func FuzzFormat(f *testing.F) {
+	for _, name := range []string{`inp_long_comments.json`, `inp_short_nopunc.json`, `inp_lines.json`} {
+		f.Add(readTestFile(f, name))
+	}
+	f.Add([]byte(`{"one": [10, 20], // comment` + "\n" + `"two": {"three": 30},}`))
+	f.Add([]byte(`[{"one" 10} {"two": [20 30]]`))
+
+	preserve := Default
+	preserve.PreserveMultiline = true
+	preserve.BraceSpacing = true
+
+	confs := []Conf{Default, Compact, JSON5, preserve}
+
+	// Separates top-level values which would otherwise merge, such as "10 20".
+	compact := Compact
+	compact.Separator = SeparatorNewline
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		ref := FormatBytes(compact, src)
+		if !isValidJSON(ref) {
+			for _, conf := range confs {
+				FormatBytes(conf, src)
+			}
+			return
+		}
+
+		for _, conf := range confs {
+			conf.SelfCheck = true
+			_, err := FormatCtx[[]byte](context.Background(), conf, src)
+			if err != nil {
+				t.Fatalf(`%v; conf: %#v`, err, conf)
+			}
+		}
+
+		conf := Default
+		conf.StrictOutput = true
+		conf.SelfCheck = true
+		out, err := FormatCtx[[]byte](context.Background(), conf, src)
+		if err != nil {
+			t.Fatalf(`%v; output: %q`, err, out)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(ref))
+		for decOut := json.NewDecoder(bytes.NewReader(out)); dec.More(); {
+			var exp, act any
+			try(dec.Decode(&exp))
+			try(decOut.Decode(&act))
+			eq(t, exp, act)
+		}
+	})
+}
+
+func TestFormat_strictPunctuation(t *testing.T) {
+	conf := Default
+	conf.StrictPunctuation = true
+
+	test := func(src string, offset int, msg string) {
+		t.Helper()
+		line, col := lineCol(src, offset)
+		var buf bytes.Buffer
+		eq(t, error(&PunctuationError{Offset: offset, Line: line, Column: col, Msg: msg}), FormatTo(conf, &buf, src))
+	}
+
+	const src = `{"one": [10, 20,], /* three */ "four": {{ .five }}, {{ if .six }} "seven": 70,} // eight`
+	tpl := Default
+	tpl.Placeholders = []Placeholder{{`{{`, `}}`}}
+	exp := FormatString(tpl, src)
+	tpl.StrictPunctuation = true
+	eqFormat(t, tpl, src, exp)
+	eqFormat(t, conf, "10\n[20]\n", "10\n[20]\n")
+	eqFormat(t, Conf{Indent: `  `, Width: 80}, `[10 20] {"one" 10}`, "[10, 20]\n{\"one\": 10}\n")
+
+	test(`[10 20]`, 4, `missing comma`)
+	test(`{"one" 10}`, 7, `missing colon`)
+	test(`{"one"}`, 6, `missing dict value`)
+	test(`{"one":}`, 7, `missing dict value`)
+	test(`{"one": 10 "two": 20}`, 11, `missing comma`)
+	test(`[10,, 20]`, 4, `unexpected comma`)
+	test(`[, 10]`, 1, `unexpected comma`)
+	test(`[10: 20]`, 3, `unexpected colon`)
+	test(`{"one":: 10}`, 7, `unexpected colon`)
+	test(`{"one", 10}`, 6, `unexpected comma`)
+	test("10,\n20", 2, `unexpected comma`)
+	test(`{"one": [{"two": 20} {"three": 30}]}`, 21, `missing comma`)
+
+	out := NewWriter(conf, new(bytes.Buffer))
+	_, err := out.Write([]byte("[10]\n[20 30]\n[40]\n"))
+	eq(t, error(&PunctuationError{Offset: 9, Line: 2, Column: 5, Msg: `missing comma`}), err)
+
+	conf.Repair = true
+	eqFormat(t, conf, `[10 20]`, `[10, 20]`)
+}
+
+func TestPunctuationErrors(t *testing.T) {
+	eq(t, []PunctuationError(nil), PunctuationErrors(Default, `{"one": [10, 20,], "two": {},} // three`))
+
+	eq(t, []PunctuationError{
+		{Offset: 14, Line: 2, Column: 5, Msg: `missing comma`, Fix: `inserted comma`},
+		{Offset: 17, Line: 2, Column: 8, Msg: `unexpected comma`, Fix: `removed comma`},
+		{Offset: 31, Line: 3, Column: 8, Msg: `missing colon`, Fix: `inserted colon`},
+		{Offset: 40, Line: 4, Column: 6, Msg: `unexpected comma`, Fix: `replaced comma with colon`},
+		{Offset: 47, Line: 5, Column: 3, Msg: `unexpected colon`, Fix: `replaced colon with comma`},
+		{Offset: 53, Line: 5, Column: 9, Msg: `missing dict value`},
+		{Offset: 55, Line: 6, Column: 1, Msg: `unexpected comma`, Fix: `removed comma`},
+	}, PunctuationErrors(Default, "{\"a\": [10\n    20,, 30],\n  \"b\"  40,\n  \"c\", 50\n  : \"d\":}\n, 60"))
+}
+
+func TestFormat_repair(t *testing.T) {
+	conf := Default
+	conf.Repair = true
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(``, ``)
+	test("{\"one\": 10}\n", "{\"one\": 10}\n")
+	test("{\n  \"one\" 10\n  \"two\": [1 2,, 3,]  // comment\n  ,\"three\":: {}\n}\n", "{\n  \"one\": 10,\n  \"two\": [1, 2, 3]  // comment\n  ,\"three\": {}\n}\n")
+	test("[10\n, 20\n\t30 // comment\n\t40]", "[10\n, 20,\n\t30, // comment\n\t40]")
+	test(`{"one": 10 /* comment */ "two": 20,}`, `{"one": 10, /* comment */ "two": 20}`)
+	test(`{"one": [10 }, "two" 20}`, `{"one": [10 ], "two": 20}`)
+	test("10, 20\r\n{\"one\" 10}", "10 20\r\n{\"one\": 10}")
+}
+
+func TestFormat_fixSmartQuotes(t *testing.T) {
+	const src = "{“one”:\u00a0“two”, ‘three’: [‘don’t’, “say \"hi\"”], \"“four”\": 10 // “five”\n}"
+	eqFormat(t, Default, `[“one two”]`, "[“one, two”]\n")
+
+	conf := Default
+	conf.FixSmartQuotes = true
+	eqFormat(t, conf, src, "{\n  \"one\": \"two\",\n  \"three\": [\"don’t\", \"say \\\"hi\\\"\"],\n  \"“four”\": 10\n  // “five”\n}\n")
+	eqFormat(t, conf, `[“one two”]`, "[\"one two\"]\n")
+	eqFormat(t, conf, "[“one\ntwo”]", "[“one, two”]\n")
+
+	conf.Repair = true
+	eqFormat(t, conf, "{“one”: “two” “three”: 10}", "{\"one\": \"two\", \"three\": 10}")
+}
+
+func TestFormat_noise(t *testing.T) {
+	const src = "\ufeff{\"one\": 10}\f\n\x00[10\x0020, \"\x00\"] // \x01\n30\x1e"
+	eqFormat(t, Default, "10\x0020", "10\x0020\n")
+
+	conf := Default
+	conf.Noise = NoiseSkip
+	eqFormat(t, conf, src, "{\"one\": 10}\n[10, 20, \"\x00\"]\n// \x01\n30\n")
+	eqFormat(t, conf, `{"one": 10}`, "{\"one\": 10}\n")
+
+	conf.Noise = NoiseReject
+	eqFormat(t, conf, "[10] // \x00\n\"\x00\"", "[10]\n// \x00\n\"\x00\"\n")
+
+	var buf bytes.Buffer
+	eq(t, error(&NoiseError{Offset: 0, Line: 1, Column: 1, Char: '\ufeff'}), FormatTo(conf, &buf, src))
+	eq(t, error(&NoiseError{Offset: 11, Line: 1, Column: 12, Char: '\f'}), FormatTo(conf, &buf, src[3:]))
+}
+
+func TestFormat_reindent(t *testing.T) {
+	conf := Default
+	conf.Reindent = true
+
+	test := func(src, exp string) {
+		t.Helper()
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(``, ``)
+	test("{\n\"one\": 10,\n        \"two\": [\n10, // comment {\n    20\n  ],\n  \t\n  \"three\": /* one\n     two */ 30\n}\n", "{\n  \"one\": 10,\n  \"two\": [\n    10, // comment {\n    20\n  ],\n\n  \"three\": /* one\n     two */ 30\n}\n")
+	test("[{\n\"one\": 10\n}]", "[{\n  \"one\": 10\n}]")
+	test("  10 \r\n{\r\n\"one\" 10,,\r\n}", "10 \r\n{\r\n  \"one\" 10,,\r\n}")
+
+	conf.Indent = "\t"
+	test("[\n[\n10\n]\n]", "[\n\t[\n\t\t10\n\t]\n]")
+}
+
+func TestFormat_keySort(t *testing.T) {
+	const src = `{"item10": 1, "Item3": 2, "item2": 3, "item02": 4, "B": 5, "a": 6}`
+
+	test := func(order KeySort, exp string) {
+		t.Helper()
+		conf := Compact
+		conf.SortKeys = true
+		conf.KeySort = order
+		eqFormat(t, conf, src, exp)
+	}
+
+	test(KeySortLexical, `{"B":5,"Item3":2,"a":6,"item02":4,"item10":1,"item2":3}`)
+	test(KeySortNatural, `{"B":5,"Item3":2,"a":6,"item2":3,"item02":4,"item10":1}`)
+	test(KeySortIgnoreCase, `{"a":6,"B":5,"item02":4,"item10":1,"item2":3,"Item3":2}`)
+	test(KeySortNaturalIgnoreCase, `{"a":6,"B":5,"item2":3,"item02":4,"Item3":2,"item10":1}`)
+}
+
+func TestFormat_keyOrder(t *testing.T) {
+	const src = `{"version": 1, "extra": 2, "name": 3, "server": {"port": 4, "host": 5}, "list": [{"b": 6, "a": 7}]}`
+
+	conf := Compact
+	conf.KeyOrder = map[string][]string{
+		``:        {`name`, `version`, `server`},
+		`/server`: {`host`, `port`},
+		`/list/*`: {`a`},
+	}
+	eqFormat(t, conf, src, `{"name":3,"version":1,"server":{"host":5,"port":4},"extra":2,"list":[{"a":7,"b":6}]}`)
+
+	conf.SortKeys = true
+	conf.KeyOrder = map[string][]string{``: {`version`}}
+	eqFormat(t, conf, src, `{"version":1,"extra":2,"list":[{"a":7,"b":6}],"name":3,"server":{"host":5,"port":4}}`)
+}
+
+func TestKeyOrderOf(t *testing.T) {
+	eq(
+		t,
+		map[string][]string{
+			``:        {`name`, `server`, `list`, `a/b`},
+			`/server`: {`host`, `port`},
+			`/list/*`: {`b`, `a`},
+			`/a~1b`:   {`c`},
+		},
+		KeyOrderOf(Default, `{"name": 1, "server": {"host": 2, "port": 3}, "list": [{"b": 4}, {"a": 5, "b": 6}]} {"a/b": {"c": 7}}`),
+	)
+}
+
+func TestKeyOrderOfType(t *testing.T) {
+	type Inner struct {
+		Port int `json:"port"`
+		Host string
+	}
+
+	type Embedded struct {
+		Extra bool `json:"extra,omitempty"`
+	}
+
+	type Outer struct {
+		Name    string  `json:"name"`
+		Skipped string  `json:"-"`
+		Server  *Inner  `json:"server"`
+		List    []Inner `json:"list"`
+		Embedded
+		private int
+	}
+
+	eq(
+		t,
+		map[string][]string{
+			``:        {`name`, `server`, `list`, `extra`},
+			`/server`: {`port`, `Host`},
+			`/list/*`: {`port`, `Host`},
+		},
+		KeyOrderOfType(reflect.TypeOf(Outer{})),
+	)
+}
+
+func TestFormat_sortDicts(t *testing.T) {
+	conf := Default
+	conf.SortDicts = []string{`/deps`, `/*/nested`}
+	eqFormat(t, conf, `{"two": {"nested": {"b": 20, "a": 10}}, "deps": {"b": {"d": 40, "c": 30}, "a": 10}}`, `{
+  "two": {"nested": {"a": 10, "b": 20}},
+  "deps": {"a": 10, "b": {"d": 40, "c": 30}}
+}
+`)
+}
+
+func TestFormat_sortDicts_keyOrder(t *testing.T) {
+	conf := Default
+	conf.SortDicts = []string{``}
+	conf.KeyOrder = map[string][]string{``: {`version`, `name`}}
+	eqFormat(t, conf, `{"b": 20, "name": "one", "a": {"d": 40, "c": 30}, "version": 1}`,
+		"{\"version\": 1, \"name\": \"one\", \"a\": {\"d\": 40, \"c\": 30}, \"b\": 20}\n")
+}
+
+func TestFormat_sortLists(t *testing.T) {
+	const src = `{
+  "files": ["b.json", "a.json", "b.json"],
+  "perms": {"read": [30, 4, 200, 4], "write": ["c", "a"]},
+  "other": ["b", "a"],
+  "nested": ["b", {"a": 10}]
+}`
+
+	conf := Default
+	conf.SortLists = []string{`/files`, `/perms/*`, `/nested`, `/missing/path`}
+	eqFormat(t, conf, src, `{
+  "files": ["a.json", "b.json", "b.json"],
+  "perms": {"read": [4, 4, 30, 200], "write": ["a", "c"]},
+  "other": ["b", "a"],
+  "nested": ["b", {"a": 10}]
+}
+`)
+
+	conf.DedupeLists = true
+	eqFormat(t, conf, src, `{
+  "files": ["a.json", "b.json"],
+  "perms": {"read": [4, 30, 200], "write": ["a", "c"]},
+  "other": ["b", "a"],
+  "nested": ["b", {"a": 10}]
+}
+`)
+
+	conf = Default
+	conf.Width = 0
+	conf.SortLists = []string{``}
+	eqFormat(t, conf, "[\"b\", // comment\n\"a\"]", "[\n  // comment\n  \"a\",\n  \"b\"\n]\n")
+}
+
+func TestFormat_placeholders(t *testing.T) {
+	conf := Default
+	conf.Placeholders = []Placeholder{{`{{`, `}}`}, {`${`, `}`}}
+
+	eqFormat(t, conf,
+		`{"one": {{ .one | quote }}, "two": ${encode({a = 1})}, "three": pre-${x}-post}`,
+		"{\"one\": {{ .one | quote }}, \"two\": ${encode({a = 1})}, \"three\": pre-${x}-post}\n",
+	)
+
+	eqFormat(t, conf,
+		`{{{ .key }}: "}}"}`,
+		"{{{ .key }}: \"}}\"}\n",
+	)
+
+	eqFormat(t, conf,
+		`{"one": 10, {{ if .two }} "two": 20, {{ end }} "three": 30}`,
+		"{\"one\": 10, {{ if .two }} \"two\": 20, {{ end }} \"three\": 30}\n",
+	)
+
+	conf.Width = 0
+	eqFormat(t, conf,
+		`{"one": 10, {{ if .two }} "two": 20, {{ end }} "three": 30}`,
+		`{
+  "one": 10,
+  {{ if .two }}
+  "two": 20,
+  {{ end }}
+  "three": 30
+}
+`,
+	)
+}
+
+func TestFormat_convertTo(t *testing.T) {
+	const src = "{a: 'one \\'two\\'', b: 0x1F, c: +1, d: .5, e: Infinity, // three\nf: [1, 2,],}"
+
+	conf := Default
+	WithInputDialect(DialectJSON5)(&conf)
+	conf.ConvertTo = DialectJSON
+	eqFormat(t, conf, src, `{"a": "one 'two'", "b": 31, "c": 1, "d": 0.5, "e": Infinity, "f": [1, 2]}
+`)
+
+	conf.ConvertTo = DialectJSONC
+	eqFormat(t, conf, src, `{
+  "a": "one 'two'",
+  "b": 31,
+  "c": 1,
+  "d": 0.5,
+  "e": Infinity,
+  // three
+  "f": [1, 2]
+}
+`)
+
+	conf = Default
+	WithInputDialect(DialectHJSON)(&conf)
+	conf.ConvertTo = DialectJSON5
+	eqFormat(t, conf, "# one\n{\n  name: John Smith\n  \"size\": 0x10\n}", `// one
+{name: "John Smith", "size": 0x10}
+`)
+
+	conf = Default
+	eq(t, nil, conf.Set(`convert-to`, `HJSON`))
+	eq(t, DialectHJSON, conf.ConvertTo)
+	eq(t, true, conf.Set(`convert-to`, `yaml`) != nil)
+}
+
+func TestFormat_multiWordAtoms(t *testing.T) {
+	const src = "{name: one  two three \t// four\ntags: [red green, blue]\nurl: five six: seven}"
+
+	conf := Default
+	eqFormat(t, conf, `{name: one two}`, "{name: one, two: }\n")
+
+	conf.MultiWordAtoms = true
+	eqFormat(t, conf, `{name: one two}`, "{name: one two}\n")
+	eqFormat(t, conf, src, `{
+  name: one  two three,
+  // four
+  tags: [red green, blue],
+  url: five six,
+  seven: 
+}
+`)
+}
+
+func TestFormat_recognizers(t *testing.T) {
+	const src = `{"one": ISODate("2024-05-01 (UTC)"), "two": new Date(2024, 4, 1), "three": Other(10, 20), "four": 10L}`
+
+	conf := Default
+	conf.Width = 0
+	conf.Recognizers = []Recognizer{CallRecognizer(`ISODate`, `Date`)}
+	eqFormat(t, conf, src, `{
+  "one": ISODate("2024-05-01 (UTC)"),
+  "two": new Date(2024, 4, 1),
+  "three": Other(10,
+  20): "four",
+  10L: 
+}
+`)
+
+	conf.Width = Default.Width
+	conf.Recognizers = []Recognizer{CallRecognizer()}
+	eqFormat(t, conf, src, `{
+  "one": ISODate("2024-05-01 (UTC)"),
+  "two": new Date(2024, 4, 1),
+  "three": Other(10, 20),
+  "four": 10L
+}
+`)
+	eq(t, []Warning(nil), Warnings(conf, src))
+
+	conf.Recognizers = []Recognizer{RecognizerFunc(func(src string) int {
+		if strings.HasPrefix(src, `<<`) {
+			return strings.Index(src, `>>`) + 2
+		}
+		return 0
+	})}
+	eqFormat(t, conf, `[<< one, two >>, 10]`, "[<< one, two >>, 10]\n")
+}
+
+func TestPreset(t *testing.T) {
+	conf, ok := Preset(`JSONC`)
+	eq(t, true, ok)
+	eq(t, JSONC, conf)
+
+	_, ok = Preset(`unknown`)
+	eq(t, false, ok)
+
+	const src = `{"one": [10, 20], /* comment */ "two": 30,}`
+	eqFormat(t, Compact, src, `{"one":[10,20],"two":30}`)
+	eqFormat(t, JSON5, src, "{\"one\": [10, 20], /* comment */ \"two\": 30}\n")
+	eqFormat(t, JSONC, src, `{
+    "one": [
+        10,
+        20
+    ],
+    /* comment */
+    "two": 30
+}
+`)
+}
+
+func TestRegisterPreset(t *testing.T) {
+	conf, ok := Preset(`package`)
+	eq(t, true, ok)
+	conf.SortDicts[0] = `unknown`
+	conf.KeyOrder[``][0] = `unknown`
+
+	conf, _ = Preset(`package`)
+	eq(t, PackageJSON, conf)
+
+	custom := Default
+	custom.Indent = "\t"
+	RegisterPreset(`Custom`, custom)
+	RegisterFilePreset(`*.custom.json`, `custom`)
+
+	conf, ok = Preset(`custom`)
+	eq(t, true, ok)
+	eq(t, custom, conf)
+	eq(t, true, strings.Contains(strings.Join(PresetNames(), ` `), `custom`))
+
+	name, ok := FilePreset(`one/two.custom.json`)
+	eq(t, true, ok)
+	eq(t, `custom`, name)
+	eq(t, `custom`, FilePresets()[`*.custom.json`])
+}
+
+func TestPreset_lines(t *testing.T) {
+	conf, ok := Preset(`lines`)
+	eq(t, true, ok)
+	eq(t, Lines, conf)
+
+	eqFormat(t, Lines, `{"one": [10, 20], "two": {}, "three": {"four": 40}, "five": [[50]], "six": "seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen"}`, `{
+  "one": [
+    10,
+    20
+  ],
+  "two": {},
+  "three": {"four": 40},
+  "five": [
+    [50]
+  ],
+  "six": "seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen"
+}
+`)
+}
+
+func TestFilePreset(t *testing.T) {
+	test := func(path, exp string) {
+		t.Helper()
+		name, ok := FilePreset(path)
+		eq(t, exp, name)
+		eq(t, exp != ``, ok)
+	}
+
+	test(`package.json`, `package`)
+	test(`/one/two/Package.JSON`, `package`)
+	test(`tsconfig.json`, `tsconfig`)
+	test(`one/tsconfig.build.json`, `tsconfig`)
+	test(`package.json/one.json`, ``)
+	test(`package-lock.json`, ``)
+
+	const src = `{"zeta": 10, "devDependencies": {"zod": "1.0.0", "axios": "2.0.0"}, "files": ["two"], "alpha": {"b": 20, "a": 10}, "name": "one"} // three`
+	eqFormat(t, PackageJSON, src, `{
+  "name": "one",
+  "files": [
+    "two"
+  ],
+  "devDependencies": {
+    "axios": "2.0.0",
+    "zod": "1.0.0"
+  },
+  "alpha": {
+    "b": 20,
+    "a": 10
+  },
+  "zeta": 10
+}
+`)
+}
+
+// Typical JSONC files are already formatted.
+func TestPreset_JSONC(t *testing.T) {
+	for _, src := range []string{`inp_vscode_settings.json`, `inp_vscode_tsconfig.json`} {
+		eqFile(t, src, src, FormatBytes(JSONC, readTestFile(t, src)))
+	}
+
+	eqFormat(t, JSONC, `{"one": 10, // two
+"three": [40, 50,], /* six */
+"seven": {}, "eight": [],}`, `{
+    "one": 10, // two
+    "three": [
+        40,
+        50
+    ], /* six */
+    "seven": {},
+    "eight": []
+}
+`)
+}
+
+func TestParse_inline(t *testing.T) {
+	conf := JSONC
+	doc := Parse(conf, "{\"one\": 10, // two\n\"three\": [40, /* five */\n60]}")
+	dict := doc.Items[0]
+
+	eq(t, []string{`// two`}, dict.Entries[0].Val.Inline)
+	eq(t, []string{`/* five */`}, dict.Entries[1].Val.Items[0].Inline)
+
+	conf.SortKeys = true
+	eqFormat(t, conf, "{\"two\": 20, // two\n\"one\": 10 // one\n}", "{\n    \"one\": 10, // one\n    \"two\": 20 // two\n}\n")
+
+	conf.SortKeys = false
+	conf.CommentsToKey = `$comment`
+	eqFormat(t, conf, "{\"two\": 20, // two\n\"one\": 10 // one\n}", `{
+    "$comment": "two\none",
+    "two": 20,
+    "one": 10
+}
+`)
+}
+
+// The document model must not affect formatting when there's nothing to change.
+func Test_document_roundtrip(t *testing.T) {
+	matches, err := filepath.Glob(testFilePath(`inp_*.json`))
+	try(err)
+
+	for _, path := range matches {
+		src := string(readFile(t, path))
+
+		for _, conf := range []Conf{Default, Compact, JSONC} {
+			eqFormat(t, conf, Parse(conf, src).String(), FormatString(conf, src))
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc := Parse(Default, "// one\n{\"two\" 20 \"three\": [40 /* five */]} 60")
+
+	eq(t, KindTop, doc.Kind)
+	eq(t, 2, len(doc.Items))
+	eq(t, []string{"// one\n"}, doc.Items[0].Comments)
+	eq(t, KindAtom, doc.Items[1].Kind)
+	eq(t, `60`, doc.Items[1].Text)
+
+	dict := doc.Items[0]
+	eq(t, KindDict, dict.Kind)
+	eq(t, 2, len(dict.Entries))
+	eq(t, `"two"`, dict.Entries[0].Key.Text)
+	eq(t, KindString, dict.Entries[0].Key.Kind)
+	eq(t, `20`, dict.Entries[0].Val.Text)
+
+	list := dict.Entries[1].Val
+	eq(t, KindList, list.Kind)
+	eq(t, `40`, list.Items[0].Text)
+	eq(t, []string{`/* five */`}, list.Trailing)
+}
+
+type marshalInner struct {
+	Ten   int            `json:"ten"`
+	Names map[string]int `json:"names"`
+}
+
+type marshalOuter struct {
+	marshalInner
+	Zeta   int                      `json:"zeta"`
+	Alpha  map[string]*marshalInner `json:"alpha"`
+	Items  []map[int]string         `json:"items"`
+	Raw    json.RawMessage          `json:"raw"`
+	Html   string                   `json:"html"`
+	Hidden string                   `json:"-"`
+}
+
+type marshalKey int
+
+func (self marshalKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf(`k%v`, int(self))), nil
+}
+
+func TestMarshal(t *testing.T) {
+	val := marshalOuter{
+		marshalInner: marshalInner{Ten: 10, Names: map[string]int{`b10`: 1, `b9`: 2}},
+		Zeta:         20,
+		Alpha:        map[string]*marshalInner{`x`: {Names: map[string]int{`a10`: 3, `a2`: 4}}},
+		Items:        []map[int]string{{10: `ten`, 9: `nine`}},
+		Raw:          json.RawMessage(`{"z10": 1, "z9": 2}`),
+		Html:         `<a & b>`,
+	}
+
+	conf := Default
+	conf.Width = 200
+	out, err := Marshal[string](conf, val)
+	eq(t, nil, err)
+	eq(t, `{"ten": 10, "names": {"b10": 1, "b9": 2}, "zeta": 20, "alpha": {"x": {"ten": 0, "names": {"a10": 3, "a2": 4}}}, "items": [{"10": "ten", "9": "nine"}], "raw": {"z10": 1, "z9": 2}, "html": "<a & b>"}
+`, out)
+
+	conf.KeySort = KeySortNatural
+	conf.KeyOrder = map[string][]string{``: {`zeta`}}
+	out, err = Marshal[string](conf, val)
+	eq(t, nil, err)
+	eq(t, `{"zeta": 20, "ten": 10, "names": {"b9": 2, "b10": 1}, "alpha": {"x": {"ten": 0, "names": {"a2": 4, "a10": 3}}}, "items": [{"9": "nine", "10": "ten"}], "raw": {"z10": 1, "z9": 2}, "html": "<a & b>"}
+`, out)
+
+	type Inner struct{ Keys map[marshalKey]map[string]int }
+	out, err = Marshal[string](conf, struct{ Inner }{Inner{map[marshalKey]map[string]int{
+		10: {`v10`: 1, `v9`: 2},
+		9:  {},
+	}}})
+	eq(t, nil, err)
+	eq(t, "{\"Keys\": {\"k9\": {}, \"k10\": {\"v9\": 2, \"v10\": 1}}}\n", out)
+
+	_, err = Marshal[[]byte](conf, func() {})
+	eq(t, true, err != nil)
+}
+
+func TestDocsOf(t *testing.T) {
+	const src = `// Settings of the server.
+{
+  // Address to listen on.
+  "server": {
+    "host": "localhost", // Or "0.0.0.0".
+    /* Port | number. */
+    "port": 8080
+  },
+  "tags": ["one", "two"],
+  "users": [
+    // The admin.
+    {"name": "root"}
+  ]
+}`
+
+	docs := DocsOf(Default, src)
+	eq(t, Docs{
+		{``, KindDict, ``, `Settings of the server.`},
+		{`/server`, KindDict, ``, `Address to listen on.`},
+		{`/server/host`, KindString, `"localhost"`, `Or "0.0.0.0".`},
+		{`/server/port`, KindNumber, `8080`, `Port | number.`},
+		{`/tags`, KindList, `["one", "two"]`, ``},
+		{`/users`, KindList, ``, ``},
+		{`/users/0`, KindDict, ``, `The admin.`},
+		{`/users/0/name`, KindString, `"root"`, ``},
+	}, docs)
+
+	documented, total := docs.Coverage()
+	eq(t, 4, documented)
+	eq(t, 7, total)
+
+	eq(t, "Settings of the server.\n\n"+
+		"| Path | Value | Description |\n"+
+		"| --- | --- | --- |\n"+
+		"| `/server` |  | Address to listen on. |\n"+
+		"| `/server/host` | *redacted* | Or \"0.0.0.0\". |\n"+
+		"| `/server/port` | *redacted* | Port \\| number. |\n"+
+		"| `/tags` | *redacted* |  |\n"+
+		"| `/users` |  |  |\n"+
+		"| `/users/0` |  | The admin. |\n"+
+		"| `/users/0/name` | *redacted* |  |\n"+
+		"\nDocumented: 4 of 7 entries (57%).\n",
+		docs.Markdown(true),
+	)
+
+	eq(t, "| Path | Value | Description |\n| --- | --- | --- |\n| `/one` | ``` `` ``` |  |\n\nDocumented: 0 of 1 entries (0%).\n",
+		DocsOf(Default, "{\"one\": ``}").Markdown(false))
+	eq(t, ``, DocsOf(Default, `10`).Markdown(false))
+
+	// Entries don't alias the source.
+	content := []byte("{\"one\": 10 // two\n}")
+	docs = DocsOf(Default, content)
+	for ind := range content {
+		content[ind] = 'X'
+	}
+	eq(t, Docs{{`/one`, KindNumber, `10`, `two`}}, docs)
+}
+
+func TestInferSchema(t *testing.T) {
+	const one = `{"id": 1, "status": "on", "tags": ["one"], "score": 1.5}
+{"id": 2, "status": "on", "tags": [], "score": null}`
+	const two = `{"id": 3, "status": "off", "tags": ["two", 10], "extra": {"ok": true}}`
+
+	eq(t, `// Inferred by jsonfmt from 3 samples.
+{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "id": {"type": "integer"},
+    "status": {"type": "string", "enum": ["on", "off"]},
+    "tags": {"type": "array", "items": {"type": ["string", "integer"]}},
+    // Optional: present in 2 of 3 samples.
+    "score": {"type": ["number", "null"]},
+    // Optional: present in 1 of 3 samples.
+    "extra": {
+      "type": "object",
+      "properties": {"ok": {"type": "boolean"}},
+      "required": ["ok"]
+    }
+  },
+  "required": ["id", "status", "tags"]
+}
+`, InferSchema[string](Default, one, two))
+
+	conf := Default
+	conf.StripComments = true
+	eq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "type": "string"}`+"\n", InferSchema[string](conf, `"one"`))
+	eq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema"}`+"\n", InferSchema[string, string](conf))
+}
+
+func TestParse_pos(t *testing.T) {
+	const src = "// one\n{\"two\": [20, true,\n  null, NaN]} \"three\""
+	doc := Parse(Default, src)
+
+	eq(t, Pos{Offset: 0, Line: 1, Column: 1}, doc.Pos)
+	eq(t, Pos{Offset: 7, Line: 2, Column: 1}, doc.Items[0].Pos)
+	eq(t, Pos{Offset: 8, Line: 2, Column: 2}, doc.Items[0].Entries[0].Key.Pos)
+	eq(t, Pos{Offset: 40, Line: 3, Column: 15}, doc.Items[1].Pos)
+
+	list := doc.Items[0].Entries[0].Val
+	eq(t, Pos{Offset: 15, Line: 2, Column: 9}, list.Pos)
+	eq(t, Pos{Offset: 28, Line: 3, Column: 3}, list.Items[2].Pos)
+	eq(t, PosAt(src, 28), list.Items[2].Pos)
+	eq(t, Pos{Offset: len(src), Line: 3, Column: 22}, PosAt(src, len(src)+10))
+
+	eq(t, []Kind{KindNumber, KindBool, KindNull, KindAtom}, []Kind{
+		list.Items[0].Type(), list.Items[1].Type(), list.Items[2].Type(), list.Items[3].Type(),
+	})
+	eq(t, KindList, list.Type())
+	eq(t, `dict`, KindDict.String())
+	eq(t, `number`, KindNumber.String())
+
+	warnings := Warnings(Default, `{"one": 10, "one": 20}`)
+	eq(t, Pos{Offset: 12, Line: 1, Column: 13}, warnings[0].Pos())
+}
+
+func TestFormat_sort_keys(t *testing.T) {
+	conf := Default
+	conf.SortKeys = true
+
+	eqFormat(t, conf,
+		`{"two": 20, // Comment
+"one": {"b": [{"d": 40, "c": 30}], "a": 10}} {"z": 0, "y": 0}`,
+		`{
+  // Comment
+  "one": {"a": 10, "b": [{"c": 30, "d": 40}]},
+  "two": 20
+}
+{"y": 0, "z": 0}
+`,
+	)
+}
+
+func TestFormat_commentAttach(t *testing.T) {
+	conf := Default
+	conf.Width = 0
+	conf.SortKeys = true
+	src := "{\n\"two\": 20,\n// About two.\n\"one\": 10,\n/* About one. */\n}"
+
+	eqFormat(t, conf, src, "{\n  // About two.\n  \"one\": 10,\n  \"two\": 20\n  /* About one. */\n}\n")
+
+	conf.CommentAttach = CommentAttachPrevious
+	eqFormat(t, conf, src, "{\n  \"one\": 10,\n  /* About one. */\n  \"two\": 20\n  // About two.\n}\n")
+
+	doc := Parse(conf, "[10, // ten\n// Still ten.\n20]")
+	eq(t, []string{"// ten\n", "// Still ten.\n"}, doc.Items[0].Items[0].After)
+	eq(t, []string(nil), doc.Items[0].Items[1].Comments)
+}
+
+func TestNew(t *testing.T) {
+	fmter := New(WithIndent("\t"), WithWidth(0), WithSortKeys(), WithTrailingComma())
+
+	conf := Default
+	conf.Indent = "\t"
+	conf.Width = 0
+	conf.SortKeys = true
+	conf.TrailingComma = true
+	eq(t, conf, fmter.Conf())
+
+	eq(t, "{\n\t\"one\": 10,\n\t\"two\": 20,\n}\n", fmter.FormatString(`{"two": 20, "one": 10}`))
+
+	placeholders := []Placeholder{{`{{`, `}}`}}
+	fmter = New(WithConf(Compact), WithPlaceholders(placeholders...))
+	placeholders[0].Start = `<<`
+	eq(t, []Placeholder{{`{{`, `}}`}}, fmter.Conf().Placeholders)
+
+	conf = Default
+	conf.KeepComments = make([]string, 1, 2)
+	conf.KeepComments[0] = `//!`
+	conf.Overrides = []Override{{`/one`, json.RawMessage(`{"width": 0}`)}}
+	fmter = New(WithConf(conf), WithKeepComments(`// KEEP:`))
+	conf.Overrides[0].Conf[1] = ' '
+	eq(t, []string{`//!`}, conf.KeepComments)
+	eq(t, ``, conf.KeepComments[:2][1])
+	eq(t, []string{`//!`, `// KEEP:`}, fmter.Conf().KeepComments)
+	eq(t, []Override{{`/one`, json.RawMessage(`{"width": 0}`)}}, fmter.Conf().Overrides)
+}
+
+func TestMerge(t *testing.T) {
+	const base = `{
+  // Server settings.
+  "server": {"host": "localhost", "port": 8080},
+  // Enabled features.
+  "features": ["one", "two"],
+  "debug": false
+}`
+
+	const override = `{
+  "server": {"port": 9090}, // Production port.
+  "features": ["three"],
+  // Enabled in production.
+  "metrics": true
+}`
+
+	conf := Default
+	conf.TrailingComments = true
+
+	eq(t, `{
+  // Server settings.
+  "server": {"host": "localhost", "port": 9090}, // Production port.
+  // Enabled features.
+  "features": ["three"],
+  "debug": false,
+  // Enabled in production.
+  "metrics": true
+}
+`, Merge[string](conf, ListReplace, base, override))
+
+	eq(t, `{"list":[10,20,30,40],"dict":{"one":10,"two":20}}`, Merge[string](Compact, ListAppend,
+		`{"list": [10, 20], "dict": {"one": 10}}`,
+		`{"list": [30, 40], "dict": {"two": 20}}`,
+	))
+
+	eq(t, `{"list":[{"one":10,"two":20},40,50]}`, Merge[string](Compact, ListByIndex,
+		`{"list": [{"one": 10}, 30, 50]}`,
+		`{"list": [{"two": 20}, 40]}`,
+	))
+
+	eq(t, `{"one":30}`, Merge[string](Compact, ListReplace, `{"one": 10}`, `{"one": 20}`, `{"one": 30}`))
+	eq(t, ``, Merge[string, string](Compact, ListReplace))
+}
+
+func TestMerge3(t *testing.T) {
+	const base = `{
+  // Server.
+  "server": {"host": "localhost", "port": 8080},
+  "tags": ["one"],
+  "name": "one",
+  "debug": false
+}`
+
+	const ours = `{
+  "name": "one",
+  // Server.
+  "server": {"host": "localhost", "port": 9090},
+  "tags": ["one", "two"],
+  "debug": true,
+  "extra": 10
+}`
+
+	const theirs = `{
+  // Server settings.
+  "server": {"host": "example.com", "port": 8080},
+  "tags": ["one", "three"],
+  "name": "two",
+  "debug": 1
+}`
+
+	test := func(conf Conf, base, ours, theirs, exp string, expOk bool) {
+		t.Helper()
+		out, ok := Merge3[string](conf, base, ours, theirs)
+		eq(t, exp, out)
+		eq(t, expOk, ok)
+	}
+
+	test(Default, base, ours, theirs, `{
+  "name": "two",
+  // Server settings.
+  "server": {"host": "example.com", "port": 9090},
+  "tags": ["one", "two", "three"],
+<<<<<<< ours
+  "debug": true,
+=======
+  "debug": 1,
+>>>>>>> theirs
+  "extra": 10
+}
+`, false)
+
+	test(Compact, `{"one": 10, "two": 20}`, `{"two": 20, "one": 10}`, `{"one": 10, "two": 30}`, `{"two":30,"one":10}`, true)
+	test(Compact, `[10, 20]`, `[10, 20, 30]`, `[10, 20, 30, 40]`, `[10,20,30,40]`, true)
+
+	test(Default, `[10, 20]`, `[10, 30]`, `[10, 40]`, `<<<<<<< ours
+[10, 30]
+=======
+[10, 40]
+>>>>>>> theirs
+`, false)
+
+	test(Default, `{"one": 10, "two": 20}`, `{"two": 20}`, `{"one": 30, "two": 20}`, `{
+  "two": 20,
+<<<<<<< ours
+=======
+  "one": 30
+>>>>>>> theirs
+}
+`, false)
+}
+
+func TestNewReader(t *testing.T) {
+	const src = `// head
+{"one": 10, "two": [20, 30]} /* after */ "three"
+[40, {"five": "six"}] 70
+// tail`
+
+	test := func(conf Conf) {
+		t.Helper()
+		exp := FormatString(conf, src)
+
+		out, err := io.ReadAll(NewReader(conf, iotest.OneByteReader(strings.NewReader(src))))
+		try(err)
+		eq(t, exp, string(out))
+
+		out, err = io.ReadAll(NewReader(conf, iotest.HalfReader(strings.NewReader(src))))
+		try(err)
+		eq(t, exp, string(out))
+
+		try(iotest.TestReader(NewReader(conf, strings.NewReader(src)), []byte(exp)))
+	}
+
+	test(Default)
+	test(Compact)
+	test(JSON5)
+
+	conf := Default
+	conf.Separator = SeparatorBlank
+	test(conf)
+
+	conf.Separator = SeparatorRS
+	test(conf)
+
+	conf.Separator = SeparatorArray
+	test(conf)
+
+	conf = Default
+	conf.SortKeys = true
+	test(conf)
+
+	conf = Default
+	conf.Repair = true
+	test(conf)
+
+	conf = Default
+	conf.MaxDepth = 1
+	_, err := io.ReadAll(NewReader(conf, strings.NewReader(src)))
+	eq(t, ErrMaxDepth, err)
+
+	_, err = io.ReadAll(NewReader(Default, iotest.ErrReader(context.Canceled)))
+	eq(t, context.Canceled, err)
+}
+
+func TestNewWriter(t *testing.T) {
+	const src = `// head
+{"one": 10, "two": [20, 30]} /* after */ "three"
+[40, {"five": "six"}] 70
+// tail`
+
+	test := func(conf Conf, size int) {
+		t.Helper()
+		var buf bytes.Buffer
+		out := NewWriter(conf, &buf)
+
+		for rest := src; len(rest) > 0; {
+			chunk := rest[:size%len(rest)+1]
+			rest = rest[len(chunk):]
+			count, err := io.WriteString(out, chunk)
+			try(err)
+			eq(t, len(chunk), count)
+		}
+		try(out.Close())
+		eq(t, FormatString(conf, src), buf.String())
+	}
+
+	for _, size := range []int{0, 2, 7, 1 << 10} {
+		test(Default, size)
+		test(Compact, size)
+
+		conf := Default
+		conf.Separator = SeparatorRS
+		test(conf, size)
+
+		conf.Separator = SeparatorArray
+		test(conf, size)
+	}
+
+	// Complete values are written before the end.
+	var buf bytes.Buffer
+	out := NewWriter(Compact, &buf)
+	_, err := io.WriteString(out, `{"one": 10} {"two"`)
+	try(err)
+	eq(t, `{"one":10}`, buf.String())
+
+	try(out.Close())
+	eq(t, FormatString(Compact, `{"one": 10} {"two"`), buf.String())
+
+	_, err = io.WriteString(out, `10`)
+	eq(t, true, err != nil)
+
+	conf := Default
+	conf.MaxDepth = 1
+	out = NewWriter(conf, &buf)
+	_, err = io.WriteString(out, `[[10]] `)
+	eq(t, ErrMaxDepth, err)
+	eq(t, ErrMaxDepth, out.Close())
+}
+
+func TestRecords(t *testing.T) {
+	test := func(src string, expElem bool, exp ...string) {
+		t.Helper()
+		var act []string
+		Records(Default, src, func(rec string, elem bool) bool {
+			eq(t, expElem, elem)
+			act = append(act, rec)
+			return true
+		})
+		eq(t, exp, act)
+	}
+
+	test(`[10, {"one": [20]}, "three",]`, true, `10`, `{"one": [20]}`, `"three"`)
+	test("// one\n[/* two */ 10 // three\n 20] // four", true, `/* two */ 10`, "// three\n 20")
+	test("{\"one\": 10}\n[20]\n\"three\"\n", false, `{"one": 10}`, `[20]`, `"three"`)
+	test(`{"one": [10, 20]}`, false, `{"one": [10, 20]}`)
+	test(`[10] [20]`, false, `[10]`, `[20]`)
+	test(`[]`, true)
+	test(``, false)
+
+	var count int
+	Records(Default, `[10, 20, 30]`, func(string, bool) bool {
+		count++
+		return count < 2
+	})
+	eq(t, 2, count)
+}
+
+// Results must not expose or alias memory of the caller after returning.
+func TestText_aliasing(t *testing.T) {
+	src := []byte(`[10, 20]`)
+	Records(Default, src, func(rec []byte, _ bool) bool {
+		_ = append(rec, `30`...)
+		return false
+	})
+	eq(t, `[10, 20]`, string(src))
+
+	merged, _ := Merge3[[]byte](Default, `{"one": 10}`, `{"one": 10}`, `{"one": 20}`)
+	merged = append(merged[:len(merged)-1], ` // two`...)
+	eq(t, `{"one": 20} // two`, string(merged))
+
+	src = []byte(`{"one": ture}`)
+	doc := Parse(Default, src)
+	conf := Default
+	conf.Atoms = StrictnessStrict
+	err := FormatTo(conf, io.Discard, src)
+	copy(src, `{"two": fals}`)
+
+	eq(t, `"one"`, doc.Items[0].Entries[0].Key.Text)
+	eq(t, `ture`, doc.Items[0].Entries[0].Val.Text)
+	eq(t, `ture`, err.(*AtomError).Text)
+}
+
+func TestParseConf(t *testing.T) {
+	conf, err := ParseConf(`indent=4, width=100,trailing-comma,sortKeys=false,placeholders={{ }}|${ },max-output-bytes=1024,max-depth=100,workers=4,layout-version=1`)
+	try(err)
+
+	exp := Default
+	exp.LayoutVersion = 1
+	exp.Indent = `    `
+	exp.Width = 100
+	exp.MaxOutputBytes = 1024
+	exp.MaxDepth = 100
+	exp.Workers = 4
+	exp.TrailingComma = true
+	exp.Placeholders = []Placeholder{{`{{`, `}}`}, {`${`, `}`}}
+	eq(t, exp, conf)
+
+	conf, err = ParseConf(`preset=jsonc,indent=tab`)
+	try(err)
+
+	exp = JSONC
+	exp.Indent = "\t"
+	eq(t, exp, conf)
+
+	conf, err = ParseConf(`separator=RS,atoms=strict`)
+	try(err)
+
+	exp = Default
+	exp.Separator = SeparatorRS
+	exp.Atoms = StrictnessStrict
+	eq(t, exp, conf)
+
+	conf, err = ParseConf(`preset=package,sort-dicts=/one|/two`)
+	try(err)
+
+	exp = PackageJSON
+	exp.SortDicts = []string{`/one`, `/two`}
+	eq(t, exp, conf)
+
+	conf, err = ParseConf(`line-ending=CRLF`)
+	try(err)
+
+	exp = Default
+	exp.LineEnding = LineEndingCRLF
+	eq(t, exp, conf)
+
+	_, err = ParseConf(`separator=comma`)
+	eq(t, true, err != nil)
+
+	conf, err = ParseConf(`colon=:`)
+	try(err)
+	eq(t, `:`, conf.Colon)
+
+	try(conf.Set(`comma`, ` , `))
+	eq(t, ` , `, conf.Comma)
+
+	try(conf.Set(`key-sort`, `Natural`))
+	eq(t, KeySortNatural, conf.KeySort)
+	eq(t, true, conf.Set(`key-sort`, `random`) != nil)
+
+	try(conf.Set(`key-order`, `:one two|/three:four`))
+	eq(t, map[string][]string{``: {`one`, `two`}, `/three`: {`four`}}, conf.KeyOrder)
+	eq(t, true, conf.Set(`key-order`, `one`) != nil)
+
+	try(conf.Set(`sort-lists`, `/one|/two/*`))
+	eq(t, []string{`/one`, `/two/*`}, conf.SortLists)
+	eq(t, true, conf.Set(`sort-lists`, `one`) != nil)
+
+	try(conf.Set(`keep-comments`, `//! | // KEEP:|`))
+	eq(t, []string{`//!`, `// KEEP:`}, conf.KeepComments)
+
+	try(conf.Set(`keep-regexp`, `^/\* @`))
+	eq(t, `^/\* @`, conf.KeepRegexp)
+	eq(t, true, conf.Set(`keep-regexp`, `(`) != nil)
+
+	try(conf.Set(`comment-attach`, `Previous`))
+	eq(t, CommentAttachPrevious, conf.CommentAttach)
+	try(conf.Set(`comment-attach`, `next`))
+	eq(t, CommentAttachNext, conf.CommentAttach)
+	eq(t, true, conf.Set(`comment-attach`, `after`) != nil)
+
+	try(conf.Set(`closing-comments`, `hoist`))
+	eq(t, ClosingCommentsHoist, conf.ClosingComments)
+	eq(t, true, conf.Set(`closing-comments`, `below`) != nil)
+
+	eq(t, true, conf.Set(`colon`, `::`) != nil)
+	eq(t, true, conf.Set(`colon`, `=`) != nil)
+
+	_, err = ParseConf(`unknown=10`)
+	eq(t, true, err != nil)
+
+	_, err = ParseConf(`width=wide`)
+	eq(t, true, err != nil)
+}
+
+func TestConf_LoadEnv(t *testing.T) {
+	t.Setenv(`JSONFMT_WIDTH`, `0`)
+	t.Setenv(`JSONFMT_PRESET`, `tabs`)
+	t.Setenv(`JSONFMT_STRIP_COMMENTS`, `true`)
+	t.Setenv(`JSONFMT_TOKEN`, `abc`)
+
+	var conf Conf
+	try(conf.LoadEnv())
+
+	exp := Tabs
+	exp.Width = 0
+	exp.StripComments = true
+	eq(t, exp, conf)
+}
+
+func TestUnmarshal(t *testing.T) {
+	type TarGlobal struct {
+		CheckForUpdatesOnStartup bool `json:"check_for_updates_on_startup"`
+		ShowInMenuBar            bool `json:"show_in_menu_bar"`
+		ShowProfileNameInMenuBar bool `json:"show_profile_name_in_menu_bar"`
+	}
+
+	type TarProfile struct {
+		// Fields elided for simplicity.
+	}
+
+	type Tar struct {
+		Global   TarGlobal    `json:"global"`
+		Profiles []TarProfile `json:"profiles"`
+	}
+
+	var tar Tar
+	try(Unmarshal(readTestFile(t, `inp_short_nopunc.json`), &tar))
+
+	eq(t, tar, Tar{
+		Global:   TarGlobal{CheckForUpdatesOnStartup: true},
+		Profiles: []TarProfile{{}},
+	})
+}
+
+func TestUnmarshal_comments(t *testing.T) {
+	var tar map[string]int
+	try(Unmarshal("{\n  // comment\n  \"one\": 10, /* comment */ \"two\": 20,\n}", &tar))
+	eq(t, map[string]int{`one`: 10, `two`: 20}, tar)
+}
+
+func TestUnmarshal_position(t *testing.T) {
+	type Tar struct {
+		One int    `json:"one"`
+		Two string `json:"two"`
+	}
+
+	test := func(src string, line, col int) {
+		t.Helper()
+
+		var tar Tar
+		err := Unmarshal(src, &tar)
+
+		var pos *UnmarshalError
+		if !errors.As(err, &pos) {
+			t.Fatalf(`expected *UnmarshalError, got %#v`, err)
+		}
+		eq(t, [2]int{line, col}, [2]int{pos.Line, pos.Column})
+	}
+
+	test("{\n  // comment\n  \"one\": 10,\n  \"two\":   20\n}", 4, 13)
+	test("{\n  /* comment */ \"one\": \"str\"\n}", 2, 28)
+	test("{\n  \"one\" 10\n  \"two\" x\n}", 3, 9)
+
+	var tar Tar
+	var syntax *json.SyntaxError
+	if !errors.As(Unmarshal(`{"one" 10 "two" x}`, &tar), &syntax) {
+		t.Fatalf(`expected the error to wrap *json.SyntaxError`)
+	}
+}
+
+func TestUnmarshalSingle(t *testing.T) {
+	test := func(src string, exp any) {
+		t.Helper()
+		var tar any
+		try(UnmarshalSingle(src, &tar))
+		eq(t, exp, tar)
+	}
+
+	fail := func(src string, line, col int) {
+		t.Helper()
+		var tar any
+		err := UnmarshalSingle(src, &tar)
+
+		var pos *UnmarshalError
+		if !errors.As(err, &pos) || !errors.Is(err, ErrLeftover) {
+			t.Fatalf(`expected *UnmarshalError wrapping ErrLeftover, got %#v`, err)
+		}
+		eq(t, [2]int{line, col}, [2]int{pos.Line, pos.Column})
+	}
+
+	test(`10`, float64(10))
+	test(`{"one" 10}, // comment`, map[string]any{`one`: float64(10)})
+	test("// comment\n[10, 20,] /* comment */\n", []any{float64(10), float64(20)})
+
+	fail(`10 20`, 1, 4)
+	fail("[10]\n]", 2, 1)
+	fail("{\"one\": 10}\n// comment\n{\"two\": 20}", 3, 1)
+}
+
+func TestFormatAll(t *testing.T) {
+	srcs := [][]byte{
+		[]byte(`{"one" 10}`),
+		[]byte(`[10 20 // comment
+]`),
+		nil,
+		[]byte(`{"three": [30, 40], "four": {"five": 50}}`),
+		[]byte(`"six"`),
+	}
+	conf := Default
+	conf.Width = 16
+	conf.TrailingComma = true
+
+	for _, workers := range []uint64{0, 2, 3, 10} {
+		conf.Workers = workers
+		out := FormatAll(conf, srcs)
+		eq(t, len(srcs), len(out))
+		for ind, src := range srcs {
+			eq(t, FormatString(conf, src), string(out[ind]))
+		}
+	}
+
+	// Outputs don't overlap.
+	out := FormatAll(conf, srcs)
+	out[0] = append(out[0], `garbage`...)
+	eq(t, FormatString(conf, srcs[1]), string(out[1]))
+
+	eq(t, 0, len(FormatAll(conf, nil)))
+
+	conf.MaxOutputBytes = 20
+	conf.Workers = 2
+	defer func() { eq(t, ErrMaxOutputBytes, recover()) }()
+	FormatAll(conf, srcs)
+	t.Fatalf(`expected a panic`)
+}
+
+func TestFormatFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		`one.json`:         {Data: []byte(`{"one" 10}`)},
+		`two.txt`:          {Data: []byte(`[two]`)},
+		`three/three.json`: {Data: []byte(`[30,]`)},
+	}
+
+	isJson := func(path string) bool { return filepath.Ext(path) == `.json` }
+
+	files, err := FormatFS(Default, fsys, isJson)
+	try(err)
+
+	eq(t, map[string][]byte{
+		`one.json`:         []byte("{\"one\": 10}\n"),
+		`three/three.json`: []byte("[30]\n"),
+	}, files)
+
+	conf := Default
+	conf.MaxDepth = 2
+	fsys[`four.json`] = &fstest.MapFile{Data: []byte(`[[[40]]]`)}
+	_, err = FormatFS(conf, fsys, isJson)
+	eq(t, true, errors.Is(err, ErrMaxDepth))
+	eq(t, true, strings.Contains(err.Error(), `"four.json"`))
+}
+
+func TestWriteDir(t *testing.T) {
+	dir := t.TempDir()
+	try(os.Mkdir(filepath.Join(dir, `sub`), os.ModePerm))
+
+	files, err := FormatFS(Default, fstest.MapFS{
+		`one.json`:     {Data: []byte(`{"one" 10}`)},
+		`sub/two.json`: {Data: []byte(`[20,]`)},
+	}, nil)
+	try(err)
+	try(WriteDir(dir, files))
+
+	eq(t, "{\"one\": 10}\n", string(readFile(t, filepath.Join(dir, `one.json`))))
+	eq(t, "[20]\n", string(readFile(t, filepath.Join(dir, `sub`, `two.json`))))
+}
+
+func eq(t testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		t.Fatalf(`
+expected (detailed):
+	%#[1]v
+actual (detailed):
+	%#[2]v
+expected (simple):
+	%[1]v
+actual (simple):
+	%[2]v
+`, exp, act)
+	}
+}
+
+func eqFormat(t testing.TB, conf Conf, input string, expected string) {
+	fmted := FormatString(conf, input)
+	if expected == fmted {
+		return
+	}
+
+	t.Fatalf(strings.TrimSpace(`
+format mismatch
+input:           %q
+expected output: %q
+actual output:   %q
+`), input, expected, fmted)
+}
+
+func eqFile(t testing.TB, pathSrc string, pathExpected string, fmtedContent []byte) {
+	expectedContent := readTestFile(t, pathExpected)
+
+	if bytes.Equal(expectedContent, fmtedContent) {
+		return
+	}
+
+	pathFmted := appendToName(pathExpected, FMTED_SUFFIX)
+	writeTestFile(t, pathFmted, fmtedContent)
+
+	t.Fatalf(strings.TrimSpace(`
+format mismatch
+source:          %q
+expected output: %q
+actual output:   %q
+`),
+		testFilePath(pathSrc),
+		testFilePath(pathExpected),
+		testFilePath(pathFmted))
+}
+
+func deleteTestFiles(pattern string) error {
+	matches, err := filepath.Glob(testFilePath(pattern))
+	if err != nil {
+		panic(fmt.Errorf(`failed to find files by pattern %q: %w`, pattern, err))
+	}
+
+	for _, path := range matches {
+		err := os.Remove(path)
+		if err != nil {
+			panic(fmt.Errorf(`failed to delete %q: %w`, path, err))
+		}
+	}
+
+	return nil
+}
+
+func readTestFile(t testing.TB, name string) []byte {
+	return readFile(t, testFilePath(name))
+}
+
+func readFile(t testing.TB, path string) []byte {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		t.Fatalf(`failed to read test file at %q: %+v`, path, err)
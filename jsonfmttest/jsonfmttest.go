@@ -0,0 +1,152 @@
+/*
+Helpers for testing the output of jsonfmt in other projects, via expected
+strings or "golden" files, like the tests of jsonfmt itself.
+
+On a mismatch, `EqFile` writes the actual output next to the expected file,
+with `FmtedSuffix` before the extension, such as "out_fmted.json", so it can be
+diffed or copied over the expected file. Use `Clean` in `TestMain` to delete
+such files:
+
+	func TestMain(m *testing.M) {
+		jsonfmttest.Clean(`testdata`)
+		code := m.Run()
+		if code == 0 {
+			jsonfmttest.Clean(`testdata`)
+		}
+		os.Exit(code)
+	}
+
+	func TestConfig(t *testing.T) {
+		jsonfmttest.EqFile(t, jsonfmt.Default, `testdata/inp.json`, `testdata/out.json`)
+	}
+*/
+package jsonfmttest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+// Inserted before the extension of files with actual output. See `EqFile`.
+const FmtedSuffix = `_fmted`
+
+/*
+Formats the source and fails the test if the output doesn't match the expected
+string. Also fails if formatting the output again changes it, see `Stable`.
+*/
+func Eq(t testing.TB, conf jsonfmt.Conf, src string, exp string) {
+	t.Helper()
+
+	act := jsonfmt.FormatString(conf, src)
+	if act != exp {
+		t.Fatalf(strings.TrimSpace(`
+format mismatch
+input:           %q
+expected output: %q
+actual output:   %q
+`), src, exp, act)
+	}
+	Stable(t, conf, act)
+}
+
+/*
+Formats the source file and fails the test if the output doesn't match the
+expected file, after writing the output to a file next to the expected one, see
+`FmtedPath`. A missing expected file is a mismatch, which makes it easy to add
+new cases: run the test and rename the file with the output. Also fails if
+formatting the output again changes it, see `Stable`.
+*/
+func EqFile(t testing.TB, conf jsonfmt.Conf, pathSrc string, pathExp string) {
+	t.Helper()
+
+	src := readFile(t, pathSrc)
+	act := jsonfmt.FormatBytes(conf, src)
+
+	exp, err := os.ReadFile(pathExp)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf(`failed to read %q: %+v`, pathExp, err)
+	}
+
+	if err != nil || !bytes.Equal(exp, act) {
+		pathAct := FmtedPath(pathExp)
+		writeFile(t, pathAct, act)
+
+		t.Fatalf(strings.TrimSpace(`
+format mismatch
+source:          %q
+expected output: %q
+actual output:   %q
+`), pathSrc, pathExp, pathAct)
+	}
+	Stable(t, conf, string(act))
+}
+
+/*
+Fails the test if formatting the source changes it, which is expected of any
+formatted output. Useful for checking files which are already formatted, such
+as config files checked into a repository.
+*/
+func Stable(t testing.TB, conf jsonfmt.Conf, src string) {
+	t.Helper()
+
+	act := jsonfmt.FormatString(conf, src)
+	if act != src {
+		t.Fatalf(strings.TrimSpace(`
+unstable format
+input:  %q
+output: %q
+`), src, act)
+	}
+}
+
+// Path of the file with the actual output for the given expected file.
+func FmtedPath(path string) string {
+	dir, file := filepath.Split(path)
+	ext := filepath.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+
+	// Dotfiles such as ".eslintrc" have no extension.
+	if base == `` {
+		return path + FmtedSuffix
+	}
+	return dir + base + FmtedSuffix + ext
+}
+
+// Deletes files with actual output written by `EqFile` in the given directory.
+func Clean(dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, `*`+FmtedSuffix+`*`))
+	if err != nil {
+		return fmt.Errorf(`[jsonfmt] failed to find files in %q: %w`, dir, err)
+	}
+
+	for _, path := range matches {
+		err := os.Remove(path)
+		if err != nil {
+			return fmt.Errorf(`[jsonfmt] failed to delete %q: %w`, path, err)
+		}
+	}
+	return nil
+}
+
+func readFile(t testing.TB, path string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(`failed to read %q: %+v`, path, err)
+	}
+	return content
+}
+
+func writeFile(t testing.TB, path string, content []byte) {
+	t.Helper()
+	err := os.WriteFile(path, content, os.ModePerm)
+	if err != nil {
+		t.Fatalf(`failed to write %q: %+v`, path, err)
+	}
+}
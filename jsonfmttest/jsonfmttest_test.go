@@ -0,0 +1,99 @@
+package jsonfmttest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+func TestEq(t *testing.T) {
+	Eq(t, jsonfmt.Default, `{"one":[10,20]}`, "{\"one\": [10, 20]}\n")
+}
+
+func TestEqFile(t *testing.T) {
+	const vscode = `../testdata/inp_vscode_settings.json`
+	EqFile(t, jsonfmt.JSONC, vscode, vscode)
+
+	dir := t.TempDir()
+	pathSrc := filepath.Join(dir, `inp.json`)
+	pathExp := filepath.Join(dir, `out.json`)
+	try(t, os.WriteFile(pathSrc, []byte(`{"one":10}`), os.ModePerm))
+
+	// The expected file is missing: the output is written next to it.
+	if !fails(func(t testing.TB) { EqFile(t, jsonfmt.Default, pathSrc, pathExp) }) {
+		t.Fatalf(`expected a mismatch for a missing file`)
+	}
+
+	act, err := os.ReadFile(FmtedPath(pathExp))
+	try(t, err)
+	if string(act) != "{\"one\": 10}\n" {
+		t.Fatalf(`unexpected output: %q`, act)
+	}
+
+	try(t, Clean(dir))
+	_, err = os.Stat(FmtedPath(pathExp))
+	if !os.IsNotExist(err) {
+		t.Fatalf(`expected the output to be deleted, got %+v`, err)
+	}
+}
+
+func TestStable(t *testing.T) {
+	Stable(t, jsonfmt.Default, "{\"one\": 10}\n")
+
+	if !fails(func(t testing.TB) { Stable(t, jsonfmt.Default, `{"one":10}`) }) {
+		t.Fatalf(`expected unformatted input to be unstable`)
+	}
+}
+
+func TestFmtedPath(t *testing.T) {
+	eq(t, `testdata/out_fmted.json`, FmtedPath(`testdata/out.json`))
+	eq(t, `testdata/out_fmted`, FmtedPath(`testdata/out`))
+	eq(t, `testdata/.eslintrc_fmted`, FmtedPath(`testdata/.eslintrc`))
+}
+
+/*
+Runs the function with a fake test which records failures. `testing.TB` can't
+be implemented outside the standard library, so this embeds a real one and
+overrides the methods used by this package.
+*/
+func fails(fun func(testing.TB)) (failed bool) {
+	tb := &fakeTB{}
+	done := make(chan struct{})
+
+	// `Fatalf` stops the goroutine, like `testing.T.FailNow`.
+	go func() {
+		defer close(done)
+		fun(tb)
+	}()
+	<-done
+	return tb.failed
+}
+
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (*fakeTB) Helper() {}
+
+func (self *fakeTB) Fatalf(string, ...interface{}) {
+	self.failed = true
+	runtime.Goexit()
+}
+
+func eq(t testing.TB, exp, act string) {
+	t.Helper()
+	if exp != act {
+		t.Fatalf("expected: %q\nactual:   %q", exp, act)
+	}
+}
+
+func try(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf(`%+v`, err)
+	}
+}
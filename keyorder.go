@@ -0,0 +1,173 @@
+package jsonfmt
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+Returns the order of keys in the given reference document, such as a golden
+config file, for use as `Conf.KeyOrder`. Dicts are recorded under their paths.
+Items of lists share the path segment "*", and their keys are combined in
+order of appearance.
+*/
+func KeyOrderOf[Src Text](conf Conf, src Src) map[string][]string {
+	out := map[string][]string{}
+	Parse(conf, src).keyOrder(out, ``)
+	return out
+}
+
+/*
+Returns the order of keys matching the fields of the given type, which should
+be a struct or a pointer, list or map of structs, for use as `Conf.KeyOrder`.
+Field names are taken from "json" tags like in "encoding/json", and fields of
+embedded structs are inlined.
+
+	conf.KeyOrder = jsonfmt.KeyOrderOfType(reflect.TypeOf(Config{}))
+*/
+func KeyOrderOfType(typ reflect.Type) map[string][]string {
+	out := map[string][]string{}
+	typeKeyOrder(out, ``, typ, map[reflect.Type]bool{})
+	return out
+}
+
+func (self *Node) keyOrder(out map[string][]string, path string) {
+	switch self.Kind {
+	case KindTop:
+		for _, val := range self.Items {
+			val.keyOrder(out, path)
+		}
+
+	case KindDict:
+		for _, val := range self.Entries {
+			key := val.Key.keyText()
+			if !hasString(out[path], key) {
+				out[path] = append(out[path], key)
+			}
+			if val.Val != nil {
+				val.Val.keyOrder(out, path+`/`+escapePath(key))
+			}
+		}
+
+	case KindList:
+		for _, val := range self.Items {
+			val.keyOrder(out, path+`/*`)
+		}
+	}
+}
+
+func typeKeyOrder(out map[string][]string, path string, typ reflect.Type, visiting map[reflect.Type]bool) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		typeKeyOrder(out, path+`/*`, typ.Elem(), visiting)
+
+	case reflect.Struct:
+		if visiting[typ] {
+			return
+		}
+		visiting[typ] = true
+		defer delete(visiting, typ)
+
+		keys := fieldKeys(out, path, typ, visiting)
+		if len(keys) > 0 {
+			out[path] = keys
+		}
+	}
+}
+
+// Keys of the fields of the struct type, including embedded fields.
+func fieldKeys(out map[string][]string, path string, typ reflect.Type, visiting map[reflect.Type]bool) []string {
+	var keys []string
+
+	for ind := 0; ind < typ.NumField(); ind++ {
+		field := typ.Field(ind)
+		tag := field.Tag.Get(`json`)
+		if tag == `-` {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, `,`)
+
+		if field.Anonymous && name == `` {
+			inner := field.Type
+			for inner.Kind() == reflect.Pointer {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				keys = append(keys, fieldKeys(out, path, inner, visiting)...)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if name == `` {
+			name = field.Name
+		}
+
+		keys = append(keys, name)
+		typeKeyOrder(out, path+`/`+escapePath(name), field.Type, visiting)
+	}
+	return keys
+}
+
+/*
+Orders dict entries by their position in the given keys. Unlisted keys follow
+in their previous order. The sort is stable.
+*/
+func (self *Node) orderKeys(keys []string) {
+	if self.Kind != KindDict {
+		return
+	}
+
+	ranks := make(map[string]int, len(keys))
+	for ind, val := range keys {
+		if _, ok := ranks[val]; !ok {
+			ranks[val] = ind
+		}
+	}
+
+	rank := func(val *Node) int {
+		ind, ok := ranks[val.keyText()]
+		if !ok {
+			return len(keys)
+		}
+		return ind
+	}
+
+	sort.SliceStable(self.Entries, func(one, two int) bool {
+		return rank(self.Entries[one].Key) < rank(self.Entries[two].Key)
+	})
+}
+
+// Applies `Conf.KeyOrder` in lexical order of paths, for determinism.
+func (self *Node) orderKeysAt(order map[string][]string) {
+	paths := make([]string, 0, len(order))
+	for path := range order {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		segments, err := pathSegments(path)
+		if err != nil {
+			continue
+		}
+		keys := order[path]
+		self.eachAt(segments, func(node *Node) { node.orderKeys(keys) })
+	}
+}
+
+func hasString(list []string, val string) bool {
+	for _, elem := range list {
+		if elem == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Line wider than `Conf.Width`, found by `LongLines`. Width counts characters,
+like the formatter.
+*/
+type LongLine struct {
+	// Position of the first character past the width.
+	Pos   Pos
+	Width int
+}
+
+func (self LongLine) String() string {
+	return fmt.Sprintf(`line %v, column %v: line is %v characters wide`, self.Pos.Line, self.Pos.Column, self.Width)
+}
+
+/*
+Returns the lines of the text wider than `Conf.Width`, in order, or nil when
+the width is 0. Meant for formatted output, where such lines contain content
+which the formatter can't break, such as long strings, atoms and comments, so
+that CI can flag them. Unlike `WarningWidth`, which predicts such values from
+the source, this measures the actual text, and doesn't parse it.
+*/
+func LongLines[Src Text](conf Conf, src Src) []LongLine {
+	return longLines(conf, text[string](src))
+}
+
+func longLines(conf Conf, src string) (out []LongLine) {
+	if conf.Width == 0 {
+		return nil
+	}
+
+	limit := int(conf.Width)
+	offset := 0
+
+	for row := 1; offset < len(src); row++ {
+		end := strings.IndexByte(src[offset:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += offset
+		}
+
+		line := strings.TrimSuffix(src[offset:end], "\r")
+		width := utf8.RuneCountInString(line)
+		if width > limit {
+			col := 0
+			for ind := range line {
+				if col == limit {
+					out = append(out, LongLine{
+						Pos:   Pos{Offset: offset + ind, Line: row, Column: ind + 1},
+						Width: width,
+					})
+					break
+				}
+				col++
+			}
+		}
+		offset = end + 1
+	}
+	return
+}
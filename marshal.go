@@ -0,0 +1,178 @@
+package jsonfmt
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+Encodes the value via "encoding/json", without escaping HTML characters such
+as "<", and formats the result according to the config, for generating config
+files which are reproducible across runs and Go versions. Keys of Go maps are
+sorted via `Conf.KeySort`, rather than only bytewise like in "encoding/json",
+while fields of structs keep their order.
+Formatting then applies other ordering rules, such as `Conf.KeyOrder` and
+`Conf.SortDicts`, to dicts from both maps and structs. Values which implement
+`json.Marshaler` are left as they encode themselves.
+
+Unlike `Format`, returns errors instead of panicking.
+*/
+func Marshal[Out Text](conf Conf, val any) (_ Out, err error) {
+	defer recoverErr(&err)
+
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(val)
+	if err != nil {
+		return
+	}
+
+	doc := parse(Default, buf.String())
+	if len(doc.Items) == 1 {
+		sortMaps(reflect.ValueOf(val), doc.Items[0], conf.KeySort)
+	}
+	return text[Out](format(nil, conf, nil, doc.String())), nil
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+/*
+Walks the Go value and the node it was encoded into, sorting the entries of
+dicts encoded from maps. Stops where they don't match.
+*/
+func sortMaps(val reflect.Value, node *Node, order KeySort) {
+	for val.IsValid() && (val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface) {
+		if val.IsNil() || isMarshaler(val) {
+			return
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() || isMarshaler(val) {
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.Map:
+		if node.Kind != KindDict {
+			return
+		}
+		node.sortEntries(order)
+
+		vals := map[string]reflect.Value{}
+		iter := val.MapRange()
+		for iter.Next() {
+			key, ok := mapKey(iter.Key())
+			if ok {
+				vals[key] = iter.Value()
+			}
+		}
+		for _, entry := range node.Entries {
+			if entry.Val != nil {
+				sortMaps(vals[entry.Key.keyText()], entry.Val, order)
+			}
+		}
+
+	case reflect.Struct:
+		if node.Kind != KindDict {
+			return
+		}
+		vals := map[string]reflect.Value{}
+		structFields(val, vals)
+		for _, entry := range node.Entries {
+			if entry.Val != nil {
+				sortMaps(vals[entry.Key.keyText()], entry.Val, order)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		if node.Kind != KindList {
+			return
+		}
+		for ind, elem := range node.Items {
+			if ind < val.Len() {
+				sortMaps(val.Index(ind), elem, order)
+			}
+		}
+	}
+}
+
+func isMarshaler(val reflect.Value) bool {
+	typ := val.Type()
+	return typ.Implements(jsonMarshaler) || typ.Implements(textMarshaler) ||
+		(val.CanAddr() && (reflect.PointerTo(typ).Implements(jsonMarshaler) || reflect.PointerTo(typ).Implements(textMarshaler)))
+}
+
+// Encodes a map key like "encoding/json".
+func mapKey(key reflect.Value) (string, bool) {
+	// Values of unexported embedded structs can't be converted to interfaces.
+	if key.Type().Implements(textMarshaler) && key.CanInterface() {
+		out, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(out), err == nil
+	}
+
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+	return ``, false
+}
+
+/*
+Collects exported fields of the struct by their names in JSON, including
+fields of embedded structs without names, which don't override fields of the
+outer struct. Simpler than the rules of "encoding/json", which only matters for
+conflicting names, where sorting may be skipped.
+*/
+func structFields(val reflect.Value, out map[string]reflect.Value) {
+	typ := val.Type()
+	var embedded []reflect.Value
+
+	for ind := 0; ind < typ.NumField(); ind++ {
+		field := typ.Field(ind)
+		tag := field.Tag.Get(`json`)
+		if tag == `-` {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, `,`)
+		if field.Anonymous && name == `` {
+			elem := val.Field(ind)
+			for elem.Kind() == reflect.Pointer && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Struct {
+				embedded = append(embedded, elem)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == `` {
+			name = field.Name
+		}
+		out[name] = val.Field(ind)
+	}
+
+	for _, val := range embedded {
+		inner := map[string]reflect.Value{}
+		structFields(val, inner)
+		for key, val := range inner {
+			if _, ok := out[key]; !ok {
+				out[key] = val
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package jsonfmt
+
+// How `Merge` combines lists present in several documents.
+type ListMerge byte
+
+const (
+	// The list from the later document replaces the earlier one.
+	ListReplace ListMerge = iota
+
+	// Items from the later document are appended to the earlier ones.
+	ListAppend
+
+	// Items at the same index are merged, like dict values. Extra items from
+	// either document are kept.
+	ListByIndex
+)
+
+/*
+Deep-merges documents left to right and formats the result. Dicts are merged
+by key: values of matching keys are merged recursively, and new keys are
+appended in order. Lists are combined according to the given strategy. Any
+other value from a later document replaces the earlier one. Top-level values
+are merged by position.
+
+Comments are taken from the winning source, which is the later document for
+replaced values and new keys. When the winning value has no comments of its
+own, the comments of the replaced value are kept, so that documentation in a
+base config survives overrides which don't have any. Comments at the end of a
+line belong to the preceding key or value, see `Node.Inline`, so they travel
+with it. Other comments belong to the following key or value, unless
+`Conf.CommentAttach` says otherwise.
+
+Common use: layered config, such as a base file with environment-specific
+overrides:
+
+	out := jsonfmt.Merge[string](jsonfmt.Default, jsonfmt.ListReplace, base, override)
+*/
+func Merge[Out, Src Text](conf Conf, lists ListMerge, srcs ...Src) Out {
+	parse := conf
+	parse.TrailingComments = true
+
+	var out *Node
+	for _, src := range srcs {
+		doc := Parse(parse, src)
+		if out == nil {
+			out = doc
+		} else {
+			out = mergeNodes(out, doc, lists)
+		}
+	}
+
+	if out == nil {
+		return Format[Out](conf, ``)
+	}
+	return Format[Out](conf, out.String())
+}
+
+func mergeNodes(prev, next *Node, lists ListMerge) *Node {
+	switch {
+	case prev.Kind == KindTop && next.Kind == KindTop:
+		next.Items = mergeItems(prev.Items, next.Items, lists)
+
+	case prev.Kind == KindDict && next.Kind == KindDict:
+		next.Entries = mergeEntries(prev.Entries, next.Entries, lists)
+
+	case prev.Kind == KindList && next.Kind == KindList && lists == ListAppend:
+		next.Items = append(prev.Items, next.Items...)
+
+	case prev.Kind == KindList && next.Kind == KindList && lists == ListByIndex:
+		next.Items = mergeItems(prev.Items, next.Items, lists)
+	}
+
+	if len(next.Comments) == 0 {
+		next.Comments = prev.Comments
+	}
+	if len(next.Inline) == 0 {
+		next.Inline = prev.Inline
+	}
+	if len(next.After) == 0 {
+		next.After = prev.After
+	}
+	if len(next.Trailing) == 0 && next.Kind == prev.Kind {
+		next.Trailing = prev.Trailing
+	}
+	return next
+}
+
+// Merges items at the same index, keeping extra items from either side.
+func mergeItems(prev, next []*Node, lists ListMerge) []*Node {
+	for ind := range next {
+		if ind < len(prev) {
+			next[ind] = mergeNodes(prev[ind], next[ind], lists)
+		}
+	}
+	if len(prev) > len(next) {
+		next = append(next, prev[len(next):]...)
+	}
+	return next
+}
+
+func mergeEntries(prev, next []Entry, lists ListMerge) []Entry {
+	out := append([]Entry(nil), prev...)
+	indexes := make(map[string]int, len(out))
+	for ind, val := range out {
+		indexes[val.Key.keyText()] = ind
+	}
+
+	for _, val := range next {
+		ind, ok := indexes[val.Key.keyText()]
+		if !ok {
+			indexes[val.Key.keyText()] = len(out)
+			out = append(out, val)
+			continue
+		}
+
+		old := out[ind]
+		if len(val.Key.Comments) == 0 {
+			val.Key.Comments = old.Key.Comments
+		}
+		if old.Val != nil && val.Val != nil {
+			val.Val = mergeNodes(old.Val, val.Val, lists)
+		}
+		out[ind] = val
+	}
+	return out
+}
@@ -0,0 +1,529 @@
+package jsonfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Lines delimiting conflicts in the output of `Merge3`, like in Git.
+const (
+	conflictOurs   = `<<<<<<< ours`
+	conflictSep    = `=======`
+	conflictTheirs = `>>>>>>> theirs`
+)
+
+/*
+Three-way merge of documents changed independently from a common ancestor,
+such as a config file edited on two branches. Returns the formatted result,
+and false if there were conflicts.
+
+Dicts are merged by key, regardless of order, so keys reordered on one side
+don't conflict with changes on the other. Keys added, removed or changed on one
+side are taken from that side, in the order of ours, followed by keys added in
+theirs. Lists changed on both sides are combined when both sides only appended
+items, skipping items appended by both. Any other value changed differently on
+both sides is a conflict. Comments of each value are merged likewise: comments
+changed on one side are taken from that side, otherwise from ours. Top-level
+values are merged by position.
+
+Conflicting dict entries or top-level values are written from both sides,
+delimited by Git-style markers on their own lines. Dicts and lists containing
+conflicts are always multi-line, and with empty `Conf.Indent`, the output is
+formatted like `Default`. Commas before an entry missing on one side follow the
+other side, so that the output parses, with extra or missing commas, after
+resolving conflicts either way:
+
+	<<<<<<< ours
+	  "port": 8080,
+	=======
+	  "port": 9090,
+	>>>>>>> theirs
+
+Document-level transformations, such as `Conf.SortKeys`, are applied to each
+document before merging.
+*/
+func Merge3[Out, Src Text](conf Conf, base, ours, theirs Src) (Out, bool) {
+	defer repanicErr()
+	out, ok := merge3(conf, text[string](base), text[string](ours), text[string](theirs))
+	return text[Out](out), ok
+}
+
+func merge3(conf Conf, base, ours, theirs string) (string, bool) {
+	prep := conf
+	if prep.Separator == SeparatorArray {
+		prep.Separator = SeparatorDefault
+	}
+	docs := [3]*Node{}
+	for ind, src := range [3]string{base, ours, theirs} {
+		docs[ind] = parse(prep, transform(prep, src))
+		if conf.StripComments {
+			docs[ind].stripComments(&conf)
+		}
+	}
+
+	var merger merger
+	root := merger.merge(docs[0], docs[1], docs[2])
+	assert(root != nil)
+
+	conf.SortKeys = false
+	conf.KeyOrder = nil
+	conf.SortLists = nil
+	conf.FoldLists = nil
+	conf.NormalizeTimes = nil
+	conf.ConvertTo = ``
+	conf.ElideBlobs = 0
+	conf.SortDicts = nil
+	conf.CommentsToKey = ``
+	conf.CommentsFromKey = ``
+	conf.Repair = false
+	conf.Reindent = false
+	conf.StripComments = false
+
+	if merger.conflicts == 0 {
+		return string(format(nil, conf, nil, (&realizer{}).node(root).String())), true
+	}
+
+	// Markers must survive formatting as single-line comments on their own lines.
+	if conf.CommentLine == `` {
+		conf.CommentLine = `//`
+	}
+	if conf.Indent == `` {
+		conf.Indent = Default.Indent
+		conf.Width = Default.Width
+	}
+	conf.OutputCommentLine = ``
+	conf.CommentStyle = CommentStyleKeep
+	conf.TrailingComments = false
+
+	prefix := conf.CommentLine + ` jsonfmt:conflict:`
+	outs := [2]string{}
+	for side := range outs {
+		outs[side] = string(format(nil, conf, nil, (&realizer{side: side, prefix: prefix}).node(root).String()))
+	}
+	return stitchConflicts(prefix, outs[0], outs[1]), false
+}
+
+// Tracks conflicts found by `merger.merge`.
+type merger struct{ conflicts int }
+
+/*
+Result of merging a value. When `plan` is set, the entries of a dict, or the
+values of a document, are merged separately, and `node` provides everything
+else. Otherwise, `node` is the merged value as-is.
+*/
+type merged struct {
+	node  *Node
+	elems []mergedElem
+	plan  bool
+}
+
+/*
+Dict entry or top-level value. For conflicts, `sides` are the entries of ours
+and theirs, nil when absent. Top-level values are stored as values without
+keys.
+*/
+type mergedElem struct {
+	key   *Node
+	val   *merged
+	sides *[2]*Entry
+}
+
+// Returns nil on conflict, which the caller records for the enclosing entry.
+func (self *merger) merge(base, ours, theirs *Node) *merged {
+	switch {
+	case ours.equal(theirs):
+		return &merged{node: mergedNode(ours, base, ours, theirs)}
+
+	// Merging dicts entry by entry keeps the key order of "ours".
+	case base.equal(ours) && !(isEntryWise(ours) && theirs != nil && theirs.Kind == ours.Kind):
+		return &merged{node: mergedNode(theirs, base, ours, theirs)}
+
+	case base.equal(theirs):
+		return &merged{node: mergedNode(ours, base, ours, theirs)}
+
+	case ours == nil || theirs == nil || ours.Kind != theirs.Kind ||
+		base != nil && base.Kind != ours.Kind:
+		return nil
+
+	case ours.Kind == KindDict:
+		return self.dict(base, ours, theirs)
+
+	case ours.Kind == KindTop:
+		return self.top(base, ours, theirs)
+
+	case ours.Kind == KindList && base != nil:
+		return self.list(base, ours, theirs)
+	}
+	return nil
+}
+
+func isEntryWise(val *Node) bool {
+	return val.Kind == KindDict || val.Kind == KindTop
+}
+
+func (self *merger) dict(base, ours, theirs *Node) *merged {
+	out := &merged{node: mergedNode(ours, base, ours, theirs), plan: true}
+	out.node.Entries = nil
+
+	prev, next := base.entryMap(), theirs.entryMap()
+	for ind := range ours.Entries {
+		key := ours.Entries[ind].Key.keyText()
+		self.elem(out, prev[key], &ours.Entries[ind], next[key])
+	}
+
+	known := ours.entryMap()
+	for ind := range theirs.Entries {
+		key := theirs.Entries[ind].Key.keyText()
+		if known[key] == nil {
+			self.elem(out, prev[key], nil, &theirs.Entries[ind])
+		}
+	}
+	return out
+}
+
+func (self *merger) top(base, ours, theirs *Node) *merged {
+	out := &merged{node: mergedNode(ours, base, ours, theirs), plan: true}
+	out.node.Items = nil
+
+	size := len(ours.Items)
+	if len(theirs.Items) > size {
+		size = len(theirs.Items)
+	}
+
+	for ind := 0; ind < size; ind++ {
+		self.elem(out, itemEntry(base.Items, ind), itemEntry(ours.Items, ind), itemEntry(theirs.Items, ind))
+	}
+	return out
+}
+
+// Combines lists when both sides only appended items to the base.
+func (self *merger) list(base, ours, theirs *Node) *merged {
+	size := len(base.Items)
+	if !hasItemsPrefix(ours.Items, base.Items) || !hasItemsPrefix(theirs.Items, base.Items) {
+		return nil
+	}
+
+	out := mergedNode(ours, base, ours, theirs)
+	out.Items = ours.Items[:len(ours.Items):len(ours.Items)]
+
+outer:
+	for _, val := range theirs.Items[size:] {
+		for _, prev := range ours.Items[size:] {
+			if val.equal(prev) {
+				continue outer
+			}
+		}
+		out.Items = append(out.Items, val)
+	}
+	return &merged{node: out}
+}
+
+func (self *merger) elem(out *merged, base, ours, theirs *Entry) {
+	switch {
+	case ours == nil && theirs == nil:
+		return
+
+	case ours == nil || theirs == nil:
+		side := ours
+		if side == nil {
+			side = theirs
+		}
+		if base == nil {
+			out.elems = append(out.elems, mergedElem{key: side.Key, val: &merged{node: side.Val}})
+			return
+		}
+		if base.Val.equal(side.Val) && base.Key.equal(side.Key) {
+			return
+		}
+
+	default:
+		val := self.merge(base.value(), ours.Val, theirs.Val)
+		if val != nil {
+			key := mergedNode(ours.Key, base.key(), ours.Key, theirs.Key)
+			out.elems = append(out.elems, mergedElem{key: key, val: val})
+			return
+		}
+	}
+
+	self.conflicts++
+	out.elems = append(out.elems, mergedElem{sides: &[2]*Entry{ours, theirs}})
+}
+
+/*
+Converts merge results into documents for one side: 0 for ours, 1 for theirs.
+Conflicts are delimited by marker comments starting with the prefix, see
+`stitchConflicts`.
+*/
+type realizer struct {
+	side   int
+	id     int
+	prefix string
+}
+
+func (self *realizer) node(val *merged) *Node {
+	if val == nil {
+		return nil
+	}
+	if !val.plan {
+		return val.node
+	}
+
+	out := *val.node
+	top := out.Kind == KindTop
+	var pending []string
+
+	add := func(key, value *Node) {
+		if top {
+			key = value
+		}
+		head := *key
+		head.Comments = append(pending, key.Comments...)
+		pending = nil
+		if top {
+			out.Items = append(out.Items, &head)
+		} else {
+			out.Entries = append(out.Entries, Entry{Key: &head, Val: value})
+		}
+	}
+
+	for _, elem := range val.elems {
+		if elem.sides == nil {
+			add(elem.key, self.node(elem.val))
+			continue
+		}
+
+		id := strconv.Itoa(self.id)
+		self.id++
+		pending = append(pending, self.prefix+id+":start\n")
+		if entry := elem.sides[self.side]; entry != nil {
+			add(entry.Key, entry.Val)
+		}
+		pending = append(pending, self.prefix+id+":end\n")
+	}
+
+	out.Trailing = append(pending, out.Trailing...)
+	return &out
+}
+
+/*
+Combines the outputs for both sides, replacing each region between marker
+comments with Git-style conflict markers followed by both versions. Outside of
+these regions, the outputs have the same lines, which may differ only in the
+comma before an entry missing on one side. The line with the comma wins.
+*/
+func stitchConflicts(prefix, ours, theirs string) string {
+	one := strings.SplitAfter(ours, "\n")
+	two := strings.SplitAfter(theirs, "\n")
+	var buf strings.Builder
+
+	region := func(lines []string) ([]string, []string) {
+		for ind, line := range lines {
+			if isConflictMarker(prefix, line) {
+				return lines[:ind], lines[ind+1:]
+			}
+		}
+		return lines, nil
+	}
+
+	for len(one) > 0 {
+		line := one[0]
+		one = one[1:]
+		if !isConflictMarker(prefix, line) {
+			if len(two) > 0 {
+				if strings.HasSuffix(strings.TrimRight(two[0], "\r\n"), `,`) {
+					line = two[0]
+				}
+				two = two[1:]
+			}
+			buf.WriteString(line)
+			continue
+		}
+
+		if len(two) > 0 {
+			two = two[1:]
+		}
+		eol := line[len(strings.TrimRight(line, "\r\n")):]
+
+		var lines []string
+		buf.WriteString(conflictOurs + eol)
+		lines, one = region(one)
+		buf.WriteString(strings.Join(lines, ``))
+		buf.WriteString(conflictSep + eol)
+		lines, two = region(two)
+		buf.WriteString(strings.Join(lines, ``))
+		buf.WriteString(conflictTheirs + eol)
+	}
+	return buf.String()
+}
+
+func isConflictMarker(prefix, line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), prefix)
+}
+
+/*
+Copy of the given value with comments merged from the three versions: comments
+changed on one side are taken from that side, otherwise from ours.
+*/
+func mergedNode(val, base, ours, theirs *Node) *Node {
+	if val == nil {
+		return nil
+	}
+
+	out := *val
+	out.Comments = mergeComments(base.comments(), ours.comments(), theirs.comments())
+	out.Trailing = mergeComments(base.trailing(), ours.trailing(), theirs.trailing())
+	out.Inline = mergeComments(base.inline(), ours.inline(), theirs.inline())
+	out.After = mergeComments(base.after(), ours.after(), theirs.after())
+	return &out
+}
+
+func mergeComments(base, ours, theirs []string) []string {
+	if equalStrings(base, ours) {
+		return theirs
+	}
+	return ours
+}
+
+func equalStrings(one, two []string) bool {
+	if len(one) != len(two) {
+		return false
+	}
+	for ind := range one {
+		if one[ind] != two[ind] {
+			return false
+		}
+	}
+	return true
+}
+
+// Structural equality, ignoring comments, layout and the order of dict keys.
+func (self *Node) equal(other *Node) bool {
+	if self == nil || other == nil {
+		return self == other
+	}
+	if self.Kind != other.Kind {
+		return false
+	}
+
+	switch self.Kind {
+	case KindDict:
+		if len(self.Entries) != len(other.Entries) {
+			return false
+		}
+		vals := other.entryMap()
+		for _, val := range self.Entries {
+			entry := vals[val.Key.keyText()]
+			if entry == nil || !val.Val.equal(entry.Val) {
+				return false
+			}
+		}
+		return true
+
+	case KindList, KindTop:
+		return len(self.Items) == len(other.Items) && hasItemsPrefix(self.Items, other.Items)
+
+	default:
+		return self.keyText() == other.keyText()
+	}
+}
+
+func hasItemsPrefix(vals, prefix []*Node) bool {
+	if len(vals) < len(prefix) {
+		return false
+	}
+	for ind, val := range prefix {
+		if !vals[ind].equal(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dict entries by key. For duplicate keys, the last entry wins.
+func (self *Node) entryMap() map[string]*Entry {
+	if self == nil {
+		return nil
+	}
+	out := make(map[string]*Entry, len(self.Entries))
+	for ind := range self.Entries {
+		out[self.Entries[ind].Key.keyText()] = &self.Entries[ind]
+	}
+	return out
+}
+
+// Top-level value at the given index as an entry without a key, or nil.
+func itemEntry(vals []*Node, ind int) *Entry {
+	if ind < len(vals) {
+		return &Entry{Val: vals[ind]}
+	}
+	return nil
+}
+
+// Removes comments, except those kept via `Conf.KeepComments` or `Conf.KeepRegexp`.
+func (self *Node) stripComments(conf *Conf) {
+	self.Comments = keptComments(conf, self.Comments)
+	self.Trailing = keptComments(conf, self.Trailing)
+	self.Inline = keptComments(conf, self.Inline)
+	self.After = keptComments(conf, self.After)
+	for _, val := range self.Entries {
+		val.Key.stripComments(conf)
+		if val.Val != nil {
+			val.Val.stripComments(conf)
+		}
+	}
+	for _, val := range self.Items {
+		val.stripComments(conf)
+	}
+}
+
+func keptComments(conf *Conf, src []string) []string {
+	var out []string
+	for _, val := range src {
+		if conf.keepsComment(func() string { return strings.TrimRight(val, "\r\n") }) {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+func (self *Node) comments() []string {
+	if self == nil {
+		return nil
+	}
+	return self.Comments
+}
+
+func (self *Node) trailing() []string {
+	if self == nil {
+		return nil
+	}
+	return self.Trailing
+}
+
+func (self *Node) inline() []string {
+	if self == nil {
+		return nil
+	}
+	return self.Inline
+}
+
+func (self *Node) after() []string {
+	if self == nil {
+		return nil
+	}
+	return self.After
+}
+
+func (self *Entry) key() *Node {
+	if self == nil {
+		return nil
+	}
+	return self.Key
+}
+
+func (self *Entry) value() *Node {
+	if self == nil {
+		return nil
+	}
+	return self.Val
+}
@@ -0,0 +1,89 @@
+package jsonfmt
+
+import "time"
+
+/*
+Measurements of a single formatting call, returned by `FormatWithMetrics`.
+Useful for telemetry in build systems which format many files.
+*/
+type Metrics struct {
+	// Size of the source, in bytes.
+	InputBytes int `json:"inputBytes"`
+
+	// Size of the output, in bytes.
+	OutputBytes int `json:"outputBytes"`
+
+	// True if the output differs from the source.
+	Changed bool `json:"changed"`
+
+	// Comments removed by `Conf.StripComments`, excluding those kept via
+	// `Conf.KeepComments` and `Conf.KeepRegexp`.
+	CommentsStripped int `json:"commentsStripped"`
+
+	// Punctuation mistakes fixed by formatting or `Conf.Repair`, such as
+	// missing commas, unless `Conf.StrictPunctuation` is enabled. See `PunctuationErrors`.
+	Repairs int `json:"repairs"`
+
+	// Time spent formatting and measuring.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+/*
+Like `Format`, but also returns measurements of the formatting, and returns
+errors instead of panicking. Comments and repairs are counted by scanning the
+source, without parsing the output.
+*/
+func FormatWithMetrics[Out, Src Text](conf Conf, src Src) (_ Out, _ Metrics, err error) {
+	defer recoverErr(&err)
+
+	start := time.Now()
+	source := text[string](src)
+	out := format(nil, conf, nil, source)
+	if conf.ConvertTo != `` {
+		conf = conf.dialect()
+	}
+	if conf.StrictOutput {
+		conf = conf.strict()
+	}
+
+	metrics := Metrics{
+		InputBytes:       len(source),
+		OutputBytes:      len(out),
+		Changed:          string(out) != source,
+		CommentsStripped: strippedComments(conf, source),
+	}
+	if (!conf.StrictPunctuation || conf.Repair) && !conf.Reindent {
+		metrics.Repairs = len(punctuationErrors(conf, source, true))
+	}
+	metrics.Elapsed = time.Since(start)
+	return text[Out](out), metrics, nil
+}
+
+// Number of comments in the source removed by `Conf.StripComments`.
+func strippedComments(conf Conf, src string) int {
+	if !conf.StripComments {
+		return 0
+	}
+
+	scan := fmter{source: src, conf: conf, discard: true}
+	scan.conf.Atoms = StrictnessPermissive
+	var count int
+
+	for scan.more() {
+		switch {
+		case scan.skipped():
+		case scan.isNextComment():
+			if scan.stripsComment() {
+				count++
+			}
+			scan.parseComment()
+		case scan.isNextPlaceholder():
+			scan.placeholder()
+		case scan.isNextByte('"'):
+			scan.string()
+		default:
+			scan.skipChar()
+		}
+	}
+	return count
+}
@@ -0,0 +1,125 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Handling of control characters and byte order marks outside of strings, such
+as NULs and form feeds between the values of a log stream. See `Conf.Noise`.
+*/
+type Noise string
+
+const (
+	// Copied as-is, except for record separators between top-level values, see
+	// `SeparatorRS`. Noise next to an atom becomes a part of it.
+	NoisePreserve Noise = ``
+
+	// Replaced with spaces before formatting, which are then dropped like other
+	// whitespace, but still separate adjacent atoms.
+	NoiseSkip Noise = `skip`
+
+	// Fails formatting with `*NoiseError`.
+	NoiseReject Noise = `reject`
+)
+
+/*
+Returned or panicked when `Conf.Noise` is `NoiseReject` and the source contains
+noise outside of strings. The position refers to the original source, counts
+bytes, and starts at 1 for lines and columns.
+*/
+type NoiseError struct {
+	Offset int
+	Line   int
+	Column int
+	Char   rune
+}
+
+func (self *NoiseError) Error() string {
+	return fmt.Sprintf(`[jsonfmt] unexpected %U at line %v, column %v`, self.Char, self.Line, self.Column)
+}
+
+// Position of the character.
+func (self *NoiseError) Pos() Pos { return Pos{self.Offset, self.Line, self.Column} }
+
+/*
+Replaces or rejects noise outside of strings, comments and placeholders, depending
+on `Conf.Noise`. Record separators between top-level values, as in JSON text
+sequences, are always replaced with spaces, see `SeparatorRS`. Sources without
+noise are returned unchanged without scanning.
+*/
+func fixNoise(conf Conf, src string) string {
+	if conf.Noise == NoisePreserve {
+		if !strings.Contains(src, string(rune(recordSeparator))) {
+			return src
+		}
+	} else if !hasNoise(src) {
+		return src
+	}
+
+	scan := fmter{source: src, conf: conf, discard: true}
+	scan.conf.Atoms = StrictnessPermissive
+	var buf strings.Builder
+	last := 0
+	depth := 0
+
+	for scan.more() {
+		switch {
+		case scan.isNextComment():
+			scan.parseComment()
+		case scan.isNextPlaceholder():
+			scan.placeholder()
+		case scan.isNextByte('"'):
+			scan.string()
+		default:
+			start := scan.cursor
+			char, size := utf8.DecodeRuneInString(scan.rest())
+
+			switch char {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth > 0 {
+					depth--
+				}
+			}
+
+			top := char == recordSeparator && depth == 0
+			if top || conf.Noise != NoisePreserve && isNoise(char) {
+				if conf.Noise == NoiseReject && !top {
+					line, col := lineCol(src, start)
+					panic(fmterErr{&NoiseError{Offset: start, Line: line, Column: col, Char: char}})
+				}
+				buf.WriteString(src[last:start])
+				buf.WriteByte(separator)
+				last = start + size
+			}
+			scan.skipChar()
+		}
+	}
+
+	if last == 0 {
+		return src
+	}
+	buf.WriteString(src[last:])
+	return buf.String()
+}
+
+func hasNoise(src string) bool {
+	for ind := 0; ind < len(src); ind++ {
+		if src[ind] < utf8.RuneSelf && isNoise(rune(src[ind])) {
+			return true
+		}
+	}
+	return strings.ContainsRune(src, byteOrderMark)
+}
+
+// ASCII control characters other than whitespace, and byte order marks.
+func isNoise(char rune) bool {
+	return char == byteOrderMark || char == 0x7f ||
+		char < ' ' && byteClasses[char]&classSpace == 0
+}
+
+const byteOrderMark = '\uFEFF'
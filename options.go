@@ -0,0 +1,421 @@
+package jsonfmt
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+/*
+Immutable formatter with a fixed config, created by `New`. Safe for concurrent
+use. An alternative to making and mutating copies of `Default`:
+
+	fmter := jsonfmt.New(jsonfmt.WithIndent("\t"), jsonfmt.WithWidth(100), jsonfmt.WithSortKeys())
+	content = fmter.FormatBytes(content)
+*/
+type Formatter struct{ conf Conf }
+
+// Configures a `Formatter` created by `New`.
+type Option func(*Conf)
+
+// Creates a formatter, starting with `Default` and applying the options.
+func New(opts ...Option) Formatter {
+	conf := Default
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return Formatter{conf.clone()}
+}
+
+// Returns a copy of the config of this formatter.
+func (self Formatter) Conf() Conf { return self.conf.clone() }
+
+// Shortcut for `FormatString` with the config of this formatter.
+func (self Formatter) FormatString(src string) string {
+	return FormatString(self.conf, src)
+}
+
+// Shortcut for `FormatBytes` with the config of this formatter.
+func (self Formatter) FormatBytes(src []byte) []byte {
+	return FormatBytes(self.conf, src)
+}
+
+// Shortcut for `FormatTo` with the config of this formatter.
+func (self Formatter) FormatTo(out io.Writer, src []byte) error {
+	return FormatTo(self.conf, out, src)
+}
+
+// Shortcut for `FormatToCtx` with the config of this formatter.
+func (self Formatter) FormatToCtx(ctx context.Context, out io.Writer, src []byte) error {
+	return FormatToCtx(ctx, self.conf, out, src)
+}
+
+// Shortcut for `NewReader` with the config of this formatter.
+func (self Formatter) NewReader(src io.Reader) io.Reader {
+	return NewReader(self.conf, src)
+}
+
+// Shortcut for `NewWriter` with the config of this formatter.
+func (self Formatter) NewWriter(out io.Writer) io.WriteCloser {
+	return NewWriter(self.conf, out)
+}
+
+/*
+Replaces the entire config, for example with a preset. Use as the first option.
+The slices are copied, so that later options don't append to them.
+*/
+func WithConf(conf Conf) Option {
+	return func(tar *Conf) { *tar = conf.clone() }
+}
+
+// Sets `Conf.Indent`.
+func WithIndent(val string) Option {
+	return func(tar *Conf) { tar.Indent = val }
+}
+
+// Sets `Conf.Width`.
+func WithWidth(val uint64) Option {
+	return func(tar *Conf) { tar.Width = val }
+}
+
+// Sets `Conf.MaxOutputBytes`.
+func WithMaxOutputBytes(val uint64) Option {
+	return func(tar *Conf) { tar.MaxOutputBytes = val }
+}
+
+// Sets `Conf.MaxDepth`.
+func WithMaxDepth(val uint64) Option {
+	return func(tar *Conf) { tar.MaxDepth = val }
+}
+
+// Sets `Conf.Workers`.
+func WithWorkers(val uint64) Option {
+	return func(tar *Conf) { tar.Workers = val }
+}
+
+// Sets `Conf.LayoutVersion`.
+func WithLayoutVersion(val uint64) Option {
+	return func(tar *Conf) { tar.LayoutVersion = val }
+}
+
+// Sets `Conf.CommentLine`.
+func WithCommentLine(val string) Option {
+	return func(tar *Conf) { tar.CommentLine = val }
+}
+
+// Sets `Conf.CommentBlockStart` and `Conf.CommentBlockEnd`.
+func WithCommentBlock(start, end string) Option {
+	return func(tar *Conf) {
+		tar.CommentBlockStart = start
+		tar.CommentBlockEnd = end
+	}
+}
+
+// Sets `Conf.OutputCommentLine`.
+func WithOutputCommentLine(val string) Option {
+	return func(tar *Conf) { tar.OutputCommentLine = val }
+}
+
+// Sets `Conf.OutputCommentBlockStart` and `Conf.OutputCommentBlockEnd`.
+func WithOutputCommentBlock(start, end string) Option {
+	return func(tar *Conf) {
+		tar.OutputCommentBlockStart = start
+		tar.OutputCommentBlockEnd = end
+	}
+}
+
+// Sets `Conf.CommentStyle`.
+func WithCommentStyle(val CommentStyle) Option {
+	return func(tar *Conf) { tar.CommentStyle = val }
+}
+
+// Sets `Conf.CommentAttach`.
+func WithCommentAttach(val CommentAttach) Option {
+	return func(tar *Conf) { tar.CommentAttach = val }
+}
+
+// Sets `Conf.ClosingComments`.
+func WithClosingComments(val ClosingComments) Option {
+	return func(tar *Conf) { tar.ClosingComments = val }
+}
+
+// Sets `Conf.CommentSpace`.
+func WithCommentSpace(val CommentSpace) Option {
+	return func(tar *Conf) { tar.CommentSpace = val }
+}
+
+// Sets `Conf.CommentsToKey`.
+func WithCommentsToKey(key string) Option {
+	return func(tar *Conf) { tar.CommentsToKey = key }
+}
+
+// Sets `Conf.CommentsFromKey`.
+func WithCommentsFromKey(key string) Option {
+	return func(tar *Conf) { tar.CommentsFromKey = key }
+}
+
+// Enables `Conf.TrailingComma`.
+func WithTrailingComma() Option {
+	return func(tar *Conf) { tar.TrailingComma = true }
+}
+
+// Enables `Conf.TrailingComments`.
+func WithTrailingComments() Option {
+	return func(tar *Conf) { tar.TrailingComments = true }
+}
+
+// Sets `Conf.CommentColumn`.
+func WithCommentColumn(val uint64) Option {
+	return func(tar *Conf) { tar.CommentColumn = val }
+}
+
+// Enables `Conf.StripComments`.
+func WithStripComments() Option {
+	return func(tar *Conf) { tar.StripComments = true }
+}
+
+// Appends to `Conf.KeepComments`.
+func WithKeepComments(markers ...string) Option {
+	return func(tar *Conf) { tar.KeepComments = append(tar.KeepComments, markers...) }
+}
+
+// Sets `Conf.KeepRegexp`.
+func WithKeepRegexp(val string) Option {
+	return func(tar *Conf) { tar.KeepRegexp = val }
+}
+
+// Enables `Conf.NormalizeComments`.
+func WithNormalizeComments() Option {
+	return func(tar *Conf) { tar.NormalizeComments = true }
+}
+
+// Enables `Conf.CollapseComments`.
+func WithCollapseComments() Option {
+	return func(tar *Conf) { tar.CollapseComments = true }
+}
+
+// Enables `Conf.SortKeys`.
+func WithSortKeys() Option {
+	return func(tar *Conf) { tar.SortKeys = true }
+}
+
+// Enables `Conf.PreserveMultiline`.
+func WithPreserveMultiline() Option {
+	return func(tar *Conf) { tar.PreserveMultiline = true }
+}
+
+// Enables `Conf.BraceSpacing`.
+func WithBraceSpacing() Option {
+	return func(tar *Conf) { tar.BraceSpacing = true }
+}
+
+// Enables `Conf.CloseSameLine`.
+func WithCloseSameLine() Option {
+	return func(tar *Conf) { tar.CloseSameLine = true }
+}
+
+// Enables `Conf.OpenNewline`.
+func WithOpenNewline() Option {
+	return func(tar *Conf) { tar.OpenNewline = true }
+}
+
+// Enables `Conf.ExpandEmpty`.
+func WithExpandEmpty() Option {
+	return func(tar *Conf) { tar.ExpandEmpty = true }
+}
+
+// Enables `Conf.InlineSingle`.
+func WithInlineSingle() Option {
+	return func(tar *Conf) { tar.InlineSingle = true }
+}
+
+// Enables `Conf.BreakAfterKey`.
+func WithBreakAfterKey() Option {
+	return func(tar *Conf) { tar.BreakAfterKey = true }
+}
+
+// Enables `Conf.StrictPunctuation`.
+func WithStrictPunctuation() Option {
+	return func(tar *Conf) { tar.StrictPunctuation = true }
+}
+
+// Enables `Conf.Repair`.
+func WithRepair() Option {
+	return func(tar *Conf) { tar.Repair = true }
+}
+
+// Enables `Conf.Reindent`.
+func WithReindent() Option {
+	return func(tar *Conf) { tar.Reindent = true }
+}
+
+// Enables `Conf.StrictOutput`.
+func WithStrictOutput() Option {
+	return func(tar *Conf) { tar.StrictOutput = true }
+}
+
+// Enables `Conf.SelfCheck`.
+func WithSelfCheck() Option {
+	return func(tar *Conf) { tar.SelfCheck = true }
+}
+
+// Adds entries to `Conf.KeyOrder`, replacing existing entries for the same paths.
+func WithKeyOrder(val map[string][]string) Option {
+	return func(tar *Conf) {
+		if tar.KeyOrder == nil {
+			tar.KeyOrder = map[string][]string{}
+		}
+		for path, keys := range val {
+			tar.KeyOrder[path] = keys
+		}
+	}
+}
+
+// Appends to `Conf.SortLists`.
+func WithSortLists(paths ...string) Option {
+	return func(tar *Conf) { tar.SortLists = append(tar.SortLists, paths...) }
+}
+
+// Appends to `Conf.FoldLists`.
+func WithFoldLists(paths ...string) Option {
+	return func(tar *Conf) { tar.FoldLists = append(tar.FoldLists, paths...) }
+}
+
+// Sets `Conf.ConvertTo`.
+func WithConvertTo(val Dialect) Option {
+	return func(tar *Conf) { tar.ConvertTo = val }
+}
+
+// Enables `Conf.FixSmartQuotes`.
+func WithFixSmartQuotes() Option {
+	return func(tar *Conf) { tar.FixSmartQuotes = true }
+}
+
+// Enables `Conf.MultiWordAtoms`.
+func WithMultiWordAtoms() Option {
+	return func(tar *Conf) { tar.MultiWordAtoms = true }
+}
+
+// Enables `Conf.FoldStrings`.
+func WithFoldStrings() Option {
+	return func(tar *Conf) { tar.FoldStrings = true }
+}
+
+// Enables `Conf.Skeleton`.
+func WithSkeleton() Option {
+	return func(tar *Conf) { tar.Skeleton = true }
+}
+
+// Sets `Conf.ElideBlobs`.
+func WithElideBlobs(val uint64) Option {
+	return func(tar *Conf) { tar.ElideBlobs = val }
+}
+
+// Sets `Conf.ExemptURLs`.
+func WithExemptURLs(val uint64) Option {
+	return func(tar *Conf) { tar.ExemptURLs = val }
+}
+
+// Appends to `Conf.NormalizeTimes`.
+func WithNormalizeTimes(paths ...string) Option {
+	return func(tar *Conf) { tar.NormalizeTimes = append(tar.NormalizeTimes, paths...) }
+}
+
+// Appends to `Conf.SortDicts`.
+func WithSortDicts(paths ...string) Option {
+	return func(tar *Conf) { tar.SortDicts = append(tar.SortDicts, paths...) }
+}
+
+// Appends to `Conf.Overrides`. The settings are a JSON object such as `{"width": 0}`.
+func WithOverride(path string, conf string) Option {
+	return func(tar *Conf) { tar.Overrides = append(tar.Overrides, Override{path, json.RawMessage(conf)}) }
+}
+
+// Enables `Conf.DedupeLists`.
+func WithDedupeLists() Option {
+	return func(tar *Conf) { tar.DedupeLists = true }
+}
+
+// Enables `Conf.SortKeys` and sets `Conf.KeySort`.
+func WithKeySort(val KeySort) Option {
+	return func(tar *Conf) {
+		tar.SortKeys = true
+		tar.KeySort = val
+	}
+}
+
+// Sets `Conf.Separator`.
+func WithSeparator(val Separator) Option {
+	return func(tar *Conf) { tar.Separator = val }
+}
+
+// Sets `Conf.LineEnding`.
+func WithLineEnding(val LineEnding) Option {
+	return func(tar *Conf) { tar.LineEnding = val }
+}
+
+// Sets `Conf.Atoms`.
+func WithAtoms(val Strictness) Option {
+	return func(tar *Conf) { tar.Atoms = val }
+}
+
+// Sets `Conf.Noise`.
+func WithNoise(val Noise) Option {
+	return func(tar *Conf) { tar.Noise = val }
+}
+
+// Sets `Conf.Colon`, such as " : ".
+func WithColon(val string) Option {
+	return func(tar *Conf) { tar.Colon = val }
+}
+
+// Sets `Conf.Comma`, such as ",".
+func WithComma(val string) Option {
+	return func(tar *Conf) { tar.Comma = val }
+}
+
+// Appends to `Conf.Placeholders`.
+func WithPlaceholders(val ...Placeholder) Option {
+	return func(tar *Conf) { tar.Placeholders = append(tar.Placeholders, val...) }
+}
+
+// Appends to `Conf.Recognizers`.
+func WithRecognizers(val ...Recognizer) Option {
+	return func(tar *Conf) { tar.Recognizers = append(tar.Recognizers, val...) }
+}
+
+// Copies slices, to avoid sharing them with the caller.
+func (self Conf) clone() Conf {
+	self.KeyOrder = cloneKeyOrder(self.KeyOrder)
+	self.SortLists = append([]string(nil), self.SortLists...)
+	self.FoldLists = append([]string(nil), self.FoldLists...)
+	self.NormalizeTimes = append([]string(nil), self.NormalizeTimes...)
+	self.SortDicts = append([]string(nil), self.SortDicts...)
+	self.Placeholders = append([]Placeholder(nil), self.Placeholders...)
+	self.Recognizers = append([]Recognizer(nil), self.Recognizers...)
+	self.KeepComments = append([]string(nil), self.KeepComments...)
+	self.Overrides = cloneOverrides(self.Overrides)
+	return self
+}
+
+func cloneOverrides(src []Override) []Override {
+	if src == nil {
+		return nil
+	}
+	out := make([]Override, len(src))
+	for ind, val := range src {
+		out[ind] = Override{val.Path, append(json.RawMessage(nil), val.Conf...)}
+	}
+	return out
+}
+
+func cloneKeyOrder(src map[string][]string) map[string][]string {
+	if src == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(src))
+	for path, keys := range src {
+		out[path] = append([]string(nil), keys...)
+	}
+	return out
+}
@@ -0,0 +1,268 @@
+package jsonfmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+/*
+Formatting settings for the dicts and lists at a path, see `Conf.Overrides`.
+`Conf` is a JSON object with the keys of `Conf`, such as `{"width": 0}`, which
+replace the settings in effect around the path.
+*/
+type Override struct {
+	Path string          `json:"path"`
+	Conf json.RawMessage `json:"conf"`
+}
+
+/*
+Keys of `Conf` which can be overridden per path. Other settings apply to the
+whole document, such as key sorting, or affect parsing, such as comment
+delimiters.
+*/
+var overrideKeys = map[string]bool{
+	`indent`:                  true,
+	`width`:                   true,
+	`outputCommentLine`:       true,
+	`outputCommentBlockStart`: true,
+	`outputCommentBlockEnd`:   true,
+	`trailingComma`:           true,
+	`trailingComments`:        true,
+	`stripComments`:           true,
+	`normalizeComments`:       true,
+	`collapseComments`:        true,
+	`foldStrings`:             true,
+	`exemptUrls`:              true,
+	`preserveMultiline`:       true,
+	`braceSpacing`:            true,
+	`closeSameLine`:           true,
+	`openNewline`:             true,
+	`expandEmpty`:             true,
+	`inlineSingle`:            true,
+	`breakAfterKey`:           true,
+	`commentStyle`:            true,
+	`keepComments`:            true,
+	`keepRegexp`:              true,
+	`commentColumn`:           true,
+	`closingComments`:         true,
+	`commentSpace`:            true,
+	`colon`:                   true,
+	`comma`:                   true,
+}
+
+// Validates the path and the keys, returning the path segments.
+func (self Override) segments() ([]string, error) {
+	path, err := pathSegments(self.Path)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] invalid override: %w`, err)
+	}
+
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(self.Conf, &keys)
+	if err != nil {
+		return nil, fmt.Errorf(`[jsonfmt] invalid override at %q: %w`, self.Path, err)
+	}
+	for key := range keys {
+		if !overrideKeys[key] {
+			return nil, fmt.Errorf(`[jsonfmt] invalid override at %q: key %q can't be overridden per path`, self.Path, key)
+		}
+	}
+	return path, nil
+}
+
+/*
+Applies the override on top of the given settings. Slices are copied first,
+since decoding JSON into a slice reuses its backing array, which belongs to the
+caller.
+*/
+func (self Override) apply(conf Conf) Conf {
+	conf.KeepComments = append([]string(nil), conf.KeepComments...)
+	err := json.Unmarshal(self.Conf, &conf)
+	if err != nil {
+		panic(fmterErr{fmt.Errorf(`[jsonfmt] invalid override at %q: %w`, self.Path, err)})
+	}
+	return conf
+}
+
+/*
+Settings for each dict and list with matching overrides, keyed by the offset of
+the opening bracket in the source. Nil without `Conf.Overrides`.
+*/
+func overrides(conf Conf, src string) map[int]Conf {
+	if len(conf.Overrides) == 0 {
+		return nil
+	}
+
+	paths := make([][]string, len(conf.Overrides))
+	for ind, val := range conf.Overrides {
+		path, err := val.segments()
+		if err != nil {
+			panic(fmterErr{err})
+		}
+		paths[ind] = path
+	}
+
+	scan := overrider{
+		fmter: fmter{source: src, conf: conf, discard: true},
+		paths: paths,
+		out:   map[int]Conf{},
+	}
+	scan.conf.StripComments = false
+	scan.conf.Atoms = StrictnessPermissive
+	scan.top(conf)
+	return scan.out
+}
+
+// Walks the source like `fmter.parseTop`, tracking the path of each value.
+type overrider struct {
+	fmter
+	paths [][]string
+	path  []string
+	out   map[int]Conf
+}
+
+func (self *overrider) top(conf Conf) {
+	for self.more() {
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.parseComment()
+			continue
+		}
+		if !self.elem(conf) {
+			self.skipChar()
+		}
+	}
+}
+
+// Returns false when nothing was consumed.
+func (self *overrider) elem(conf Conf) bool {
+	start := self.cursor
+
+	switch {
+	case self.isNextPlaceholder():
+		self.placeholder()
+		return true
+	case self.isNextByte('{'):
+		self.dict(self.override(conf))
+		return true
+	case self.isNextByte('['):
+		self.list(self.override(conf))
+		return true
+	default:
+		self.any()
+		return self.cursor > start
+	}
+}
+
+// Applies the overrides matching the current path to the dict or list at the cursor.
+func (self *overrider) override(conf Conf) Conf {
+	found := false
+	for ind, path := range self.paths {
+		if matchPath(path, self.path) {
+			conf = self.conf.Overrides[ind].apply(conf)
+			found = true
+		}
+	}
+	if found {
+		self.out[self.cursor] = conf
+	}
+	return conf
+}
+
+func (self *overrider) dict(conf Conf) {
+	self.nest()
+	defer self.unnest()
+	defer self.truncatePath(len(self.path))
+	self.skipByte()
+	key := true
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.skipByte()
+			return
+		}
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.parseComment()
+			continue
+		}
+		if key && self.isNextDirective() {
+			self.placeholder()
+			continue
+		}
+
+		start := self.cursor
+		if key {
+			self.any()
+			if self.cursor == start {
+				self.skipChar()
+				continue
+			}
+
+			node := Node{Kind: KindAtom, Text: self.source[start:self.cursor]}
+			if self.source[start] == '"' {
+				node.Kind = KindString
+			}
+			self.path = append(self.path, node.keyText())
+			key = false
+			continue
+		}
+
+		ok := self.elem(conf)
+		self.path = self.path[:len(self.path)-1]
+		key = true
+		if !ok {
+			self.skipChar()
+		}
+	}
+}
+
+func (self *overrider) list(conf Conf) {
+	self.nest()
+	defer self.unnest()
+	defer self.truncatePath(len(self.path))
+	self.skipByte()
+
+	for ind := 0; self.more(); {
+		if self.isNextCloser() {
+			self.skipByte()
+			return
+		}
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.parseComment()
+			continue
+		}
+
+		self.path = append(self.path, strconv.Itoa(ind))
+		ok := self.elem(conf)
+		self.path = self.path[:len(self.path)-1]
+		if !ok {
+			self.skipChar()
+			continue
+		}
+		ind++
+	}
+}
+
+func (self *overrider) truncatePath(val int) { self.path = self.path[:val] }
+
+// True if the path has the same segments as the pattern, where "*" matches any.
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for ind, val := range pattern {
+		if val != `*` && val != path[ind] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package jsonfmt
+
+import (
+	"context"
+	"unicode/utf8"
+)
+
+/*
+Minimum amount of source formatted by one worker at a time, see
+`Conf.Workers`. Smaller sources are formatted sequentially.
+*/
+const batchSize = 1 << 18
+
+/*
+Values formatted ahead of time by other goroutines, see `Conf.Workers`. The
+main formatter still walks the entire source, and pastes a value formatted
+ahead of time only when its state at the start of the value, such as the
+column, matches the state assumed by the worker. Otherwise it formats the
+value itself. This makes the output identical to sequential formatting, while
+regular inputs, such as newline-delimited JSON or huge lists, are formatted
+almost entirely by workers.
+*/
+type parallel struct {
+	batches chan chan []formatted
+	done    chan struct{}
+	pending []formatted
+}
+
+// Value formatted ahead of time, and the state assumed at its start.
+type formatted struct {
+	start   int
+	end     int
+	indent  int
+	col     int
+	newline bool
+	out     string
+}
+
+type batch struct {
+	vals []formatted
+	out  chan []formatted
+}
+
+func (self *fmter) startParallel(workers int) {
+	par := &parallel{
+		batches: make(chan chan []formatted, workers*2),
+		done:    make(chan struct{}),
+	}
+	jobs := make(chan batch, workers)
+
+	conf := self.conf
+	conf.MaxOutputBytes = 0
+
+	go par.produce(conf, self.source, jobs)
+	for ind := 0; ind < workers; ind++ {
+		go par.work(conf, self.source, self.ctx, jobs)
+	}
+	self.par = par
+}
+
+// Stops the remaining work. Used for `defer`.
+func (self *parallel) stop() { close(self.done) }
+
+/*
+Splits the source into batches of records, like `Records`, and hands them to
+workers, keeping the order of results for `parallel.next`.
+*/
+func (self *parallel) produce(conf Conf, source string, jobs chan<- batch) {
+	defer close(jobs)
+	defer close(self.batches)
+
+	// Errors are reported by the main formatter, and would crash this goroutine.
+	conf.StripComments = false
+	conf.Atoms = StrictnessPermissive
+	scan := fmter{source: source, conf: conf, discard: true}
+	closer := scan.recordsStart()
+	state := scan.predict(closer != 0)
+
+	var vals []formatted
+	size := 0
+
+	send := func() bool {
+		out := make(chan []formatted, 1)
+		select {
+		case self.batches <- out:
+		case <-self.done:
+			return false
+		}
+		select {
+		case jobs <- batch{vals, out}:
+		case <-self.done:
+			return false
+		}
+		vals, size = nil, 0
+		return true
+	}
+
+	scan.records(closer, func(_, start, end int) bool {
+		val := state
+		val.start = start
+		vals = append(vals, val)
+		size += end - start
+		return size < batchSize || send()
+	})
+
+	if len(vals) > 0 {
+		send()
+	}
+}
+
+/*
+State of the main formatter at the start of each record, in the absence of
+comments. With `Conf.Width` at 0, the column doesn't matter.
+*/
+func (self *fmter) predict(elem bool) (out formatted) {
+	if elem {
+		out.indent = 1
+		if self.whitespace() {
+			out.col = utf8.RuneCountInString(self.conf.Indent)
+		}
+		return
+	}
+
+	switch self.conf.Separator {
+	case SeparatorRS:
+		out.col = 1
+	case SeparatorNewline, SeparatorBlank:
+		out.newline = true
+	default:
+		out.newline = self.whitespace()
+	}
+	return
+}
+
+func (self *parallel) work(conf Conf, source string, ctx context.Context, jobs <-chan batch) {
+	for job := range jobs {
+		job.out <- self.format(conf, source, ctx, job.vals)
+	}
+}
+
+/*
+Formats each value in the state assumed by `fmter.predict`. On failure, such as
+a canceled context, returns nil, and the main formatter reports the error when
+it reaches the same values.
+*/
+func (self *parallel) format(conf Conf, source string, ctx context.Context, vals []formatted) (out []formatted) {
+	defer func() {
+		if recover() != nil {
+			out = nil
+		}
+	}()
+
+	fmter := fmter{source: source, conf: conf, ctx: ctx}
+	var ends []int
+
+	for ind := range vals {
+		select {
+		case <-self.done:
+			return nil
+		default:
+		}
+
+		val := &vals[ind]
+		fmter.cursor = val.start
+		fmter.indent = val.indent
+		fmter.depth = val.indent
+		fmter.col = val.col
+		fmter.tail = 0
+		if val.newline {
+			fmter.tail = '\n'
+		}
+
+		fmter.any()
+		val.end = fmter.cursor
+		ends = append(ends, fmter.buf.Len())
+	}
+
+	// Single allocation for the entire batch.
+	str := fmter.buf.String()
+	prev := 0
+	for ind, end := range ends {
+		vals[ind].out = str[prev:end]
+		prev = end
+	}
+	return vals
+}
+
+// Returns the next value formatted ahead of time, if it starts at the cursor.
+func (self *parallel) next(cursor int) (formatted, bool) {
+	for {
+		for len(self.pending) > 0 && self.pending[0].start < cursor {
+			self.pending = self.pending[1:]
+		}
+		if len(self.pending) > 0 {
+			val := self.pending[0]
+			return val, val.start == cursor
+		}
+
+		out, ok := <-self.batches
+		if !ok {
+			return formatted{}, false
+		}
+		self.pending = <-out
+	}
+}
+
+/*
+Writes the value at the cursor formatted ahead of time, see `parallel`. Returns
+false when there's no such value, or when the state of the main formatter
+doesn't match the state assumed by the worker.
+*/
+func (self *fmter) pasted() bool {
+	if self.par == nil || len(self.snapshots) > 0 || self.value {
+		return false
+	}
+
+	val, ok := self.par.next(self.cursor)
+	if !ok || val.end <= val.start || val.indent != self.indent ||
+		val.newline != self.hasNewlineSuffix() ||
+		self.conf.Width > 0 && val.col != self.col {
+		return false
+	}
+
+	self.writeString(val.out)
+	self.cursor = val.end
+	return true
+}
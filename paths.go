@@ -0,0 +1,60 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Splits a path into segments. Paths are JSON pointers (RFC 6901), such as
+"/permissions/allow", where "~1" stands for "/" and "~0" for "~". The segment
+"*" matches any key or list index. The empty path matches top-level values.
+*/
+func pathSegments(path string) ([]string, error) {
+	if path == `` {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, `/`) {
+		return nil, fmt.Errorf(`path %q must be empty or start with "/"`, path)
+	}
+
+	out := strings.Split(path[1:], `/`)
+	for ind, val := range out {
+		out[ind] = strings.NewReplacer(`~1`, `/`, `~0`, `~`).Replace(val)
+	}
+	return out, nil
+}
+
+// Inverse of the unescaping in `pathSegments`.
+func escapePath(key string) string {
+	return strings.NewReplacer(`~`, `~0`, `/`, `~1`).Replace(key)
+}
+
+// Calls the function with each node of the document matching the path.
+func (self *Node) eachAt(path []string, fun func(*Node)) {
+	if self.Kind == KindTop {
+		for _, val := range self.Items {
+			val.eachAt(path, fun)
+		}
+		return
+	}
+
+	if len(path) == 0 {
+		fun(self)
+		return
+	}
+
+	head, tail := path[0], path[1:]
+
+	for _, val := range self.Entries {
+		if val.Val != nil && (head == `*` || head == val.Key.keyText()) {
+			val.Val.eachAt(tail, fun)
+		}
+	}
+
+	for ind, val := range self.Items {
+		if head == `*` || head == fmt.Sprint(ind) {
+			val.eachAt(tail, fun)
+		}
+	}
+}
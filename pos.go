@@ -0,0 +1,56 @@
+package jsonfmt
+
+/*
+Position in a source, as in `Node.Pos` and the `Pos` methods of errors and
+warnings such as `Warning.Pos`. Counts bytes. Lines and columns start at 1. The
+zero value means an unknown position, for example of nodes created by
+transformations.
+*/
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// True if the position is known.
+func (self Pos) IsValid() bool { return self.Line > 0 }
+
+/*
+Returns the position of the byte offset in the source. Offsets past the end of
+the source are clamped to it.
+*/
+func PosAt[Src Text](src Src, offset int) Pos {
+	return posAt(text[string](src), offset)
+}
+
+func posAt(src string, offset int) Pos {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	line, col := lineCol(src, offset)
+	return Pos{Offset: offset, Line: line, Column: col}
+}
+
+/*
+Position of the byte offset in the source being parsed, computed from the
+previous one, since parsing moves forward. Avoids rescanning the source for
+every node.
+*/
+func (self *fmter) pos(offset int) Pos {
+	prev := self.lastPos
+	if !prev.IsValid() || offset < prev.Offset {
+		prev = Pos{Line: 1, Column: 1}
+	}
+
+	for ind := prev.Offset; ind < offset; ind++ {
+		if self.source[ind] == '\n' {
+			prev.Line++
+			prev.Column = 1
+		} else {
+			prev.Column++
+		}
+	}
+	prev.Offset = offset
+	self.lastPos = prev
+	return prev
+}
@@ -0,0 +1,198 @@
+package jsonfmt
+
+import "fmt"
+
+/*
+Returned or panicked when `Conf.StrictPunctuation` is enabled and the source has
+missing or extra commas or colons. The position refers to the source, and
+counts bytes. Lines and columns start at 1. With `NewReader` and `NewWriter`,
+the position is relative to the top-level values being formatted at once.
+*/
+type PunctuationError struct {
+	Offset int
+	Line   int
+	Column int
+	Msg    string
+	Fix    string // Fix applied by formatting, such as "inserted comma", see `PunctuationErrors`.
+}
+
+func (self *PunctuationError) Error() string {
+	return fmt.Sprintf(`[jsonfmt] %v at line %v, column %v`, self.Msg, self.Line, self.Column)
+}
+
+// Position of the mistake.
+func (self PunctuationError) Pos() Pos { return Pos{self.Offset, self.Line, self.Column} }
+
+/*
+Returns all punctuation mistakes in the source: missing or extra commas and
+colons, which formatting fixes, unless `Conf.StrictPunctuation` is enabled, in
+which case it fails with the first of them. `PunctuationError.Fix` describes how
+formatting fixes each one. Useful for telling authors of hand-edited files what
+was wrong with them. Like `Format`, panics on hard errors such as `ErrMaxDepth`.
+*/
+func PunctuationErrors[Src Text](conf Conf, src Src) []PunctuationError {
+	defer repanicErr()
+	return punctuationErrors(conf, text[string](src), true)
+}
+
+/*
+Verifies the punctuation of the source before formatting, when
+`Conf.StrictPunctuation` is enabled. Otherwise the formatter silently inserts
+missing commas and colons, and drops extra ones.
+*/
+func checkPunctuation(conf Conf, src string) {
+	if conf.StrictPunctuation && !conf.Repair && !conf.Reindent {
+		punctuationErrors(conf, src, false)
+	}
+}
+
+func punctuationErrors(conf Conf, src string, all bool) []PunctuationError {
+	conf.StripComments = false
+	conf.Atoms = StrictnessPermissive
+
+	scan := puncter{fmter: fmter{source: src, conf: conf, discard: true}, all: all}
+	scan.top()
+	return scan.out
+}
+
+// Expected punctuation in `puncter.elems`.
+const (
+	punctElem  = iota // Element or the end. Trailing commas are allowed.
+	punctColon        // After a dict key.
+	punctValue        // After a colon.
+	punctComma        // After an element: comma or the end.
+)
+
+/*
+Walks the source like `fmter.parseTop`, tracking the expected punctuation.
+Without `puncter.all`, panics with the first mistake.
+*/
+type puncter struct {
+	fmter
+	all bool
+	out []PunctuationError
+}
+
+func (self *puncter) top() {
+	for self.more() {
+		switch {
+		case self.skippedSpace():
+		case self.isNextComment():
+			self.parseComment()
+		case self.isNextByte(','):
+			self.mistake(`unexpected comma`, `removed comma`)
+			self.skipByte()
+		case self.isNextByte(':'):
+			self.mistake(`unexpected colon`, `removed colon`)
+			self.skipByte()
+		case !self.any():
+			self.skipChar()
+		}
+	}
+}
+
+// Returns false when nothing was consumed.
+func (self *puncter) any() bool {
+	start := self.cursor
+
+	switch {
+	case self.isNextPlaceholder():
+		self.placeholder()
+	case self.isNextByte('{'):
+		self.elems(true)
+	case self.isNextByte('['):
+		self.elems(false)
+	case self.isNextByte('"'):
+		self.string()
+	default:
+		self.atom()
+	}
+	return self.cursor > start
+}
+
+func (self *puncter) elems(dict bool) {
+	self.nest()
+	defer self.unnest()
+	self.skipByte()
+	state := punctElem
+
+	for self.more() {
+		if self.skippedSpace() {
+			continue
+		}
+
+		if self.isNextComment() {
+			self.parseComment()
+			continue
+		}
+
+		if self.isNextCloser() {
+			if state == punctColon || state == punctValue {
+				self.mistake(`missing dict value`, ``)
+			}
+			self.skipByte()
+			return
+		}
+
+		if self.isNextByte(',') {
+			switch state {
+			case punctComma:
+				state = punctElem
+			case punctColon:
+				self.mistake(`unexpected comma`, `replaced comma with colon`)
+				state = punctValue
+			default:
+				self.mistake(`unexpected comma`, `removed comma`)
+			}
+			self.skipByte()
+			continue
+		}
+
+		if self.isNextByte(':') {
+			switch state {
+			case punctColon:
+				state = punctValue
+			case punctComma:
+				self.mistake(`unexpected colon`, `replaced colon with comma`)
+				state = punctElem
+			default:
+				self.mistake(`unexpected colon`, `removed colon`)
+			}
+			self.skipByte()
+			continue
+		}
+
+		if state == punctColon {
+			self.mistake(`missing colon`, `inserted colon`)
+			state = punctValue
+		}
+		if state == punctComma {
+			self.mistake(`missing comma`, `inserted comma`)
+			state = punctElem
+		}
+
+		if dict && state == punctElem && self.isNextDirective() {
+			self.placeholder()
+			continue
+		}
+
+		if !self.any() {
+			self.skipChar()
+			continue
+		}
+
+		if dict && state == punctElem {
+			state = punctColon
+		} else {
+			state = punctComma
+		}
+	}
+}
+
+func (self *puncter) mistake(msg, fix string) {
+	line, col := lineCol(self.source, self.cursor)
+	if !self.all {
+		panic(fmterErr{&PunctuationError{Offset: self.cursor, Line: line, Column: col, Msg: msg}})
+	}
+	self.out = append(self.out, PunctuationError{Offset: self.cursor, Line: line, Column: col, Msg: msg, Fix: fix})
+}
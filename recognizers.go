@@ -0,0 +1,120 @@
+package jsonfmt
+
+import "strings"
+
+/*
+Recognizes custom tokens which are treated as opaque atoms, like placeholders,
+even when they contain whitespace or punctuation. See `Conf.Recognizers`.
+*/
+type Recognizer interface {
+	// Returns the length in bytes of the token at the start of the source, or 0
+	// if there's none. Must not exceed the length of the source.
+	Recognize(src string) int
+}
+
+// Function implementing `Recognizer`.
+type RecognizerFunc func(string) int
+
+// Implement `Recognizer`.
+func (self RecognizerFunc) Recognize(src string) int {
+	if self == nil {
+		return 0
+	}
+	return self(src)
+}
+
+/*
+Recognizes calls such as `ISODate("2024-05-01T00:00:00Z")`, `ObjectId("...")`
+or `new Date(2024, 4, 1)`, which appear in dumps of the Mongo shell and in
+JavaScript. The name must be immediately followed by balanced parentheses, and
+may be preceded by `new`. Strings inside the parentheses may contain anything.
+Without names, any name is accepted.
+*/
+func CallRecognizer(names ...string) Recognizer {
+	return RecognizerFunc(func(src string) int { return callLen(src, names) })
+}
+
+func callLen(src string, names []string) int {
+	ind := 0
+	if strings.HasPrefix(src, `new `) {
+		ind = len(`new `)
+		for ind < len(src) && src[ind] == ' ' {
+			ind++
+		}
+	}
+
+	start := ind
+	for ind < len(src) && isNameByte(src[ind]) {
+		ind++
+	}
+	if ind == start || isDigit(src[start]) || ind >= len(src) || src[ind] != '(' {
+		return 0
+	}
+	if len(names) > 0 && !hasString(names, src[start:ind]) {
+		return 0
+	}
+
+	depth := 0
+	for ; ind < len(src); ind++ {
+		switch char := src[ind]; char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return ind + 1
+			}
+		case '"', '\'':
+			ind = quotedEnd(src, ind, char)
+		}
+	}
+	return 0
+}
+
+// Names of calls recognized by `CallRecognizer`, such as `NumberLong` or `Date`.
+func isNameByte(char byte) bool {
+	return isLetter(char) || isDigit(char) || char == '_' || char == '$' || char == '.'
+}
+
+// Offset of the closing quote of the string at the offset, or the end of the source.
+func quotedEnd(src string, ind int, quote byte) int {
+	for ind++; ind < len(src); ind++ {
+		switch src[ind] {
+		case '\\':
+			ind++
+		case quote:
+			return ind
+		}
+	}
+	return len(src)
+}
+
+// Length of a token recognized by `Conf.Recognizers` at the cursor, or 0.
+func (self *fmter) nextToken() int {
+	if len(self.conf.Recognizers) == 0 || !self.more() {
+		return 0
+	}
+
+	rest := self.rest()
+	for _, val := range self.conf.Recognizers {
+		if val == nil {
+			continue
+		}
+		size := val.Recognize(rest)
+		if size > len(rest) {
+			size = len(rest)
+		}
+		if size > 0 {
+			return size
+		}
+	}
+	return 0
+}
+
+// Copies the token recognized at the cursor as-is. See `Conf.Recognizers`.
+func (self *fmter) token() {
+	end := self.cursor + self.nextToken()
+	assert(end > self.cursor)
+	self.writeLines(self.source[self.cursor:end])
+	self.cursor = end
+}
@@ -0,0 +1,175 @@
+package jsonfmt
+
+/*
+Splits the source into records, calling the function with each record, in
+order, until it returns false. If the source consists of a single list,
+possibly surrounded by comments, records are its elements, and the function
+receives `elem = true`. Otherwise records are top-level values, for example
+lines of newline-delimited JSON. Records are slices of the source, including
+the comments which precede them, without separating punctuation. Comments
+after the last record are not included. Records given as bytes share memory
+with the source, but their capacity is limited to their length, so appending
+to them doesn't overwrite the rest of the source.
+
+Uses the same permissive rules as the formatter, but doesn't build a document
+or allocate per record, which makes it suitable for huge inputs, such as
+memory-mapped files. Each record can be formatted separately, for example to
+split a huge list into smaller files.
+*/
+func Records[Src Text](conf Conf, src Src, fun func(rec Src, elem bool) bool) {
+	source := text[string](src)
+	conf.StripComments = false
+
+	fmter := fmter{source: source, conf: conf, discard: true}
+	closer := fmter.recordsStart()
+	elem := closer != 0
+
+	fmter.records(closer, func(start, _, end int) bool {
+		return fun(text[Src](source[start:end]), elem)
+	})
+}
+
+/*
+Positions the scanner before the first record. Returns the closer of the list
+whose elements are records, or 0 when records are top-level values.
+*/
+func (self *fmter) recordsStart() byte {
+	elem := self.isOnlyList()
+	*self = self.fresh()
+	if !elem {
+		return 0
+	}
+
+	for !self.isNextByte('[') {
+		self.skipFiller()
+	}
+	self.skipByte()
+	return ']'
+}
+
+// Same source and config, from the start.
+func (self *fmter) fresh() fmter {
+	return fmter{source: self.source, conf: self.conf, discard: true}
+}
+
+// True if the source consists of a single list and possibly comments.
+func (self *fmter) isOnlyList() bool {
+	for self.more() && !self.isNextByte('[') {
+		if !self.skipFiller() {
+			return false
+		}
+	}
+	if !self.more() {
+		return false
+	}
+
+	self.skipValue()
+	for self.more() {
+		if !self.skipFiller() {
+			return false
+		}
+	}
+	return true
+}
+
+// Skips whitespace, punctuation or a comment. Returns false on anything else.
+func (self *fmter) skipFiller() bool {
+	if self.skipped() {
+		return true
+	}
+	if self.isNextCommentSingle() {
+		self.commentSingle()
+		return true
+	}
+	if self.isNextCommentMulti() {
+		self.commentMulti()
+		return true
+	}
+	return false
+}
+
+/*
+Calls the function with the bounds of each record until the closer or the end
+of the source. Records start at the first preceding comment. The second
+argument is the start of the value itself.
+*/
+func (self *fmter) records(closer byte, fun func(int, int, int) bool) {
+	start := -1
+
+	for self.more() {
+		if closer != 0 && self.isNextByte(closer) {
+			return
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if start < 0 {
+			start = self.cursor
+		}
+		if self.skipFiller() {
+			continue
+		}
+
+		cursor := self.cursor
+		self.skipValue()
+		if self.cursor == cursor {
+			self.skipChar()
+			start = -1
+			continue
+		}
+
+		if !fun(start, cursor, self.cursor) {
+			return
+		}
+		start = -1
+	}
+}
+
+// Skips the next value, including nested dicts and lists, without output.
+func (self *fmter) skipValue() {
+	if self.isNextPlaceholder() {
+		self.placeholder()
+		return
+	}
+	if self.isNextByte('"') {
+		self.string()
+		return
+	}
+	if !self.isNextByte('{') && !self.isNextByte('[') {
+		self.atom()
+		return
+	}
+
+	depth := 0
+	for self.more() {
+		switch {
+		case self.isNextByte('{') || self.isNextByte('['):
+			depth++
+			self.skipByte()
+
+		case self.isNextByte('}') || self.isNextByte(']'):
+			depth--
+			self.skipByte()
+			if depth == 0 {
+				return
+			}
+
+		case self.isNextByte('"'):
+			self.string()
+
+		case self.isNextPlaceholder():
+			self.placeholder()
+
+		case self.isNextCommentSingle():
+			self.commentSingle()
+
+		case self.isNextCommentMulti():
+			self.commentMulti()
+
+		default:
+			self.skipChar()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package jsonfmt
+
+/*
+Reindent mode, see `Conf.Reindent`. Copies the source verbatim, except for the
+leading whitespace of each line, which is replaced according to nesting.
+
+Lines are indented one level deeper than the line which opened the innermost
+dict or list, so that several brackets opened on one line, such as `[{`, add
+only one level. Lines starting with a closing bracket are indented like the
+line which opened it. Multi-line comments and strings are left unchanged.
+*/
+func (self *fmter) reindentTop() {
+	var levels []int
+	start := true
+
+	for self.more() {
+		self.maybeFlush()
+
+		if start {
+			start = false
+			self.skipIndent()
+
+			if self.more() && !self.isNextByte('\n') && !self.isNextByte('\r') {
+				self.indent = reindentLevel(levels, self.isNextByte('}') || self.isNextByte(']'))
+				self.writeIndent()
+			}
+			continue
+		}
+
+		if self.isNextByte('\n') || self.isNextByte('\r') {
+			self.byte()
+			start = true
+			continue
+		}
+
+		if self.isNextByte('{') || self.isNextByte('[') {
+			levels = append(levels, self.indent)
+			self.byte()
+			continue
+		}
+
+		if self.isNextByte('}') || self.isNextByte(']') {
+			if len(levels) > 0 {
+				levels = levels[:len(levels)-1]
+			}
+			self.byte()
+			continue
+		}
+
+		if self.isNextPlaceholder() {
+			self.copied((*fmter).placeholder)
+		} else if self.isNextByte('"') {
+			self.copied((*fmter).string)
+		} else if self.isNextCommentSingle() {
+			self.restOfLine()
+		} else if self.isNextCommentMulti() {
+			self.copied((*fmter).commentMulti)
+		} else {
+			self.char()
+		}
+	}
+}
+
+// Indentation level of a line, given the levels of the lines of open brackets.
+func reindentLevel(levels []int, closer bool) int {
+	if len(levels) == 0 {
+		return 0
+	}
+	if closer {
+		return levels[len(levels)-1]
+	}
+	return levels[len(levels)-1] + 1
+}
+
+// Copies the source until the end of the line, excluding the newline.
+func (self *fmter) restOfLine() {
+	for self.more() && !self.isNextByte('\n') && !self.isNextByte('\r') {
+		self.char()
+	}
+}
+
+// Skips spaces and tabs, but not newlines.
+func (self *fmter) skipIndent() {
+	for self.isNextByte(' ') || self.isNextByte('\t') || self.isNextByte('\v') {
+		self.skipByte()
+	}
+}
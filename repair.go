@@ -0,0 +1,178 @@
+package jsonfmt
+
+/*
+Repair mode, see `Conf.Repair`. Everything except punctuation is copied from
+the source verbatim. Punctuation between values is kept where it's valid,
+inserted right after the preceding value where it's missing, and dropped where
+it's extra. A mismatched closing bracket is replaced with the right one.
+*/
+func (self *fmter) repairTop() {
+	for self.more() {
+		self.maybeFlush()
+
+		if self.isNextSpace() {
+			self.byte()
+			continue
+		}
+
+		if self.isNextPunctuation() || self.isNextByte('}') || self.isNextByte(']') {
+			self.skipByte()
+			continue
+		}
+
+		if !self.scanned((*fmter).repairAny) {
+			self.skipChar()
+		}
+	}
+}
+
+func (self *fmter) repairAny() {
+	if self.isNextPlaceholder() {
+		self.copied((*fmter).placeholder)
+	} else if self.isNextByte('{') {
+		self.repairCollection('}', true)
+	} else if self.isNextByte('[') {
+		self.repairCollection(']', false)
+	} else {
+		self.copied((*fmter).any)
+	}
+}
+
+func (self *fmter) repairCollection(closer byte, dict bool) {
+	self.nest()
+	defer self.unnest()
+
+	self.byte()
+	key := true
+	var pending byte
+
+	for self.more() {
+		self.maybeFlush()
+
+		if self.isNextByte(closer) {
+			self.byte()
+			return
+		}
+
+		if self.isNextSpace() {
+			self.byte()
+			continue
+		}
+
+		if self.isNextPunctuation() {
+			if self.isNextByte(pending) {
+				self.byte()
+				pending = 0
+			} else {
+				self.skipByte()
+			}
+			continue
+		}
+
+		// Mismatched closer: most likely a typo, rather than a missing closer.
+		if self.isNextByte('}') || self.isNextByte(']') {
+			self.skipByte()
+			self.writeByte(closer)
+			return
+		}
+
+		if self.isNextComment() {
+			self.copied((*fmter).any)
+			continue
+		}
+
+		if dict && key && self.isNextDirective() {
+			self.copied((*fmter).placeholder)
+			continue
+		}
+
+		if !self.scanned((*fmter).repairAny) {
+			self.skipChar()
+			continue
+		}
+
+		var punct byte
+		if dict && key {
+			punct = ':'
+		} else if self.hasValueBefore() {
+			punct = ','
+		}
+		if dict {
+			key = !key
+		}
+
+		pending = 0
+		if punct != 0 {
+			if self.hasPunctuationBefore(punct) {
+				pending = punct
+			} else {
+				self.writeByte(punct)
+			}
+		}
+	}
+}
+
+// Scans without output, then writes the scanned source text unchanged.
+func (self *fmter) copied(fun func(*fmter)) {
+	start := self.cursor
+	prev := self.discard
+	self.discard = true
+	fun(self)
+	self.discard = prev
+	self.writeString(self.source[start:self.cursor])
+}
+
+/*
+True if the given punctuation follows, possibly after whitespace, comments and
+other punctuation.
+*/
+func (self *fmter) hasPunctuationBefore(char byte) bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	for self.more() {
+		if self.isNextByte(char) {
+			return true
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			self.any()
+			continue
+		}
+
+		return false
+	}
+	return false
+}
+
+// True if another value follows before the end of the current dict or list.
+func (self *fmter) hasValueBefore() bool {
+	defer self.reset(self.mark())
+
+	defer self.setDiscard(self.discard)
+	self.discard = true
+
+	for self.more() {
+		if self.isNextByte('}') || self.isNextByte(']') {
+			return false
+		}
+
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			self.any()
+			continue
+		}
+
+		return true
+	}
+	return false
+}
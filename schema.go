@@ -0,0 +1,266 @@
+package jsonfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Returns a copy of the config with layout hints taken from a JSON Schema, so
+that one schema defines both validation and presentation of config files.
+
+The order of "properties" becomes `Conf.KeyOrder` for the matching dicts.
+Properties with "propertyOrder", a common editor annotation, come first, sorted
+by it. The "x-jsonfmt" annotation of a subschema may override the order via
+"order", a list of keys; force matching dicts and lists to be multi-line via
+"expand": true; and set other layout settings via "conf", an object like in
+`Override.Conf`.
+
+Subschemas are found via "properties", "items", "additionalProperties",
+"patternProperties", "allOf", "anyOf", "oneOf", and local "$ref" such as
+"#/$defs/server". Recursive references are followed once. Paths already
+present in `Conf.KeyOrder` are kept. Overrides from the schema come before
+`Conf.Overrides`, which take precedence. Other keywords, such as "title",
+don't affect layout.
+*/
+func SchemaLayout[Src Text](conf Conf, src Src) (_ Conf, err error) {
+	defer recoverErr(&err)
+
+	// Copied, since keys of the schema end up in the config.
+	root := parse(Default, string(src))
+	if len(root.Items) != 1 || root.Items[0].Kind != KindDict {
+		return conf, fmt.Errorf(`[jsonfmt] invalid schema: expected a single dict`)
+	}
+
+	walker := schemaWalker{
+		root:     root.Items[0],
+		order:    map[string][]string{},
+		visiting: map[*Node]bool{},
+	}
+	walker.walk(walker.root, ``)
+
+	for _, val := range walker.overrides {
+		_, err := val.segments()
+		if err != nil {
+			return conf, fmt.Errorf(`[jsonfmt] invalid schema: %w`, err)
+		}
+	}
+
+	conf = conf.clone()
+	if conf.KeyOrder == nil && len(walker.order) > 0 {
+		conf.KeyOrder = map[string][]string{}
+	}
+	for path, keys := range walker.order {
+		if _, ok := conf.KeyOrder[path]; !ok {
+			conf.KeyOrder[path] = keys
+		}
+	}
+
+	var overrides []Override
+	for _, val := range walker.overrides {
+		if !hasOverride(conf.Overrides, val) {
+			overrides = append(overrides, val)
+		}
+	}
+	conf.Overrides = append(overrides, conf.Overrides...)
+	return conf, nil
+}
+
+// Collects layout hints from a schema document. See `SchemaLayout`.
+type schemaWalker struct {
+	root      *Node
+	order     map[string][]string
+	overrides []Override
+	visiting  map[*Node]bool
+}
+
+func (self *schemaWalker) walk(node *Node, path string) {
+	node = self.resolve(node)
+	if node == nil || self.visiting[node] {
+		return
+	}
+	self.visiting[node] = true
+	defer delete(self.visiting, node)
+
+	hints := schemaField(node, `x-jsonfmt`)
+	keys := self.properties(node, nil, map[*Node]bool{})
+	if order := schemaField(hints, `order`); order != nil {
+		keys = schemaStrings(order)
+	}
+	if len(keys) > 0 && self.order[path] == nil {
+		self.order[path] = keys
+	}
+
+	if val := schemaField(hints, `expand`); val != nil && val.Text == `true` {
+		self.override(path, `{"width":0}`)
+	}
+	if val := schemaField(hints, `conf`); val != nil && val.Kind == KindDict {
+		self.override(path, Format[string](Compact, val.String()))
+	}
+
+	for _, entry := range schemaEntries(schemaField(node, `properties`)) {
+		self.walk(entry.Val, path+`/`+escapePath(entry.Key.keyText()))
+	}
+	for _, entry := range schemaEntries(schemaField(node, `patternProperties`)) {
+		self.walk(entry.Val, path+`/*`)
+	}
+	self.walk(schemaField(node, `additionalProperties`), path+`/*`)
+
+	items := schemaField(node, `items`)
+	if items != nil && items.Kind == KindList {
+		for ind, val := range items.Items {
+			self.walk(val, path+`/`+strconv.Itoa(ind))
+		}
+	} else {
+		self.walk(items, path+`/*`)
+	}
+
+	for _, key := range []string{`allOf`, `anyOf`, `oneOf`} {
+		if val := schemaField(node, key); val != nil {
+			for _, val := range val.Items {
+				self.walk(val, path)
+			}
+		}
+	}
+}
+
+/*
+Keys of "properties", including those of "allOf" subschemas, in order of
+declaration, then stably sorted by "propertyOrder".
+*/
+func (self *schemaWalker) properties(node *Node, out []string, seen map[*Node]bool) []string {
+	node = self.resolve(node)
+	if node == nil || seen[node] {
+		return out
+	}
+	seen[node] = true
+
+	start := len(out)
+	for _, entry := range schemaEntries(schemaField(node, `properties`)) {
+		key := entry.Key.keyText()
+		if !hasString(out, key) {
+			out = append(out, key)
+		}
+	}
+
+	props := schemaField(node, `properties`)
+	tail := out[start:]
+	sort.SliceStable(tail, func(one, two int) bool {
+		return propertyOrder(props, tail[one]) < propertyOrder(props, tail[two])
+	})
+
+	if val := schemaField(node, `allOf`); val != nil {
+		for _, val := range val.Items {
+			out = self.properties(val, out, seen)
+		}
+	}
+	return out
+}
+
+// Follows local references such as "#/$defs/server". Others are ignored.
+func (self *schemaWalker) resolve(node *Node) *Node {
+	for depth := 0; node != nil && node.Kind == KindDict; depth++ {
+		ref := schemaField(node, `$ref`)
+		if ref == nil || depth > 64 {
+			return node
+		}
+
+		path := ref.keyText()
+		if !strings.HasPrefix(path, `#`) {
+			return node
+		}
+		segments, err := pathSegments(path[1:])
+		if err != nil {
+			return nil
+		}
+		node = schemaAt(self.root, segments)
+	}
+	return node
+}
+
+func (self *schemaWalker) override(path, conf string) {
+	val := Override{Path: path, Conf: json.RawMessage(conf)}
+	if !hasOverride(self.overrides, val) {
+		self.overrides = append(self.overrides, val)
+	}
+}
+
+// Value of the given key in a dict, or nil.
+func schemaField(node *Node, key string) *Node {
+	for _, entry := range schemaEntries(node) {
+		if entry.Key.keyText() == key {
+			return entry.Val
+		}
+	}
+	return nil
+}
+
+// Entries of a dict which have values.
+func schemaEntries(node *Node) []Entry {
+	if node == nil || node.Kind != KindDict {
+		return nil
+	}
+	var out []Entry
+	for _, entry := range node.Entries {
+		if entry.Val != nil {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func schemaStrings(node *Node) []string {
+	var out []string
+	for _, val := range node.Items {
+		if val.Kind == KindString {
+			out = append(out, val.keyText())
+		}
+	}
+	return out
+}
+
+// Node at the given path, where segments are keys or list indexes, or nil.
+func schemaAt(node *Node, path []string) *Node {
+	for _, seg := range path {
+		switch {
+		case node == nil:
+			return nil
+		case node.Kind == KindDict:
+			node = schemaField(node, seg)
+		case node.Kind == KindList:
+			ind, err := strconv.Atoi(seg)
+			if err != nil || ind < 0 || ind >= len(node.Items) {
+				return nil
+			}
+			node = node.Items[ind]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// "propertyOrder" of the given property, or a large default like in editors.
+func propertyOrder(props *Node, key string) float64 {
+	val := schemaField(schemaField(props, key), `propertyOrder`)
+	if val != nil && val.Kind == KindAtom {
+		out, err := strconv.ParseFloat(val.Text, 64)
+		if err == nil {
+			return out
+		}
+	}
+	return 1000
+}
+
+func hasOverride(list []Override, val Override) bool {
+	for _, elem := range list {
+		if elem.Path == val.Path && bytes.Equal(elem.Conf, val.Conf) {
+			return true
+		}
+	}
+	return false
+}
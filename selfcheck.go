@@ -0,0 +1,69 @@
+package jsonfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Returned or panicked when the output fails `Conf.SelfCheck`.
+var ErrSelfCheck = errors.New(`[jsonfmt] self-check failed`)
+
+// Overrides settings which would produce invalid JSON. See `Conf.StrictOutput`.
+func (self Conf) strict() Conf {
+	self.StripComments = true
+	self.KeepComments = nil
+	self.KeepRegexp = ``
+	self.TrailingComma = false
+	self.CommentsFromKey = ``
+	if self.Separator == SeparatorRS {
+		self.Separator = SeparatorNewline
+	}
+	return self
+}
+
+/*
+Checks the invariants of the output, see `Conf.SelfCheck`. Must be called
+before anything is flushed.
+*/
+func (self *fmter) selfCheck() {
+	out := self.buf.Bytes()
+
+	if self.conf.StrictOutput && !isValidJSON(out) {
+		panic(fmterErr{fmt.Errorf(`%w: output is not valid JSON`, ErrSelfCheck)})
+	}
+
+	conf := self.conf
+	conf.SelfCheck = false
+	conf.MaxOutputBytes = 0
+
+	// The output is already a single list.
+	if conf.Separator == SeparatorArray {
+		conf.Separator = SeparatorDefault
+	}
+
+	again := fmter{source: transform(conf, fixNoise(conf, string(out))), conf: conf}
+	again.overrides = overrides(conf, again.source)
+	again.top()
+
+	if !bytes.Equal(out, again.buf.Bytes()) {
+		panic(fmterErr{fmt.Errorf(`%w: formatting the output again changes it`, ErrSelfCheck)})
+	}
+}
+
+// Like `json.Valid`, but allows a sequence of values, such as NDJSON.
+func isValidJSON(src []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	for {
+		var val json.RawMessage
+		err := dec.Decode(&val)
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
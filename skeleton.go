@@ -0,0 +1,32 @@
+package jsonfmt
+
+import "strconv"
+
+/*
+Replaces strings and atoms with placeholders of their types, such as
+"<string>" or "<number>", and samples lists and top-level values, keeping only
+the first element, followed by a placeholder with the number of the others,
+such as "<99 more>". Dict keys are kept. See `Conf.Skeleton`.
+*/
+func (self *Node) skeleton() {
+	switch self.Kind {
+	case KindTop, KindList:
+		if len(self.Items) > 1 {
+			more := &Node{Kind: KindString, Text: `"<` + strconv.Itoa(len(self.Items)-1) + ` more>"`}
+			self.Items = append(self.Items[:1:1], more)
+		}
+		if len(self.Items) > 0 {
+			self.Items[0].skeleton()
+		}
+
+	case KindDict:
+		for _, val := range self.Entries {
+			if val.Val != nil {
+				val.Val.skeleton()
+			}
+		}
+
+	case KindString, KindAtom:
+		self.Text = `"<` + self.Type().String() + `>"`
+	}
+}
@@ -0,0 +1,117 @@
+package jsonfmt
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Replaces typographic quotes around keys and values with ASCII quotes, and
+Unicode spaces between values with ASCII spaces. See `Conf.FixSmartQuotes`.
+Strings, comments and placeholders are copied as-is. Sources without non-ASCII
+characters are returned unchanged without scanning.
+*/
+func fixSmartQuotes(conf Conf, src string) string {
+	if !hasNonASCII(src) {
+		return src
+	}
+
+	scan := fmter{source: src, conf: conf, discard: true}
+	scan.conf.Atoms = StrictnessPermissive
+	var buf strings.Builder
+	last := 0
+
+	for scan.more() {
+		switch {
+		case scan.isNextComment():
+			scan.parseComment()
+		case scan.isNextPlaceholder():
+			scan.placeholder()
+		case scan.isNextByte('"'):
+			scan.string()
+		default:
+			start := scan.cursor
+			char, size := utf8.DecodeRuneInString(scan.rest())
+
+			if end := smartStringEnd(src, start, char); end >= 0 {
+				_, closer := utf8.DecodeLastRuneInString(src[:end])
+				buf.WriteString(src[last:start])
+				buf.WriteByte('"')
+				buf.WriteString(strings.ReplaceAll(src[start+size:end-closer], `"`, `\"`))
+				buf.WriteByte('"')
+				scan.cursor = end
+				last = end
+				continue
+			}
+
+			if char >= utf8.RuneSelf && unicode.IsSpace(char) {
+				buf.WriteString(src[last:start])
+				buf.WriteByte(separator)
+				last = start + size
+			}
+			scan.skipChar()
+		}
+	}
+
+	if last == 0 {
+		return src
+	}
+	buf.WriteString(src[last:])
+	return buf.String()
+}
+
+func hasNonASCII(src string) bool {
+	for ind := 0; ind < len(src); ind++ {
+		if src[ind] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Offset after the closing quote of the string opened by the typographic quote at
+the offset, or -1 when there's no such string. The closing quote must be
+followed by punctuation, a bracket, another quote, or the end of the line, so
+that apostrophes, as in ‘don’t’, don't end the string. Strings don't span
+lines.
+*/
+func smartStringEnd(src string, start int, opener rune) int {
+	var closer rune
+	switch opener {
+	case '“':
+		closer = '”'
+	case '‘':
+		closer = '’'
+	default:
+		return -1
+	}
+
+	ind := start + utf8.RuneLen(opener)
+	for ind < len(src) {
+		char, size := utf8.DecodeRuneInString(src[ind:])
+		ind += size
+
+		if char == '\n' || char == '\r' {
+			return -1
+		}
+		if char == closer && isSmartStringEnd(src[ind:]) {
+			return ind
+		}
+	}
+	return -1
+}
+
+func isSmartStringEnd(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t")
+	if rest == `` {
+		return true
+	}
+	switch rest[0] {
+	case ',', ':', '{', '}', '[', ']', '"', '\n', '\r':
+		return true
+	default:
+		return strings.HasPrefix(rest, `“`) || strings.HasPrefix(rest, `‘`)
+	}
+}
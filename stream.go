@@ -0,0 +1,208 @@
+package jsonfmt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Amount of source read at once by `NewReader`.
+const readSize = 1 << 15
+
+var errClosed = errors.New(`[jsonfmt] write to closed writer`)
+
+/*
+Returns a reader which formats the given source on the fly, as the output is
+consumed. For example, this allows to pretty-print an HTTP response body while
+streaming it to a file or terminal. Errors of the source and of formatting,
+such as `ErrMaxDepth`, are returned from `Read`.
+
+Formatting a value requires its entire source, so each top-level value is
+formatted once it's been read entirely, and internal buffering is bounded by
+the largest top-level value and its output. For a single document, this means
+the entire document, but for newline-delimited JSON, only one line. Settings
+which restructure the entire source, such as `Conf.SortKeys`, `SeparatorArray`,
+`Conf.Repair`, `Conf.Reindent` and `Conf.SelfCheck`, make the reader buffer the
+entire source and output. The output is the same as with `Format`.
+*/
+func NewReader(conf Conf, src io.Reader) io.Reader {
+	out := &reader{src: src}
+	out.stream = newStream(conf, &out.ready)
+	return out
+}
+
+/*
+Returns a writer which accepts JSON written in arbitrary chunks, and writes
+formatted output to the given writer, as soon as top-level values are complete.
+Buffering is the same as in `NewReader`. `Close` formats the remaining source
+and must be called at the end. It doesn't close the underlying writer. Errors
+of the underlying writer and of formatting are returned from `Write` and
+`Close`, and once an error occurs, all following calls return it.
+*/
+func NewWriter(conf Conf, out io.Writer) io.WriteCloser {
+	return &writer{stream: newStream(conf, out)}
+}
+
+type reader struct {
+	stream
+	src   io.Reader
+	ready bytes.Buffer
+	err   error
+}
+
+func (self *reader) Read(buf []byte) (int, error) {
+	for self.ready.Len() == 0 && self.err == nil {
+		self.err = self.fill()
+	}
+	if self.ready.Len() > 0 {
+		return self.ready.Read(buf)
+	}
+	return 0, self.err
+}
+
+/*
+Reads more of the source and formats what's complete. Returns `io.EOF` once
+everything has been formatted.
+*/
+func (self *reader) fill() (err error) {
+	defer recoverErr(&err)
+
+	start := len(self.pending)
+	self.pending = append(self.pending, make([]byte, readSize)...)
+	count, err := self.src.Read(self.pending[start:])
+	self.pending = self.pending[:start+count]
+
+	if errors.Is(err, io.EOF) {
+		self.finish()
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	self.advance()
+	return nil
+}
+
+type writer struct {
+	stream
+	err error
+}
+
+func (self *writer) Write(src []byte) (int, error) {
+	if self.err == nil {
+		self.err = self.write(src)
+	}
+	if self.err != nil {
+		return 0, self.err
+	}
+	return len(src), nil
+}
+
+func (self *writer) write(src []byte) (err error) {
+	defer recoverErr(&err)
+	self.pending = append(self.pending, src...)
+	self.advance()
+	return
+}
+
+func (self *writer) Close() error {
+	if self.err != nil {
+		return self.err
+	}
+	err := self.close()
+	if err != nil {
+		self.err = err
+		return err
+	}
+	self.err = errClosed
+	return nil
+}
+
+func (self *writer) close() (err error) {
+	defer recoverErr(&err)
+	self.finish()
+	return
+}
+
+/*
+Shared by `NewReader` and `NewWriter`. Accumulates pending source, and formats
+the top-level values which are certainly complete, continuing the state of the
+previous output, as if the entire source was formatted at once.
+*/
+type stream struct {
+	fmter   fmter
+	pending []byte
+	whole   bool
+	next    int
+}
+
+func newStream(conf Conf, out io.Writer) stream {
+	if conf.StrictOutput {
+		conf = conf.strict()
+	}
+
+	whole := conf.restructures() || conf.Separator == SeparatorArray ||
+		conf.Repair || conf.Reindent || conf.SelfCheck
+
+	return stream{fmter: fmter{conf: conf, out: out}, whole: whole}
+}
+
+/*
+Formats the complete values among the pending source. Scans only once the
+pending source has doubled since the last scan, so that scanning the same
+incomplete value again and again takes amortized linear time.
+*/
+func (self *stream) advance() {
+	if self.whole || len(self.pending) < self.next {
+		return
+	}
+
+	end := self.boundary()
+	if end > 0 {
+		self.format(end)
+	}
+	self.next = len(self.pending) * 2
+}
+
+// Formats the remaining source at the end.
+func (self *stream) finish() {
+	if self.whole {
+		format(nil, self.fmter.conf, self.fmter.out, string(self.pending))
+	} else {
+		self.format(len(self.pending))
+	}
+	self.pending = nil
+}
+
+/*
+Offset after the last top-level value which is followed by something else in
+the pending source, or 0. The value at the very end may continue in the
+source which hasn't been received yet.
+*/
+func (self *stream) boundary() int {
+	source := text[string](self.pending)
+	scan := fmter{source: source, conf: self.fmter.conf, discard: true}
+	scan.conf.StripComments = false
+	end := 0
+
+	scan.records(0, func(_, _, next int) bool {
+		if next < len(source) {
+			end = next
+		}
+		return true
+	})
+	return end
+}
+
+func (self *stream) format(end int) {
+	fmter := &self.fmter
+	fmter.conf = fmter.conf.lineEnding(string(self.pending[:end])).layoutVersion()
+	fmter.source = string(self.pending[:end])
+	checkPunctuation(fmter.conf, fmter.source)
+	fmter.overrides = overrides(fmter.conf, fmter.source)
+	fmter.cursor = 0
+	fmter.comments = nil
+	fmter.top()
+	fmter.flush()
+	self.pending = self.pending[:copy(self.pending, self.pending[end:])]
+}
@@ -0,0 +1,10 @@
+//go:build jsonfmt_safe
+
+package jsonfmt
+
+/*
+Conversion between two text types without "unsafe", for environments which
+forbid it. Unlike the default version, conversions between strings and bytes
+copy the text.
+*/
+func text[Out, Src Text](src Src) Out { return Out(src) }
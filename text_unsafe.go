@@ -0,0 +1,26 @@
+//go:build !jsonfmt_safe
+
+package jsonfmt
+
+import "unsafe"
+
+/*
+Allocation-free conversion between two text types. The output shares memory
+with the input. Strings converted to bytes must not be mutated, unless they
+were allocated by this package and aren't referenced elsewhere, as with the
+outputs of `Format` and `Merge3`. Build with the tag "jsonfmt_safe" to copy
+instead, without "unsafe".
+*/
+func text[Out, Src Text](src Src) (out Out) {
+	// Bytes to string, or same kinds: the headers share their layout up to the
+	// length, and the capacity of bytes isn't needed.
+	if unsafe.Sizeof(src) >= unsafe.Sizeof(out) {
+		return *(*Out)(unsafe.Pointer(&src))
+	}
+
+	// String to bytes: the string header has no capacity, which must not be read
+	// from the memory after it.
+	str := *(*string)(unsafe.Pointer(&src))
+	buf := unsafe.Slice(unsafe.StringData(str), len(str))
+	return *(*Out)(unsafe.Pointer(&buf))
+}
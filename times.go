@@ -0,0 +1,67 @@
+package jsonfmt
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+Formats of timestamps recognized by `Conf.NormalizeTimes`, in order of
+preference. Timestamps without a zone are assumed to be in UTC. Go also accepts
+fractional seconds after the seconds in each of these.
+*/
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	`2006-01-02T15:04:05Z0700`,
+	`2006-01-02 15:04:05Z07:00`,
+	`2006-01-02 15:04:05Z0700`,
+	`2006-01-02 15:04:05 -0700`,
+	`2006-01-02T15:04:05`,
+	`2006-01-02 15:04:05`,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	time.RubyDate,
+	time.UnixDate,
+	time.ANSIC,
+}
+
+/*
+Rewrites a string containing a timestamp in one of `timeLayouts` into RFC 3339,
+keeping its offset and fractional seconds. Other nodes are left as-is.
+*/
+func (self *Node) normalizeTime() {
+	if self.Kind != KindString {
+		return
+	}
+
+	out, ok := normalizeTime(self.keyText())
+	if ok {
+		self.Text = `"` + out + `"`
+	}
+}
+
+func normalizeTime(src string) (string, bool) {
+	src = strings.TrimSpace(src)
+
+	for _, layout := range timeLayouts {
+		inst, err := time.Parse(layout, src)
+		if err != nil {
+			continue
+		}
+
+		// For abbreviations other than UTC, `time.Parse` makes up a zone with a
+		// zero offset, unless the abbreviation matches the local zone, which
+		// would make the result depend on the machine.
+		if strings.Contains(layout, `MST`) {
+			name, _ := inst.Zone()
+			if name != `UTC` && name != `GMT` {
+				return ``, false
+			}
+		}
+		return inst.Format(time.RFC3339Nano), true
+	}
+	return ``, false
+}
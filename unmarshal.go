@@ -0,0 +1,120 @@
+package jsonfmt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Returned by `UnmarshalSingle`, wrapped in `*UnmarshalError`.
+var ErrLeftover = errors.New(`unexpected content after the first value`)
+
+/*
+Error returned by `Unmarshal` when decoding fails at a known position, such as
+a syntax error or a type mismatch. The position refers to the original source,
+before formatting, and counts bytes. Lines and columns start at 1. Use
+`errors.As` to get the position, and `errors.Unwrap` to get the original error
+from "encoding/json".
+*/
+type UnmarshalError struct {
+	Offset int
+	Line   int
+	Column int
+	Err    error
+}
+
+func (self *UnmarshalError) Error() string {
+	return fmt.Sprintf(`[jsonfmt] failed to unmarshal at line %v, column %v: %v`, self.Line, self.Column, self.Err)
+}
+
+func (self *UnmarshalError) Unwrap() error { return self.Err }
+
+// Position of the error.
+func (self *UnmarshalError) Pos() Pos { return Pos{self.Offset, self.Line, self.Column} }
+
+/*
+Translates the offset of a decoding error, which refers to the formatted
+output, to the source offset from which the offending byte was written.
+Errors without offsets are returned unchanged.
+*/
+func unmarshalError(src string, offsets []int, err error) error {
+	pos, ok := errorOffset(err)
+	if !ok {
+		return err
+	}
+
+	// Offsets are past the offending byte.
+	offset := len(src)
+	if pos > 0 && int(pos) <= len(offsets) {
+		offset = offsets[pos-1]
+	}
+
+	line, col := lineCol(src, offset)
+	return &UnmarshalError{Offset: offset, Line: line, Column: col, Err: err}
+}
+
+// Offset of a decoding error in the decoded text, if any.
+func errorOffset(err error) (int64, bool) {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+
+	if errors.As(err, &syntax) {
+		return syntax.Offset, true
+	}
+	if errors.As(err, &typ) {
+		return typ.Offset, true
+	}
+	return 0, false
+}
+
+// 1-based line and column of the byte offset. Columns count bytes.
+func lineCol(src string, pos int) (int, int) {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	head := src[:pos]
+	return strings.Count(head, "\n") + 1, pos - strings.LastIndexByte(head, '\n')
+}
+
+/*
+Source offset of the first content after the first top-level value, or -1.
+Skips the same content as `fmter.top` before the first value.
+*/
+func (self *fmter) leftover() int {
+	found := false
+
+	for self.more() {
+		if self.skipped() {
+			continue
+		}
+
+		if self.isNextComment() {
+			assert(self.scannedAny())
+			continue
+		}
+
+		if found {
+			return self.cursor
+		}
+
+		if self.isNextByte('}') || self.isNextByte(']') {
+			self.skipChar()
+			continue
+		}
+
+		if self.scannedAny() {
+			found = true
+			continue
+		}
+
+		self.skipChar()
+	}
+	return -1
+}
+
+func (self *fmter) truncateOffsets() {
+	if self.track {
+		self.offsets = self.offsets[:self.buf.Len()]
+	}
+}
@@ -0,0 +1,302 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Category of `Warning`.
+type WarningKind string
+
+const (
+	// Dict key which occurs earlier in the same dict. Decoders typically keep
+	// the last occurrence, silently dropping the others.
+	WarningDuplicateKey WarningKind = `duplicate-key`
+
+	// Atom which is not valid JSON, such as `undefined`, `NaN` or `0x10`.
+	// Reported only when `Conf.Atoms` is not `StrictnessPermissive`. Dict keys
+	// followed by a colon are exempt.
+	WarningAtom WarningKind = `atom`
+
+	// String or atom which exceeds `Conf.Width` even on its own line, since the
+	// formatter never breaks strings or atoms.
+	WarningWidth WarningKind = `width`
+
+	// Comment whose style, line or block, differs from the first comment in the
+	// source, while `Conf.CommentStyle` keeps comment styles as-is.
+	WarningMixedComments WarningKind = `mixed-comments`
+
+	// String or atom which looks like a number formatted for a locale, with
+	// a decimal comma or digit grouping, such as "1,5", "1.234,56" or
+	// 1.234.567. Typical for data exported from spreadsheets, which decoders
+	// read as strings or reject. Dict keys are exempt.
+	WarningLocaleNumber WarningKind = `locale-number`
+)
+
+/*
+Non-fatal problem found by `Warnings`. The position refers to the source, and
+counts bytes. Lines and columns start at 1.
+*/
+type Warning struct {
+	Kind   WarningKind
+	Offset int
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Position of the problem.
+func (self Warning) Pos() Pos { return Pos{self.Offset, self.Line, self.Column} }
+
+func (self Warning) String() string {
+	return fmt.Sprintf(`line %v, column %v: %v`, self.Line, self.Column, self.Msg)
+}
+
+/*
+Reports non-fatal problems in the source, which don't prevent formatting, in
+the order of their positions. See `WarningKind` for the categories. Uses the
+same permissive rules as the formatter: broken punctuation is not reported,
+since formatting fixes it. Like `Format`, panics on hard errors such as
+`ErrMaxDepth`, which can be checked separately via `FormatTo` or `FormatCtx`.
+Document-level transformations such as `Conf.SortKeys` are ignored, so that
+positions refer to the given source.
+*/
+func Warnings[Src Text](conf Conf, src Src) []Warning {
+	defer repanicErr()
+	return warnings(conf, text[string](src))
+}
+
+func warnings(conf Conf, src string) []Warning {
+	atoms := conf.Atoms
+	conf.StripComments = false
+	conf.Atoms = StrictnessPermissive
+
+	warner := warner{fmter: fmter{source: src, conf: conf, discard: true}, atoms: atoms}
+	warner.top()
+	return warner.out
+}
+
+/*
+Walks the source like `fmter.parseTop`, tracking the columns at which values
+would start in multi-line output, for `WarningWidth`.
+*/
+type warner struct {
+	fmter
+	out   []Warning
+	style string // Style of the first comment: "line" or "block".
+	atoms Strictness
+	key   bool // True while walking a dict key.
+}
+
+func (self *warner) top() {
+	for self.more() {
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.comment()
+			continue
+		}
+		if !self.elem(0, 0) {
+			self.skipChar()
+		}
+	}
+}
+
+// Returns false when nothing was consumed.
+func (self *warner) elem(indent, col int) bool {
+	start := self.cursor
+
+	switch {
+	case self.isNextPlaceholder():
+		self.placeholder()
+		return true
+	case self.isNextByte('{'):
+		self.dict(indent)
+		return true
+	case self.isNextByte('['):
+		self.list(indent)
+		return true
+	case self.isNextByte('"'):
+		self.string()
+		self.checkLocaleString(start)
+	default:
+		self.atom()
+		if self.atoms != StrictnessPermissive && self.cursor > start && !self.isKnownAtom(start) {
+			self.warn(WarningAtom, start, `%v`, unknownAtomMsg(self.source[start:self.cursor]))
+		}
+		self.checkLocaleAtom(start)
+	}
+
+	if self.cursor == start {
+		return false
+	}
+	self.checkWidth(start, col)
+	return true
+}
+
+func (self *warner) dict(indent int) {
+	self.nest()
+	defer self.unnest()
+	self.skipByte()
+
+	keys := map[string]bool{}
+	key := true
+	keyWidth := 0
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.skipByte()
+			return
+		}
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.comment()
+			continue
+		}
+		if key && self.isNextDirective() {
+			self.placeholder()
+			continue
+		}
+
+		start := self.cursor
+		col := self.indentWidth(indent + 1)
+		if !key && !self.conf.BreakAfterKey {
+			col += keyWidth + self.colonWidth()
+		}
+		self.key = key
+		ok := self.elem(indent+1, col)
+		self.key = false
+		if !ok {
+			self.skipChar()
+			continue
+		}
+
+		if key {
+			node := Node{Kind: KindAtom, Text: self.source[start:self.cursor]}
+			if self.source[start] == '"' {
+				node.Kind = KindString
+			}
+			name := node.keyText()
+			if keys[name] {
+				self.warn(WarningDuplicateKey, start, `duplicate key %q`, name)
+			}
+			keys[name] = true
+			keyWidth = utf8.RuneCountInString(node.Text)
+		}
+		key = !key
+	}
+}
+
+func (self *warner) list(indent int) {
+	self.nest()
+	defer self.unnest()
+	self.skipByte()
+
+	for self.more() {
+		if self.isNextCloser() {
+			self.skipByte()
+			return
+		}
+		if self.skipped() {
+			continue
+		}
+		if self.isNextComment() {
+			self.comment()
+			continue
+		}
+		if !self.elem(indent+1, self.indentWidth(indent+1)) {
+			self.skipChar()
+		}
+	}
+}
+
+func (self *warner) comment() {
+	start := self.cursor
+	style := string(CommentStyleBlock)
+	if self.isNextCommentSingle() {
+		style = string(CommentStyleLine)
+	}
+	self.parseComment()
+
+	if self.conf.CommentStyle != CommentStyleKeep {
+		return
+	}
+	if self.style == `` {
+		self.style = style
+	} else if self.style != style {
+		self.warn(WarningMixedComments, start, `%v comment in a source which starts with %v comments`, style, self.style)
+	}
+}
+
+/*
+Numbers formatted for a locale, such as "1,5", "1.234,56", "1 234" or
+"1,234.56". Strings of digits with dots and without commas, such as "1.234"
+or "192.168.100.200", are fine: they're either valid decimals or something
+other than numbers.
+*/
+var localeNumberString = regexp.MustCompile(
+	`^[-+]?(\d+,\d+|\d{1,3}([.' \x{a0}\x{202f}]\d{3})+,\d+|\d{1,3}(,\d{3})+\.\d+|\d{1,3}([,' \x{a0}\x{202f}]\d{3})+)$`,
+)
+
+/*
+Atoms such as 1.234.567. Atoms end at commas, so decimal commas can't be
+detected: 1,5 is two numbers.
+*/
+var localeNumberAtom = regexp.MustCompile(`^[-+]?\d{1,3}(\.\d{3}){2,}$`)
+
+func (self *warner) checkLocaleString(start int) {
+	if self.key || self.cursor == start {
+		return
+	}
+	text := (&Node{Kind: KindString, Text: self.source[start:self.cursor]}).keyText()
+	if localeNumberString.MatchString(text) {
+		self.warn(WarningLocaleNumber, start, `string %q looks like a number formatted for a locale`, text)
+	}
+}
+
+func (self *warner) checkLocaleAtom(start int) {
+	if self.key || self.cursor == start {
+		return
+	}
+	text := self.source[start:self.cursor]
+	if localeNumberAtom.MatchString(text) {
+		self.warn(WarningLocaleNumber, start, `%v looks like a number formatted for a locale`, text)
+	}
+}
+
+// Strings and atoms which don't fit even on their own line.
+func (self *warner) checkWidth(start, col int) {
+	if self.conf.Width == 0 || !self.whitespace() {
+		return
+	}
+	width := col + utf8.RuneCountInString(self.source[start:self.cursor])
+	if width > int(self.conf.Width) {
+		self.warn(WarningWidth, start, `value ends at column %v, past the width of %v`, width, self.conf.Width)
+	}
+}
+
+func (self *warner) indentWidth(indent int) int {
+	return indent * utf8.RuneCountInString(self.conf.Indent)
+}
+
+func (self *warner) colonWidth() int {
+	if self.conf.Colon != `` {
+		return utf8.RuneCountInString(self.conf.Colon)
+	}
+	return len(`: `)
+}
+
+func (self *warner) warn(kind WarningKind, pos int, msg string, args ...any) {
+	line, col := lineCol(self.source, pos)
+	self.out = append(self.out, Warning{
+		Kind:   kind,
+		Offset: pos,
+		Line:   line,
+		Column: col,
+		Msg:    fmt.Sprintf(msg, args...),
+	})
+}
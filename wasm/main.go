@@ -0,0 +1,93 @@
+//go:build js && wasm
+
+/*
+WebAssembly build of jsonfmt for browsers, web playgrounds and web extensions.
+Defines a global "jsonfmt" object with one function:
+
+	jsonfmt.format(conf, text)
+
+The config may be null or undefined for `jsonfmt.Default`, a preset name such as
+"jsonc", a compact config string such as "indent=2,width=100,trailing-comma",
+or an object with the fields of `jsonfmt.Conf`, such as {width: 100}, applied on
+top of `jsonfmt.Default`. Returns the formatted text, or an `Error` when the
+config is invalid or formatting fails, for example on broken punctuation with
+"strict-punctuation"; exceptions can't be thrown from Go, and a panic would
+stop the runtime.
+
+Build:
+
+	GOOS=js GOARCH=wasm go build -o jsonfmt.wasm ./wasm
+	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" .
+
+Before Go 1.24, "wasm_exec.js" is in "misc/wasm" instead of "lib/wasm". Usage in
+a browser:
+
+	<script src="wasm_exec.js"></script>
+	<script>
+		const go = new Go()
+		WebAssembly.instantiateStreaming(fetch(`jsonfmt.wasm`), go.importObject)
+			.then(({instance}) => {
+				go.run(instance)
+				console.log(jsonfmt.format(`jsonc`, `{"one": "two"}`))
+			})
+	</script>
+*/
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"syscall/js"
+
+	"github.com/mitranim/jsonfmt"
+)
+
+func main() {
+	js.Global().Set(`jsonfmt`, map[string]any{
+		`format`: js.FuncOf(format),
+	})
+
+	// Keeps the exported functions callable.
+	select {}
+}
+
+func format(_ js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return jsError(`[jsonfmt] expected 2 arguments: config and text`)
+	}
+
+	conf, err := parseConf(args[0])
+	if err != nil {
+		return jsError(err.Error())
+	}
+
+	var out strings.Builder
+	err = jsonfmt.FormatTo(conf, &out, args[1].String())
+	if err != nil {
+		return jsError(err.Error())
+	}
+	return out.String()
+}
+
+func parseConf(val js.Value) (jsonfmt.Conf, error) {
+	switch val.Type() {
+	case js.TypeUndefined, js.TypeNull:
+		return jsonfmt.Default, nil
+
+	case js.TypeString:
+		conf, ok := jsonfmt.Preset(val.String())
+		if ok {
+			return conf, nil
+		}
+		return jsonfmt.ParseConf(val.String())
+
+	default:
+		conf := jsonfmt.Default
+		err := json.Unmarshal([]byte(js.Global().Get(`JSON`).Call(`stringify`, val).String()), &conf)
+		return conf, err
+	}
+}
+
+func jsError(msg string) js.Value {
+	return js.Global().Get(`Error`).New(msg)
+}